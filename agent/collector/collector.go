@@ -9,6 +9,8 @@ import (
 	"runtime"
 	"time"
 
+	"devops-agent/credential"
+
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
@@ -140,7 +142,7 @@ func (c *MetricsCollector) Collect() (*Metrics, error) {
 	return metrics, nil
 }
 
-func (c *MetricsCollector) Report(serverAddr, token string, metrics *Metrics) error {
+func (c *MetricsCollector) Report(serverAddr string, creds *credential.Store, metrics *Metrics) error {
 	data, err := json.Marshal(metrics)
 	if err != nil {
 		return fmt.Errorf("marshal metrics: %w", err)
@@ -153,10 +155,8 @@ func (c *MetricsCollector) Report(serverAddr, token string, metrics *Metrics) er
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Agent-Token", token)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := creds.Do(req)
 	if err != nil {
 		return fmt.Errorf("send report: %w", err)
 	}
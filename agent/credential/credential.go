@@ -0,0 +1,218 @@
+package credential
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+const (
+	tokenHeader  = "X-Agent-Token"
+	rotateHeader = "X-Agent-Rotate"
+)
+
+// Credential is the long-lived credential issued by the server on registration.
+type Credential struct {
+	AgentID string `json:"agent_id"`
+	HostID  string `json:"host_id,omitempty"`
+	Token   string `json:"token"`
+}
+
+// Store holds the agent credential, persists it to disk and rotates it when
+// the server asks for a new one.
+type Store struct {
+	path       string
+	serverAddr string
+	client     *http.Client
+
+	mu       sync.RWMutex
+	cred     Credential
+	rotating bool
+}
+
+// Load reads the credential stored at path. If none exists yet, the agent
+// registers with the bootstrap token and saves the credential it receives.
+func Load(path, serverAddr, bootstrapToken string) (*Store, error) {
+	s := &Store{
+		path:       path,
+		serverAddr: serverAddr,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.cred); err != nil {
+			return nil, fmt.Errorf("parse credential %s: %w", path, err)
+		}
+		if s.cred.Token != "" {
+			return s, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read credential: %w", err)
+	}
+
+	if bootstrapToken == "" {
+		return nil, fmt.Errorf("no credential at %s, a bootstrap token is required to register", path)
+	}
+	if err := s.register(bootstrapToken); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AgentID returns the ID the server assigned to this agent.
+func (s *Store) AgentID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cred.AgentID
+}
+
+// Do sends req with the current credential. When the server asks for
+// rotation, a new credential is requested in the background.
+func (s *Store) Do(req *http.Request) (*http.Response, error) {
+	s.mu.RLock()
+	req.Header.Set(tokenHeader, s.cred.Token)
+	s.mu.RUnlock()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get(rotateHeader) == "true" {
+		go s.rotate()
+	}
+	return resp, nil
+}
+
+func (s *Store) register(bootstrapToken string) error {
+	info, err := host.Info()
+	if err != nil {
+		return fmt.Errorf("collect host info: %w", err)
+	}
+	payload, err := json.Marshal(map[string]string{
+		"hostname":         info.Hostname,
+		"os":               info.OS,
+		"platform":         info.Platform,
+		"platform_version": info.PlatformVersion,
+		"arch":             info.KernelArch,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.serverAddr+"/api/v1/agent/register", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tokenHeader, bootstrapToken)
+
+	cred, err := s.requestCredential(req)
+	if err != nil {
+		return fmt.Errorf("register agent: %w", err)
+	}
+	if err := s.save(cred); err != nil {
+		return err
+	}
+	log.Printf("Registered as agent %s", cred.AgentID)
+	return nil
+}
+
+// rotate exchanges the current credential for a new one. The server keeps
+// accepting the old credential until the new one is used, so a failed save
+// leaves the agent working with the old credential.
+func (s *Store) rotate() {
+	s.mu.Lock()
+	if s.rotating {
+		s.mu.Unlock()
+		return
+	}
+	s.rotating = true
+	token := s.cred.Token
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.rotating = false
+		s.mu.Unlock()
+	}()
+
+	req, err := http.NewRequest("POST", s.serverAddr+"/api/v1/agent/rotate", nil)
+	if err != nil {
+		log.Printf("Failed to rotate credential: %v", err)
+		return
+	}
+	req.Header.Set(tokenHeader, token)
+
+	cred, err := s.requestCredential(req)
+	if err != nil {
+		log.Printf("Failed to rotate credential: %v", err)
+		return
+	}
+	if err := s.save(cred); err != nil {
+		log.Printf("Failed to save rotated credential: %v", err)
+		return
+	}
+	log.Println("Agent credential rotated")
+}
+
+func (s *Store) requestCredential(req *http.Request) (*Credential, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Code int        `json:"code"`
+		Data Credential `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if result.Data.Token == "" {
+		return nil, fmt.Errorf("server returned no credential")
+	}
+	return &result.Data, nil
+}
+
+// save writes the credential to disk before switching to it, so the agent
+// never uses a credential it could lose on restart.
+func (s *Store) save(cred *Credential) error {
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create credential dir: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write credential: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write credential: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cred = *cred
+	s.mu.Unlock()
+	return nil
+}
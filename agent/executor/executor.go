@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"os/exec"
 	"time"
+
+	"devops-agent/credential"
 )
 
 type Command struct {
@@ -75,11 +77,11 @@ func (e *CommandExecutor) Execute(cmd *Command) *CommandResult {
 	return result
 }
 
-func (e *CommandExecutor) StartListener(serverAddr, token string) {
+func (e *CommandExecutor) StartListener(serverAddr string, creds *credential.Store) {
 	log.Println("Command listener started")
 
 	for {
-		commands, err := e.fetchCommands(serverAddr, token)
+		commands, err := e.fetchCommands(serverAddr, creds)
 		if err != nil {
 			time.Sleep(5 * time.Second)
 			continue
@@ -88,7 +90,7 @@ func (e *CommandExecutor) StartListener(serverAddr, token string) {
 		for _, cmd := range commands {
 			log.Printf("Executing command: %s", cmd.ID)
 			result := e.Execute(&cmd)
-			if err := e.reportResult(serverAddr, token, result); err != nil {
+			if err := e.reportResult(serverAddr, creds, result); err != nil {
 				log.Printf("Failed to report result: %v", err)
 			}
 		}
@@ -97,17 +99,14 @@ func (e *CommandExecutor) StartListener(serverAddr, token string) {
 	}
 }
 
-func (e *CommandExecutor) fetchCommands(serverAddr, token string) ([]Command, error) {
+func (e *CommandExecutor) fetchCommands(serverAddr string, creds *credential.Store) ([]Command, error) {
 	url := fmt.Sprintf("%s/api/v1/agent/commands", serverAddr)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("X-Agent-Token", token)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := creds.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +133,7 @@ func (e *CommandExecutor) fetchCommands(serverAddr, token string) ([]Command, er
 	return result.Data, nil
 }
 
-func (e *CommandExecutor) reportResult(serverAddr, token string, result *CommandResult) error {
+func (e *CommandExecutor) reportResult(serverAddr string, creds *credential.Store, result *CommandResult) error {
 	data, err := json.Marshal(result)
 	if err != nil {
 		return err
@@ -147,10 +146,8 @@ func (e *CommandExecutor) reportResult(serverAddr, token string, result *Command
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Agent-Token", token)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := creds.Do(req)
 	if err != nil {
 		return err
 	}
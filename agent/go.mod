@@ -2,6 +2,10 @@ module devops-agent
 
 go 1.21
 
+require github.com/shirou/gopsutil/v3 v3.23.12
+
 require (
-	github.com/shirou/gopsutil/v3 v3.23.12
+	github.com/tklauser/go-sysconf v0.3.12 // indirect
+	github.com/tklauser/numcpus v0.6.1 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
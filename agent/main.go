@@ -9,25 +9,30 @@ import (
 	"time"
 
 	"devops-agent/collector"
+	"devops-agent/credential"
 	"devops-agent/executor"
 )
 
 var (
 	serverAddr     = flag.String("server", "http://localhost:8080", "DevOps server address")
 	reportInterval = flag.Int("interval", 15, "Metrics report interval (seconds)")
-	agentToken     = flag.String("token", "", "Agent authentication token")
+	bootstrapToken = flag.String("token", "", "One-time bootstrap token, only used when no credential is stored")
+	credentialPath = flag.String("credential", "/var/lib/devops-agent/credential", "Path of the stored agent credential")
 )
 
 func main() {
 	flag.Parse()
 
-	if *agentToken == "" {
-		log.Fatal("Agent token is required. Use -token flag.")
-	}
-
 	log.Printf("DevOps Agent starting...")
 	log.Printf("Server: %s, Interval: %ds", *serverAddr, *reportInterval)
 
+	// Load the stored credential, or register with the bootstrap token
+	creds, err := credential.Load(*credentialPath, *serverAddr, *bootstrapToken)
+	if err != nil {
+		log.Fatalf("Failed to load agent credential: %v", err)
+	}
+	log.Printf("Agent ID: %s", creds.AgentID())
+
 	// Initialize collector
 	mc := collector.NewMetricsCollector()
 
@@ -39,7 +44,7 @@ func main() {
 	defer ticker.Stop()
 
 	// Start command listener
-	go exec.StartListener(*serverAddr, *agentToken)
+	go exec.StartListener(*serverAddr, creds)
 
 	// Handle graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -56,7 +61,7 @@ func main() {
 				continue
 			}
 
-			if err := mc.Report(*serverAddr, *agentToken, metrics); err != nil {
+			if err := mc.Report(*serverAddr, creds, metrics); err != nil {
 				log.Printf("Failed to report metrics: %v", err)
 			}
 
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"devops/internal/config"
+	agentHandler "devops/internal/handler/agent"
+	alertHandler "devops/internal/handler/alert"
 	auditHandler "devops/internal/handler/audit"
 	authHandler "devops/internal/handler/auth"
 	configHandler "devops/internal/handler/config"
@@ -18,10 +20,15 @@ import (
 	groupHandler "devops/internal/handler/group"
 	k8sHandler "devops/internal/handler/k8s"
 	monitorHandler "devops/internal/handler/monitor"
+	pipelineHandler "devops/internal/handler/pipeline"
 	userHandler "devops/internal/handler/user"
+	webhookHandler "devops/internal/handler/webhook"
 	"devops/internal/middleware"
 	"devops/internal/model"
 	"devops/internal/pkg/jwt"
+	"devops/internal/pkg/lock"
+	"devops/internal/pkg/notify"
+	"devops/internal/pkg/storage"
 	"devops/internal/repository"
 	"devops/internal/service"
 
@@ -42,6 +49,48 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Initialize deploy locker: Redis backed by Postgres advisory locks on a dedicated pool.
+	// Every lock is also held in Postgres, so the database alone is used while Redis is unreachable
+	lockDB, err := repository.OpenLockDB(&cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to initialize lock database: %v", err)
+	}
+	var locker lock.Locker = lock.NewPGLocker(lockDB)
+	if redisClient, err := repository.InitRedis(&cfg.Redis); err != nil {
+		log.Printf("Redis unavailable, using database locks for deployments: %v", err)
+	} else {
+		locker = lock.NewFallbackLocker(lock.NewRedisLocker(redisClient, "devops:lock:"), locker)
+	}
+
+	// Initialize artifact storage
+	artifactStore, err := storage.New(storage.Config{
+		Type:      cfg.Storage.Type,
+		LocalDir:  cfg.Storage.LocalDir,
+		Endpoint:  cfg.Storage.Endpoint,
+		AccessKey: cfg.Storage.AccessKey,
+		SecretKey: cfg.Storage.SecretKey,
+		Bucket:    cfg.Storage.Bucket,
+		Region:    cfg.Storage.Region,
+		UseSSL:    cfg.Storage.UseSSL,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize artifact storage: %v", err)
+	}
+
+	// Initialize alert notification channels
+	notifyChannels := notify.NewChannels(notify.Config{
+		SMTPHost:        cfg.Notify.Email.Host,
+		SMTPPort:        cfg.Notify.Email.Port,
+		SMTPUsername:    cfg.Notify.Email.Username,
+		SMTPPassword:    cfg.Notify.Email.Password,
+		SMTPFrom:        cfg.Notify.Email.From,
+		SMTPTo:          cfg.Notify.Email.To,
+		SMTPUseTLS:      cfg.Notify.Email.UseTLS,
+		DingTalkWebhook: cfg.Notify.DingTalk,
+		WeChatWebhook:   cfg.Notify.WeChat,
+		FeishuWebhook:   cfg.Notify.Feishu,
+	})
+
 	// Initialize JWT manager
 	jwtManager := jwt.NewJWTManager(cfg.JWT.Secret, cfg.JWT.ExpireHour)
 
@@ -54,9 +103,22 @@ func main() {
 	hostRepo := repository.NewHostRepository(db)
 	hostGroupRepo := repository.NewHostGroupRepository(db)
 	hostTagRepo := repository.NewHostTagRepository(db)
+	agentRepo := repository.NewAgentRepository(db)
+	agentTokenRepo := repository.NewAgentBootstrapTokenRepository(db)
+	hostMetricRepo := repository.NewHostMetricRepository(db)
+	agentCommandRepo := repository.NewAgentCommandRepository(db)
+	alertRuleRepo := repository.NewAlertRuleRepository(db)
+	alertHistoryRepo := repository.NewAlertHistoryRepository(db)
 	appRepo := repository.NewAppRepository(db)
 	envRepo := repository.NewEnvRepository(db)
 	deployRepo := repository.NewDeploymentRepository(db)
+	scriptRepo := repository.NewDeployScriptRepository(db)
+	artifactRepo := repository.NewArtifactRepository(db)
+	approvalRepo := repository.NewApprovalRepository(db)
+	freezeRepo := repository.NewFreezeWindowRepository(db)
+	pipelineRepo := repository.NewPipelineRepository(db)
+	pipelineHistoryRepo := repository.NewPipelineHistoryRepository(db)
+	pipelineRunRepo := repository.NewPipelineRunRepository(db)
 	configRepo := repository.NewConfigRepository(db)
 	configHistoryRepo := repository.NewConfigHistoryRepository(db)
 	clusterRepo := repository.NewClusterRepository(db)
@@ -79,11 +141,26 @@ func main() {
 	hostService := service.NewHostService(hostRepo, hostGroupRepo, hostTagRepo)
 	hostGroupService := service.NewHostGroupService(hostGroupRepo)
 	hostTagService := service.NewHostTagService(hostTagRepo)
+	agentService := service.NewAgentService(agentRepo, agentTokenRepo, hostMetricRepo, agentCommandRepo, hostRepo, cfg.Agent.OfflineAfter)
+	hostMetricService := service.NewHostMetricService(hostMetricRepo, hostRepo)
+	if cfg.Alertmanager.Token == "" {
+		log.Printf("Alertmanager webhook token is not configured, webhook requests will be rejected")
+	}
+	alertService := service.NewAlertService(alertRuleRepo, alertHistoryRepo, hostMetricRepo, hostRepo, notifyChannels, cfg.Alertmanager.Token, cfg.Alertmanager.Channels)
 	appService := service.NewAppService(appRepo, envRepo, deployRepo, hostRepo)
-	deployService := service.NewDeploymentService(deployRepo, appRepo)
-	envService := service.NewEnvService(envRepo)
-	configService := service.NewConfigService(configRepo, configHistoryRepo, cfg.JWT.Secret)
+	artifactService := service.NewArtifactService(artifactRepo, appRepo, artifactStore)
+	builder := service.NewArtifactBuilder(cfg.Build.Workspace, artifactService)
 	k8sService := service.NewK8sService(clusterRepo, k8sHistoryRepo, cfg.JWT.Secret)
+	configService := service.NewConfigService(configRepo, configHistoryRepo, cfg.JWT.Secret)
+	deployService := service.NewDeploymentService(deployRepo, appRepo, scriptRepo, approvalRepo, freezeRepo, auditRepo, k8sService, configService, locker, builder)
+	approvalService := service.NewDeployApprovalService(approvalRepo, deployRepo, envRepo, auditRepo)
+	freezeService := service.NewFreezeWindowService(freezeRepo, envRepo)
+	statsService := service.NewDeployStatsService(deployRepo, envRepo)
+	pipelineService := service.NewPipelineService(pipelineRepo, pipelineHistoryRepo, pipelineRunRepo, appRepo, envRepo, deployRepo, deployService, k8sService, cfg.Build.Workspace)
+	webhookService := service.NewGitWebhookService(appRepo, auditRepo, deployService, cfg.JWT.Secret)
+	scriptService := service.NewDeployScriptService(scriptRepo, appRepo)
+	envService := service.NewEnvService(envRepo)
+	promoteService := service.NewDeployPromotionService(deployRepo, appRepo, envRepo, configService, deployService)
 
 	// Initialize admin user
 	if err := authService.InitAdminUser(); err != nil {
@@ -100,8 +177,12 @@ func main() {
 	userH := userHandler.NewHandler(userService, roleService)
 	groupH := groupHandler.NewHandler(groupService)
 	auditH := auditHandler.NewHandler(auditService)
-	monitorH := monitorHandler.NewHandler(hostService, hostGroupService, hostTagService)
-	deployH := deployHandler.NewHandler(appService, deployService, envService)
+	monitorH := monitorHandler.NewHandler(hostService, hostGroupService, hostTagService, hostMetricService)
+	agentH := agentHandler.NewHandler(agentService)
+	alertH := alertHandler.NewHandler(alertService)
+	deployH := deployHandler.NewHandler(appService, deployService, envService, scriptService, artifactService, approvalService, freezeService, statsService, promoteService)
+	pipelineH := pipelineHandler.NewHandler(pipelineService)
+	webhookH := webhookHandler.NewHandler(webhookService)
 	configH := configHandler.NewHandler(configService)
 	k8sH := k8sHandler.NewHandler(k8sService)
 
@@ -111,6 +192,10 @@ func main() {
 	}
 
 	r := gin.Default()
+	// Client IPs identify agents and hosts, so only trust X-Forwarded-For from configured proxies
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 
 	// Middleware
 	r.Use(middleware.CORS())
@@ -136,6 +221,18 @@ func main() {
 		auth := api.Group("/auth")
 		authH.RegisterRoutes(auth)
 
+		// Webhook routes (verified by per-app signature instead of JWT)
+		webhooks := api.Group("/webhooks")
+		webhookH.RegisterRoutes(webhooks)
+
+		// Alertmanager webhook (verified by bearer token instead of JWT)
+		alerts := api.Group("/alerts")
+		alertH.RegisterWebhookRoutes(alerts)
+
+		// Agent routes (verified by bootstrap token or agent credential instead of JWT)
+		agents := api.Group("/agent")
+		agentH.RegisterRoutes(agents)
+
 		// Protected routes
 		protected := api.Group("")
 		protected.Use(middleware.JWTAuth(jwtManager))
@@ -157,9 +254,21 @@ func main() {
 		// Monitor routes (with permission check)
 		monitorH.RegisterRoutes(protected)
 
+		// Agent management and host command routes
+		agentH.RegisterManageRoutes(protected)
+
+		// Alert rule and history routes
+		alertH.RegisterRoutes(protected)
+
 		// Deploy routes (with permission check)
 		deployH.RegisterRoutes(protected)
 
+		// Pipeline routes
+		pipelineH.RegisterRoutes(protected)
+
+		// Webhook secret routes
+		webhookH.RegisterSecretRoutes(protected)
+
 		// Config routes (with permission check)
 		configH.RegisterRoutes(protected)
 
@@ -167,6 +276,15 @@ func main() {
 		k8sH.RegisterRoutes(protected)
 	}
 
+	// Background jobs stop when the server shuts down
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	// Fail deployments orphaned by a previous shutdown and restart their queues
+	deployService.RecoverDeployments()
+	go agentService.Run(bgCtx)
+	go hostMetricService.Run(bgCtx)
+	go alertService.Run(bgCtx)
+
 	// Start server with graceful shutdown
 	addr := fmt.Sprintf(":%s", cfg.Server.Port)
 	srv := &http.Server{
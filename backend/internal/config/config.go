@@ -3,20 +3,28 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	JWT      JWTConfig      `mapstructure:"jwt"`
+	Server       ServerConfig       `mapstructure:"server"`
+	Database     DatabaseConfig     `mapstructure:"database"`
+	Redis        RedisConfig        `mapstructure:"redis"`
+	JWT          JWTConfig          `mapstructure:"jwt"`
+	Build        BuildConfig        `mapstructure:"build"`
+	Storage      StorageConfig      `mapstructure:"storage"`
+	Agent        AgentConfig        `mapstructure:"agent"`
+	Notify       NotifyConfig       `mapstructure:"notify"`
+	Alertmanager AlertmanagerConfig `mapstructure:"alertmanager"`
 }
 
 type ServerConfig struct {
 	Port string `mapstructure:"port"`
 	Mode string `mapstructure:"mode"`
+	// TrustedProxies 允许设置 X-Forwarded-For 的反向代理地址或网段，为空时只使用连接的来源地址
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type DatabaseConfig struct {
@@ -26,6 +34,8 @@ type DatabaseConfig struct {
 	Password string `mapstructure:"password"`
 	DBName   string `mapstructure:"dbname"`
 	SSLMode  string `mapstructure:"sslmode"`
+	// LockMaxConns 部署锁专用连接池的最大连接数，每个持有中的部署锁占用一个连接
+	LockMaxConns int `mapstructure:"lock_max_conns"`
 }
 
 type RedisConfig struct {
@@ -40,6 +50,53 @@ type JWTConfig struct {
 	ExpireHour int    `mapstructure:"expire_hour"`
 }
 
+type BuildConfig struct {
+	Workspace string `mapstructure:"workspace"` // 构建时检出代码的临时目录
+}
+
+// StorageConfig 构建产物存储，type 为 local 或 s3（兼容 MinIO 等 S3 协议存储）
+type StorageConfig struct {
+	Type      string `mapstructure:"type"`
+	LocalDir  string `mapstructure:"local_dir"`
+	Endpoint  string `mapstructure:"endpoint"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	Bucket    string `mapstructure:"bucket"`
+	Region    string `mapstructure:"region"`
+	UseSSL    bool   `mapstructure:"use_ssl"`
+}
+
+// AgentConfig 主机 Agent 接入配置
+type AgentConfig struct {
+	OfflineAfter int `mapstructure:"offline_after"` // 超过该秒数没有心跳的主机标记为离线
+}
+
+// NotifyConfig 告警通知渠道，告警规则只能使用已配置的渠道
+type NotifyConfig struct {
+	Email    EmailConfig `mapstructure:"email"`
+	DingTalk string      `mapstructure:"dingtalk_webhook"`
+	WeChat   string      `mapstructure:"wechat_webhook"`
+	Feishu   string      `mapstructure:"feishu_webhook"`
+}
+
+// EmailConfig 邮件通知使用的 SMTP 服务，告警发送给 to 中的所有地址
+type EmailConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+	UseTLS   bool     `mapstructure:"use_tls"`
+}
+
+// AlertmanagerConfig Alertmanager webhook 接收配置。token 非空时要求请求携带 Authorization: Bearer <token>；
+// channels 为转发告警的通知渠道，为空时转发到所有已配置的渠道
+type AlertmanagerConfig struct {
+	Token    string   `mapstructure:"token"`
+	Channels []string `mapstructure:"channels"`
+}
+
 var GlobalConfig *Config
 
 func Load(path string) (*Config, error) {
@@ -83,6 +140,11 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("DB_SSLMODE"); v != "" {
 		cfg.Database.SSLMode = v
 	}
+	if v := os.Getenv("DB_LOCK_MAX_CONNS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Database.LockMaxConns = n
+		}
+	}
 	if v := os.Getenv("REDIS_HOST"); v != "" {
 		cfg.Redis.Host = v
 	}
@@ -108,6 +170,65 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("SERVER_MODE"); v != "" {
 		cfg.Server.Mode = v
 	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.Server.TrustedProxies = strings.Split(v, ",")
+	}
+	if v := os.Getenv("BUILD_WORKSPACE"); v != "" {
+		cfg.Build.Workspace = v
+	}
+	if v := os.Getenv("STORAGE_TYPE"); v != "" {
+		cfg.Storage.Type = v
+	}
+	if v := os.Getenv("STORAGE_LOCAL_DIR"); v != "" {
+		cfg.Storage.LocalDir = v
+	}
+	if v := os.Getenv("S3_ENDPOINT"); v != "" {
+		cfg.Storage.Endpoint = v
+	}
+	if v := os.Getenv("S3_ACCESS_KEY"); v != "" {
+		cfg.Storage.AccessKey = v
+	}
+	if v := os.Getenv("S3_SECRET_KEY"); v != "" {
+		cfg.Storage.SecretKey = v
+	}
+	if v := os.Getenv("S3_BUCKET"); v != "" {
+		cfg.Storage.Bucket = v
+	}
+	if v := os.Getenv("SMTP_HOST"); v != "" {
+		cfg.Notify.Email.Host = v
+	}
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if p, err := strconv.Atoi(v); err == nil {
+			cfg.Notify.Email.Port = p
+		}
+	}
+	if v := os.Getenv("SMTP_USERNAME"); v != "" {
+		cfg.Notify.Email.Username = v
+	}
+	if v := os.Getenv("SMTP_PASSWORD"); v != "" {
+		cfg.Notify.Email.Password = v
+	}
+	if v := os.Getenv("SMTP_FROM"); v != "" {
+		cfg.Notify.Email.From = v
+	}
+	if v := os.Getenv("SMTP_TO"); v != "" {
+		cfg.Notify.Email.To = strings.Split(v, ",")
+	}
+	if v := os.Getenv("DINGTALK_WEBHOOK"); v != "" {
+		cfg.Notify.DingTalk = v
+	}
+	if v := os.Getenv("WECHAT_WEBHOOK"); v != "" {
+		cfg.Notify.WeChat = v
+	}
+	if v := os.Getenv("FEISHU_WEBHOOK"); v != "" {
+		cfg.Notify.Feishu = v
+	}
+	if v := os.Getenv("ALERTMANAGER_WEBHOOK_TOKEN"); v != "" {
+		cfg.Alertmanager.Token = v
+	}
+	if v := os.Getenv("ALERTMANAGER_CHANNELS"); v != "" {
+		cfg.Alertmanager.Channels = strings.Split(v, ",")
+	}
 }
 
 func LoadDefault() *Config {
@@ -117,12 +238,13 @@ func LoadDefault() *Config {
 			Mode: "debug",
 		},
 		Database: DatabaseConfig{
-			Host:     "localhost",
-			Port:     5432,
-			User:     "postgres",
-			Password: "postgres",
-			DBName:   "devops",
-			SSLMode:  "disable",
+			Host:         "localhost",
+			Port:         5432,
+			User:         "postgres",
+			Password:     "postgres",
+			DBName:       "devops",
+			SSLMode:      "disable",
+			LockMaxConns: 50,
 		},
 		Redis: RedisConfig{
 			Host:     "localhost",
@@ -134,5 +256,21 @@ func LoadDefault() *Config {
 			Secret:     "devops-secret-key-change-in-production",
 			ExpireHour: 24,
 		},
+		Build: BuildConfig{
+			Workspace: "/tmp/devops-builds",
+		},
+		Storage: StorageConfig{
+			Type:     "local",
+			LocalDir: "data/artifacts",
+		},
+		Agent: AgentConfig{
+			OfflineAfter: 60,
+		},
+		Notify: NotifyConfig{
+			Email: EmailConfig{
+				Port:   465,
+				UseTLS: true,
+			},
+		},
 	}
 }
@@ -0,0 +1,344 @@
+package agent
+
+import (
+	"net/http"
+	"strconv"
+
+	"devops/internal/middleware"
+	"devops/internal/model"
+	"devops/internal/pkg/response"
+	"devops/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// AgentTokenHeader Agent 请求携带凭证（注册时为引导令牌）的请求头
+	AgentTokenHeader = "X-Agent-Token"
+	// AgentRotateHeader 管理员要求轮换凭证时在响应中设置，Agent 收到后调用 /agent/rotate
+	AgentRotateHeader = "X-Agent-Rotate"
+	contextAgentKey   = "agent"
+	// maxPayloadSize Agent 请求体的大小上限，命令输出在服务端另有截断
+	maxPayloadSize = 4 << 20
+)
+
+type Handler struct {
+	agentService *service.AgentService
+}
+
+func NewHandler(agentService *service.AgentService) *Handler {
+	return &Handler{agentService: agentService}
+}
+
+// RegisterRoutes 注册 Agent 调用的接口，不经过 JWT 认证。
+// 注册接口校验引导令牌，其余接口校验 Agent 注册时获得的凭证
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	r.POST("/register", limitBody, h.Register)
+
+	authed := r.Group("", h.authenticate, limitBody)
+	authed.POST("/report", h.Report)
+	authed.GET("/commands", h.PollCommands)
+	authed.POST("/result", h.ReportResult)
+	authed.POST("/rotate", h.Rotate)
+}
+
+// RegisterManageRoutes 注册 Agent、引导令牌和主机命令的管理接口，需要登录
+func (h *Handler) RegisterManageRoutes(r *gin.RouterGroup) {
+	r.GET("/agents", middleware.RequireOperator(), h.ListAgents)
+	r.POST("/agents/:id/rotate", middleware.RequireAdmin(), h.RequestRotate)
+	r.POST("/agents/:id/revoke", middleware.RequireAdmin(), h.Revoke)
+	r.GET("/agent-tokens", middleware.RequireAdmin(), h.ListBootstrapTokens)
+	r.POST("/agent-tokens", middleware.RequireAdmin(), h.CreateBootstrapToken)
+	r.DELETE("/agent-tokens/:id", middleware.RequireAdmin(), h.DeleteBootstrapToken)
+	r.GET("/hosts/:id/commands", middleware.RequireOperator(), h.ListCommands)
+	r.POST("/hosts/:id/commands", middleware.RequireAdmin(), h.CreateCommand)
+	r.GET("/agent-commands/:id", middleware.RequireOperator(), h.GetCommand)
+}
+
+func (h *Handler) authenticate(c *gin.Context) {
+	agent, err := h.agentService.Authenticate(c.GetHeader(AgentTokenHeader))
+	if err != nil {
+		if err == service.ErrAgentUnauthorized {
+			response.Unauthorized(c, "invalid agent credential")
+		} else {
+			response.ServerError(c, err.Error())
+		}
+		c.Abort()
+		return
+	}
+	if agent.RotateRequested {
+		c.Header(AgentRotateHeader, "true")
+	}
+	c.Set(contextAgentKey, agent)
+	c.Next()
+}
+
+func limitBody(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPayloadSize)
+	c.Next()
+}
+
+func currentAgent(c *gin.Context) *model.Agent {
+	return c.MustGet(contextAgentKey).(*model.Agent)
+}
+
+func (h *Handler) Register(c *gin.Context) {
+	var req service.AgentRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	cred, err := h.agentService.Register(c.GetHeader(AgentTokenHeader), &req, c.ClientIP())
+	if err != nil {
+		switch err {
+		case service.ErrBootstrapTokenInvalid:
+			response.Unauthorized(c, "引导令牌无效、已过期或已被使用")
+		case service.ErrAgentHostBound:
+			response.Forbidden(c, "主机已接入 Agent，请先吊销原 Agent 或使用指定该主机的引导令牌")
+		case service.ErrHostNotFound:
+			response.NotFound(c, "引导令牌指定的主机不存在")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, cred)
+}
+
+func (h *Handler) Rotate(c *gin.Context) {
+	cred, err := h.agentService.Rotate(currentAgent(c))
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, cred)
+}
+
+func (h *Handler) Report(c *gin.Context) {
+	var metrics service.AgentMetrics
+	if err := c.ShouldBindJSON(&metrics); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	if err := h.agentService.Report(currentAgent(c), &metrics); err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, nil)
+}
+
+func (h *Handler) PollCommands(c *gin.Context) {
+	cmds, err := h.agentService.PollCommands(currentAgent(c))
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, cmds)
+}
+
+func (h *Handler) ReportResult(c *gin.Context) {
+	var result service.AgentCommandResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	if err := h.agentService.ReportResult(currentAgent(c), &result); err != nil {
+		switch err {
+		case service.ErrAgentCommandNotFound:
+			response.NotFound(c, "命令不存在")
+		case service.ErrAgentCommandFinished:
+			response.Error(c, 2004, "命令已结束")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, nil)
+}
+
+func (h *Handler) ListAgents(c *gin.Context) {
+	agents, err := h.agentService.ListAgents()
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, agents)
+}
+
+func (h *Handler) RequestRotate(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.agentService.RequestRotate(id); err != nil {
+		handleAgentError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "已要求 Agent 轮换凭证", nil)
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.agentService.Revoke(id); err != nil {
+		handleAgentError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "Agent 凭证已吊销", nil)
+}
+
+func (h *Handler) ListBootstrapTokens(c *gin.Context) {
+	tokens, err := h.agentService.ListBootstrapTokens()
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, tokens)
+}
+
+func (h *Handler) CreateBootstrapToken(c *gin.Context) {
+	var req service.CreateBootstrapTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	token, err := h.agentService.CreateBootstrapToken(&req, claims.UserID, claims.Username)
+	if err != nil {
+		if err == service.ErrHostNotFound {
+			response.NotFound(c, "主机不存在")
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, token)
+}
+
+func (h *Handler) DeleteBootstrapToken(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.agentService.DeleteBootstrapToken(id); err != nil {
+		if err == service.ErrBootstrapTokenNotFound {
+			response.NotFound(c, "引导令牌不存在")
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "删除成功", nil)
+}
+
+func handleAgentError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrAgentNotFound:
+		response.NotFound(c, "Agent 不存在")
+	case service.ErrAgentRevoked:
+		response.Error(c, 2005, "Agent 凭证已吊销，请重新注册")
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
+func (h *Handler) ListCommands(c *gin.Context) {
+	hostID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	page := getIntParam(c, "page", 1)
+	pageSize := getIntParam(c, "page_size", 20)
+	cmds, total, err := h.agentService.ListCommands(hostID, page, pageSize)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessPage(c, cmds, total, page, pageSize)
+}
+
+func (h *Handler) CreateCommand(c *gin.Context) {
+	hostID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.CreateAgentCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	cmd, err := h.agentService.CreateCommand(hostID, &req, claims.UserID, claims.Username)
+	if err != nil {
+		switch err {
+		case service.ErrHostNotFound:
+			response.NotFound(c, "主机不存在")
+		case service.ErrHostNoAgent:
+			response.Error(c, 2003, "主机未接入 Agent")
+		case service.ErrAgentCommandInvalid:
+			response.BadRequest(c, "命令超时时间需在 0 到 3600 秒之间")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, cmd)
+}
+
+func (h *Handler) GetCommand(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	cmd, err := h.agentService.GetCommand(id)
+	if err != nil {
+		response.NotFound(c, "命令不存在")
+		return
+	}
+
+	response.Success(c, cmd)
+}
+
+func getIntParam(c *gin.Context, key string, defaultVal int) int {
+	val := c.Query(key)
+	if val == "" {
+		return defaultVal
+	}
+	if n, err := strconv.Atoi(val); err == nil {
+		return n
+	}
+	return defaultVal
+}
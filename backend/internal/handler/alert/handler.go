@@ -0,0 +1,202 @@
+package alert
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"devops/internal/middleware"
+	"devops/internal/pkg/response"
+	"devops/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	alertService *service.AlertService
+}
+
+func NewHandler(alertService *service.AlertService) *Handler {
+	return &Handler{alertService: alertService}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	rules := r.Group("/alert-rules")
+	{
+		rules.GET("", h.ListRules)
+		rules.POST("", middleware.RequireOperator(), h.CreateRule)
+		rules.GET("/:id", h.GetRule)
+		rules.PUT("/:id", middleware.RequireOperator(), h.UpdateRule)
+		rules.DELETE("/:id", middleware.RequireOperator(), h.DeleteRule)
+	}
+
+	r.GET("/alert-history", h.ListHistory)
+	r.GET("/alert-channels", h.ListChannels)
+}
+
+// RegisterWebhookRoutes 注册 Alertmanager webhook，不经过 JWT 认证而是校验 Bearer 令牌，未配置令牌时拒绝请求
+func (h *Handler) RegisterWebhookRoutes(r *gin.RouterGroup) {
+	r.POST("/webhook", h.ReceiveAlertmanager)
+}
+
+func (h *Handler) ReceiveAlertmanager(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !h.alertService.AuthorizeWebhook(token) {
+		response.Unauthorized(c, "invalid webhook token")
+		return
+	}
+
+	var payload service.AlertmanagerPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	if err := h.alertService.ReceiveAlertmanager(&payload); err != nil {
+		if errors.Is(err, service.ErrAlertmanagerPayload) {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, nil)
+}
+
+func (h *Handler) ListRules(c *gin.Context) {
+	rules, err := h.alertService.ListRules()
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, rules)
+}
+
+func (h *Handler) CreateRule(c *gin.Context) {
+	var req service.CreateAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	rule, err := h.alertService.CreateRule(&req)
+	if err != nil {
+		handleRuleError(c, err)
+		return
+	}
+
+	response.Success(c, rule)
+}
+
+func (h *Handler) GetRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	rule, err := h.alertService.GetRule(id)
+	if err != nil {
+		response.NotFound(c, "告警规则不存在")
+		return
+	}
+
+	response.Success(c, rule)
+}
+
+func (h *Handler) UpdateRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.UpdateAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	rule, err := h.alertService.UpdateRule(id, &req)
+	if err != nil {
+		handleRuleError(c, err)
+		return
+	}
+
+	response.Success(c, rule)
+}
+
+func (h *Handler) DeleteRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.alertService.DeleteRule(id); err != nil {
+		handleRuleError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "删除成功", nil)
+}
+
+func (h *Handler) ListHistory(c *gin.Context) {
+	page := getIntParam(c, "page", 1)
+	pageSize := getIntParam(c, "page_size", 20)
+
+	var status *int
+	if s := c.Query("status"); s != "" {
+		if st, err := strconv.Atoi(s); err == nil {
+			status = &st
+		}
+	}
+	var hostID, ruleID *uuid.UUID
+	if v := c.Query("host_id"); v != "" {
+		if id, err := uuid.Parse(v); err == nil {
+			hostID = &id
+		}
+	}
+	if v := c.Query("rule_id"); v != "" {
+		if id, err := uuid.Parse(v); err == nil {
+			ruleID = &id
+		}
+	}
+
+	histories, total, err := h.alertService.ListHistory(page, pageSize, c.Query("source"), status, hostID, ruleID)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessPage(c, histories, total, page, pageSize)
+}
+
+func (h *Handler) ListChannels(c *gin.Context) {
+	response.Success(c, h.alertService.ListChannels())
+}
+
+func handleRuleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrAlertRuleNotFound):
+		response.NotFound(c, "告警规则不存在")
+	case errors.Is(err, service.ErrAlertRuleInvalid):
+		response.BadRequest(c, "无效的告警规则: "+err.Error())
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
+func getIntParam(c *gin.Context, key string, defaultVal int) int {
+	val := c.Query(key)
+	if val == "" {
+		return defaultVal
+	}
+	if n, err := strconv.Atoi(val); err == nil {
+		return n
+	}
+	return defaultVal
+}
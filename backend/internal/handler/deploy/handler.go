@@ -1,10 +1,16 @@
 package deploy
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"time"
 
 	"devops/internal/middleware"
 	"devops/internal/pkg/response"
+	"devops/internal/repository"
 	"devops/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -12,20 +18,38 @@ import (
 )
 
 type Handler struct {
-	appService    *service.AppService
-	deployService *service.DeploymentService
-	envService    *service.EnvService
+	appService      *service.AppService
+	deployService   *service.DeploymentService
+	envService      *service.EnvService
+	scriptService   *service.DeployScriptService
+	artifactService *service.ArtifactService
+	approvalService *service.DeployApprovalService
+	freezeService   *service.FreezeWindowService
+	statsService    *service.DeployStatsService
+	promoteService  *service.DeployPromotionService
 }
 
 func NewHandler(
 	appService *service.AppService,
 	deployService *service.DeploymentService,
 	envService *service.EnvService,
+	scriptService *service.DeployScriptService,
+	artifactService *service.ArtifactService,
+	approvalService *service.DeployApprovalService,
+	freezeService *service.FreezeWindowService,
+	statsService *service.DeployStatsService,
+	promoteService *service.DeployPromotionService,
 ) *Handler {
 	return &Handler{
-		appService:    appService,
-		deployService: deployService,
-		envService:    envService,
+		appService:      appService,
+		deployService:   deployService,
+		envService:      envService,
+		scriptService:   scriptService,
+		artifactService: artifactService,
+		approvalService: approvalService,
+		freezeService:   freezeService,
+		statsService:    statsService,
+		promoteService:  promoteService,
 	}
 }
 
@@ -37,20 +61,47 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 		apps.GET("/:id", h.GetApp)
 		apps.PUT("/:id", h.UpdateApp)
 		apps.DELETE("/:id", h.DeleteApp)
+		apps.GET("/:id/scripts", h.ListScripts)
+		apps.POST("/:id/scripts", h.CreateScript)
+		apps.GET("/:id/scripts/:scriptId", h.GetScript)
+		apps.PUT("/:id/scripts/:scriptId", h.UpdateScript)
+		apps.DELETE("/:id/scripts/:scriptId", h.DeleteScript)
+		apps.GET("/:id/artifacts", h.ListArtifacts)
+		apps.GET("/:id/artifacts/:artifactId/download", h.DownloadArtifact)
 	}
 
 	deploys := r.Group("/deployments")
 	{
 		deploys.GET("", h.ListDeployments)
 		deploys.POST("", h.CreateDeployment)
+		deploys.GET("/queue", h.ListDeployQueue)
+		deploys.GET("/running", h.ListRunningDeployments)
+		deploys.GET("/stats", h.GetDeploymentStats)
 		deploys.GET("/:id", h.GetDeployment)
+		deploys.GET("/:id/logs/stream", h.StreamDeploymentLogs)
 		deploys.POST("/:id/start", h.StartDeployment)
+		deploys.DELETE("/:id/queue", h.CancelQueuedDeployment)
+		deploys.POST("/:id/pause", h.PauseDeployment)
+		deploys.POST("/:id/resume", h.ResumeDeployment)
+		deploys.POST("/:id/cancel", h.CancelDeployment)
+		deploys.GET("/:id/approvals", h.ListApprovals)
+		deploys.POST("/:id/approve", h.ApproveDeployment)
+		deploys.POST("/:id/reject", h.RejectDeployment)
+		deploys.POST("/:id/promote", h.PromoteDeployment)
+		deploys.POST("/:id/retry", h.RetryDeployment)
 		deploys.POST("/rollback", h.Rollback)
 	}
 
 	envs := r.Group("/environments")
 	{
 		envs.GET("", h.ListEnvironments)
+		envs.GET("/:id/approval-policy", h.GetApprovalPolicy)
+		envs.PUT("/:id/approval-policy", middleware.RequireAdmin(), h.UpdateApprovalPolicy)
+		envs.GET("/freeze-windows/upcoming", h.ListUpcomingFreezes)
+		envs.GET("/:id/freeze-windows", h.ListFreezeWindows)
+		envs.POST("/:id/freeze-windows", middleware.RequireAdmin(), h.CreateFreezeWindow)
+		envs.PUT("/:id/freeze-windows/:windowId", middleware.RequireAdmin(), h.UpdateFreezeWindow)
+		envs.DELETE("/:id/freeze-windows/:windowId", middleware.RequireAdmin(), h.DeleteFreezeWindow)
 	}
 }
 
@@ -90,6 +141,18 @@ func (h *Handler) CreateApp(c *gin.Context) {
 			response.Error(c, 3001, "应用代码已存在")
 			return
 		}
+		if err == service.ErrAppStrategy {
+			response.BadRequest(c, "无效的发布策略")
+			return
+		}
+		if err == service.ErrAppK8sTarget {
+			response.BadRequest(c, "无效的 Kubernetes 部署目标")
+			return
+		}
+		if errors.Is(err, service.ErrAppConfigTarget) {
+			response.BadRequest(c, "无效的配置注入设置: "+err.Error())
+			return
+		}
 		response.ServerError(c, err.Error())
 		return
 	}
@@ -132,6 +195,18 @@ func (h *Handler) UpdateApp(c *gin.Context) {
 			response.NotFound(c, "应用不存在")
 			return
 		}
+		if err == service.ErrAppStrategy {
+			response.BadRequest(c, "无效的发布策略")
+			return
+		}
+		if err == service.ErrAppK8sTarget {
+			response.BadRequest(c, "无效的 Kubernetes 部署目标")
+			return
+		}
+		if errors.Is(err, service.ErrAppConfigTarget) {
+			response.BadRequest(c, "无效的配置注入设置: "+err.Error())
+			return
+		}
 		response.ServerError(c, err.Error())
 		return
 	}
@@ -154,24 +229,203 @@ func (h *Handler) DeleteApp(c *gin.Context) {
 	response.SuccessWithMessage(c, "删除成功", nil)
 }
 
-// Deployment handlers
-func (h *Handler) ListDeployments(c *gin.Context) {
-	appID, err := uuid.Parse(c.Query("app_id"))
+// Deploy script handlers
+func (h *Handler) ListScripts(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	scripts, err := h.scriptService.List(appID)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, scripts)
+}
+
+func (h *Handler) CreateScript(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.CreateScriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	script, err := h.scriptService.Create(appID, &req)
+	if err != nil {
+		h.handleScriptError(c, err)
+		return
+	}
+
+	response.Success(c, script)
+}
+
+func (h *Handler) GetScript(c *gin.Context) {
+	appID, scriptID, ok := parseScriptIDs(c)
+	if !ok {
+		return
+	}
+
+	script, err := h.scriptService.GetByID(appID, scriptID)
+	if err != nil {
+		h.handleScriptError(c, err)
+		return
+	}
+
+	response.Success(c, script)
+}
+
+func (h *Handler) UpdateScript(c *gin.Context) {
+	appID, scriptID, ok := parseScriptIDs(c)
+	if !ok {
+		return
+	}
+
+	var req service.UpdateScriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	script, err := h.scriptService.Update(appID, scriptID, &req)
 	if err != nil {
-		response.BadRequest(c, "需要提供app_id")
+		h.handleScriptError(c, err)
+		return
+	}
+
+	response.Success(c, script)
+}
+
+func (h *Handler) DeleteScript(c *gin.Context) {
+	appID, scriptID, ok := parseScriptIDs(c)
+	if !ok {
+		return
+	}
+
+	if err := h.scriptService.Delete(appID, scriptID); err != nil {
+		h.handleScriptError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "删除成功", nil)
+}
+
+func (h *Handler) handleScriptError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrAppNotFound:
+		response.NotFound(c, "应用不存在")
+	case service.ErrScriptNotFound:
+		response.NotFound(c, "部署脚本不存在")
+	case service.ErrScriptTypeInvalid:
+		response.BadRequest(c, "脚本类型必须为 before_deploy、deploy 或 after_deploy")
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
+func parseScriptIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+	scriptID, err := uuid.Parse(c.Param("scriptId"))
+	if err != nil {
+		response.BadRequest(c, "无效的脚本ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return appID, scriptID, true
+}
+
+// Artifact handlers
+func (h *Handler) ListArtifacts(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
 		return
 	}
 
 	page := getIntParam(c, "page", 1)
 	pageSize := getIntParam(c, "page_size", 20)
 
-	deployments, total, err := h.deployService.List(appID, page, pageSize)
+	artifacts, total, err := h.artifactService.List(appID, page, pageSize)
 	if err != nil {
+		if err == service.ErrAppNotFound {
+			response.NotFound(c, "应用不存在")
+			return
+		}
 		response.ServerError(c, err.Error())
 		return
 	}
 
-	response.SuccessPage(c, deployments, total, page, pageSize)
+	response.SuccessPage(c, artifacts, total, page, pageSize)
+}
+
+func (h *Handler) DownloadArtifact(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+	artifactID, err := uuid.Parse(c.Param("artifactId"))
+	if err != nil {
+		response.BadRequest(c, "无效的产物ID")
+		return
+	}
+
+	artifact, err := h.artifactService.GetByID(appID, artifactID)
+	if err != nil {
+		response.NotFound(c, "构建产物不存在")
+		return
+	}
+
+	reader, err := h.artifactService.Open(c.Request.Context(), artifact)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(200, artifact.Size, "application/gzip", reader, map[string]string{
+		"Content-Disposition": `attachment; filename="` + artifact.FileName + `"`,
+		"X-Checksum-Sha256":   artifact.SHA256,
+	})
+}
+
+// Deployment handlers
+// ListDeployments 查询所有应用的部署，可按应用、环境、状态、类型、创建人、时间范围和关键字过滤
+func (h *Handler) ListDeployments(c *gin.Context) {
+	var params repository.DeploymentQueryParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	deployments, total, err := h.deployService.List(&params)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessPage(c, deployments, total, params.Page, params.PageSize)
+}
+
+func (h *Handler) ListRunningDeployments(c *gin.Context) {
+	deployments, err := h.deployService.ListRunning()
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, deployments)
 }
 
 func (h *Handler) CreateDeployment(c *gin.Context) {
@@ -182,12 +436,15 @@ func (h *Handler) CreateDeployment(c *gin.Context) {
 	}
 
 	claims := middleware.GetCurrentUser(c)
-	deployment, err := h.deployService.Create(&req, claims.UserID)
+	deployment, err := h.deployService.Create(&req, claims.UserID, freezeOverride(c, req.FreezeOverrideReason))
 	if err != nil {
 		if err == service.ErrAppNotFound {
 			response.NotFound(c, "应用不存在")
 			return
 		}
+		if handleFreezeError(c, err) {
+			return
+		}
 		response.ServerError(c, err.Error())
 		return
 	}
@@ -211,6 +468,78 @@ func (h *Handler) GetDeployment(c *gin.Context) {
 	response.Success(c, deployment)
 }
 
+// StreamDeploymentLogs 以 SSE 推送部署日志：先回放已缓冲的日志，再实时推送新日志，
+// 部署结束时发送 end 事件。已结束的部署直接回放 Output。
+// 部署未结束但无法继续推送（如订阅因消费过慢被断开）时发送 reset 事件，
+// 客户端应丢弃已显示的日志并重新连接以获取回放。
+func (h *Handler) StreamDeploymentLogs(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	deployment, err := h.deployService.GetByID(id)
+	if err != nil {
+		response.NotFound(c, "部署记录不存在")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	replay, lines, cancel, live := h.deployService.SubscribeLogs(deployment)
+	if !live {
+		// 部署可能尚未开始（等待审批）、在其他实例上执行或因服务重启中断，此时 Output 不完整
+		if !deploymentFinished(deployment.Status) {
+			c.SSEvent("reset", deployment.Status)
+			return
+		}
+		for _, line := range strings.Split(strings.TrimRight(deployment.Output, "\n"), "\n") {
+			c.SSEvent("log", line)
+		}
+		c.SSEvent("end", deployment.Status)
+		return
+	}
+	defer cancel()
+
+	for _, line := range replay {
+		c.SSEvent("log", line)
+	}
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// 通道关闭既可能是部署结束，也可能是订阅者消费过慢被断开，
+				// 只有确认部署已结束才发送 end，否则通知客户端重新订阅
+				if latest, err := h.deployService.GetByID(id); err == nil && deploymentFinished(latest.Status) {
+					c.SSEvent("end", latest.Status)
+				} else {
+					c.SSEvent("reset", deployment.Status)
+				}
+				return false
+			}
+			c.SSEvent("log", line)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// deploymentFinished 判断部署是否处于终态：成功、失败、已取消或已驳回
+func deploymentFinished(status int) bool {
+	switch status {
+	case 2, 3, 5, 7:
+		return true
+	}
+	return false
+}
+
 func (h *Handler) StartDeployment(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -218,12 +547,195 @@ func (h *Handler) StartDeployment(c *gin.Context) {
 		return
 	}
 
-	if err := h.deployService.StartDeploy(id); err != nil {
+	var req struct {
+		FreezeOverrideReason string `json:"freeze_override_reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
+	queued, err := h.deployService.StartDeploy(id, freezeOverride(c, req.FreezeOverrideReason))
+	if err != nil {
+		if handleFreezeError(c, err) {
+			return
+		}
+		switch err {
+		case service.ErrDeployNotFound:
+			response.NotFound(c, "部署记录不存在")
+		case service.ErrAppNotFound:
+			response.NotFound(c, "应用不存在")
+		case service.ErrDeployNotPending:
+			response.Error(c, 3002, "部署不是待执行状态")
+		case service.ErrDeployAwaitingApproval:
+			response.Error(c, 3009, "部署等待审批，审批通过后才能执行")
+		case service.ErrAppNoHosts:
+			response.Error(c, 3003, "应用未关联主机")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	if queued {
+		response.SuccessWithMessage(c, "该应用环境已有部署在执行，已加入队列", gin.H{"queued": true})
+		return
+	}
+	response.SuccessWithMessage(c, "部署已启动", gin.H{"queued": false})
+}
+
+func (h *Handler) ListDeployQueue(c *gin.Context) {
+	var appID, envID *uuid.UUID
+	if aid := c.Query("app_id"); aid != "" {
+		if id, err := uuid.Parse(aid); err == nil {
+			appID = &id
+		}
+	}
+	if eid := c.Query("env_id"); eid != "" {
+		if id, err := uuid.Parse(eid); err == nil {
+			envID = &id
+		}
+	}
+
+	deployments, err := h.deployService.ListQueue(appID, envID)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, deployments)
+}
+
+// GetDeploymentStats 统计 from 至 to（含）之间结束的部署，日期格式 2006-01-02，默认最近 30 天
+func (h *Handler) GetDeploymentStats(c *gin.Context) {
+	today := time.Now()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	q := service.DeployStatsQuery{
+		From: today.AddDate(0, 0, -29),
+		To:   today.AddDate(0, 0, 1),
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			response.BadRequest(c, "无效的开始日期")
+			return
+		}
+		q.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			response.BadRequest(c, "无效的结束日期")
+			return
+		}
+		q.To = t.AddDate(0, 0, 1)
+	}
+	if aid := c.Query("app_id"); aid != "" {
+		if id, err := uuid.Parse(aid); err == nil {
+			q.AppID = &id
+		}
+	}
+	if eid := c.Query("env_id"); eid != "" {
+		if id, err := uuid.Parse(eid); err == nil {
+			q.EnvID = &id
+		}
+	}
+
+	stats, err := h.statsService.Stats(&q)
+	if err != nil {
+		if err == service.ErrStatsRange {
+			response.BadRequest(c, "统计区间无效，最长 366 天")
+			return
+		}
 		response.ServerError(c, err.Error())
 		return
 	}
 
-	response.SuccessWithMessage(c, "部署已启动", nil)
+	response.Success(c, stats)
+}
+
+func (h *Handler) CancelQueuedDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.deployService.CancelQueued(id); err != nil {
+		switch err {
+		case service.ErrDeployNotFound:
+			response.NotFound(c, "部署记录不存在")
+		case service.ErrDeployNotQueued:
+			response.Error(c, 3007, "部署不在队列中")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.SuccessWithMessage(c, "已移出队列", nil)
+}
+
+func (h *Handler) PauseDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.deployService.Pause(id); err != nil {
+		handleDeployControlError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "部署将在当前批次完成后暂停", nil)
+}
+
+func (h *Handler) ResumeDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.deployService.Resume(id); err != nil {
+		handleDeployControlError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "部署已继续", nil)
+}
+
+func (h *Handler) CancelDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.deployService.Cancel(id); err != nil {
+		handleDeployControlError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "部署已取消", nil)
+}
+
+func handleDeployControlError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrDeployNotFound:
+		response.NotFound(c, "部署记录不存在")
+	case service.ErrDeployNotRunning:
+		response.Error(c, 3004, "部署未在运行")
+	case service.ErrDeployNotPaused:
+		response.Error(c, 3005, "部署未处于暂停或等待晋级状态")
+	case service.ErrDeployFinished:
+		response.Error(c, 3008, "部署已结束")
+	default:
+		response.ServerError(c, err.Error())
+	}
 }
 
 func (h *Handler) Rollback(c *gin.Context) {
@@ -240,13 +752,213 @@ func (h *Handler) Rollback(c *gin.Context) {
 	claims := middleware.GetCurrentUser(c)
 	deployment, err := h.deployService.Rollback(req.AppID, req.TargetDeployID, claims.UserID)
 	if err != nil {
-		response.ServerError(c, err.Error())
+		switch err {
+		case service.ErrDeployNotFound:
+			response.NotFound(c, "目标部署记录不存在")
+		case service.ErrRollbackUnavailable:
+			response.Error(c, 3006, "目标部署没有可回滚的发布版本")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, deployment)
+}
+
+// RetryDeployment 在失败或取消的部署上重试未成功的主机，host_ids 为空时重试全部未成功的主机
+func (h *Handler) RetryDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.RetryDeployRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
+	deployment, err := h.deployService.Retry(id, &req, freezeOverride(c, req.FreezeOverrideReason))
+	if err != nil {
+		if handleFreezeError(c, err) {
+			return
+		}
+		switch err {
+		case service.ErrDeployNotFound:
+			response.NotFound(c, "部署记录不存在")
+		case service.ErrAppNotFound:
+			response.NotFound(c, "应用不存在")
+		case service.ErrDeployNotRetryable:
+			response.Error(c, 3019, "只能重试同一应用同一环境下最新的、失败或已取消的主机部署")
+		case service.ErrRetryHosts:
+			response.Error(c, 3019, "没有可重试的主机，或指定的主机在本次部署中已成功")
+		case service.ErrDeployBusy:
+			response.Error(c, 3020, "该应用有其他部署正在执行，请稍后重试")
+		case service.ErrConfigChanged:
+			response.Error(c, 3019, "部署后配置已变更，请重新创建部署")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.SuccessWithMessage(c, "已开始重试", deployment)
+}
+
+// PromoteDeployment 将成功的部署晋级到目标环境
+func (h *Handler) PromoteDeployment(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.PromoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	deployment, err := h.promoteService.Promote(id, &req, claims.UserID, freezeOverride(c, req.FreezeOverrideReason))
+	if err != nil {
+		var missing *service.MissingConfigError
+		switch {
+		case errors.As(err, &missing):
+			response.Error(c, 3016, fmt.Sprintf("目标环境 %s 缺少配置项: %s", missing.Env, strings.Join(missing.Keys, ", ")))
+		case err == service.ErrDeployNotFound:
+			response.NotFound(c, "部署记录不存在")
+		case err == service.ErrEnvNotFound:
+			response.NotFound(c, "环境不存在")
+		case err == service.ErrAppNotFound:
+			response.NotFound(c, "应用不存在")
+		case err == service.ErrPromoteSource:
+			response.Error(c, 3017, "只能晋级成功的部署")
+		case err == service.ErrPromoteSameEnv:
+			response.Error(c, 3017, "部署已在目标环境中")
+		case err == service.ErrPromoteTarget:
+			response.Error(c, 3018, "无法确定目标环境中的应用，请指定 app_id")
+		case err == service.ErrPromoteSourceConfig:
+			response.Error(c, 3022, "无法确定来源部署的应用或环境，不能校验配置项")
+		default:
+			if !handleFreezeError(c, err) {
+				response.ServerError(c, err.Error())
+			}
+		}
 		return
 	}
 
 	response.Success(c, deployment)
 }
 
+// Approval handlers
+func (h *Handler) ListApprovals(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	approvals, err := h.approvalService.ListApprovals(id)
+	if err != nil {
+		handleApprovalError(c, err)
+		return
+	}
+
+	response.Success(c, approvals)
+}
+
+func (h *Handler) ApproveDeployment(c *gin.Context) {
+	h.decideDeployment(c, true)
+}
+
+func (h *Handler) RejectDeployment(c *gin.Context) {
+	h.decideDeployment(c, false)
+}
+
+func (h *Handler) decideDeployment(c *gin.Context, approve bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	decide := h.approvalService.Reject
+	message := "已驳回部署"
+	if approve {
+		decide = h.approvalService.Approve
+		message = "已审批通过"
+	}
+
+	deployment, err := decide(id, claims, req.Comment, c.ClientIP())
+	if err != nil {
+		handleApprovalError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, message, deployment)
+}
+
+func handleApprovalError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrDeployNotFound:
+		response.NotFound(c, "部署记录不存在")
+	case service.ErrDeployNotAwaitingApproval:
+		response.Error(c, 3010, "部署不在待审批状态")
+	case service.ErrApprovalNotAllowed:
+		response.Forbidden(c, "无权审批该部署")
+	case service.ErrApprovalDuplicate:
+		response.Error(c, 3011, "已对该部署做出过审批")
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
+// freezeOverride 请求中带有强制部署原因时，以当前用户构造封版期强制部署信息
+func freezeOverride(c *gin.Context, reason string) *service.FreezeOverride {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil
+	}
+	claims := middleware.GetCurrentUser(c)
+	return &service.FreezeOverride{
+		UserID:   claims.UserID,
+		Username: claims.Username,
+		RoleCode: claims.RoleCode,
+		Reason:   reason,
+		IP:       c.ClientIP(),
+	}
+}
+
+// handleFreezeError 处理封版相关错误，已写入响应时返回 true
+func handleFreezeError(c *gin.Context, err error) bool {
+	var frozen *service.FrozenError
+	if errors.As(err, &frozen) {
+		response.Error(c, 3012, fmt.Sprintf("环境处于封版期「%s」，至 %s 结束", frozen.Window.Name, frozen.End.Format("2006-01-02 15:04")))
+		return true
+	}
+	if err == service.ErrFreezeOverrideForbidden {
+		response.Forbidden(c, "仅管理员可在封版期强制部署")
+		return true
+	}
+	return false
+}
+
 // Environment handlers
 func (h *Handler) ListEnvironments(c *gin.Context) {
 	envs, err := h.envService.List()
@@ -258,6 +970,177 @@ func (h *Handler) ListEnvironments(c *gin.Context) {
 	response.Success(c, envs)
 }
 
+func (h *Handler) GetApprovalPolicy(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	policy, err := h.approvalService.GetPolicy(id)
+	if err != nil {
+		if err == service.ErrEnvNotFound {
+			response.NotFound(c, "环境不存在")
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, policy)
+}
+
+func (h *Handler) UpdateApprovalPolicy(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.ApprovalPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	policy, err := h.approvalService.SavePolicy(id, &req, middleware.GetCurrentUser(c), c.ClientIP())
+	if err != nil {
+		switch err {
+		case service.ErrEnvNotFound:
+			response.NotFound(c, "环境不存在")
+		case service.ErrApprovalPolicyInvalid:
+			response.BadRequest(c, "启用审批时需指定审批角色或用户组")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, policy)
+}
+
+// Freeze window handlers
+func (h *Handler) ListFreezeWindows(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	windows, err := h.freezeService.List(id)
+	if err != nil {
+		handleFreezeWindowError(c, err)
+		return
+	}
+
+	response.Success(c, windows)
+}
+
+func (h *Handler) CreateFreezeWindow(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.FreezeWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	window, err := h.freezeService.Create(id, &req, claims.UserID)
+	if err != nil {
+		handleFreezeWindowError(c, err)
+		return
+	}
+
+	response.Success(c, window)
+}
+
+func (h *Handler) UpdateFreezeWindow(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+	windowID, err := uuid.Parse(c.Param("windowId"))
+	if err != nil {
+		response.BadRequest(c, "无效的封版窗口ID")
+		return
+	}
+
+	var req service.FreezeWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	window, err := h.freezeService.Update(id, windowID, &req)
+	if err != nil {
+		handleFreezeWindowError(c, err)
+		return
+	}
+
+	response.Success(c, window)
+}
+
+func (h *Handler) DeleteFreezeWindow(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+	windowID, err := uuid.Parse(c.Param("windowId"))
+	if err != nil {
+		response.BadRequest(c, "无效的封版窗口ID")
+		return
+	}
+
+	if err := h.freezeService.Delete(id, windowID); err != nil {
+		handleFreezeWindowError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "删除成功", nil)
+}
+
+// ListUpcomingFreezes 列出当前及未来 days 天内的封版时间段，可按 env_id 过滤，供前端提示
+func (h *Handler) ListUpcomingFreezes(c *gin.Context) {
+	var envID *uuid.UUID
+	if eid := c.Query("env_id"); eid != "" {
+		if id, err := uuid.Parse(eid); err == nil {
+			envID = &id
+		}
+	}
+	days := getIntParam(c, "days", 14)
+	if days < 1 || days > 90 {
+		days = 14
+	}
+
+	occurrences, err := h.freezeService.Upcoming(envID, days)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, occurrences)
+}
+
+func handleFreezeWindowError(c *gin.Context, err error) {
+	switch err {
+	case service.ErrEnvNotFound:
+		response.NotFound(c, "环境不存在")
+	case service.ErrFreezeWindowNotFound:
+		response.NotFound(c, "封版窗口不存在")
+	case service.ErrFreezeWindowInvalid:
+		response.BadRequest(c, "无效的封版窗口")
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
 // Helper
 func getIntParam(c *gin.Context, key string, defaultVal int) int {
 	val := c.Query(key)
@@ -1,6 +1,9 @@
 package monitor
 
 import (
+	"strconv"
+	"time"
+
 	"devops/internal/pkg/response"
 	"devops/internal/service"
 
@@ -12,17 +15,20 @@ type Handler struct {
 	hostService      *service.HostService
 	hostGroupService *service.HostGroupService
 	hostTagService   *service.HostTagService
+	metricService    *service.HostMetricService
 }
 
 func NewHandler(
 	hostService *service.HostService,
 	hostGroupService *service.HostGroupService,
 	hostTagService *service.HostTagService,
+	metricService *service.HostMetricService,
 ) *Handler {
 	return &Handler{
 		hostService:      hostService,
 		hostGroupService: hostGroupService,
 		hostTagService:   hostTagService,
+		metricService:    metricService,
 	}
 }
 
@@ -35,6 +41,7 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 		hosts.PUT("/:id", h.UpdateHost)
 		hosts.DELETE("/:id", h.DeleteHost)
 		hosts.POST("/:id/test", h.TestConnection)
+		hosts.GET("/:id/metrics", h.GetHostMetrics)
 	}
 
 	groups := r.Group("/host-groups")
@@ -272,7 +279,67 @@ func (h *Handler) DeleteHostTag(c *gin.Context) {
 	response.SuccessWithMessage(c, "删除成功", nil)
 }
 
+// GetHostMetrics 查询主机指标序列。from/to 为 unix 秒或 RFC3339，默认最近 1 小时；
+// step 为秒数或 5m 这样的时长，默认按时间范围自动选择
+func (h *Handler) GetHostMetrics(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	to, err := parseTimeParam(c.Query("to"), time.Now())
+	if err != nil {
+		response.BadRequest(c, "无效的结束时间")
+		return
+	}
+	from, err := parseTimeParam(c.Query("from"), to.Add(-time.Hour))
+	if err != nil {
+		response.BadRequest(c, "无效的开始时间")
+		return
+	}
+	var step time.Duration
+	if s := c.Query("step"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			step = time.Duration(n) * time.Second
+		} else if step, err = time.ParseDuration(s); err != nil {
+			response.BadRequest(c, "无效的 step")
+			return
+		}
+	}
+
+	result, err := h.metricService.Query(id, &service.HostMetricQuery{
+		Metric: c.DefaultQuery("metric", "cpu"),
+		From:   from,
+		To:     to,
+		Step:   step,
+	})
+	if err != nil {
+		switch err {
+		case service.ErrHostNotFound:
+			response.NotFound(c, "主机不存在")
+		case service.ErrMetricQueryInvalid:
+			response.BadRequest(c, "无效的查询参数：metric 需为 cpu、memory、disk、network 或 load，from 需早于 to，且数据点不超过 11000 个")
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, result)
+}
+
 // Helper function
+func parseTimeParam(s string, defaultVal time.Time) (time.Time, error) {
+	if s == "" {
+		return defaultVal, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func getIntParam(c *gin.Context, key string, defaultVal int) int {
 	val := c.Query(key)
 	if val == "" {
@@ -0,0 +1,263 @@
+package pipeline
+
+import (
+	"errors"
+	"strconv"
+
+	"devops/internal/middleware"
+	"devops/internal/pkg/response"
+	"devops/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	pipelineService *service.PipelineService
+}
+
+func NewHandler(pipelineService *service.PipelineService) *Handler {
+	return &Handler{pipelineService: pipelineService}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	apps := r.Group("/apps")
+	{
+		apps.GET("/:id/pipeline", h.GetPipeline)
+		apps.PUT("/:id/pipeline", middleware.RequireDeveloper(), h.SavePipeline)
+		apps.GET("/:id/pipeline/history", h.GetPipelineHistory)
+		apps.GET("/:id/pipeline/history/:version", h.GetPipelineVersion)
+		apps.GET("/:id/pipeline/runs", h.ListRuns)
+		apps.POST("/:id/pipeline/runs", middleware.RequireDeveloper(), h.RunPipeline)
+	}
+
+	runs := r.Group("/pipeline-runs")
+	{
+		runs.GET("/:id", h.GetRun)
+		runs.POST("/:id/cancel", middleware.RequireDeveloper(), h.CancelRun)
+		runs.POST("/:id/approve", middleware.RequireOperator(), h.ApproveRun)
+		runs.POST("/:id/reject", middleware.RequireOperator(), h.RejectRun)
+	}
+}
+
+func (h *Handler) GetPipeline(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	pipeline, err := h.pipelineService.Get(appID)
+	if err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.Success(c, pipeline)
+}
+
+func (h *Handler) SavePipeline(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.SavePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	pipeline, err := h.pipelineService.Save(appID, &req, claims.UserID, claims.Username)
+	if err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.Success(c, pipeline)
+}
+
+func (h *Handler) GetPipelineHistory(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	limit := getIntParam(c, "limit", 20)
+	histories, err := h.pipelineService.GetHistory(appID, limit)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.Success(c, histories)
+}
+
+func (h *Handler) GetPipelineVersion(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		response.BadRequest(c, "无效的版本号")
+		return
+	}
+
+	history, err := h.pipelineService.GetVersion(appID, version)
+	if err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.Success(c, history)
+}
+
+func (h *Handler) ListRuns(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	page := getIntParam(c, "page", 1)
+	pageSize := getIntParam(c, "page_size", 20)
+	runs, total, err := h.pipelineService.ListRuns(appID, page, pageSize)
+	if err != nil {
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessPage(c, runs, total, page, pageSize)
+}
+
+func (h *Handler) RunPipeline(c *gin.Context) {
+	appID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req service.RunPipelineRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	run, err := h.pipelineService.Run(appID, &req, "manual", claims.UserID)
+	if err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.Success(c, run)
+}
+
+func (h *Handler) GetRun(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	run, err := h.pipelineService.GetRun(id)
+	if err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.Success(c, run)
+}
+
+func (h *Handler) CancelRun(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.pipelineService.CancelRun(id); err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, "已取消流水线", nil)
+}
+
+func (h *Handler) ApproveRun(c *gin.Context) {
+	h.decideRun(c, true)
+}
+
+func (h *Handler) RejectRun(c *gin.Context) {
+	h.decideRun(c, false)
+}
+
+func (h *Handler) decideRun(c *gin.Context, approve bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+	}
+
+	claims := middleware.GetCurrentUser(c)
+	decide := h.pipelineService.Reject
+	message := "已驳回"
+	if approve {
+		decide = h.pipelineService.Approve
+		message = "已确认通过"
+	}
+
+	if err := decide(id, claims.Username, req.Comment); err != nil {
+		handlePipelineError(c, err)
+		return
+	}
+
+	response.SuccessWithMessage(c, message, nil)
+}
+
+func handlePipelineError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPipelineInvalid):
+		response.Error(c, 3013, "流水线定义无效: "+err.Error())
+	case errors.Is(err, service.ErrAppNotFound):
+		response.NotFound(c, "应用不存在")
+	case errors.Is(err, service.ErrPipelineNotFound):
+		response.NotFound(c, "流水线不存在")
+	case errors.Is(err, service.ErrPipelineRunNotFound):
+		response.NotFound(c, "流水线执行记录不存在")
+	case errors.Is(err, service.ErrPipelineRunNotWaiting):
+		response.Error(c, 3014, "流水线不在等待确认状态")
+	case errors.Is(err, service.ErrPipelineRunFinished):
+		response.Error(c, 3015, "流水线已结束")
+	default:
+		response.ServerError(c, err.Error())
+	}
+}
+
+func getIntParam(c *gin.Context, key string, defaultVal int) int {
+	val := c.Query(key)
+	if val == "" {
+		return defaultVal
+	}
+	if n, err := strconv.Atoi(val); err == nil {
+		return n
+	}
+	return defaultVal
+}
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"devops/internal/middleware"
+	"devops/internal/pkg/response"
+	"devops/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxPayloadSize webhook 请求体的大小上限
+const maxPayloadSize = 10 << 20
+
+type Handler struct {
+	webhookService *service.GitWebhookService
+}
+
+func NewHandler(webhookService *service.GitWebhookService) *Handler {
+	return &Handler{webhookService: webhookService}
+}
+
+// RegisterRoutes 注册 webhook 接收地址，不经过 JWT 认证，由签名校验请求来源
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	r.POST("/git/:appCode", h.ReceiveGit)
+}
+
+// RegisterSecretRoutes 注册 webhook 密钥管理接口，需要登录
+func (h *Handler) RegisterSecretRoutes(r *gin.RouterGroup) {
+	r.POST("/apps/:id/webhook-secret", middleware.RequireOperator(), h.GenerateSecret)
+	r.DELETE("/apps/:id/webhook-secret", middleware.RequireOperator(), h.DeleteSecret)
+}
+
+func (h *Handler) ReceiveGit(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPayloadSize))
+	if err != nil {
+		response.BadRequest(c, "请求体过大或读取失败")
+		return
+	}
+
+	result, err := h.webhookService.HandlePush(c.Param("appCode"), c.Request.Header, body, c.ClientIP())
+	if err != nil {
+		var frozen *service.FrozenError
+		switch {
+		case errors.As(err, &frozen):
+			response.Error(c, 3012, fmt.Sprintf("环境处于封版期「%s」，至 %s 结束", frozen.Window.Name, frozen.End.Format("2006-01-02 15:04")))
+		case err == service.ErrAppNotFound:
+			response.NotFound(c, "应用不存在")
+		case err == service.ErrWebhookNotConfigured:
+			response.Forbidden(c, "应用未启用 webhook")
+		case err == service.ErrWebhookSignature:
+			response.Unauthorized(c, "webhook 签名校验失败")
+		case err == service.ErrWebhookDuplicate:
+			response.Error(c, 3021, "该 webhook 投递已处理过，拒绝重复请求")
+		case err == service.ErrWebhookProvider:
+			response.BadRequest(c, "不支持的 webhook 来源")
+		case errors.Is(err, service.ErrWebhookPayload):
+			response.BadRequest(c, err.Error())
+		default:
+			response.ServerError(c, err.Error())
+		}
+		return
+	}
+
+	response.Success(c, result)
+}
+
+func (h *Handler) GenerateSecret(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	secret, err := h.webhookService.GenerateSecret(id)
+	if err != nil {
+		if err == service.ErrAppNotFound {
+			response.NotFound(c, "应用不存在")
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "密钥仅显示一次，请妥善保存", gin.H{"secret": secret})
+}
+
+func (h *Handler) DeleteSecret(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		response.BadRequest(c, "无效的ID")
+		return
+	}
+
+	if err := h.webhookService.DeleteSecret(id); err != nil {
+		if err == service.ErrAppNotFound {
+			response.NotFound(c, "应用不存在")
+			return
+		}
+		response.ServerError(c, err.Error())
+		return
+	}
+
+	response.SuccessWithMessage(c, "已停用 webhook", nil)
+}
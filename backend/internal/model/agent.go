@@ -0,0 +1,145 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Agent 通过引导令牌注册的主机 Agent，注册时关联或创建主机，之后使用各自的凭证访问 Agent 接口
+type Agent struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	HostID           *uuid.UUID `json:"host_id" gorm:"type:uuid;index"`
+	Host             *Host      `json:"host,omitempty" gorm:"foreignKey:HostID"`
+	Hostname         string     `json:"hostname" gorm:"size:100;uniqueIndex:idx_agent_identity"`
+	IP               string     `json:"ip" gorm:"size:50;uniqueIndex:idx_agent_identity"` // 注册时的来源 IP
+	OS               string     `json:"os" gorm:"size:50"`
+	Platform         string     `json:"platform" gorm:"size:50"`
+	Arch             string     `json:"arch" gorm:"size:20"`
+	TokenHash        string     `json:"-" gorm:"size:64;index"`    // 凭证的 SHA-256，吊销后为空
+	PendingTokenHash string     `json:"-" gorm:"size:64;index"`    // 轮换中的新凭证，Agent 首次使用后替换 TokenHash
+	RotateRequested  bool       `json:"rotate_requested"`          // 管理员要求轮换凭证，Agent 下次请求时收到通知
+	RevokedAt        *time.Time `json:"revoked_at"`                // 凭证被吊销的时间，吊销后需重新注册
+	LastSeenAt       time.Time  `json:"last_seen_at" gorm:"index"` // 最近一次上报指标或拉取命令的时间
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (a *Agent) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// AgentBootstrapToken 管理员创建的一次性引导令牌，Agent 使用它注册并换取长期凭证
+type AgentBootstrapToken struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	TokenHash   string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Description string     `json:"description" gorm:"size:255"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	HostID      *uuid.UUID `json:"host_id" gorm:"type:uuid"`  // 指定时 Agent 只能注册到该主机，并替换主机上已有的 Agent
+	AgentID     *uuid.UUID `json:"agent_id" gorm:"type:uuid"` // 使用该令牌注册的 Agent
+	CreatedBy   uuid.UUID  `json:"created_by" gorm:"type:uuid"`
+	Username    string     `json:"username" gorm:"size:50"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (t *AgentBootstrapToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// HostMetric Agent 上报的一次主机指标
+type HostMetric struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	AgentID        uuid.UUID  `json:"agent_id" gorm:"type:uuid;index"`
+	HostID         *uuid.UUID `json:"host_id" gorm:"type:uuid;index:idx_host_metric_time"`
+	CPUPercent     float64    `json:"cpu_percent"`
+	CPUCores       int        `json:"cpu_cores"`
+	MemTotal       uint64     `json:"mem_total"`
+	MemUsed        uint64     `json:"mem_used"`
+	MemPercent     float64    `json:"mem_percent"`
+	DiskTotal      uint64     `json:"disk_total"`
+	DiskUsed       uint64     `json:"disk_used"`
+	DiskPercent    float64    `json:"disk_percent"`
+	NetBytesSent   uint64     `json:"net_bytes_sent"` // 累计值，速率需由相邻两次上报相减得到
+	NetBytesRecv   uint64     `json:"net_bytes_recv"`
+	NetPacketsSent uint64     `json:"net_packets_sent"`
+	NetPacketsRecv uint64     `json:"net_packets_recv"`
+	Load1          float64    `json:"load1"`
+	Load5          float64    `json:"load5"`
+	Load15         float64    `json:"load15"`
+	Uptime         uint64     `json:"uptime"` // seconds
+	CollectedAt    time.Time  `json:"collected_at" gorm:"index:idx_host_metric_time"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (m *HostMetric) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// HostMetricRollup 主机指标的降采样数据，Resolution 为 60（1 分钟）或 3600（1 小时）。
+// 仪表类指标为区间内的样本平均值，网络流量为区间内的增量
+type HostMetricRollup struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	HostID         uuid.UUID `json:"host_id" gorm:"type:uuid;uniqueIndex:idx_host_metric_rollup"`
+	Resolution     int       `json:"resolution" gorm:"uniqueIndex:idx_host_metric_rollup;index:idx_host_metric_rollup_time"` // seconds
+	BucketStart    time.Time `json:"bucket_start" gorm:"uniqueIndex:idx_host_metric_rollup;index:idx_host_metric_rollup_time"`
+	Samples        int       `json:"samples"`
+	CPUPercent     float64   `json:"cpu_percent"`
+	CPUMax         float64   `json:"cpu_max"`
+	MemPercent     float64   `json:"mem_percent"`
+	MemUsed        uint64    `json:"mem_used"`
+	MemTotal       uint64    `json:"mem_total"`
+	DiskPercent    float64   `json:"disk_percent"`
+	DiskUsed       uint64    `json:"disk_used"`
+	DiskTotal      uint64    `json:"disk_total"`
+	NetBytesSent   uint64    `json:"net_bytes_sent"`
+	NetBytesRecv   uint64    `json:"net_bytes_recv"`
+	NetPacketsSent uint64    `json:"net_packets_sent"`
+	NetPacketsRecv uint64    `json:"net_packets_recv"`
+	Load1          float64   `json:"load1"`
+	Load5          float64   `json:"load5"`
+	Load15         float64   `json:"load15"`
+}
+
+func (m *HostMetricRollup) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+// AgentCommand 下发给主机 Agent 执行的命令，Agent 轮询拉取并回报结果
+type AgentCommand struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	HostID       uuid.UUID  `json:"host_id" gorm:"type:uuid;index;not null"`
+	AgentID      *uuid.UUID `json:"agent_id" gorm:"type:uuid"` // 拉取该命令的 Agent
+	Command      string     `json:"command" gorm:"type:text;not null"`
+	Timeout      int        `json:"timeout" gorm:"default:60"`     // seconds
+	Status       int        `json:"status" gorm:"default:0;index"` // 0: pending, 1: dispatched, 2: success, 3: failed, 4: timeout
+	Output       string     `json:"output" gorm:"type:text"`
+	ExitCode     *int       `json:"exit_code"`
+	Error        string     `json:"error" gorm:"size:500"`
+	CreatedBy    uuid.UUID  `json:"created_by" gorm:"type:uuid"`
+	Username     string     `json:"username" gorm:"size:50"`
+	DispatchedAt *time.Time `json:"dispatched_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (c *AgentCommand) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
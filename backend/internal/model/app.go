@@ -8,27 +8,52 @@ import (
 )
 
 type Application struct {
-	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
-	Name        string         `json:"name" gorm:"size:100;not null;index"`
-	Code        string         `json:"code" gorm:"uniqueIndex;size:50;not null"`
-	Type        string         `json:"type" gorm:"size:20;not null"` // web, api, service, job
-	Language    string         `json:"language" gorm:"size:20"`       // go, java, python, nodejs
-	RepoURL     string         `json:"repo_url" gorm:"size:255"`
-	Branch      string         `json:"branch" gorm:"size:50;default:'main'"`
-	DeployPath  string         `json:"deploy_path" gorm:"size:255"`
-	BuildCmd    string         `json:"build_cmd" gorm:"size:500"`
-	StartCmd    string         `json:"start_cmd" gorm:"size:500"`
-	StopCmd     string         `json:"stop_cmd" gorm:"size:500"`
-	HealthCheck string         `json:"health_check" gorm:"size:255"` // health check URL
-	EnvID       *uuid.UUID     `json:"env_id" gorm:"type:uuid;index"`
-	Env         *Environment   `json:"env,omitempty" gorm:"foreignKey:EnvID"`
-	Hosts       []Host         `json:"hosts,omitempty" gorm:"many2many:app_hosts;"`
-	Status      int            `json:"status" gorm:"default:1;index"` // 1: enabled, 0: disabled
-	Description string         `json:"description" gorm:"size:255"`
-	CreatedBy   uuid.UUID      `json:"created_by" gorm:"type:uuid"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID             uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	Name           string         `json:"name" gorm:"size:100;not null;index"`
+	Code           string         `json:"code" gorm:"uniqueIndex;size:50;not null"`
+	Type           string         `json:"type" gorm:"size:20;not null"` // web, api, service, job
+	Language       string         `json:"language" gorm:"size:20"`      // go, java, python, nodejs
+	RepoURL        string         `json:"repo_url" gorm:"size:255"`
+	Branch         string         `json:"branch" gorm:"size:50;default:'main'"`
+	DeployPath     string         `json:"deploy_path" gorm:"size:255"`
+	BuildCmd       string         `json:"build_cmd" gorm:"size:500"`
+	StartCmd       string         `json:"start_cmd" gorm:"size:500"`
+	StopCmd        string         `json:"stop_cmd" gorm:"size:500"`
+	HealthCheck    string         `json:"health_check" gorm:"size:255"`                   // health check URL
+	HealthStatus   int            `json:"health_status"`                                  // 期望的 HTTP 状态码，0 表示任意 2xx
+	HealthBody     string         `json:"health_body" gorm:"size:255"`                    // 响应体需包含的内容，为空不检查
+	HealthRetries  int            `json:"health_retries" gorm:"default:5"`                // 健康检查最多尝试次数
+	HealthInterval int            `json:"health_interval" gorm:"default:3"`               // 两次尝试间隔秒数
+	HealthTimeout  int            `json:"health_timeout" gorm:"default:10"`               // 单次请求超时秒数
+	AutoRollback   bool           `json:"auto_rollback"`                                  // 健康检查失败时自动回滚到上一个成功的发布
+	DeployStrategy string         `json:"deploy_strategy" gorm:"size:20;default:'all'"`   // all 全量, rolling 按批滚动, canary 金丝雀
+	BatchSize      int            `json:"batch_size" gorm:"default:1"`                    // rolling 每批主机数
+	CanaryCount    int            `json:"canary_count" gorm:"default:1"`                  // canary 首批主机数
+	CanaryPromote  string         `json:"canary_promote" gorm:"size:20;default:'manual'"` // manual 手动晋级, timed 定时晋级
+	CanaryWait     int            `json:"canary_wait" gorm:"default:300"`                 // timed 晋级前等待秒数
+	KeepReleases   int            `json:"keep_releases" gorm:"default:5"`                 // 主机上保留的发布目录数
+	KeepArtifacts  int            `json:"keep_artifacts" gorm:"default:20"`               // 产物存储中保留的构建产物数
+	K8sClusterID   *uuid.UUID     `json:"k8s_cluster_id" gorm:"type:uuid;index"`          // 设置后部署到该集群的工作负载，而不是 SSH 主机
+	K8sNamespace   string         `json:"k8s_namespace" gorm:"size:200"`                  // 工作负载所在命名空间
+	K8sKind        string         `json:"k8s_kind" gorm:"size:20;default:'Deployment'"`   // Deployment, StatefulSet, DaemonSet
+	K8sWorkload    string         `json:"k8s_workload" gorm:"size:200"`                   // 工作负载名称
+	K8sContainer   string         `json:"k8s_container" gorm:"size:200"`                  // 更新镜像的容器，为空时使用第一个容器
+	K8sImage       string         `json:"k8s_image" gorm:"size:255"`                      // 镜像仓库，为空时沿用容器当前镜像仅替换 tag
+	K8sTimeout     int            `json:"k8s_timeout" gorm:"default:300"`                 // 等待滚动更新完成的秒数
+	WebhookSecret  string         `json:"-" gorm:"size:255"`                              // Git webhook 密钥（加密存储），为空时不接收 webhook
+	AutoStart      bool           `json:"auto_start"`                                     // webhook 创建的部署是否立即开始执行
+	ConfigFormat   string         `json:"config_format" gorm:"size:20"`                   // 部署时注入配置中心的配置：空 不注入, env 环境变量文件, template 配置模板
+	ConfigPath     string         `json:"config_path" gorm:"size:255"`                    // 配置文件在主机上的路径，相对路径基于本次发布目录
+	ConfigTemplate string         `json:"config_template" gorm:"type:text"`               // template 格式的 Go 模板
+	EnvID          *uuid.UUID     `json:"env_id" gorm:"type:uuid;index"`
+	Env            *Environment   `json:"env,omitempty" gorm:"foreignKey:EnvID"`
+	Hosts          []Host         `json:"hosts,omitempty" gorm:"many2many:app_hosts;"`
+	Status         int            `json:"status" gorm:"default:1;index"` // 1: enabled, 0: disabled
+	Description    string         `json:"description" gorm:"size:255"`
+	CreatedBy      uuid.UUID      `json:"created_by" gorm:"type:uuid"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (a *Application) BeforeCreate(tx *gorm.DB) error {
@@ -58,22 +83,34 @@ func (e *Environment) BeforeCreate(tx *gorm.DB) error {
 }
 
 type Deployment struct {
-	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
-	AppID       uuid.UUID      `json:"app_id" gorm:"type:uuid;index;not null"`
-	App         *Application   `json:"app,omitempty" gorm:"foreignKey:AppID"`
-	Version     string         `json:"version" gorm:"size:50"`
-	CommitID    string         `json:"commit_id" gorm:"size:50"`
-	CommitMsg   string         `json:"commit_msg" gorm:"size:255"`
-	Branch      string         `json:"branch" gorm:"size:50"`
-	Type        string         `json:"type" gorm:"size:20;default:'deploy'"` // deploy, rollback
-	Status      int            `json:"status" gorm:"default:0"`              // 0: pending, 1: running, 2: success, 3: failed
-	Output      string         `json:"output" gorm:"type:text"`
-	HostResults string         `json:"host_results" gorm:"type:text"` // JSON array of per-host results
-	StartTime   *time.Time     `json:"start_time"`
-	EndTime     *time.Time     `json:"end_time"`
-	CreatedBy   uuid.UUID      `json:"created_by" gorm:"type:uuid"`
-	CreatedAt   time.Time      `json:"created_at" gorm:"index"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	ID             uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
+	AppID          uuid.UUID    `json:"app_id" gorm:"type:uuid;index;not null"`
+	App            *Application `json:"app,omitempty" gorm:"foreignKey:AppID"`
+	EnvID          *uuid.UUID   `json:"env_id" gorm:"type:uuid;index"` // 部署时应用所属环境，同一应用同一环境同时只执行一个部署
+	Version        string       `json:"version" gorm:"size:50"`
+	CommitID       string       `json:"commit_id" gorm:"size:50"`
+	CommitMsg      string       `json:"commit_msg" gorm:"size:255"`
+	CommitTime     *time.Time   `json:"commit_time"` // 提交时间，已知时用于统计从提交到上线的前置时间
+	Branch         string       `json:"branch" gorm:"size:50"`
+	Type           string       `json:"type" gorm:"size:20;default:'deploy'"` // deploy, rollback
+	Release        string       `json:"release" gorm:"size:128"`              // 主机上的发布目录名，回滚时切换回该目录；Kubernetes 应用为镜像 tag
+	ArtifactID     *uuid.UUID   `json:"artifact_id" gorm:"type:uuid"`         // 构建产物，应用未配置构建命令时为空
+	PromotedFrom   *uuid.UUID   `json:"promoted_from" gorm:"type:uuid;index"` // 晋级来源部署，沿用其版本、提交和构建产物
+	ConfigVersions string       `json:"config_versions" gorm:"type:text"`     // JSON array of DeployConfigVersion，本次部署下发的配置版本
+	Status         int          `json:"status" gorm:"default:0;index"`        // 0: pending, 1: running, 2: success, 3: failed, 4: queued, 5: cancelled, 6: awaiting_approval, 7: rejected
+	Output         string       `json:"output" gorm:"type:text"`
+	HostResults    string       `json:"host_results" gorm:"type:text"` // JSON array of per-host results
+	Strategy       string       `json:"strategy" gorm:"size:20"`       // all, rolling, canary
+	BatchTotal     int          `json:"batch_total"`
+	BatchCurrent   int          `json:"batch_current"`   // 当前执行的批次，从 1 开始
+	Paused         bool         `json:"paused"`          // 批次间暂停，等待继续或晋级
+	FreezeOverride bool         `json:"freeze_override"` // 管理员在封版期内强制执行，出队时不再检查封版
+	QueuedAt       *time.Time   `json:"queued_at"`       // 进入执行队列的时间，队列按此 FIFO 出队
+	StartTime      *time.Time   `json:"start_time"`
+	EndTime        *time.Time   `json:"end_time"`
+	CreatedBy      uuid.UUID    `json:"created_by" gorm:"type:uuid;index"`
+	CreatedAt      time.Time    `json:"created_at" gorm:"index"`
+	UpdatedAt      time.Time    `json:"updated_at"`
 }
 
 func (d *Deployment) BeforeCreate(tx *gorm.DB) error {
@@ -83,6 +120,117 @@ func (d *Deployment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// DeployHostResult 单台主机的部署结果，序列化后存入 Deployment.HostResults
+type DeployHostResult struct {
+	HostID    uuid.UUID  `json:"host_id"`
+	HostName  string     `json:"host_name"`
+	HostIP    string     `json:"host_ip"`
+	Status    string     `json:"status"`            // success, failed, cancelled, skipped
+	Step      string     `json:"step,omitempty"`    // 失败时所在步骤
+	Retries   int        `json:"retries,omitempty"` // 重试次数
+	Output    string     `json:"output"`
+	Error     string     `json:"error,omitempty"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+}
+
+// DeployConfigVersion 部署下发的一个配置项及其版本，序列化后存入 Deployment.ConfigVersions
+type DeployConfigVersion struct {
+	ConfigID uuid.UUID `json:"config_id"`
+	Key      string    `json:"key"`
+	AppCode  string    `json:"app_code,omitempty"` // 为空表示全局配置
+	Version  int       `json:"version"`
+}
+
+// Artifact 构建阶段产出的部署包（tar.gz）
+type Artifact struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	AppID        uuid.UUID `json:"app_id" gorm:"type:uuid;index;not null"`
+	DeploymentID uuid.UUID `json:"deployment_id" gorm:"type:uuid;index"`
+	Version      string    `json:"version" gorm:"size:50"`
+	CommitID     string    `json:"commit_id" gorm:"size:50"`
+	Branch       string    `json:"branch" gorm:"size:50"`
+	FileName     string    `json:"file_name" gorm:"size:255"`
+	StoragePath  string    `json:"storage_path" gorm:"size:500"` // 产物存储中的对象路径
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256" gorm:"size:64"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (a *Artifact) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// ApprovalPolicy 环境的部署审批策略，启用后该环境的部署需审批通过才能执行
+type ApprovalPolicy struct {
+	ID               uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
+	EnvID            uuid.UUID    `json:"env_id" gorm:"type:uuid;uniqueIndex;not null"`
+	Env              *Environment `json:"env,omitempty" gorm:"foreignKey:EnvID"`
+	Enabled          bool         `json:"enabled"`
+	ApproverRoles    string       `json:"approver_roles" gorm:"type:text"`  // JSON array of role codes
+	ApproverGroups   string       `json:"approver_groups" gorm:"type:text"` // JSON array of user group IDs
+	MinApprovals     int          `json:"min_approvals" gorm:"default:1"`
+	AllowSelfApprove bool         `json:"allow_self_approve" gorm:"default:false"` // 是否允许部署创建者审批自己的部署
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+}
+
+func (p *ApprovalPolicy) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// DeploymentApproval 部署的一条审批意见
+type DeploymentApproval struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	DeploymentID uuid.UUID `json:"deployment_id" gorm:"type:uuid;uniqueIndex:idx_deploy_approver;not null"`
+	UserID       uuid.UUID `json:"user_id" gorm:"type:uuid;uniqueIndex:idx_deploy_approver;not null"`
+	Username     string    `json:"username" gorm:"size:50"`
+	Decision     string    `json:"decision" gorm:"size:20;not null"` // approved, rejected
+	Comment      string    `json:"comment" gorm:"size:500"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (a *DeploymentApproval) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// FreezeWindow 环境的变更封版窗口，窗口内禁止创建和执行部署（管理员可填写原因强制执行）
+type FreezeWindow struct {
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	EnvID       uuid.UUID      `json:"env_id" gorm:"type:uuid;index;not null"`
+	Name        string         `json:"name" gorm:"size:100;not null"`
+	Type        string         `json:"type" gorm:"size:20;not null"` // once 一次性, weekly 每周重复
+	StartAt     *time.Time     `json:"start_at"`                     // once
+	EndAt       *time.Time     `json:"end_at"`                       // once
+	StartDay    int            `json:"start_day"`                    // weekly, 0: Sunday ... 6: Saturday
+	StartTime   string         `json:"start_time" gorm:"size:5"`     // weekly, HH:MM
+	EndDay      int            `json:"end_day"`                      // weekly
+	EndTime     string         `json:"end_time" gorm:"size:5"`       // weekly
+	Timezone    string         `json:"timezone" gorm:"size:50"`      // weekly, IANA 时区，为空时使用服务器时区
+	Enabled     bool           `json:"enabled"`
+	Description string         `json:"description" gorm:"size:255"`
+	CreatedBy   uuid.UUID      `json:"created_by" gorm:"type:uuid"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (f *FreezeWindow) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
+
 type DeployScript struct {
 	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
 	AppID     uuid.UUID      `json:"app_id" gorm:"type:uuid;index"`
@@ -102,3 +250,19 @@ func (s *DeployScript) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// WebhookDelivery 已处理的 Git webhook 投递，按来源和投递 ID 唯一，用于拒绝重放的请求
+type WebhookDelivery struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	AppID      uuid.UUID `json:"app_id" gorm:"type:uuid;index;not null"`
+	Provider   string    `json:"provider" gorm:"size:20;uniqueIndex:idx_webhook_delivery;not null"`
+	DeliveryID string    `json:"delivery_id" gorm:"size:100;uniqueIndex:idx_webhook_delivery;not null"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
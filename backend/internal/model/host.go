@@ -94,19 +94,23 @@ func (a *AlertRule) BeforeCreate(tx *gorm.DB) error {
 }
 
 type AlertHistory struct {
-	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
-	RuleID     uuid.UUID  `json:"rule_id" gorm:"type:uuid;index"`
-	RuleName   string     `json:"rule_name" gorm:"size:100"`
-	HostID     uuid.UUID  `json:"host_id" gorm:"type:uuid;index"`
-	HostName   string     `json:"host_name" gorm:"size:100"`
-	HostIP     string     `json:"host_ip" gorm:"size:50"`
-	Metric     string     `json:"metric" gorm:"size:50"`
-	Value      float64    `json:"value"`
-	Threshold  float64    `json:"threshold"`
-	Severity   string     `json:"severity" gorm:"size:20"`
-	Status     int        `json:"status" gorm:"default:0"` // 0: firing, 1: resolved
-	ResolvedAt *time.Time `json:"resolved_at"`
-	CreatedAt  time.Time  `json:"created_at" gorm:"index"`
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	RuleID      uuid.UUID  `json:"rule_id" gorm:"type:uuid;index"`
+	RuleName    string     `json:"rule_name" gorm:"size:100"`
+	HostID      uuid.UUID  `json:"host_id" gorm:"type:uuid;index"`
+	HostName    string     `json:"host_name" gorm:"size:100"`
+	HostIP      string     `json:"host_ip" gorm:"size:50"`
+	Metric      string     `json:"metric" gorm:"size:50"`
+	Value       float64    `json:"value"`
+	Threshold   float64    `json:"threshold"`
+	Severity    string     `json:"severity" gorm:"size:20"`
+	Status      int        `json:"status" gorm:"default:0"` // 0: firing, 1: resolved
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	Source      string     `json:"source" gorm:"size:20;default:'rule';index"` // rule, alertmanager
+	Fingerprint string     `json:"fingerprint" gorm:"size:64;index"`           // Alertmanager 告警指纹
+	Summary     string     `json:"summary" gorm:"size:500"`
+	Labels      string     `json:"labels" gorm:"type:text"` // Alertmanager 告警的标签，JSON 对象
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
 }
 
 func (a *AlertHistory) BeforeCreate(tx *gorm.DB) error {
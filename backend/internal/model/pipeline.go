@@ -0,0 +1,90 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Pipeline 应用的流水线定义，Content 为 YAML 格式的流水线描述
+type Pipeline struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	AppID     uuid.UUID      `json:"app_id" gorm:"type:uuid;uniqueIndex"`
+	Content   string         `json:"content" gorm:"type:text"`
+	Version   int            `json:"version" gorm:"default:1"`
+	CreatedBy uuid.UUID      `json:"created_by" gorm:"type:uuid"`
+	UpdatedBy uuid.UUID      `json:"updated_by" gorm:"type:uuid"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (p *Pipeline) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// PipelineHistory 流水线定义的每个版本
+type PipelineHistory struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	PipelineID uuid.UUID `json:"pipeline_id" gorm:"type:uuid;index"`
+	AppID      uuid.UUID `json:"app_id" gorm:"type:uuid;index"`
+	Content    string    `json:"content" gorm:"type:text"`
+	Version    int       `json:"version"`
+	Action     string    `json:"action" gorm:"size:20"` // create, update
+	CreatedBy  uuid.UUID `json:"created_by" gorm:"type:uuid"`
+	Username   string    `json:"username" gorm:"size:50"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+func (h *PipelineHistory) BeforeCreate(tx *gorm.DB) error {
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+	return nil
+}
+
+// PipelineRun 流水线的一次执行，Content 为执行时的流水线定义快照
+type PipelineRun struct {
+	ID              uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
+	AppID           uuid.UUID    `json:"app_id" gorm:"type:uuid;index"`
+	App             *Application `json:"app,omitempty" gorm:"foreignKey:AppID"`
+	PipelineVersion int          `json:"pipeline_version"`
+	Content         string       `json:"content" gorm:"type:text"`
+	Version         string       `json:"version" gorm:"size:50"`
+	Branch          string       `json:"branch" gorm:"size:50"`
+	CommitID        string       `json:"commit_id" gorm:"size:50"`
+	Trigger         string       `json:"trigger" gorm:"size:20;default:'manual'"` // manual, webhook
+	Variables       string       `json:"variables" gorm:"type:text"`              // JSON object
+	Status          int          `json:"status" gorm:"default:0;index"`           // 0: pending, 1: running, 2: success, 3: failed, 4: waiting, 5: cancelled
+	Steps           string       `json:"steps" gorm:"type:text"`                  // JSON array of PipelineStepResult
+	StartTime       *time.Time   `json:"start_time"`
+	EndTime         *time.Time   `json:"end_time"`
+	CreatedBy       uuid.UUID    `json:"created_by" gorm:"type:uuid"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+func (r *PipelineRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// PipelineStepResult 流水线中单个步骤的执行结果，序列化后存放在 PipelineRun.Steps 中
+type PipelineStepResult struct {
+	Stage        string     `json:"stage"`
+	Step         string     `json:"step"`
+	Type         string     `json:"type"`
+	Env          string     `json:"env,omitempty"`
+	Status       string     `json:"status"` // pending, running, waiting, success, failed, skipped, cancelled
+	DeploymentID *uuid.UUID `json:"deployment_id,omitempty"`
+	Output       string     `json:"output"`
+	Error        string     `json:"error,omitempty"`
+	StartTime    *time.Time `json:"start_time,omitempty"`
+	EndTime      *time.Time `json:"end_time,omitempty"`
+}
@@ -0,0 +1,239 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lock is a held lock. Unlock releases it; calling Unlock more than once is a no-op.
+type Lock interface {
+	Unlock() error
+}
+
+// Locker acquires named, non-blocking locks shared across server instances.
+// Locks taken through different backends do not exclude each other; use
+// FallbackLocker to combine backends.
+type Locker interface {
+	// TryLock attempts to acquire the lock for key. ok is false when another
+	// holder already owns it.
+	TryLock(ctx context.Context, key string) (l Lock, ok bool, err error)
+}
+
+const (
+	redisLockTTL     = 30 * time.Second
+	redisLockRefresh = 10 * time.Second
+)
+
+var (
+	// Only extend or delete the key when it still holds our token.
+	redisRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisLocker implements Locker with SET NX and a TTL that is refreshed in the
+// background while the lock is held, so a crashed holder releases it after the TTL.
+type RedisLocker struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisLocker creates a RedisLocker. All keys are stored under prefix.
+func NewRedisLocker(client *redis.Client, prefix string) *RedisLocker {
+	return &RedisLocker{client: client, prefix: prefix}
+}
+
+func (l *RedisLocker) TryLock(ctx context.Context, key string) (Lock, bool, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	key = l.prefix + key
+	ok, err := l.client.SetNX(ctx, key, token, redisLockTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	held := &redisLock{client: l.client, key: key, token: token, done: make(chan struct{})}
+	go held.keepAlive()
+	return held, true, nil
+}
+
+type redisLock struct {
+	client *redis.Client
+	key    string
+	token  string
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (l *redisLock) keepAlive() {
+	ticker := time.NewTicker(redisLockRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), redisLockRefresh)
+			err := redisRefreshScript.Run(ctx, l.client, []string{l.key}, l.token, redisLockTTL.Milliseconds()).Err()
+			cancel()
+			if err != nil {
+				log.Printf("Failed to refresh lock %s: %v", l.key, err)
+			}
+		}
+	}
+}
+
+func (l *redisLock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = redisUnlockScript.Run(ctx, l.client, []string{l.key}, l.token).Err()
+	})
+	return err
+}
+
+// pgConnTimeout bounds how long TryLock waits for a free connection when every
+// connection of the lock pool is held.
+const pgConnTimeout = 5 * time.Second
+
+// ErrNoLockConn is returned when the lock pool has no free connection.
+var ErrNoLockConn = errors.New("lock: no free database connection")
+
+// PGLocker implements Locker with Postgres session-level advisory locks. Each
+// held lock pins one connection until it is released; if the server dies the
+// connection drops and Postgres releases the lock. db should be a pool
+// dedicated to locks so that held locks cannot starve regular queries.
+type PGLocker struct {
+	db *sql.DB
+}
+
+func NewPGLocker(db *sql.DB) *PGLocker {
+	return &PGLocker{db: db}
+}
+
+func (l *PGLocker) TryLock(ctx context.Context, key string) (Lock, bool, error) {
+	connCtx, cancel := context.WithTimeout(ctx, pgConnTimeout)
+	conn, err := l.db.Conn(connCtx)
+	cancel()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, false, ErrNoLockConn
+		}
+		return nil, false, err
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &pgLock{conn: conn, key: key}, true, nil
+}
+
+type pgLock struct {
+	conn *sql.Conn
+	key  string
+	once sync.Once
+}
+
+func (l *pgLock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", l.key)
+		// Closing returns the connection to the pool; if the unlock failed the
+		// session is discarded by the driver and the lock goes with it.
+		if cerr := l.conn.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// FallbackLocker takes every lock on secondary, and also on primary while
+// primary is reachable. A contended key is rejected by primary without
+// touching secondary; when primary fails the lock is taken on secondary
+// alone. Because every holder owns the secondary lock, holders that fell
+// back still exclude holders that did not, and a primary lock that expired
+// during an outage cannot be taken over while its holder still runs.
+type FallbackLocker struct {
+	primary   Locker
+	secondary Locker
+}
+
+func NewFallbackLocker(primary, secondary Locker) *FallbackLocker {
+	return &FallbackLocker{primary: primary, secondary: secondary}
+}
+
+func (l *FallbackLocker) TryLock(ctx context.Context, key string) (Lock, bool, error) {
+	first, ok, err := l.primary.TryLock(ctx, key)
+	if err != nil {
+		log.Printf("Primary locker failed for %s, falling back: %v", key, err)
+		first = nil
+	} else if !ok {
+		return nil, false, nil
+	}
+
+	second, ok, err := l.secondary.TryLock(ctx, key)
+	if err != nil || !ok {
+		if first != nil {
+			if uerr := first.Unlock(); uerr != nil {
+				log.Printf("Failed to release primary lock %s: %v", key, uerr)
+			}
+		}
+		return nil, false, err
+	}
+	if first == nil {
+		return second, true, nil
+	}
+	return &multiLock{locks: []Lock{first, second}}, true, nil
+}
+
+// multiLock releases its locks in reverse order of acquisition.
+type multiLock struct {
+	locks []Lock
+}
+
+func (l *multiLock) Unlock() error {
+	var err error
+	for i := len(l.locks) - 1; i >= 0; i-- {
+		if uerr := l.locks[i].Unlock(); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,195 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisLocker(t *testing.T) (*RedisLocker, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisLocker(client, "test:"), mr
+}
+
+func TestRedisLocker(t *testing.T) {
+	ctx := context.Background()
+	locker, mr := newRedisLocker(t)
+
+	held, ok, err := locker.TryLock(ctx, "app/env")
+	if err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	if ttl := mr.TTL("test:app/env"); ttl <= 0 || ttl > redisLockTTL {
+		t.Errorf("ttl = %v, want (0, %v]", ttl, redisLockTTL)
+	}
+	if _, ok, err := locker.TryLock(ctx, "app/env"); err != nil || ok {
+		t.Fatalf("second TryLock = %v, %v, want false", ok, err)
+	}
+	if _, ok, err := locker.TryLock(ctx, "app/other"); err != nil || !ok {
+		t.Fatalf("TryLock on another key = %v, %v", ok, err)
+	}
+
+	if err := held.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := held.Unlock(); err != nil {
+		t.Fatalf("second Unlock: %v", err)
+	}
+	if mr.Exists("test:app/env") {
+		t.Error("Unlock did not delete the key")
+	}
+	if _, ok, err := locker.TryLock(ctx, "app/env"); err != nil || !ok {
+		t.Fatalf("TryLock after Unlock = %v, %v", ok, err)
+	}
+}
+
+func TestRedisLockerUnlockKeepsOtherHolder(t *testing.T) {
+	ctx := context.Background()
+	locker, mr := newRedisLocker(t)
+
+	expired, ok, err := locker.TryLock(ctx, "app/env")
+	if err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	mr.FastForward(redisLockTTL + 1)
+
+	if _, ok, err := locker.TryLock(ctx, "app/env"); err != nil || !ok {
+		t.Fatalf("TryLock after expiry = %v, %v", ok, err)
+	}
+	if err := expired.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("test:app/env") {
+		t.Error("Unlock of an expired lock deleted the new holder's key")
+	}
+}
+
+// memLocker is an in-process Locker standing in for Postgres.
+type memLocker struct {
+	mu    sync.Mutex
+	held  map[string]bool
+	calls int
+}
+
+func (l *memLocker) TryLock(_ context.Context, key string) (Lock, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.held[key] {
+		return nil, false, nil
+	}
+	l.held[key] = true
+	return lockFunc(func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, key)
+		return nil
+	}), true, nil
+}
+
+type lockFunc func() error
+
+func (f lockFunc) Unlock() error { return f() }
+
+type failingLocker struct{}
+
+func (failingLocker) TryLock(context.Context, string) (Lock, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func TestFallbackLocker(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// setup prepares the primary and secondary lockers and returns the
+		// primary used by the locker under test.
+		setup          func(t *testing.T, redisLocker *RedisLocker, secondary *memLocker) Locker
+		wantOK         bool
+		wantSecondary  int // secondary TryLock calls
+		wantRedisKey   bool
+		wantSecondHeld bool
+	}{
+		{
+			name:           "takes both locks",
+			setup:          func(t *testing.T, r *RedisLocker, _ *memLocker) Locker { return r },
+			wantOK:         true,
+			wantSecondary:  1,
+			wantRedisKey:   true,
+			wantSecondHeld: true,
+		},
+		{
+			name: "primary contended",
+			setup: func(t *testing.T, r *RedisLocker, _ *memLocker) Locker {
+				if _, ok, err := r.TryLock(ctx, "k"); err != nil || !ok {
+					t.Fatalf("TryLock = %v, %v", ok, err)
+				}
+				return r
+			},
+			wantOK:        false,
+			wantSecondary: 0,
+			wantRedisKey:  true,
+		},
+		{
+			name:           "primary unavailable",
+			setup:          func(t *testing.T, _ *RedisLocker, _ *memLocker) Locker { return failingLocker{} },
+			wantOK:         true,
+			wantSecondary:  1,
+			wantSecondHeld: true,
+		},
+		{
+			name: "held by a holder that fell back",
+			setup: func(t *testing.T, r *RedisLocker, s *memLocker) Locker {
+				if _, ok, err := NewFallbackLocker(failingLocker{}, s).TryLock(ctx, "k"); err != nil || !ok {
+					t.Fatalf("fallback TryLock = %v, %v", ok, err)
+				}
+				return r
+			},
+			wantOK:         false,
+			wantSecondary:  2,
+			wantRedisKey:   false,
+			wantSecondHeld: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisLocker, mr := newRedisLocker(t)
+			secondary := &memLocker{held: make(map[string]bool)}
+			locker := NewFallbackLocker(tt.setup(t, redisLocker, secondary), secondary)
+
+			held, ok, err := locker.TryLock(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if secondary.calls != tt.wantSecondary {
+				t.Errorf("secondary TryLock calls = %d, want %d", secondary.calls, tt.wantSecondary)
+			}
+			if got := mr.Exists("test:k"); got != tt.wantRedisKey {
+				t.Errorf("redis key exists = %v, want %v", got, tt.wantRedisKey)
+			}
+			if got := secondary.held["k"]; got != tt.wantSecondHeld {
+				t.Errorf("secondary held = %v, want %v", got, tt.wantSecondHeld)
+			}
+			if !ok {
+				return
+			}
+
+			if err := held.Unlock(); err != nil {
+				t.Fatal(err)
+			}
+			if mr.Exists("test:k") || secondary.held["k"] {
+				t.Error("Unlock did not release both locks")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package notify
+
+// Channel names used by alert rules.
+const (
+	ChannelEmail    = "email"
+	ChannelDingTalk = "dingtalk"
+	ChannelWeChat   = "wechat"
+	ChannelFeishu   = "feishu"
+)
+
+// Config holds the notification channels alerts can be sent to.
+// A channel is only available when it is configured.
+type Config struct {
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPFrom        string
+	SMTPTo          []string
+	SMTPUseTLS      bool
+	DingTalkWebhook string
+	WeChatWebhook   string
+	FeishuWebhook   string
+}
+
+// NewChannels builds a notifier for every configured channel, keyed by channel name.
+func NewChannels(cfg Config) map[string]Notifier {
+	channels := make(map[string]Notifier)
+	if cfg.SMTPHost != "" && cfg.SMTPFrom != "" && len(cfg.SMTPTo) > 0 {
+		channels[ChannelEmail] = NewEmailNotifier(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.SMTPTo, cfg.SMTPUseTLS)
+	}
+	if cfg.DingTalkWebhook != "" {
+		channels[ChannelDingTalk] = NewDingTalkNotifier(cfg.DingTalkWebhook)
+	}
+	if cfg.WeChatWebhook != "" {
+		channels[ChannelWeChat] = NewWeChatWorkNotifier(cfg.WeChatWebhook)
+	}
+	if cfg.FeishuWebhook != "" {
+		channels[ChannelFeishu] = NewFeishuNotifier(cfg.FeishuWebhook)
+	}
+	return channels
+}
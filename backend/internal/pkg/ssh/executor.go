@@ -2,8 +2,14 @@ package ssh
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -63,15 +69,76 @@ func NewExecutor(cfg *Config) (*Executor, error) {
 	}, nil
 }
 
-func (e *Executor) Connect() error {
-	client, err := ssh.Dial("tcp", e.addr, e.config)
+func (e *Executor) Connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: e.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.addr)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
-	e.client = client
+
+	// Abort the handshake if ctx is cancelled before it completes
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, e.addr, e.config)
+	stop()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	e.client = ssh.NewClient(c, chans, reqs)
 	return nil
 }
 
+// newSession connects on first use and opens a new session
+func (e *Executor) newSession(ctx context.Context) (*ssh.Session, error) {
+	if e.client == nil {
+		if err := e.Connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	session, err := e.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return session, nil
+}
+
+// cancelGrace is how long a cancelled command gets to exit after SIGTERM
+// before it is sent SIGKILL and the session is closed.
+const cancelGrace = 5 * time.Second
+
+// run starts command on session and waits for it. When ctx is cancelled the
+// remote process is signalled and the session closed, and ctx.Err() is returned.
+func run(ctx context.Context, session *ssh.Session, command string) error {
+	if err := session.Start(command); err != nil {
+		return err
+	}
+	return wait(ctx, session)
+}
+
+// wait waits for a started session, aborting it as run does when ctx is cancelled.
+func wait(ctx context.Context, session *ssh.Session) error {
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Not every sshd honours signal requests; closing the session is the fallback
+	session.Signal(ssh.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+	}
+	return ctx.Err()
+}
+
 func (e *Executor) Close() error {
 	if e.client != nil {
 		return e.client.Close()
@@ -85,16 +152,10 @@ type ExecResult struct {
 	ExitCode int
 }
 
-func (e *Executor) Execute(command string) (*ExecResult, error) {
-	if e.client == nil {
-		if err := e.Connect(); err != nil {
-			return nil, err
-		}
-	}
-
-	session, err := e.client.NewSession()
+func (e *Executor) Execute(ctx context.Context, command string) (*ExecResult, error) {
+	session, err := e.newSession(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, err
 	}
 	defer session.Close()
 
@@ -102,7 +163,7 @@ func (e *Executor) Execute(command string) (*ExecResult, error) {
 	session.Stdout = &stdout
 	session.Stderr = &stderr
 
-	err = session.Run(command)
+	err = run(ctx, session, command)
 
 	result := &ExecResult{
 		Stdout: stdout.String(),
@@ -120,52 +181,117 @@ func (e *Executor) Execute(command string) (*ExecResult, error) {
 	return result, nil
 }
 
-func (e *Executor) ExecuteWithOutput(command string, output io.Writer) error {
-	if e.client == nil {
-		if err := e.Connect(); err != nil {
-			return err
-		}
-	}
-
-	session, err := e.client.NewSession()
+func (e *Executor) ExecuteWithOutput(ctx context.Context, command string, output io.Writer) error {
+	session, err := e.newSession(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return err
 	}
 	defer session.Close()
 
-	session.Stdout = output
-	session.Stderr = output
+	// stdout and stderr are copied concurrently, so guard the shared writer
+	w := &lockedWriter{w: output}
+	session.Stdout = w
+	session.Stderr = w
 
-	return session.Run(command)
+	return run(ctx, session, command)
 }
 
-func (e *Executor) Upload(localContent []byte, remotePath string, mode string) error {
-	if e.client == nil {
-		if err := e.Connect(); err != nil {
-			return err
-		}
+// ExitStatus returns the remote exit status carried by an error from
+// ExecuteWithOutput, and false if the command did not exit normally.
+func ExitStatus(err error) (int, bool) {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
 	}
+	return 0, false
+}
 
-	session, err := e.client.NewSession()
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// Upload writes content to remotePath with the given octal mode (e.g. "0644")
+// using the SCP sink protocol. The remote file keeps its mode if it exists.
+func (e *Executor) Upload(ctx context.Context, localContent []byte, remotePath string, mode string) error {
+	return e.UploadReader(ctx, bytes.NewReader(localContent), int64(len(localContent)), remotePath, mode)
+}
+
+// UploadReader is like Upload but streams exactly size bytes from r, so large
+// files do not have to be held in memory.
+func (e *Executor) UploadReader(ctx context.Context, r io.Reader, size int64, remotePath string, mode string) error {
+	// The file name is sent on a single protocol line
+	if strings.ContainsAny(remotePath, "\n\r") {
+		return fmt.Errorf("invalid remote path %q", remotePath)
+	}
+	session, err := e.newSession(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+		return err
 	}
 	defer session.Close()
 
+	// The pipe must be requested before the session starts
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin: %w", err)
+	}
+	// scp reports protocol errors on stdout, prefixed with \x01 or \x02
+	var output bytes.Buffer
+	w := &lockedWriter{w: &output}
+	session.Stdout = w
+	session.Stderr = w
+
+	if err := session.Start("scp -t " + shellQuote(remotePath)); err != nil {
+		return err
+	}
+
+	sent := make(chan error, 1)
 	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
-		fmt.Fprintf(w, "C%s %d %s\n", mode, len(localContent), remotePath)
-		w.Write(localContent)
-		fmt.Fprint(w, "\x00")
+		// The sink rejects file names containing a slash; the target path is
+		// already given to scp -t, so only the base name is sent
+		_, err := fmt.Fprintf(stdin, "C%s %d %s\n", mode, size, path.Base(remotePath))
+		if err == nil {
+			var n int64
+			n, err = io.CopyN(stdin, r, size)
+			if err == io.EOF {
+				err = fmt.Errorf("short read: %d of %d bytes", n, size)
+			}
+		}
+		if err == nil {
+			_, err = stdin.Write([]byte{0})
+		}
+		if closeErr := stdin.Close(); err == nil {
+			err = closeErr
+		}
+		sent <- err
 	}()
 
-	return session.Run("scp -t " + remotePath)
+	if err := wait(ctx, session); err != nil {
+		if msg := strings.Trim(output.String(), "\x00\x01\x02\n "); msg != "" && ctx.Err() == nil {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	if err := <-sent; err != nil {
+		return fmt.Errorf("failed to send file: %w", err)
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 // ExecuteScript executes a shell script on remote host
-func (e *Executor) ExecuteScript(script string) (*ExecResult, error) {
+func (e *Executor) ExecuteScript(ctx context.Context, script string) (*ExecResult, error) {
 	// Create a temporary script and execute
 	command := fmt.Sprintf("bash -c '%s'", script)
-	return e.Execute(command)
+	return e.Execute(ctx, command)
 }
@@ -0,0 +1,137 @@
+package ssh_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops/internal/pkg/ssh"
+	"devops/internal/pkg/ssh/sshtest"
+)
+
+func newTestExecutor(t *testing.T) *ssh.Executor {
+	t.Helper()
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	srv := sshtest.NewServer(t)
+	executor, err := ssh.NewExecutor(&ssh.Config{
+		Host:     srv.Host,
+		Port:     srv.Port,
+		Username: sshtest.User,
+		Password: sshtest.Password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { executor.Close() })
+	return executor
+}
+
+func TestUpload(t *testing.T) {
+	executor := newTestExecutor(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{name: "artifact.tar.gz", content: []byte("release contents\x00\x01"), mode: 0o644},
+		{name: ".env", content: []byte("DB_PASSWORD='secret'\n"), mode: 0o600},
+		{name: "empty", content: []byte{}, mode: 0o600},
+		{name: "app config.env", content: []byte("A=1\n"), mode: 0o600},
+		{name: "it's $(touch injected); `id`", content: []byte("B=2\n"), mode: 0o644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := filepath.Join(dir, tt.name)
+			if err := executor.Upload(context.Background(), tt.content, remote, fmt.Sprintf("%04o", tt.mode)); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			got, err := os.ReadFile(remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.content) {
+				t.Errorf("content = %q, want %q", got, tt.content)
+			}
+			info, err := os.Stat(remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.mode {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.mode)
+			}
+		})
+	}
+}
+
+func TestUploadDoesNotRunShellMetacharacters(t *testing.T) {
+	executor := newTestExecutor(t)
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "a;touch injected")
+
+	if err := executor.Upload(context.Background(), []byte("x"), remote, "0644"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if _, err := os.Stat(remote); err != nil {
+		t.Errorf("file was not written to the literal path: %v", err)
+	}
+	for _, p := range []string{filepath.Join(dir, "injected"), "injected"} {
+		if _, err := os.Stat(p); err == nil {
+			os.Remove(p)
+			t.Errorf("shell command in the path was executed (%s exists)", p)
+		}
+	}
+}
+
+func TestUploadReader(t *testing.T) {
+	executor := newTestExecutor(t)
+	dir := t.TempDir()
+
+	// Larger than the SSH channel window, so the content is sent in several chunks
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	remote := filepath.Join(dir, "artifact.tar.gz")
+	if err := executor.UploadReader(context.Background(), bytes.NewReader(content), int64(len(content)), remote, "0644"); err != nil {
+		t.Fatalf("UploadReader: %v", err)
+	}
+	got, err := os.ReadFile(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("uploaded %d bytes, want %d", len(got), len(content))
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := executor.UploadReader(context.Background(), strings.NewReader("abc"), 10, short, "0644"); err == nil {
+		t.Fatal("UploadReader with a short reader succeeded")
+	}
+}
+
+func TestUploadMissingDirectory(t *testing.T) {
+	executor := newTestExecutor(t)
+	remote := filepath.Join(t.TempDir(), "missing", "file")
+
+	if err := executor.Upload(context.Background(), []byte("x"), remote, "0644"); err == nil {
+		t.Fatal("Upload to a missing directory succeeded")
+	}
+}
+
+func TestExecute(t *testing.T) {
+	executor := newTestExecutor(t)
+
+	result, err := executor.Execute(context.Background(), "echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" || result.ExitCode != 3 {
+		t.Errorf("result = %+v", result)
+	}
+}
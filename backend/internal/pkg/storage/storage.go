@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no object exists under the key.
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores opaque objects (build artifacts) under slash-separated keys.
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Config selects and configures a Storage backend.
+type Config struct {
+	Type     string // local or s3
+	LocalDir string
+
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// New creates the backend selected by cfg.Type, defaulting to local.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Type {
+	case "s3":
+		s, err := NewS3Storage(cfg.Endpoint, cfg.AccessKey, cfg.SecretKey, cfg.Bucket, cfg.Region, cfg.UseSSL)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.EnsureBucket(ctx, cfg.Region); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "", "local":
+		return NewLocalStorage(cfg.LocalDir), nil
+	default:
+		return nil, errors.New("unknown storage type: " + cfg.Type)
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStorage runs the behaviour every backend must provide.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	key := fmt.Sprintf("demo/%d.tar.gz", time.Now().UnixNano())
+	t.Cleanup(func() { s.Delete(ctx, key) })
+
+	read := func(t *testing.T) string {
+		t.Helper()
+		r, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing key = %v, want ErrNotFound", err)
+	}
+
+	for _, content := range []string{"first release", "second, longer release"} {
+		if err := s.Put(ctx, key, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if got := read(t); got != content {
+			t.Fatalf("Get = %q, want %q", got, content)
+		}
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	testStorage(t, NewLocalStorage(t.TempDir()))
+}
+
+func TestLocalStorageRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocalStorage(root + "/objects")
+	ctx := context.Background()
+
+	for _, key := range []string{"", "/", "../outside", "demo/../../outside"} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), 1); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(root + "/outside"); err == nil {
+		t.Error("object was written outside the storage root")
+	}
+}
+
+// TestS3Storage runs against an S3-compatible service such as a local MinIO:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	STORAGE_TEST_S3_ENDPOINT=127.0.0.1:9000 go test ./internal/pkg/storage
+//
+// The access and secret keys default to MinIO's minioadmin.
+func TestS3Storage(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_S3_ENDPOINT is not set")
+	}
+	accessKey := envOr("STORAGE_TEST_S3_ACCESS_KEY", "minioadmin")
+	secretKey := envOr("STORAGE_TEST_S3_SECRET_KEY", "minioadmin")
+	bucket := fmt.Sprintf("devops-test-%d", time.Now().UnixNano())
+
+	s, err := New(Config{
+		Type:      "s3",
+		Endpoint:  endpoint,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Bucket:    bucket,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s3 := s.(*S3Storage)
+	t.Cleanup(func() { s3.client.RemoveBucket(context.Background(), bucket) })
+
+	// A second EnsureBucket must accept the existing bucket
+	if err := s3.EnsureBucket(context.Background(), "us-east-1"); err != nil {
+		t.Fatalf("EnsureBucket: %v", err)
+	}
+	testStorage(t, s)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,365 @@
+package repository
+
+import (
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AgentRepository struct {
+	db *gorm.DB
+}
+
+func NewAgentRepository(db *gorm.DB) *AgentRepository {
+	return &AgentRepository{db: db}
+}
+
+func (r *AgentRepository) Create(agent *model.Agent) error {
+	return r.db.Create(agent).Error
+}
+
+func (r *AgentRepository) Update(agent *model.Agent) error {
+	return r.db.Save(agent).Error
+}
+
+// GetByIdentity 按主机名和注册时的来源 IP 查找 Agent，不存在时返回 nil
+func (r *AgentRepository) GetByIdentity(hostname, ip string) (*model.Agent, error) {
+	var agents []model.Agent
+	if err := r.db.Where("hostname = ? AND ip = ?", hostname, ip).Limit(1).Find(&agents).Error; err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, nil
+	}
+	return &agents[0], nil
+}
+
+func (r *AgentRepository) GetByID(id uuid.UUID) (*model.Agent, error) {
+	var agent model.Agent
+	err := r.db.Preload("Host").First(&agent, "id = ?", id).Error
+	return &agent, err
+}
+
+// GetByTokenHash 按当前凭证或轮换中的新凭证查找未吊销的 Agent，不存在时返回 nil
+func (r *AgentRepository) GetByTokenHash(hash string) (*model.Agent, error) {
+	var agents []model.Agent
+	err := r.db.Where("revoked_at IS NULL AND (token_hash = ? OR pending_token_hash = ?)", hash, hash).
+		Limit(1).Find(&agents).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, nil
+	}
+	return &agents[0], nil
+}
+
+func (r *AgentRepository) List() ([]model.Agent, error) {
+	var agents []model.Agent
+	err := r.db.Preload("Host").Order("hostname ASC").Find(&agents).Error
+	return agents, err
+}
+
+func (r *AgentRepository) CountByHost(hostID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Agent{}).Where("host_id = ? AND revoked_at IS NULL", hostID).Count(&count).Error
+	return count, err
+}
+
+// CountOthersByHost 统计主机上除 exceptID 外未吊销的 Agent 数
+func (r *AgentRepository) CountOthersByHost(hostID, exceptID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Agent{}).Where("host_id = ? AND id <> ? AND revoked_at IS NULL", hostID, exceptID).Count(&count).Error
+	return count, err
+}
+
+func (r *AgentRepository) Touch(id uuid.UUID, seenAt time.Time) error {
+	return r.db.Model(&model.Agent{}).Where("id = ?", id).Update("last_seen_at", seenAt).Error
+}
+
+// SetPendingToken 保存轮换生成的新凭证，并清除轮换请求
+func (r *AgentRepository) SetPendingToken(id uuid.UUID, hash string) error {
+	return r.db.Model(&model.Agent{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"pending_token_hash": hash,
+			"rotate_requested":   false,
+		}).Error
+}
+
+// PromotePendingToken Agent 使用新凭证后，以新凭证替换旧凭证
+func (r *AgentRepository) PromotePendingToken(id uuid.UUID, hash string) error {
+	return r.db.Model(&model.Agent{}).Where("id = ? AND pending_token_hash = ?", id, hash).
+		Updates(map[string]interface{}{
+			"token_hash":         hash,
+			"pending_token_hash": "",
+		}).Error
+}
+
+func (r *AgentRepository) RequestRotate(id uuid.UUID) error {
+	return r.db.Model(&model.Agent{}).Where("id = ? AND revoked_at IS NULL", id).Update("rotate_requested", true).Error
+}
+
+// Revoke 吊销 Agent 的凭证，Agent 需使用新的引导令牌重新注册
+func (r *AgentRepository) Revoke(id uuid.UUID, revokedAt time.Time) error {
+	return r.db.Model(&model.Agent{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"token_hash":         "",
+			"pending_token_hash": "",
+			"rotate_requested":   false,
+			"revoked_at":         revokedAt,
+		}).Error
+}
+
+// RevokeOthersByHost 吊销主机上除 exceptID 外的所有 Agent
+func (r *AgentRepository) RevokeOthersByHost(hostID, exceptID uuid.UUID, revokedAt time.Time) error {
+	return r.db.Model(&model.Agent{}).Where("host_id = ? AND id <> ? AND revoked_at IS NULL", hostID, exceptID).
+		Updates(map[string]interface{}{
+			"token_hash":         "",
+			"pending_token_hash": "",
+			"rotate_requested":   false,
+			"revoked_at":         revokedAt,
+		}).Error
+}
+
+// MarkStaleHostsOffline 将 Agent 在 before 之后没有心跳的在线主机标记为离线，返回更新的主机数
+func (r *AgentRepository) MarkStaleHostsOffline(before time.Time) (int64, error) {
+	stale := r.db.Model(&model.Agent{}).Select("host_id").
+		Where("host_id IS NOT NULL").
+		Group("host_id").
+		Having("MAX(last_seen_at) < ?", before)
+	result := r.db.Model(&model.Host{}).Where("status = 1 AND id IN (?)", stale).Update("status", 0)
+	return result.RowsAffected, result.Error
+}
+
+// Agent Bootstrap Token
+type AgentBootstrapTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAgentBootstrapTokenRepository(db *gorm.DB) *AgentBootstrapTokenRepository {
+	return &AgentBootstrapTokenRepository{db: db}
+}
+
+func (r *AgentBootstrapTokenRepository) Create(token *model.AgentBootstrapToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *AgentBootstrapTokenRepository) List() ([]model.AgentBootstrapToken, error) {
+	var tokens []model.AgentBootstrapToken
+	err := r.db.Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *AgentBootstrapTokenRepository) Delete(id uuid.UUID) (bool, error) {
+	result := r.db.Delete(&model.AgentBootstrapToken{}, "id = ?", id)
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetUsable 返回未使用且未过期的引导令牌，令牌无效时返回 nil
+func (r *AgentBootstrapTokenRepository) GetUsable(hash string, now time.Time) (*model.AgentBootstrapToken, error) {
+	var tokens []model.AgentBootstrapToken
+	err := r.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).Limit(1).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+// Consume 将未使用且未过期的引导令牌标记为已使用，返回令牌，令牌无效时返回 nil
+func (r *AgentBootstrapTokenRepository) Consume(hash string, now time.Time) (*model.AgentBootstrapToken, error) {
+	var token model.AgentBootstrapToken
+	result := r.db.Model(&token).Clauses(clause.Returning{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// Release 撤销对尚未绑定 Agent 的引导令牌的使用，令牌在有效期内可再次使用
+func (r *AgentBootstrapTokenRepository) Release(id uuid.UUID) error {
+	return r.db.Model(&model.AgentBootstrapToken{}).Where("id = ? AND agent_id IS NULL", id).Update("used_at", nil).Error
+}
+
+func (r *AgentBootstrapTokenRepository) SetAgent(id, agentID uuid.UUID) error {
+	return r.db.Model(&model.AgentBootstrapToken{}).Where("id = ?", id).Update("agent_id", agentID).Error
+}
+
+// Host Metric
+type HostMetricRepository struct {
+	db *gorm.DB
+}
+
+func NewHostMetricRepository(db *gorm.DB) *HostMetricRepository {
+	return &HostMetricRepository{db: db}
+}
+
+func (r *HostMetricRepository) Create(metric *model.HostMetric) error {
+	return r.db.Create(metric).Error
+}
+
+// ListRaw 按主机和采集时间排序返回 [from, to) 内已关联主机的原始指标，hostID 为 nil 时返回所有主机
+func (r *HostMetricRepository) ListRaw(hostID *uuid.UUID, from, to time.Time) ([]model.HostMetric, error) {
+	var metrics []model.HostMetric
+	query := r.db.Where("host_id IS NOT NULL AND collected_at >= ? AND collected_at < ?", from, to)
+	if hostID != nil {
+		query = query.Where("host_id = ?", *hostID)
+	}
+	err := query.Order("host_id, collected_at").Find(&metrics).Error
+	return metrics, err
+}
+
+// ListRawByHosts 按主机和采集时间排序返回 [from, to) 内指定主机的原始指标，hostIDs 为空时返回所有已关联主机
+func (r *HostMetricRepository) ListRawByHosts(hostIDs []uuid.UUID, from, to time.Time) ([]model.HostMetric, error) {
+	var metrics []model.HostMetric
+	query := r.db.Where("host_id IS NOT NULL AND collected_at >= ? AND collected_at < ?", from, to)
+	if len(hostIDs) > 0 {
+		query = query.Where("host_id IN ?", hostIDs)
+	}
+	err := query.Order("host_id, collected_at").Find(&metrics).Error
+	return metrics, err
+}
+
+// ListRollupsByHosts 按主机和区间排序返回 [from, to) 内指定主机、指定精度的降采样数据，hostIDs 为空时返回所有主机
+func (r *HostMetricRepository) ListRollupsByHosts(hostIDs []uuid.UUID, resolution int, from, to time.Time) ([]model.HostMetricRollup, error) {
+	var rollups []model.HostMetricRollup
+	query := r.db.Where("resolution = ? AND bucket_start >= ? AND bucket_start < ?", resolution, from, to)
+	if len(hostIDs) > 0 {
+		query = query.Where("host_id IN ?", hostIDs)
+	}
+	err := query.Order("host_id, bucket_start").Find(&rollups).Error
+	return rollups, err
+}
+
+// ListRollups 按主机和区间排序返回 [from, to) 内指定精度的降采样数据，hostID 为 nil 时返回所有主机
+func (r *HostMetricRepository) ListRollups(hostID *uuid.UUID, resolution int, from, to time.Time) ([]model.HostMetricRollup, error) {
+	var rollups []model.HostMetricRollup
+	query := r.db.Where("resolution = ? AND bucket_start >= ? AND bucket_start < ?", resolution, from, to)
+	if hostID != nil {
+		query = query.Where("host_id = ?", *hostID)
+	}
+	err := query.Order("host_id, bucket_start").Find(&rollups).Error
+	return rollups, err
+}
+
+// LatestRollup 返回指定精度最新一条降采样数据的区间开始时间，没有数据时返回 nil
+func (r *HostMetricRepository) LatestRollup(resolution int) (*time.Time, error) {
+	var rollups []model.HostMetricRollup
+	err := r.db.Select("bucket_start").Where("resolution = ?", resolution).
+		Order("bucket_start DESC").Limit(1).Find(&rollups).Error
+	if err != nil || len(rollups) == 0 {
+		return nil, err
+	}
+	return &rollups[0].BucketStart, nil
+}
+
+// SaveRollups 写入降采样数据，同一主机、精度和区间已存在时覆盖
+func (r *HostMetricRepository) SaveRollups(rollups []model.HostMetricRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "host_id"}, {Name: "resolution"}, {Name: "bucket_start"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"samples", "cpu_percent", "cpu_max", "mem_percent", "mem_used", "mem_total",
+			"disk_percent", "disk_used", "disk_total", "net_bytes_sent", "net_bytes_recv",
+			"net_packets_sent", "net_packets_recv", "load1", "load5", "load15",
+		}),
+	}).CreateInBatches(rollups, 500).Error
+}
+
+func (r *HostMetricRepository) DeleteRawBefore(before time.Time) (int64, error) {
+	result := r.db.Where("collected_at < ?", before).Delete(&model.HostMetric{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *HostMetricRepository) DeleteRollupsBefore(resolution int, before time.Time) (int64, error) {
+	result := r.db.Where("resolution = ? AND bucket_start < ?", resolution, before).Delete(&model.HostMetricRollup{})
+	return result.RowsAffected, result.Error
+}
+
+// Agent Command
+type AgentCommandRepository struct {
+	db *gorm.DB
+}
+
+func NewAgentCommandRepository(db *gorm.DB) *AgentCommandRepository {
+	return &AgentCommandRepository{db: db}
+}
+
+func (r *AgentCommandRepository) Create(cmd *model.AgentCommand) error {
+	return r.db.Create(cmd).Error
+}
+
+func (r *AgentCommandRepository) GetByID(id uuid.UUID) (*model.AgentCommand, error) {
+	var cmd model.AgentCommand
+	err := r.db.First(&cmd, "id = ?", id).Error
+	return &cmd, err
+}
+
+func (r *AgentCommandRepository) ListByHost(hostID uuid.UUID, page, pageSize int) ([]model.AgentCommand, int64, error) {
+	var cmds []model.AgentCommand
+	var total int64
+
+	query := r.db.Model(&model.AgentCommand{}).Where("host_id = ?", hostID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := query.Omit("output").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&cmds).Error; err != nil {
+		return nil, 0, err
+	}
+	return cmds, total, nil
+}
+
+// ListPending 按创建顺序返回主机待下发的命令
+func (r *AgentCommandRepository) ListPending(hostID uuid.UUID, limit int) ([]model.AgentCommand, error) {
+	var cmds []model.AgentCommand
+	err := r.db.Where("host_id = ? AND status = 0", hostID).Order("created_at ASC").Limit(limit).Find(&cmds).Error
+	return cmds, err
+}
+
+// Dispatch 将待下发的命令标记为已下发，返回是否由本次调用领取
+func (r *AgentCommandRepository) Dispatch(id, agentID uuid.UUID, dispatchedAt time.Time) (bool, error) {
+	result := r.db.Model(&model.AgentCommand{}).Where("id = ? AND status = 0", id).
+		Updates(map[string]interface{}{
+			"status":        1,
+			"agent_id":      agentID,
+			"dispatched_at": dispatchedAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Finish 记录已下发命令的执行结果，命令不处于已下发状态时返回 false
+func (r *AgentCommandRepository) Finish(id uuid.UUID, status int, output string, exitCode int, errMsg string, finishedAt time.Time) (bool, error) {
+	result := r.db.Model(&model.AgentCommand{}).Where("id = ? AND status = 1", id).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"output":      output,
+			"exit_code":   exitCode,
+			"error":       errMsg,
+			"finished_at": finishedAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ExpireDispatched 将下发后超过命令超时时间加 grace 仍未回报结果的命令标记为超时
+func (r *AgentCommandRepository) ExpireDispatched(now time.Time, grace time.Duration) (int64, error) {
+	result := r.db.Model(&model.AgentCommand{}).
+		Where("status = 1 AND dispatched_at + (timeout + ?) * INTERVAL '1 second' < ?", int(grace.Seconds()), now).
+		Updates(map[string]interface{}{
+			"status":      4,
+			"error":       "agent did not report a result",
+			"finished_at": now,
+		})
+	return result.RowsAffected, result.Error
+}
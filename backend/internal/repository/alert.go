@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AlertRuleRepository struct {
+	db *gorm.DB
+}
+
+func NewAlertRuleRepository(db *gorm.DB) *AlertRuleRepository {
+	return &AlertRuleRepository{db: db}
+}
+
+// Create 写入所有字段，避免 Enabled=false、Duration=0 被列默认值覆盖
+func (r *AlertRuleRepository) Create(rule *model.AlertRule) error {
+	return r.db.Select("*").Create(rule).Error
+}
+
+func (r *AlertRuleRepository) Update(rule *model.AlertRule) error {
+	return r.db.Save(rule).Error
+}
+
+func (r *AlertRuleRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&model.AlertRule{}, "id = ?", id).Error
+}
+
+func (r *AlertRuleRepository) GetByID(id uuid.UUID) (*model.AlertRule, error) {
+	var rule model.AlertRule
+	err := r.db.First(&rule, "id = ?", id).Error
+	return &rule, err
+}
+
+func (r *AlertRuleRepository) List() ([]model.AlertRule, error) {
+	var rules []model.AlertRule
+	err := r.db.Order("created_at DESC").Find(&rules).Error
+	return rules, err
+}
+
+func (r *AlertRuleRepository) ListEnabled() ([]model.AlertRule, error) {
+	var rules []model.AlertRule
+	err := r.db.Where("enabled = ?", true).Find(&rules).Error
+	return rules, err
+}
+
+// Alert History
+type AlertHistoryRepository struct {
+	db *gorm.DB
+}
+
+func NewAlertHistoryRepository(db *gorm.DB) *AlertHistoryRepository {
+	return &AlertHistoryRepository{db: db}
+}
+
+func (r *AlertHistoryRepository) Create(history *model.AlertHistory) error {
+	return r.db.Create(history).Error
+}
+
+func (r *AlertHistoryRepository) List(page, pageSize int, source string, status *int, hostID, ruleID *uuid.UUID) ([]model.AlertHistory, int64, error) {
+	var histories []model.AlertHistory
+	var total int64
+
+	query := r.db.Model(&model.AlertHistory{})
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if status != nil {
+		query = query.Where("status = ?", *status)
+	}
+	if hostID != nil {
+		query = query.Where("host_id = ?", *hostID)
+	}
+	if ruleID != nil {
+		query = query.Where("rule_id = ?", *ruleID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&histories).Error; err != nil {
+		return nil, 0, err
+	}
+	return histories, total, nil
+}
+
+// ListFiringByRules 返回告警规则产生的、尚未恢复的告警
+func (r *AlertHistoryRepository) ListFiringByRules() ([]model.AlertHistory, error) {
+	var histories []model.AlertHistory
+	err := r.db.Where("source = ? AND status = 0", "rule").Find(&histories).Error
+	return histories, err
+}
+
+// GetFiringByFingerprint 按指纹查找 Alertmanager 推送的未恢复告警，不存在时返回 nil
+func (r *AlertHistoryRepository) GetFiringByFingerprint(fingerprint string) (*model.AlertHistory, error) {
+	var histories []model.AlertHistory
+	err := r.db.Where("source = ? AND fingerprint = ? AND status = 0", "alertmanager", fingerprint).
+		Order("created_at DESC").Limit(1).Find(&histories).Error
+	if err != nil || len(histories) == 0 {
+		return nil, err
+	}
+	return &histories[0], nil
+}
+
+// UpdateExternal 更新 Alertmanager 告警的级别、摘要和标签
+func (r *AlertHistoryRepository) UpdateExternal(id uuid.UUID, severity, summary, labels string) error {
+	return r.db.Model(&model.AlertHistory{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"severity": severity,
+			"summary":  summary,
+			"labels":   labels,
+		}).Error
+}
+
+// Resolve 将告警标记为已恢复，告警已恢复时返回 false
+func (r *AlertHistoryRepository) Resolve(id uuid.UUID, resolvedAt time.Time) (bool, error) {
+	result := r.db.Model(&model.AlertHistory{}).Where("id = ? AND status = 0", id).
+		Updates(map[string]interface{}{
+			"status":      1,
+			"resolved_at": resolvedAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"time"
+
 	"devops/internal/model"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AppRepository struct {
@@ -41,6 +44,21 @@ func (r *AppRepository) Update(app *model.Application) error {
 	return r.db.Save(app).Error
 }
 
+func (r *AppRepository) UpdateWebhookSecret(id uuid.UUID, secret string) error {
+	return r.db.Model(&model.Application{}).Where("id = ?", id).Update("webhook_secret", secret).Error
+}
+
+// ClaimWebhookDelivery 记录一次 webhook 投递，同一来源的投递 ID 已存在时返回 false
+func (r *AppRepository) ClaimWebhookDelivery(delivery *model.WebhookDelivery) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(delivery)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseWebhookDelivery 删除投递记录，使处理失败的投递可以重新发送
+func (r *AppRepository) ReleaseWebhookDelivery(provider, deliveryID string) error {
+	return r.db.Where("provider = ? AND delivery_id = ?", provider, deliveryID).Delete(&model.WebhookDelivery{}).Error
+}
+
 func (r *AppRepository) Delete(id uuid.UUID) error {
 	return r.db.Delete(&model.Application{}, "id = ?", id).Error
 }
@@ -87,6 +105,13 @@ func (r *AppRepository) UpdateHosts(appID uuid.UUID, hostIDs []uuid.UUID) error
 	return r.db.Model(app).Association("Hosts").Replace(hosts)
 }
 
+// ListByEnvAndRepo 列出环境中使用同一代码仓库的应用
+func (r *AppRepository) ListByEnvAndRepo(envID uuid.UUID, repoURL string) ([]model.Application, error) {
+	var apps []model.Application
+	err := r.db.Preload("Env").Preload("Hosts").Where("env_id = ? AND repo_url = ?", envID, repoURL).Find(&apps).Error
+	return apps, err
+}
+
 // Environment
 type EnvRepository struct {
 	db *gorm.DB
@@ -161,26 +186,430 @@ func (r *DeploymentRepository) Update(deploy *model.Deployment) error {
 	return r.db.Save(deploy).Error
 }
 
-func (r *DeploymentRepository) List(appID uuid.UUID, page, pageSize int) ([]model.Deployment, int64, error) {
+func (r *DeploymentRepository) UpdateHostResults(id uuid.UUID, hostResults string) error {
+	return r.db.Model(&model.Deployment{}).Where("id = ?", id).Update("host_results", hostResults).Error
+}
+
+// UpdateConfigVersions 记录部署下发的配置版本
+func (r *DeploymentRepository) UpdateConfigVersions(id uuid.UUID, versions string) error {
+	return r.db.Model(&model.Deployment{}).Where("id = ?", id).Update("config_versions", versions).Error
+}
+
+// UpdateArtifact 关联构建产物，并记录构建时解析出的提交
+func (r *DeploymentRepository) UpdateArtifact(id, artifactID uuid.UUID, commitID string) error {
+	return r.db.Model(&model.Deployment{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"artifact_id": artifactID,
+			"commit_id":   commitID,
+		}).Error
+}
+
+func (r *DeploymentRepository) UpdateProgress(id uuid.UUID, batchCurrent int, paused bool) error {
+	return r.db.Model(&model.Deployment{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"batch_current": batchCurrent,
+			"paused":        paused,
+		}).Error
+}
+
+// UpdateStatusIf 仅当部署处于 from 状态时将其改为 to，返回是否更新成功
+func (r *DeploymentRepository) UpdateStatusIf(id uuid.UUID, from, to int) (bool, error) {
+	result := r.db.Model(&model.Deployment{}).Where("id = ? AND status = ?", id, from).Update("status", to)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Dequeue 将排队中的部署移出队列并恢复为待执行，返回是否更新成功
+func (r *DeploymentRepository) Dequeue(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&model.Deployment{}).Where("id = ? AND status = 4", id).
+		Updates(map[string]interface{}{
+			"status":    0,
+			"queued_at": nil,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// queuedQuery 排队中的部署，envID 为 nil 时匹配未关联环境的部署
+func (r *DeploymentRepository) queuedQuery(appID uuid.UUID, envID *uuid.UUID) *gorm.DB {
+	query := r.db.Model(&model.Deployment{}).Where("app_id = ? AND status = 4", appID)
+	if envID != nil {
+		return query.Where("env_id = ?", *envID)
+	}
+	return query.Where("env_id IS NULL")
+}
+
+// FirstQueued 返回同一应用同一环境下最早入队的部署，队列为空时返回 nil
+func (r *DeploymentRepository) FirstQueued(appID uuid.UUID, envID *uuid.UUID) (*model.Deployment, error) {
+	var deploys []model.Deployment
+	if err := r.queuedQuery(appID, envID).Order("queued_at ASC, created_at ASC").Limit(1).Find(&deploys).Error; err != nil {
+		return nil, err
+	}
+	if len(deploys) == 0 {
+		return nil, nil
+	}
+	return &deploys[0], nil
+}
+
+func (r *DeploymentRepository) CountQueued(appID uuid.UUID, envID *uuid.UUID) (int64, error) {
+	var count int64
+	err := r.queuedQuery(appID, envID).Count(&count).Error
+	return count, err
+}
+
+// ListQueued 按出队顺序列出排队中的部署，appID、envID 为 nil 时不过滤
+func (r *DeploymentRepository) ListQueued(appID, envID *uuid.UUID) ([]model.Deployment, error) {
+	var deploys []model.Deployment
+	query := r.db.Preload("App").Where("status = 4")
+	if appID != nil {
+		query = query.Where("app_id = ?", *appID)
+	}
+	if envID != nil {
+		query = query.Where("env_id = ?", *envID)
+	}
+	err := query.Order("queued_at ASC, created_at ASC").Find(&deploys).Error
+	return deploys, err
+}
+
+// DeploymentQueryParams 部署查询参数，未设置的条件不过滤
+type DeploymentQueryParams struct {
+	Page      int        `form:"page"`
+	PageSize  int        `form:"page_size"`
+	AppID     *uuid.UUID `form:"app_id"`
+	EnvID     *uuid.UUID `form:"env_id"`
+	Status    *int       `form:"status"`
+	Type      string     `form:"type"` // deploy, rollback
+	CreatedBy *uuid.UUID `form:"created_by"`
+	StartTime *time.Time `form:"start_time"`
+	EndTime   *time.Time `form:"end_time"`
+	Keyword   string     `form:"keyword"` // 匹配版本号、提交 ID 或提交信息
+}
+
+// Query 按创建时间倒序分页查询部署，列表不返回部署日志
+func (r *DeploymentRepository) Query(params *DeploymentQueryParams) ([]model.Deployment, int64, error) {
 	var deploys []model.Deployment
 	var total int64
 
-	query := r.db.Model(&model.Deployment{}).Where("app_id = ?", appID)
+	query := r.db.Model(&model.Deployment{})
+
+	if params.AppID != nil {
+		query = query.Where("app_id = ?", *params.AppID)
+	}
+	if params.EnvID != nil {
+		query = query.Where("env_id = ?", *params.EnvID)
+	}
+	if params.Status != nil {
+		query = query.Where("status = ?", *params.Status)
+	}
+	if params.Type != "" {
+		query = query.Where("type = ?", params.Type)
+	}
+	if params.CreatedBy != nil {
+		query = query.Where("created_by = ?", *params.CreatedBy)
+	}
+	if params.StartTime != nil {
+		query = query.Where("created_at >= ?", params.StartTime)
+	}
+	if params.EndTime != nil {
+		query = query.Where("created_at <= ?", params.EndTime)
+	}
+	if params.Keyword != "" {
+		kw := LikeWrap(params.Keyword)
+		query = query.Where("version LIKE ? OR commit_id LIKE ? OR commit_msg LIKE ?", kw, kw, kw)
+	}
 
 	if err := query.Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
-	offset := (page - 1) * pageSize
-	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&deploys).Error; err != nil {
+	offset := (params.Page - 1) * params.PageSize
+	if err := query.Preload("App").Omit("output").Offset(offset).Limit(params.PageSize).Order("created_at DESC").Find(&deploys).Error; err != nil {
 		return nil, 0, err
 	}
 
 	return deploys, total, nil
 }
 
+// ListRunning 列出所有应用中正在执行的部署，含批次间暂停的部署
+func (r *DeploymentRepository) ListRunning() ([]model.Deployment, error) {
+	var deploys []model.Deployment
+	err := r.db.Preload("App").Omit("output").Where("status = 1").Order("start_time ASC").Find(&deploys).Error
+	return deploys, err
+}
+
 func (r *DeploymentRepository) GetLatestByAppID(appID uuid.UUID) (*model.Deployment, error) {
 	var deploy model.Deployment
 	err := r.db.Where("app_id = ? AND status = 2", appID).Order("created_at DESC").First(&deploy).Error
 	return &deploy, err
 }
+
+// LatestRelease 返回同一应用同一环境下最近一次成功且有发布目录的部署，没有时返回 nil
+func (r *DeploymentRepository) LatestRelease(appID uuid.UUID, envID *uuid.UUID) (*model.Deployment, error) {
+	query := r.db.Where("app_id = ? AND status = 2 AND release <> ''", appID)
+	if envID != nil {
+		query = query.Where("env_id = ?", *envID)
+	} else {
+		query = query.Where("env_id IS NULL")
+	}
+
+	var deploys []model.Deployment
+	if err := query.Order("end_time DESC").Limit(1).Find(&deploys).Error; err != nil {
+		return nil, err
+	}
+	if len(deploys) == 0 {
+		return nil, nil
+	}
+	return &deploys[0], nil
+}
+
+// HasNewer 同一应用同一环境下是否存在晚于 deploy 创建的部署，已驳回的部署未执行过，不计入
+func (r *DeploymentRepository) HasNewer(deploy *model.Deployment) (bool, error) {
+	query := r.db.Model(&model.Deployment{}).
+		Where("app_id = ? AND id <> ? AND created_at > ? AND status <> 7", deploy.AppID, deploy.ID, deploy.CreatedAt)
+	if deploy.EnvID != nil {
+		query = query.Where("env_id = ?", *deploy.EnvID)
+	} else {
+		query = query.Where("env_id IS NULL")
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// ListFinished 按结束时间升序列出 [from, to) 内结束的成功和失败部署，appID、envID 为 nil 时不过滤
+func (r *DeploymentRepository) ListFinished(from, to time.Time, appID, envID *uuid.UUID) ([]model.Deployment, error) {
+	query := r.db.Select("id", "app_id", "env_id", "type", "status", "commit_time", "start_time", "end_time", "created_at").
+		Preload("App", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name", "code") }).
+		Where("status IN ? AND end_time >= ? AND end_time < ?", []int{2, 3}, from, to)
+	if appID != nil {
+		query = query.Where("app_id = ?", *appID)
+	}
+	if envID != nil {
+		query = query.Where("env_id = ?", *envID)
+	}
+
+	var deploys []model.Deployment
+	err := query.Order("end_time ASC").Find(&deploys).Error
+	return deploys, err
+}
+
+// Deploy Script
+type DeployScriptRepository struct {
+	db *gorm.DB
+}
+
+func NewDeployScriptRepository(db *gorm.DB) *DeployScriptRepository {
+	return &DeployScriptRepository{db: db}
+}
+
+func (r *DeployScriptRepository) Create(script *model.DeployScript) error {
+	return r.db.Create(script).Error
+}
+
+func (r *DeployScriptRepository) GetByID(id uuid.UUID) (*model.DeployScript, error) {
+	var script model.DeployScript
+	err := r.db.First(&script, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+func (r *DeployScriptRepository) Update(script *model.DeployScript) error {
+	return r.db.Save(script).Error
+}
+
+func (r *DeployScriptRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&model.DeployScript{}, "id = ?", id).Error
+}
+
+func (r *DeployScriptRepository) ListByApp(appID uuid.UUID) ([]model.DeployScript, error) {
+	var scripts []model.DeployScript
+	err := r.db.Where("app_id = ?", appID).Order("sort ASC, created_at ASC").Find(&scripts).Error
+	return scripts, err
+}
+
+func (r *DeployScriptRepository) ListEnabledByApp(appID uuid.UUID) ([]model.DeployScript, error) {
+	var scripts []model.DeployScript
+	err := r.db.Where("app_id = ? AND enabled = ?", appID, true).Order("sort ASC, created_at ASC").Find(&scripts).Error
+	return scripts, err
+}
+
+// Artifact
+type ArtifactRepository struct {
+	db *gorm.DB
+}
+
+func NewArtifactRepository(db *gorm.DB) *ArtifactRepository {
+	return &ArtifactRepository{db: db}
+}
+
+func (r *ArtifactRepository) Create(artifact *model.Artifact) error {
+	return r.db.Create(artifact).Error
+}
+
+func (r *ArtifactRepository) GetByID(id uuid.UUID) (*model.Artifact, error) {
+	var artifact model.Artifact
+	err := r.db.First(&artifact, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+func (r *ArtifactRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&model.Artifact{}, "id = ?", id).Error
+}
+
+func (r *ArtifactRepository) ListByApp(appID uuid.UUID, page, pageSize int) ([]model.Artifact, int64, error) {
+	var artifacts []model.Artifact
+	var total int64
+
+	query := r.db.Model(&model.Artifact{}).Where("app_id = ?", appID)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&artifacts).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return artifacts, total, nil
+}
+
+// ListExpired 返回应用最新的 keep 个之外的构建产物
+func (r *ArtifactRepository) ListExpired(appID uuid.UUID, keep int) ([]model.Artifact, error) {
+	var artifacts []model.Artifact
+	err := r.db.Where("app_id = ?", appID).Order("created_at DESC").Offset(keep).Find(&artifacts).Error
+	return artifacts, err
+}
+
+// ListReferenced 返回应用仍可能被使用的产物：未结束的部署（等待、执行、排队、待审批）使用的产物，
+// 各环境最新成功部署的产物（可被晋级），以及各环境最新一次部署为失败或已取消时的产物（可被重试）
+func (r *ArtifactRepository) ListReferenced(appID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.Raw(`
+		SELECT artifact_id FROM deployments
+		WHERE app_id = ? AND artifact_id IS NOT NULL AND status IN (0, 1, 4, 6)
+		UNION
+		SELECT artifact_id FROM (
+			SELECT DISTINCT ON (env_id) artifact_id FROM deployments
+			WHERE app_id = ? AND status = 2
+			ORDER BY env_id, created_at DESC
+		) latest_success WHERE artifact_id IS NOT NULL
+		UNION
+		SELECT artifact_id FROM (
+			SELECT DISTINCT ON (env_id) artifact_id, status FROM deployments
+			WHERE app_id = ? AND status <> 7
+			ORDER BY env_id, created_at DESC
+		) latest WHERE artifact_id IS NOT NULL AND status IN (3, 5)
+	`, appID, appID, appID).Scan(&ids).Error
+	return ids, err
+}
+
+// Approval
+type ApprovalRepository struct {
+	db *gorm.DB
+}
+
+func NewApprovalRepository(db *gorm.DB) *ApprovalRepository {
+	return &ApprovalRepository{db: db}
+}
+
+// GetPolicyByEnv 获取环境的审批策略，未配置时返回 nil
+func (r *ApprovalRepository) GetPolicyByEnv(envID uuid.UUID) (*model.ApprovalPolicy, error) {
+	var policies []model.ApprovalPolicy
+	if err := r.db.Where("env_id = ?", envID).Limit(1).Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return &policies[0], nil
+}
+
+func (r *ApprovalRepository) SavePolicy(policy *model.ApprovalPolicy) error {
+	return r.db.Save(policy).Error
+}
+
+func (r *ApprovalRepository) Create(approval *model.DeploymentApproval) error {
+	return r.db.Create(approval).Error
+}
+
+func (r *ApprovalRepository) ListByDeployment(deploymentID uuid.UUID) ([]model.DeploymentApproval, error) {
+	var approvals []model.DeploymentApproval
+	err := r.db.Where("deployment_id = ?", deploymentID).Order("created_at ASC").Find(&approvals).Error
+	return approvals, err
+}
+
+func (r *ApprovalRepository) HasDecided(deploymentID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&model.DeploymentApproval{}).
+		Where("deployment_id = ? AND user_id = ?", deploymentID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *ApprovalRepository) CountApproved(deploymentID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.DeploymentApproval{}).
+		Where("deployment_id = ? AND decision = ?", deploymentID, "approved").Count(&count).Error
+	return count, err
+}
+
+// UserInGroups 用户是否属于任一指定用户组
+func (r *ApprovalRepository) UserInGroups(userID uuid.UUID, groupIDs []uuid.UUID) (bool, error) {
+	if len(groupIDs) == 0 {
+		return false, nil
+	}
+	var count int64
+	err := r.db.Table("user_group_members").
+		Where("user_id = ? AND user_group_id IN ?", userID, groupIDs).Count(&count).Error
+	return count > 0, err
+}
+
+// Freeze Window
+type FreezeWindowRepository struct {
+	db *gorm.DB
+}
+
+func NewFreezeWindowRepository(db *gorm.DB) *FreezeWindowRepository {
+	return &FreezeWindowRepository{db: db}
+}
+
+func (r *FreezeWindowRepository) Create(window *model.FreezeWindow) error {
+	return r.db.Create(window).Error
+}
+
+func (r *FreezeWindowRepository) GetByID(id uuid.UUID) (*model.FreezeWindow, error) {
+	var window model.FreezeWindow
+	err := r.db.First(&window, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+func (r *FreezeWindowRepository) Update(window *model.FreezeWindow) error {
+	return r.db.Save(window).Error
+}
+
+func (r *FreezeWindowRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&model.FreezeWindow{}, "id = ?", id).Error
+}
+
+// ListByEnv 列出环境的封版窗口，envID 为 nil 时列出所有环境
+func (r *FreezeWindowRepository) ListByEnv(envID *uuid.UUID) ([]model.FreezeWindow, error) {
+	var windows []model.FreezeWindow
+	query := r.db.Model(&model.FreezeWindow{})
+	if envID != nil {
+		query = query.Where("env_id = ?", *envID)
+	}
+	err := query.Order("created_at ASC").Find(&windows).Error
+	return windows, err
+}
+
+func (r *FreezeWindowRepository) ListEnabledByEnv(envID uuid.UUID) ([]model.FreezeWindow, error) {
+	var windows []model.FreezeWindow
+	err := r.db.Where("env_id = ? AND enabled = ?", envID, true).Find(&windows).Error
+	return windows, err
+}
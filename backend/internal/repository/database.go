@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -12,11 +13,15 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-func InitDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
+func dsn(cfg *config.DatabaseConfig) string {
+	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
 	)
+}
+
+func InitDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
+	dsn := dsn(cfg)
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
@@ -47,10 +52,23 @@ func InitDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
 		&model.HostTag{},
 		&model.AlertRule{},
 		&model.AlertHistory{},
+		&model.Agent{},
+		&model.AgentBootstrapToken{},
+		&model.HostMetric{},
+		&model.HostMetricRollup{},
+		&model.AgentCommand{},
 		&model.Application{},
 		&model.Environment{},
 		&model.Deployment{},
 		&model.DeployScript{},
+		&model.Artifact{},
+		&model.ApprovalPolicy{},
+		&model.DeploymentApproval{},
+		&model.FreezeWindow{},
+		&model.WebhookDelivery{},
+		&model.Pipeline{},
+		&model.PipelineHistory{},
+		&model.PipelineRun{},
 		&model.ConfigItem{},
 		&model.ConfigHistory{},
 		&model.Cluster{},
@@ -61,3 +79,25 @@ func InitDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// OpenLockDB 打开部署锁专用的连接池。会话级 advisory lock 在持有期间独占一个连接，
+// 与业务查询共用连接池时，长时间运行或暂停的部署会耗尽连接池
+func OpenLockDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn(cfg)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect lock database: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+	maxConns := cfg.LockMaxConns
+	if maxConns <= 0 {
+		maxConns = 50
+	}
+	sqlDB.SetMaxOpenConns(maxConns)
+	sqlDB.SetMaxIdleConns(2)
+	return sqlDB, nil
+}
@@ -37,6 +37,13 @@ func (r *HostRepository) GetByIP(ip string) (*model.Host, error) {
 	return &host, nil
 }
 
+// ListByHostname 按主机名查找主机，用于将 Agent 关联到主机
+func (r *HostRepository) ListByHostname(hostname string) ([]model.Host, error) {
+	var hosts []model.Host
+	err := r.db.Where("hostname = ?", hostname).Find(&hosts).Error
+	return hosts, err
+}
+
 func (r *HostRepository) Update(host *model.Host) error {
 	return r.db.Save(host).Error
 }
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PipelineRepository struct {
+	db *gorm.DB
+}
+
+func NewPipelineRepository(db *gorm.DB) *PipelineRepository {
+	return &PipelineRepository{db: db}
+}
+
+// GetByApp 获取应用的流水线定义，未定义时返回 nil
+func (r *PipelineRepository) GetByApp(appID uuid.UUID) (*model.Pipeline, error) {
+	var pipelines []model.Pipeline
+	if err := r.db.Where("app_id = ?", appID).Limit(1).Find(&pipelines).Error; err != nil {
+		return nil, err
+	}
+	if len(pipelines) == 0 {
+		return nil, nil
+	}
+	return &pipelines[0], nil
+}
+
+func (r *PipelineRepository) Save(pipeline *model.Pipeline) error {
+	return r.db.Save(pipeline).Error
+}
+
+// Pipeline History
+type PipelineHistoryRepository struct {
+	db *gorm.DB
+}
+
+func NewPipelineHistoryRepository(db *gorm.DB) *PipelineHistoryRepository {
+	return &PipelineHistoryRepository{db: db}
+}
+
+func (r *PipelineHistoryRepository) Create(history *model.PipelineHistory) error {
+	return r.db.Create(history).Error
+}
+
+func (r *PipelineHistoryRepository) ListByApp(appID uuid.UUID, limit int) ([]model.PipelineHistory, error) {
+	var histories []model.PipelineHistory
+	err := r.db.Where("app_id = ?", appID).Order("version DESC").Limit(limit).Find(&histories).Error
+	return histories, err
+}
+
+func (r *PipelineHistoryRepository) GetByVersion(appID uuid.UUID, version int) (*model.PipelineHistory, error) {
+	var history model.PipelineHistory
+	err := r.db.Where("app_id = ? AND version = ?", appID, version).First(&history).Error
+	return &history, err
+}
+
+// Pipeline Run
+type PipelineRunRepository struct {
+	db *gorm.DB
+}
+
+func NewPipelineRunRepository(db *gorm.DB) *PipelineRunRepository {
+	return &PipelineRunRepository{db: db}
+}
+
+func (r *PipelineRunRepository) Create(run *model.PipelineRun) error {
+	return r.db.Create(run).Error
+}
+
+func (r *PipelineRunRepository) GetByID(id uuid.UUID) (*model.PipelineRun, error) {
+	var run model.PipelineRun
+	err := r.db.Preload("App").First(&run, "id = ?", id).Error
+	return &run, err
+}
+
+func (r *PipelineRunRepository) List(appID uuid.UUID, page, pageSize int) ([]model.PipelineRun, int64, error) {
+	var runs []model.PipelineRun
+	var total int64
+
+	query := r.db.Model(&model.PipelineRun{}).Where("app_id = ?", appID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	if err := query.Omit("content").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&runs).Error; err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+func (r *PipelineRunRepository) UpdateStatus(id uuid.UUID, status int) error {
+	return r.db.Model(&model.PipelineRun{}).Where("id = ?", id).Update("status", status).Error
+}
+
+func (r *PipelineRunRepository) UpdateSteps(id uuid.UUID, steps string) error {
+	return r.db.Model(&model.PipelineRun{}).Where("id = ?", id).Update("steps", steps).Error
+}
+
+func (r *PipelineRunRepository) Start(id uuid.UUID, startTime time.Time) error {
+	return r.db.Model(&model.PipelineRun{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     1,
+			"start_time": startTime,
+		}).Error
+}
+
+func (r *PipelineRunRepository) Finish(id uuid.UUID, status int, endTime time.Time) error {
+	return r.db.Model(&model.PipelineRun{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":   status,
+			"end_time": endTime,
+		}).Error
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrHostNoAgent          = errors.New("host has no connected agent")
+	ErrAgentCommandNotFound = errors.New("agent command not found")
+	ErrAgentCommandFinished = errors.New("agent command is not waiting for a result")
+	ErrAgentCommandInvalid  = errors.New("invalid agent command")
+)
+
+const (
+	// agentCheckInterval 检查 Agent 心跳和命令超时的间隔
+	agentCheckInterval = 15 * time.Second
+	// agentCommandGrace 命令超时后等待 Agent 回报结果的额外时间
+	agentCommandGrace = time.Minute
+	// agentPollBatch Agent 每次拉取的命令数。Agent 串行执行拉取到的命令，逐条下发使下发时间即开始执行时间，
+	// 否则同一批中排在后面的命令等待期间就会被按超时处理
+	agentPollBatch = 1
+	// maxAgentCommandOutput 保存的命令输出上限，超出时保留末尾部分
+	maxAgentCommandOutput  = 64 << 10
+	maxAgentCommandTimeout = 3600
+)
+
+// AgentService 管理主机 Agent 的注册和凭证，接收 Agent 上报的指标、下发命令并记录执行结果。
+// 有 Agent 的主机在线状态由心跳决定
+type AgentService struct {
+	agentRepo     *repository.AgentRepository
+	bootstrapRepo *repository.AgentBootstrapTokenRepository
+	metricRepo    *repository.HostMetricRepository
+	commandRepo   *repository.AgentCommandRepository
+	hostRepo      *repository.HostRepository
+	offlineAfter  time.Duration
+}
+
+func NewAgentService(
+	agentRepo *repository.AgentRepository,
+	bootstrapRepo *repository.AgentBootstrapTokenRepository,
+	metricRepo *repository.HostMetricRepository,
+	commandRepo *repository.AgentCommandRepository,
+	hostRepo *repository.HostRepository,
+	offlineAfter int,
+) *AgentService {
+	return &AgentService{
+		agentRepo:     agentRepo,
+		bootstrapRepo: bootstrapRepo,
+		metricRepo:    metricRepo,
+		commandRepo:   commandRepo,
+		hostRepo:      hostRepo,
+		offlineAfter:  time.Duration(positiveOr(offlineAfter, 60)) * time.Second,
+	}
+}
+
+// AgentMetrics Agent 上报的指标，与 agent/collector.Metrics 一致
+type AgentMetrics struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Platform string `json:"platform"`
+	Uptime   uint64 `json:"uptime"`
+	CPU      struct {
+		UsagePercent float64 `json:"usage_percent"`
+		Cores        int     `json:"cores"`
+	} `json:"cpu"`
+	Memory struct {
+		Total       uint64  `json:"total"`
+		Used        uint64  `json:"used"`
+		Available   uint64  `json:"available"`
+		UsedPercent float64 `json:"used_percent"`
+	} `json:"memory"`
+	Disk struct {
+		Total       uint64  `json:"total"`
+		Used        uint64  `json:"used"`
+		Free        uint64  `json:"free"`
+		UsedPercent float64 `json:"used_percent"`
+	} `json:"disk"`
+	Network struct {
+		BytesSent   uint64 `json:"bytes_sent"`
+		BytesRecv   uint64 `json:"bytes_recv"`
+		PacketsSent uint64 `json:"packets_sent"`
+		PacketsRecv uint64 `json:"packets_recv"`
+	} `json:"network"`
+	Load struct {
+		Load1  float64 `json:"load1"`
+		Load5  float64 `json:"load5"`
+		Load15 float64 `json:"load15"`
+	} `json:"load"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// AgentCommandPayload 下发给 Agent 的命令，与 agent/executor.Command 一致
+type AgentCommandPayload struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Timeout int    `json:"timeout"`
+}
+
+// AgentCommandResult Agent 回报的命令结果，与 agent/executor.CommandResult 一致
+type AgentCommandResult struct {
+	ID       string `json:"id" binding:"required"`
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error"`
+}
+
+// Report 记录 Agent 心跳和上报的指标
+func (s *AgentService) Report(agent *model.Agent, metrics *AgentMetrics) error {
+	now := time.Now()
+	if err := s.agentRepo.Touch(agent.ID, now); err != nil {
+		return err
+	}
+
+	if agent.HostID != nil {
+		if err := s.hostRepo.UpdateStatus(*agent.HostID, 1); err != nil {
+			log.Printf("Failed to mark host %s online: %v", *agent.HostID, err)
+		}
+	}
+
+	collectedAt := metrics.CollectedAt
+	if collectedAt.IsZero() {
+		collectedAt = now
+	}
+	return s.metricRepo.Create(&model.HostMetric{
+		AgentID:        agent.ID,
+		HostID:         agent.HostID,
+		CPUPercent:     metrics.CPU.UsagePercent,
+		CPUCores:       metrics.CPU.Cores,
+		MemTotal:       metrics.Memory.Total,
+		MemUsed:        metrics.Memory.Used,
+		MemPercent:     metrics.Memory.UsedPercent,
+		DiskTotal:      metrics.Disk.Total,
+		DiskUsed:       metrics.Disk.Used,
+		DiskPercent:    metrics.Disk.UsedPercent,
+		NetBytesSent:   metrics.Network.BytesSent,
+		NetBytesRecv:   metrics.Network.BytesRecv,
+		NetPacketsSent: metrics.Network.PacketsSent,
+		NetPacketsRecv: metrics.Network.PacketsRecv,
+		Load1:          metrics.Load.Load1,
+		Load5:          metrics.Load.Load5,
+		Load15:         metrics.Load.Load15,
+		Uptime:         metrics.Uptime,
+		CollectedAt:    collectedAt,
+	})
+}
+
+// PollCommands 返回并领取 Agent 所在主机最早的一条待执行命令，拉取同时视为一次心跳。
+// 命令的超时从下发时开始计算
+func (s *AgentService) PollCommands(agent *model.Agent) ([]AgentCommandPayload, error) {
+	payloads := []AgentCommandPayload{}
+	now := time.Now()
+	if err := s.agentRepo.Touch(agent.ID, now); err != nil {
+		log.Printf("Failed to update heartbeat of agent %s: %v", agent.ID, err)
+	}
+	if agent.HostID == nil {
+		return payloads, nil
+	}
+
+	cmds, err := s.commandRepo.ListPending(*agent.HostID, agentPollBatch)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		claimed, err := s.commandRepo.Dispatch(cmd.ID, agent.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+		payloads = append(payloads, AgentCommandPayload{
+			ID:      cmd.ID.String(),
+			Command: cmd.Command,
+			Timeout: cmd.Timeout,
+		})
+	}
+	return payloads, nil
+}
+
+// ReportResult 记录命令的执行结果，只接受领取了该命令的 Agent 的回报
+func (s *AgentService) ReportResult(agent *model.Agent, result *AgentCommandResult) error {
+	id, err := uuid.Parse(result.ID)
+	if err != nil {
+		return ErrAgentCommandNotFound
+	}
+	cmd, err := s.commandRepo.GetByID(id)
+	if err != nil || cmd.AgentID == nil || *cmd.AgentID != agent.ID {
+		return ErrAgentCommandNotFound
+	}
+
+	status := 2 // success
+	if result.ExitCode != 0 || result.Error != "" {
+		status = 3 // failed
+	}
+	// PostgreSQL 的 text 不能包含 NUL，截断后也需保持 UTF-8 有效
+	output := strings.ReplaceAll(result.Output, "\x00", "")
+	if len(output) > maxAgentCommandOutput {
+		output = strings.ToValidUTF8(output[len(output)-maxAgentCommandOutput:], "")
+	}
+	errMsg := result.Error
+	if runes := []rune(errMsg); len(runes) > 500 {
+		errMsg = string(runes[:500])
+	}
+
+	finished, err := s.commandRepo.Finish(id, status, output, result.ExitCode, errMsg, time.Now())
+	if err != nil {
+		return err
+	}
+	if !finished {
+		return ErrAgentCommandFinished
+	}
+	return nil
+}
+
+type CreateAgentCommandRequest struct {
+	Command string `json:"command" binding:"required"`
+	Timeout int    `json:"timeout"` // seconds, 默认 60
+}
+
+// CreateCommand 为主机创建待下发的命令，主机需已关联 Agent
+func (s *AgentService) CreateCommand(hostID uuid.UUID, req *CreateAgentCommandRequest, createdBy uuid.UUID, username string) (*model.AgentCommand, error) {
+	if _, err := s.hostRepo.GetByID(hostID); err != nil {
+		return nil, ErrHostNotFound
+	}
+	if req.Timeout < 0 || req.Timeout > maxAgentCommandTimeout {
+		return nil, ErrAgentCommandInvalid
+	}
+	count, err := s.agentRepo.CountByHost(hostID)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrHostNoAgent
+	}
+
+	cmd := &model.AgentCommand{
+		HostID:    hostID,
+		Command:   req.Command,
+		Timeout:   positiveOr(req.Timeout, 60),
+		CreatedBy: createdBy,
+		Username:  username,
+	}
+	if err := s.commandRepo.Create(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func (s *AgentService) GetCommand(id uuid.UUID) (*model.AgentCommand, error) {
+	cmd, err := s.commandRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrAgentCommandNotFound
+	}
+	return cmd, nil
+}
+
+func (s *AgentService) ListCommands(hostID uuid.UUID, page, pageSize int) ([]model.AgentCommand, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.commandRepo.ListByHost(hostID, page, pageSize)
+}
+
+func (s *AgentService) ListAgents() ([]model.Agent, error) {
+	return s.agentRepo.List()
+}
+
+// Run 定期将心跳超时的主机标记为离线，并将长时间未回报结果的命令标记为超时，直到 ctx 结束
+func (s *AgentService) Run(ctx context.Context) {
+	ticker := time.NewTicker(agentCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := s.agentRepo.MarkStaleHostsOffline(now.Add(-s.offlineAfter)); err != nil {
+				log.Printf("Failed to check agent heartbeats: %v", err)
+			} else if n > 0 {
+				log.Printf("Marked %d host(s) offline after missing agent heartbeats", n)
+			}
+			if _, err := s.commandRepo.ExpireDispatched(now, agentCommandGrace); err != nil {
+				log.Printf("Failed to expire agent commands: %v", err)
+			}
+		}
+	}
+}
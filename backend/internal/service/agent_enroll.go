@@ -0,0 +1,359 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAgentNotFound          = errors.New("agent not found")
+	ErrAgentUnauthorized      = errors.New("invalid agent credential")
+	ErrAgentRevoked           = errors.New("agent credential has been revoked")
+	ErrBootstrapTokenInvalid  = errors.New("bootstrap token is invalid, expired or already used")
+	ErrBootstrapTokenNotFound = errors.New("bootstrap token not found")
+	ErrAgentHostBound         = errors.New("host already has an active agent")
+)
+
+const (
+	defaultBootstrapTTL = 24  // hours
+	maxBootstrapTTL     = 720 // hours
+)
+
+// AgentCredential Agent 的长期凭证，只在注册和轮换时返回一次
+type AgentCredential struct {
+	AgentID uuid.UUID  `json:"agent_id"`
+	HostID  *uuid.UUID `json:"host_id"`
+	Token   string     `json:"token"`
+}
+
+type CreateBootstrapTokenRequest struct {
+	Description string     `json:"description"`
+	ExpiresIn   int        `json:"expires_in"` // hours, 默认 24，最长 720
+	HostID      *uuid.UUID `json:"host_id"`    // 指定时只能注册到该主机，用于重新接入已有 Agent 的主机
+}
+
+// BootstrapTokenResult 创建的引导令牌，Token 只在创建时返回一次
+type BootstrapTokenResult struct {
+	*model.AgentBootstrapToken
+	Token string `json:"token"`
+}
+
+// CreateBootstrapToken 创建一次性引导令牌，数据库只保存令牌的哈希
+func (s *AgentService) CreateBootstrapToken(req *CreateBootstrapTokenRequest, createdBy uuid.UUID, username string) (*BootstrapTokenResult, error) {
+	ttl := positiveOr(req.ExpiresIn, defaultBootstrapTTL)
+	if ttl > maxBootstrapTTL {
+		ttl = maxBootstrapTTL
+	}
+	if req.HostID != nil {
+		if _, err := s.hostRepo.GetByID(*req.HostID); err != nil {
+			return nil, ErrHostNotFound
+		}
+	}
+	plain, hash, err := newAgentToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &model.AgentBootstrapToken{
+		TokenHash:   hash,
+		HostID:      req.HostID,
+		Description: req.Description,
+		ExpiresAt:   time.Now().Add(time.Duration(ttl) * time.Hour),
+		CreatedBy:   createdBy,
+		Username:    username,
+	}
+	if err := s.bootstrapRepo.Create(token); err != nil {
+		return nil, err
+	}
+	return &BootstrapTokenResult{AgentBootstrapToken: token, Token: plain}, nil
+}
+
+func (s *AgentService) ListBootstrapTokens() ([]model.AgentBootstrapToken, error) {
+	return s.bootstrapRepo.List()
+}
+
+func (s *AgentService) DeleteBootstrapToken(id uuid.UUID) error {
+	deleted, err := s.bootstrapRepo.Delete(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrBootstrapTokenNotFound
+	}
+	return nil
+}
+
+// AgentRegisterRequest Agent 注册时上报的主机信息，来自 gopsutil host.Info()
+type AgentRegisterRequest struct {
+	Hostname        string `json:"hostname" binding:"required,max=100,hostname_rfc1123"`
+	OS              string `json:"os" binding:"max=50"`
+	Platform        string `json:"platform" binding:"max=50"`
+	PlatformVersion string `json:"platform_version" binding:"max=50"`
+	Arch            string `json:"arch" binding:"max=20"`
+}
+
+// Register 使用引导令牌注册 Agent 并签发长期凭证。
+// 同一主机名和来源 IP 重新注册时沿用原 Agent 记录并替换凭证；Agent 按主机名或 IP 关联已有主机，找不到时自动创建主机。
+// 主机名和来源 IP 均可伪造，因此已有未吊销 Agent 的主机不会被重新绑定，需管理员先吊销原 Agent，
+// 或创建指定该主机的引导令牌（注册时吊销主机上原有的 Agent）。
+// 绑定检查通过后才消费引导令牌，被拒绝或注册失败的请求不会使令牌失效
+func (s *AgentService) Register(bootstrap string, req *AgentRegisterRequest, ip string) (*AgentCredential, error) {
+	if bootstrap == "" {
+		return nil, ErrBootstrapTokenInvalid
+	}
+	now := time.Now()
+	tokenHash := hashAgentToken(bootstrap)
+	token, err := s.bootstrapRepo.GetUsable(tokenHash, now)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, ErrBootstrapTokenInvalid
+	}
+
+	agent, err := s.agentRepo.GetByIdentity(req.Hostname, ip)
+	if err != nil {
+		return nil, err
+	}
+	if agent == nil {
+		agent = &model.Agent{Hostname: req.Hostname, IP: ip}
+	}
+	host, err := s.findHost(agent, token, req.Hostname, ip)
+	if err != nil {
+		return nil, err
+	}
+	var others int64
+	if host != nil {
+		if others, err = s.agentRepo.CountOthersByHost(host.ID, agent.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkAgentBinding(agent, token, others); err != nil {
+		return nil, err
+	}
+
+	// 同一令牌的并发注册只有一个能消费成功
+	consumed, err := s.bootstrapRepo.Consume(tokenHash, now)
+	if err != nil {
+		return nil, err
+	}
+	if consumed == nil {
+		return nil, ErrBootstrapTokenInvalid
+	}
+
+	cred, err := s.bind(agent, token, host, others, req, ip, now)
+	if err != nil {
+		if err := s.bootstrapRepo.Release(token.ID); err != nil {
+			log.Printf("Failed to release bootstrap token %s: %v", token.ID, err)
+		}
+		return nil, err
+	}
+	return cred, nil
+}
+
+// checkAgentBinding 未指定主机的引导令牌不能接管仍有未吊销 Agent 的记录或主机，others 为主机上的其他未吊销 Agent 数
+func checkAgentBinding(agent *model.Agent, token *model.AgentBootstrapToken, others int64) error {
+	if token.HostID != nil {
+		return nil
+	}
+	if agent.ID != uuid.Nil && agent.RevokedAt == nil {
+		return ErrAgentHostBound
+	}
+	if others > 0 {
+		return ErrAgentHostBound
+	}
+	return nil
+}
+
+// bind 签发凭证并将 Agent 绑定到主机，host 为 nil 时创建主机。指定主机的引导令牌吊销主机上原有的 Agent
+func (s *AgentService) bind(agent *model.Agent, token *model.AgentBootstrapToken, host *model.Host, others int64, req *AgentRegisterRequest, ip string, now time.Time) (*AgentCredential, error) {
+	plain, hash, err := newAgentToken()
+	if err != nil {
+		return nil, err
+	}
+	if host != nil && others > 0 {
+		if err := s.agentRepo.RevokeOthersByHost(host.ID, agent.ID, now); err != nil {
+			return nil, err
+		}
+		log.Printf("Revoked previous agents of host %s for bootstrap token %s", host.Name, token.ID)
+	}
+
+	agent.OS = req.OS
+	agent.Platform = req.Platform
+	agent.Arch = req.Arch
+	agent.TokenHash = hash
+	agent.PendingTokenHash = ""
+	agent.RotateRequested = false
+	agent.RevokedAt = nil
+	agent.LastSeenAt = now
+
+	host, err = s.registerHost(host, req, ip)
+	if err != nil {
+		return nil, err
+	}
+	agent.HostID = &host.ID
+	agent.Host = nil
+
+	if agent.ID == uuid.Nil {
+		err = s.agentRepo.Create(agent)
+	} else {
+		err = s.agentRepo.Update(agent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bootstrapRepo.SetAgent(token.ID, agent.ID); err != nil {
+		log.Printf("Failed to record agent %s on bootstrap token %s: %v", agent.ID, token.ID, err)
+	}
+	log.Printf("Agent %s (%s) registered for host %s", req.Hostname, ip, host.Name)
+
+	return &AgentCredential{AgentID: agent.ID, HostID: agent.HostID, Token: plain}, nil
+}
+
+// findHost 查找 Agent 要绑定的主机：引导令牌指定的主机、Agent 原来绑定的主机，或按主机名和 IP 匹配的主机。
+// 找不到时返回 nil，由 registerHost 创建
+func (s *AgentService) findHost(agent *model.Agent, token *model.AgentBootstrapToken, hostname, ip string) (*model.Host, error) {
+	if token.HostID != nil {
+		host, err := s.hostRepo.GetByID(*token.HostID)
+		if err != nil {
+			return nil, ErrHostNotFound
+		}
+		return host, nil
+	}
+	if agent.HostID != nil {
+		if linked, err := s.hostRepo.GetByID(*agent.HostID); err == nil {
+			return linked, nil
+		}
+	}
+	return s.matchHost(hostname, ip), nil
+}
+
+// registerHost 更新 Agent 绑定主机的主机名、系统和架构，host 为 nil 时创建主机
+func (s *AgentService) registerHost(host *model.Host, req *AgentRegisterRequest, ip string) (*model.Host, error) {
+	osName := strings.TrimSpace(req.Platform + " " + req.PlatformVersion)
+	if osName == "" {
+		osName = req.OS
+	}
+	if host == nil {
+		host = &model.Host{
+			Name:        req.Hostname,
+			Hostname:    req.Hostname,
+			IP:          ip,
+			Port:        22,
+			OS:          osName,
+			Arch:        req.Arch,
+			Status:      1,
+			Description: "由 Agent 注册自动创建",
+		}
+		if err := s.hostRepo.Create(host); err != nil {
+			return nil, err
+		}
+		return host, nil
+	}
+
+	host.Hostname = req.Hostname
+	host.OS = osName
+	host.Arch = req.Arch
+	host.Status = 1
+	host.Group = nil
+	host.Tags = nil
+	if err := s.hostRepo.Update(host); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// matchHost 主机名唯一匹配时使用该主机，否则按来源 IP 匹配
+func (s *AgentService) matchHost(hostname, ip string) *model.Host {
+	if hostname != "" {
+		hosts, err := s.hostRepo.ListByHostname(hostname)
+		if err != nil {
+			log.Printf("Failed to look up host by hostname %s: %v", hostname, err)
+		} else if len(hosts) == 1 {
+			return &hosts[0]
+		}
+	}
+	if host, err := s.hostRepo.GetByIP(ip); err == nil {
+		return host
+	}
+	return nil
+}
+
+// Authenticate 按凭证查找 Agent。Agent 首次使用轮换得到的新凭证时，新凭证替换旧凭证
+func (s *AgentService) Authenticate(token string) (*model.Agent, error) {
+	if token == "" {
+		return nil, ErrAgentUnauthorized
+	}
+	hash := hashAgentToken(token)
+	agent, err := s.agentRepo.GetByTokenHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if agent == nil {
+		return nil, ErrAgentUnauthorized
+	}
+	if agent.PendingTokenHash == hash {
+		if err := s.agentRepo.PromotePendingToken(agent.ID, hash); err != nil {
+			return nil, err
+		}
+		agent.TokenHash = hash
+		agent.PendingTokenHash = ""
+	}
+	return agent, nil
+}
+
+// Rotate 为 Agent 签发新凭证。旧凭证在 Agent 首次使用新凭证前仍然有效，避免 Agent 保存失败后无法访问
+func (s *AgentService) Rotate(agent *model.Agent) (*AgentCredential, error) {
+	plain, hash, err := newAgentToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.agentRepo.SetPendingToken(agent.ID, hash); err != nil {
+		return nil, err
+	}
+	return &AgentCredential{AgentID: agent.ID, HostID: agent.HostID, Token: plain}, nil
+}
+
+// RequestRotate 要求 Agent 轮换凭证，Agent 在下一次请求的响应中收到通知后自行换取新凭证
+func (s *AgentService) RequestRotate(id uuid.UUID) error {
+	agent, err := s.agentRepo.GetByID(id)
+	if err != nil {
+		return ErrAgentNotFound
+	}
+	if agent.RevokedAt != nil {
+		return ErrAgentRevoked
+	}
+	return s.agentRepo.RequestRotate(id)
+}
+
+// Revoke 吊销 Agent 的凭证，Agent 需使用新的引导令牌重新注册
+func (s *AgentService) Revoke(id uuid.UUID) error {
+	if _, err := s.agentRepo.GetByID(id); err != nil {
+		return ErrAgentNotFound
+	}
+	return s.agentRepo.Revoke(id, time.Now())
+}
+
+// newAgentToken 生成随机令牌，返回明文和用于存储的哈希
+func newAgentToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	plain := hex.EncodeToString(buf)
+	return plain, hashAgentToken(plain), nil
+}
+
+func hashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
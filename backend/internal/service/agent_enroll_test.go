@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckAgentBinding(t *testing.T) {
+	hostID := uuid.New()
+	revokedAt := time.Now()
+	newAgent := &model.Agent{}
+	activeAgent := &model.Agent{ID: uuid.New()}
+	revokedAgent := &model.Agent{ID: uuid.New(), RevokedAt: &revokedAt}
+	anyHost := &model.AgentBootstrapToken{}
+	pinned := &model.AgentBootstrapToken{HostID: &hostID}
+
+	tests := []struct {
+		name    string
+		agent   *model.Agent
+		token   *model.AgentBootstrapToken
+		others  int64
+		wantErr error
+	}{
+		{name: "new agent on a free host", agent: newAgent, token: anyHost},
+		{name: "new agent on a host with an agent", agent: newAgent, token: anyHost, others: 1, wantErr: ErrAgentHostBound},
+		{name: "re-register an active agent", agent: activeAgent, token: anyHost, wantErr: ErrAgentHostBound},
+		{name: "re-register a revoked agent", agent: revokedAgent, token: anyHost},
+		{name: "revoked agent on a host with another agent", agent: revokedAgent, token: anyHost, others: 2, wantErr: ErrAgentHostBound},
+		{name: "pinned token replaces an active agent", agent: activeAgent, token: pinned},
+		{name: "pinned token replaces the host's agents", agent: newAgent, token: pinned, others: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkAgentBinding(tt.agent, tt.token, tt.others); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkAgentBinding = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
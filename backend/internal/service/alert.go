@@ -0,0 +1,595 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/notify"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	ErrAlertRuleInvalid  = errors.New("invalid alert rule")
+)
+
+const (
+	// alertEvalInterval 告警规则的评估间隔
+	alertEvalInterval = 30 * time.Second
+	// alertStaleAfter 主机最近一次上报早于该时间时视为没有数据，已触发的告警随之恢复
+	alertStaleAfter = 2 * time.Minute
+	// maxAlertDuration 持续时间上限，原始指标只保留 24 小时
+	maxAlertDuration = 86400
+	// alertRawWindow 评估时只读取该范围内的原始指标，持续时间更长的规则在此之前的部分使用 1 分钟汇总数据，
+	// 该范围需大于汇总任务的间隔和迟到上报的重算范围
+	alertRawWindow = 10 * time.Minute
+)
+
+// alertMetricUnits 告警规则支持的指标，network 为收发速率之和
+var alertMetricUnits = map[string]string{
+	"cpu":     "%",
+	"memory":  "%",
+	"disk":    "%",
+	"network": " B/s",
+	"load":    "",
+}
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// AlertService 管理告警规则，定期使用 Agent 上报的指标评估规则，并通过配置的渠道发送告警和恢复通知。
+// Alertmanager 推送的告警也记录在同一告警历史中
+type AlertService struct {
+	ruleRepo             *repository.AlertRuleRepository
+	historyRepo          *repository.AlertHistoryRepository
+	metricRepo           *repository.HostMetricRepository
+	hostRepo             *repository.HostRepository
+	channels             map[string]notify.Notifier
+	webhookToken         string
+	alertmanagerChannels []string
+	webhookMu            sync.Mutex
+}
+
+func NewAlertService(
+	ruleRepo *repository.AlertRuleRepository,
+	historyRepo *repository.AlertHistoryRepository,
+	metricRepo *repository.HostMetricRepository,
+	hostRepo *repository.HostRepository,
+	channels map[string]notify.Notifier,
+	webhookToken string,
+	alertmanagerChannels []string,
+) *AlertService {
+	return &AlertService{
+		ruleRepo:             ruleRepo,
+		historyRepo:          historyRepo,
+		metricRepo:           metricRepo,
+		hostRepo:             hostRepo,
+		channels:             channels,
+		webhookToken:         webhookToken,
+		alertmanagerChannels: alertmanagerChannels,
+	}
+}
+
+type CreateAlertRuleRequest struct {
+	Name        string      `json:"name" binding:"required"`
+	Metric      string      `json:"metric" binding:"required"`
+	Operator    string      `json:"operator" binding:"required"`
+	Threshold   float64     `json:"threshold"`
+	Duration    *int        `json:"duration"` // seconds, 默认 60
+	Severity    string      `json:"severity"` // 默认 warning
+	Enabled     *bool       `json:"enabled"`
+	HostIDs     []uuid.UUID `json:"host_ids"` // 为空时对所有接入 Agent 的主机生效
+	Channels    []string    `json:"channels"`
+	Description string      `json:"description"`
+}
+
+type UpdateAlertRuleRequest struct {
+	Name        *string      `json:"name"`
+	Metric      *string      `json:"metric"`
+	Operator    *string      `json:"operator"`
+	Threshold   *float64     `json:"threshold"`
+	Duration    *int         `json:"duration"`
+	Severity    *string      `json:"severity"`
+	Enabled     *bool        `json:"enabled"`
+	HostIDs     *[]uuid.UUID `json:"host_ids"`
+	Channels    *[]string    `json:"channels"`
+	Description *string      `json:"description"`
+}
+
+func (s *AlertService) CreateRule(req *CreateAlertRuleRequest) (*model.AlertRule, error) {
+	duration := 60
+	if req.Duration != nil {
+		duration = *req.Duration
+	}
+	severity := req.Severity
+	if severity == "" {
+		severity = "warning"
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	rule := &model.AlertRule{
+		Name:        req.Name,
+		Metric:      req.Metric,
+		Operator:    req.Operator,
+		Threshold:   req.Threshold,
+		Duration:    duration,
+		Severity:    severity,
+		Enabled:     enabled,
+		Description: req.Description,
+	}
+	if err := s.setRuleTargets(rule, req.HostIDs, req.Channels); err != nil {
+		return nil, err
+	}
+	if err := s.validateRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Create(rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *AlertService) UpdateRule(id uuid.UUID, req *UpdateAlertRuleRequest) (*model.AlertRule, error) {
+	rule, err := s.ruleRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrAlertRuleNotFound
+	}
+
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Metric != nil {
+		rule.Metric = *req.Metric
+	}
+	if req.Operator != nil {
+		rule.Operator = *req.Operator
+	}
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.Duration != nil {
+		rule.Duration = *req.Duration
+	}
+	if req.Severity != nil {
+		rule.Severity = *req.Severity
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Description != nil {
+		rule.Description = *req.Description
+	}
+	hostIDs, channels := parseRuleHostIDs(rule), parseRuleChannels(rule)
+	if req.HostIDs != nil {
+		hostIDs = *req.HostIDs
+	}
+	if req.Channels != nil {
+		channels = *req.Channels
+	}
+	if err := s.setRuleTargets(rule, hostIDs, channels); err != nil {
+		return nil, err
+	}
+	if err := s.validateRule(rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *AlertService) DeleteRule(id uuid.UUID) error {
+	if _, err := s.ruleRepo.GetByID(id); err != nil {
+		return ErrAlertRuleNotFound
+	}
+	return s.ruleRepo.Delete(id)
+}
+
+func (s *AlertService) GetRule(id uuid.UUID) (*model.AlertRule, error) {
+	rule, err := s.ruleRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrAlertRuleNotFound
+	}
+	return rule, nil
+}
+
+func (s *AlertService) ListRules() ([]model.AlertRule, error) {
+	return s.ruleRepo.List()
+}
+
+// ListHistory 查询告警历史，source 为 rule 或 alertmanager，为空时不过滤
+func (s *AlertService) ListHistory(page, pageSize int, source string, status *int, hostID, ruleID *uuid.UUID) ([]model.AlertHistory, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return s.historyRepo.List(page, pageSize, source, status, hostID, ruleID)
+}
+
+// ListChannels 返回已配置的通知渠道
+func (s *AlertService) ListChannels() []string {
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setRuleTargets 校验规则的主机和通知渠道并以 JSON 保存到规则
+func (s *AlertService) setRuleTargets(rule *model.AlertRule, hostIDs []uuid.UUID, channels []string) error {
+	for _, id := range hostIDs {
+		if _, err := s.hostRepo.GetByID(id); err != nil {
+			return fmt.Errorf("%w: host %s not found", ErrAlertRuleInvalid, id)
+		}
+	}
+	for _, ch := range channels {
+		if s.channels[ch] == nil {
+			return fmt.Errorf("%w: notification channel %q is not configured", ErrAlertRuleInvalid, ch)
+		}
+	}
+
+	rule.HostIDs = ""
+	if len(hostIDs) > 0 {
+		data, _ := json.Marshal(hostIDs)
+		rule.HostIDs = string(data)
+	}
+	rule.Channels = ""
+	if len(channels) > 0 {
+		data, _ := json.Marshal(channels)
+		rule.Channels = string(data)
+	}
+	return nil
+}
+
+func (s *AlertService) validateRule(rule *model.AlertRule) error {
+	if _, ok := alertMetricUnits[rule.Metric]; !ok {
+		return fmt.Errorf("%w: metric must be one of cpu, memory, disk, network, load", ErrAlertRuleInvalid)
+	}
+	switch rule.Operator {
+	case ">", ">=", "<", "<=", "==":
+	default:
+		return fmt.Errorf("%w: operator must be one of >, >=, <, <=, ==", ErrAlertRuleInvalid)
+	}
+	if rule.Duration < 0 || rule.Duration > maxAlertDuration {
+		return fmt.Errorf("%w: duration must be between 0 and %d seconds", ErrAlertRuleInvalid, maxAlertDuration)
+	}
+	if !alertSeverities[rule.Severity] {
+		return fmt.Errorf("%w: severity must be one of info, warning, critical", ErrAlertRuleInvalid)
+	}
+	return nil
+}
+
+func parseRuleHostIDs(rule *model.AlertRule) []uuid.UUID {
+	var ids []uuid.UUID
+	if rule.HostIDs != "" {
+		if err := json.Unmarshal([]byte(rule.HostIDs), &ids); err != nil {
+			log.Printf("Invalid host_ids on alert rule %s: %v", rule.ID, err)
+		}
+	}
+	return ids
+}
+
+func parseRuleChannels(rule *model.AlertRule) []string {
+	var channels []string
+	if rule.Channels != "" {
+		if err := json.Unmarshal([]byte(rule.Channels), &channels); err != nil {
+			log.Printf("Invalid channels on alert rule %s: %v", rule.ID, err)
+		}
+	}
+	return channels
+}
+
+// Run 定期评估已启用的告警规则，直到 ctx 结束
+func (s *AlertService) Run(ctx context.Context) {
+	ticker := time.NewTicker(alertEvalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.evaluate(now); err != nil {
+				log.Printf("Failed to evaluate alert rules: %v", err)
+			}
+		}
+	}
+}
+
+// evaluate 评估所有已启用的规则。条件持续满足 Duration 秒后产生告警，条件不再满足时恢复；
+// 规则被禁用、删除或不再包含该主机时，未恢复的告警直接恢复且不发送通知
+func (s *AlertService) evaluate(now time.Time) error {
+	rules, err := s.ruleRepo.ListEnabled()
+	if err != nil {
+		return err
+	}
+	firing, err := s.historyRepo.ListFiringByRules()
+	if err != nil {
+		return err
+	}
+	open := make(map[string]*model.AlertHistory, len(firing))
+	for i := range firing {
+		open[alertKey(firing[i].RuleID, firing[i].HostID)] = &firing[i]
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		hostIDs := parseRuleHostIDs(rule)
+		values, err := s.ruleValues(rule, hostIDs, now)
+		if err != nil {
+			return err
+		}
+		targets := make(map[uuid.UUID]bool)
+		for _, hostID := range hostIDs {
+			targets[hostID] = true
+		}
+		if len(targets) == 0 {
+			for hostID := range values {
+				targets[hostID] = true
+			}
+			for _, h := range firing {
+				if h.RuleID == rule.ID {
+					targets[h.HostID] = true
+				}
+			}
+		}
+
+		for hostID := range targets {
+			key := alertKey(rule.ID, hostID)
+			if _, seen := values[hostID]; !seen && open[key] == nil {
+				continue
+			}
+			value, holds := evaluateAlertRule(rule, values[hostID], now)
+			history := open[key]
+			delete(open, key)
+			switch {
+			case holds && history == nil:
+				s.fire(rule, hostID, value, now)
+			case !holds && history != nil:
+				s.resolve(history, rule, value, now)
+			}
+		}
+	}
+
+	for _, history := range open {
+		if _, err := s.historyRepo.Resolve(history.ID, now); err != nil {
+			log.Printf("Failed to resolve alert %s: %v", history.ID, err)
+		}
+	}
+	return nil
+}
+
+// ruleValues 按主机返回评估规则所需的指标值，只查询规则的目标主机在 Duration 覆盖范围内的数据。
+// 最近 alertRawWindow 内使用原始指标，更早的部分使用 1 分钟汇总数据，避免长持续时间的规则每次评估都扫描大量原始指标
+func (s *AlertService) ruleValues(rule *model.AlertRule, hostIDs []uuid.UUID, now time.Time) (map[uuid.UUID][]alertValue, error) {
+	values := make(map[uuid.UUID][]alertValue)
+	from := now.Add(-time.Duration(rule.Duration) * time.Second)
+	cut := from
+	if now.Sub(from) > alertRawWindow {
+		cut = now.Add(-alertRawWindow).Truncate(time.Minute)
+		// 多取一个区间，保证最早的数据不晚于 from
+		rollups, err := s.metricRepo.ListRollupsByHosts(hostIDs, 60, from.Truncate(time.Minute).Add(-time.Minute), cut)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(rollups); {
+			j := i
+			for j < len(rollups) && rollups[j].HostID == rollups[i].HostID {
+				j++
+			}
+			values[rollups[i].HostID] = alertRollupValues(rule.Metric, rollups[i:j])
+			i = j
+		}
+	}
+
+	// 向前多取一段用于计算网络速率并保证最早的数据不晚于 from；采集时间来自 Agent，允许少量时钟偏差
+	metrics, err := s.metricRepo.ListRawByHosts(hostIDs, cut.Add(-metricDeltaLookback), now.Add(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(metrics); {
+		j := i
+		for j < len(metrics) && *metrics[j].HostID == *metrics[i].HostID {
+			j++
+		}
+		hostID := *metrics[i].HostID
+		raw := alertMetricValues(rule.Metric, metrics[i:j])
+		if len(values[hostID]) > 0 {
+			// 已有汇总数据覆盖 cut 之前的部分
+			k := sort.Search(len(raw), func(k int) bool { return !raw[k].time.Before(cut) })
+			raw = raw[k:]
+		}
+		values[hostID] = append(values[hostID], raw...)
+		i = j
+	}
+	return values, nil
+}
+
+func (s *AlertService) fire(rule *model.AlertRule, hostID uuid.UUID, value float64, now time.Time) {
+	host, err := s.hostRepo.GetByID(hostID)
+	if err != nil {
+		log.Printf("Alert rule %s fired for unknown host %s", rule.Name, hostID)
+		return
+	}
+	history := &model.AlertHistory{
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		HostID:    host.ID,
+		HostName:  host.Name,
+		HostIP:    host.IP,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		Severity:  rule.Severity,
+		Status:    0, // firing
+		Source:    "rule",
+	}
+	if err := s.historyRepo.Create(history); err != nil {
+		log.Printf("Failed to record alert %s for host %s: %v", rule.Name, host.Name, err)
+		return
+	}
+
+	unit := alertMetricUnits[rule.Metric]
+	content := fmt.Sprintf("- 主机：%s (%s)\n- 指标：%s 当前值 %.2f%s，阈值 %s %.2f%s，持续 %d 秒\n- 级别：%s\n- 时间：%s",
+		host.Name, host.IP, rule.Metric, value, unit, rule.Operator, rule.Threshold, unit, rule.Duration,
+		rule.Severity, now.Format("2006-01-02 15:04:05"))
+	s.send(parseRuleChannels(rule), fmt.Sprintf("[告警] %s", rule.Name), content)
+}
+
+func (s *AlertService) resolve(history *model.AlertHistory, rule *model.AlertRule, value float64, now time.Time) {
+	resolved, err := s.historyRepo.Resolve(history.ID, now)
+	if err != nil {
+		log.Printf("Failed to resolve alert %s: %v", history.ID, err)
+		return
+	}
+	if !resolved {
+		return
+	}
+
+	current := "无数据"
+	if !math.IsNaN(value) {
+		current = fmt.Sprintf("%.2f%s", value, alertMetricUnits[rule.Metric])
+	}
+	content := fmt.Sprintf("- 主机：%s (%s)\n- 指标：%s 当前值 %s\n- 告警时间：%s\n- 恢复时间：%s（持续 %s）",
+		history.HostName, history.HostIP, rule.Metric, current,
+		history.CreatedAt.Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"),
+		now.Sub(history.CreatedAt).Round(time.Second))
+	s.send(parseRuleChannels(rule), fmt.Sprintf("[已恢复] %s", rule.Name), content)
+}
+
+// send 异步发送通知，未配置的渠道被忽略
+func (s *AlertService) send(channels []string, title, content string) {
+	for _, name := range channels {
+		notifier := s.channels[name]
+		if notifier == nil {
+			log.Printf("Notification channel %s is not configured, skipping %q", name, title)
+			continue
+		}
+		go func(name string, notifier notify.Notifier) {
+			if err := notifier.Send(title, content); err != nil {
+				log.Printf("Failed to send %q to %s: %v", title, name, err)
+			}
+		}(name, notifier)
+	}
+}
+
+// evaluateAlertRule 根据按时间排序的指标值返回主机最近一次的指标值以及条件是否已持续满足 Duration 秒。
+// 主机最近没有上报时返回 NaN 和 false
+func evaluateAlertRule(rule *model.AlertRule, values []alertValue, now time.Time) (float64, bool) {
+	if len(values) == 0 {
+		return math.NaN(), false
+	}
+	latest := values[len(values)-1]
+	if now.Sub(latest.time) > alertStaleAfter {
+		return math.NaN(), false
+	}
+
+	since := latest.time
+	for i := len(values) - 1; i >= 0; i-- {
+		if !compareAlertValue(rule.Operator, values[i].value, rule.Threshold) {
+			break
+		}
+		since = values[i].time
+	}
+	if !compareAlertValue(rule.Operator, latest.value, rule.Threshold) {
+		return latest.value, false
+	}
+	return latest.value, latest.time.Sub(since) >= time.Duration(rule.Duration)*time.Second
+}
+
+type alertValue struct {
+	time  time.Time
+	value float64
+}
+
+// alertMetricValues 按时间顺序返回主机的指标值，network 由相邻两次上报的计数器差值计算速率
+func alertMetricValues(metric string, metrics []model.HostMetric) []alertValue {
+	values := make([]alertValue, 0, len(metrics))
+	for i, m := range metrics {
+		var v float64
+		switch metric {
+		case "cpu":
+			v = m.CPUPercent
+		case "memory":
+			v = m.MemPercent
+		case "disk":
+			v = m.DiskPercent
+		case "load":
+			v = m.Load1
+		case "network":
+			if i == 0 {
+				continue
+			}
+			prev := metrics[i-1]
+			seconds := m.CollectedAt.Sub(prev.CollectedAt).Seconds()
+			if seconds <= 0 {
+				continue
+			}
+			bytes := counterDelta(prev.NetBytesSent, m.NetBytesSent) + counterDelta(prev.NetBytesRecv, m.NetBytesRecv)
+			v = float64(bytes) / seconds
+		default:
+			return nil
+		}
+		values = append(values, alertValue{time: m.CollectedAt, value: v})
+	}
+	return values
+}
+
+// alertRollupValues 按时间顺序返回主机 1 分钟汇总数据的指标值，时间为区间开始时间，network 为区间内的平均速率
+func alertRollupValues(metric string, rollups []model.HostMetricRollup) []alertValue {
+	values := make([]alertValue, 0, len(rollups))
+	for _, r := range rollups {
+		var v float64
+		switch metric {
+		case "cpu":
+			v = r.CPUPercent
+		case "memory":
+			v = r.MemPercent
+		case "disk":
+			v = r.DiskPercent
+		case "load":
+			v = r.Load1
+		case "network":
+			v = float64(r.NetBytesSent+r.NetBytesRecv) / float64(r.Resolution)
+		default:
+			return nil
+		}
+		values = append(values, alertValue{time: r.BucketStart, value: v})
+	}
+	return values
+}
+
+func compareAlertValue(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	}
+	return false
+}
+
+func alertKey(ruleID, hostID uuid.UUID) string {
+	return ruleID.String() + "/" + hostID.String()
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"devops/internal/model"
+)
+
+func TestEvaluateAlertRule(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	// series 返回每隔 15 秒一次、最后一次在 now 的 CPU 指标
+	series := func(values ...float64) []model.HostMetric {
+		metrics := make([]model.HostMetric, len(values))
+		for i, v := range values {
+			metrics[i] = model.HostMetric{
+				CollectedAt: now.Add(-time.Duration(len(values)-1-i) * 15 * time.Second),
+				CPUPercent:  v,
+			}
+		}
+		return metrics
+	}
+	rule := func(op string, threshold float64, duration int) *model.AlertRule {
+		return &model.AlertRule{Metric: "cpu", Operator: op, Threshold: threshold, Duration: duration}
+	}
+
+	tests := []struct {
+		name      string
+		rule      *model.AlertRule
+		metrics   []model.HostMetric
+		now       time.Time
+		wantValue float64
+		wantHolds bool
+	}{
+		{name: "no data", rule: rule(">", 80, 60), now: now, wantValue: math.NaN()},
+		{name: "stale data", rule: rule(">", 80, 0), metrics: series(95), now: now.Add(3 * time.Minute), wantValue: math.NaN()},
+		{name: "below threshold", rule: rule(">", 80, 60), metrics: series(90, 90, 90, 90, 50), now: now, wantValue: 50},
+		{name: "held for duration", rule: rule(">", 80, 60), metrics: series(90, 90, 90, 90, 95), now: now, wantValue: 95, wantHolds: true},
+		{name: "held shorter than duration", rule: rule(">", 80, 60), metrics: series(50, 90, 90, 90, 95), now: now, wantValue: 95},
+		{name: "held since last dip", rule: rule(">", 80, 15), metrics: series(90, 90, 50, 90, 95), now: now, wantValue: 95, wantHolds: true},
+		{name: "dip resets duration", rule: rule(">", 80, 30), metrics: series(90, 90, 50, 90, 95), now: now, wantValue: 95},
+		{name: "zero duration fires immediately", rule: rule(">", 80, 0), metrics: series(95), now: now, wantValue: 95, wantHolds: true},
+		{name: "greater or equal", rule: rule(">=", 80, 15), metrics: series(80, 80), now: now, wantValue: 80, wantHolds: true},
+		{name: "less than", rule: rule("<", 10, 15), metrics: series(5, 8), now: now, wantValue: 8, wantHolds: true},
+		{name: "less or equal", rule: rule("<=", 10, 15), metrics: series(11, 10), now: now, wantValue: 10},
+		{name: "equal", rule: rule("==", 0, 15), metrics: series(0, 0), now: now, wantValue: 0, wantHolds: true},
+		{name: "unknown operator", rule: rule("!=", 80, 0), metrics: series(95), now: now, wantValue: 95},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, holds := evaluateAlertRule(tt.rule, alertMetricValues(tt.rule.Metric, tt.metrics), tt.now)
+			if holds != tt.wantHolds {
+				t.Errorf("holds = %v, want %v", holds, tt.wantHolds)
+			}
+			if math.IsNaN(tt.wantValue) != math.IsNaN(value) || (!math.IsNaN(value) && value != tt.wantValue) {
+				t.Errorf("value = %v, want %v", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestAlertMetricValues(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	metrics := []model.HostMetric{
+		{CollectedAt: base, MemPercent: 40, NetBytesSent: 1000, NetBytesRecv: 1000},
+		{CollectedAt: base.Add(10 * time.Second), MemPercent: 50, NetBytesSent: 2000, NetBytesRecv: 1500},
+		{CollectedAt: base.Add(10 * time.Second), MemPercent: 55, NetBytesSent: 2500, NetBytesRecv: 1500}, // 重复上报
+		{CollectedAt: base.Add(20 * time.Second), MemPercent: 60, NetBytesSent: 100, NetBytesRecv: 100},   // 主机重启
+	}
+
+	tests := []struct {
+		metric string
+		want   []float64
+	}{
+		{metric: "memory", want: []float64{40, 50, 55, 60}},
+		{metric: "network", want: []float64{150, 20}},
+		{metric: "unknown", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			var got []float64
+			for _, v := range alertMetricValues(tt.metric, metrics) {
+				got = append(got, v.value)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("values = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("values = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateAlertRuleWithRollups(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	cut := now.Add(-alertRawWindow)
+	rule := &model.AlertRule{Metric: "network", Operator: ">", Threshold: 1000, Duration: 3600}
+
+	// 一小时前到 cut 之间为 1 分钟汇总，之后为原始指标
+	var rollups []model.HostMetricRollup
+	for t := now.Add(-time.Hour - time.Minute); t.Before(cut); t = t.Add(time.Minute) {
+		rollups = append(rollups, model.HostMetricRollup{Resolution: 60, BucketStart: t, NetBytesSent: 90000, NetBytesRecv: 30000})
+	}
+	var metrics []model.HostMetric
+	var sent uint64
+	for t := cut; !t.After(now); t = t.Add(15 * time.Second) {
+		metrics = append(metrics, model.HostMetric{CollectedAt: t, NetBytesSent: sent})
+		sent += 30000
+	}
+	values := append(alertRollupValues(rule.Metric, rollups), alertMetricValues(rule.Metric, metrics)...)
+
+	value, holds := evaluateAlertRule(rule, values, now)
+	if value != 2000 || !holds {
+		t.Errorf("value = %v, holds = %v, want 2000, true", value, holds)
+	}
+
+	// 汇总数据中的一分钟低于阈值时条件未持续满足
+	rollups[len(rollups)/2].NetBytesSent = 0
+	values = append(alertRollupValues(rule.Metric, rollups), alertMetricValues(rule.Metric, metrics)...)
+	if _, holds := evaluateAlertRule(rule, values, now); holds {
+		t.Error("rule holds although one rolled up minute was below the threshold")
+	}
+}
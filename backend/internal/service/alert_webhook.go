@@ -0,0 +1,209 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"devops/internal/model"
+)
+
+var ErrAlertmanagerPayload = errors.New("unsupported alertmanager payload")
+
+// AlertmanagerPayload Alertmanager webhook 的请求体（version 4）
+type AlertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"` // firing, resolved
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AuthorizeWebhook 校验 Alertmanager 请求携带的令牌，未配置令牌时拒绝所有请求
+func (s *AlertService) AuthorizeWebhook(token string) bool {
+	if s.webhookToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.webhookToken)) == 1
+}
+
+// ReceiveAlertmanager 按指纹记录 Alertmanager 推送的告警：新告警写入告警历史并转发通知，
+// 已记录的告警更新标签和摘要，resolved 告警标记为已恢复并发送恢复通知。
+// 告警的 instance 标签按 IP（或主机名）关联到主机
+func (s *AlertService) ReceiveAlertmanager(payload *AlertmanagerPayload) error {
+	if payload.Version != "" && payload.Version != "4" {
+		return fmt.Errorf("%w: version %s", ErrAlertmanagerPayload, payload.Version)
+	}
+
+	// Alertmanager 可能并发推送多个分组，串行处理避免同一指纹重复创建
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	for i := range payload.Alerts {
+		if err := s.receiveAlert(&payload.Alerts[i]); err != nil {
+			return err
+		}
+	}
+	if payload.TruncatedAlerts > 0 {
+		log.Printf("Alertmanager truncated %d alert(s) in group %s", payload.TruncatedAlerts, payload.GroupKey)
+	}
+	return nil
+}
+
+func (s *AlertService) receiveAlert(alert *AlertmanagerAlert) error {
+	fingerprint := alert.Fingerprint
+	if fingerprint == "" {
+		fingerprint = labelsFingerprint(alert.Labels)
+	}
+	history, err := s.historyRepo.GetFiringByFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	if alert.Status == "resolved" {
+		if history == nil {
+			return nil
+		}
+		resolvedAt := alert.EndsAt
+		if resolvedAt.IsZero() {
+			resolvedAt = time.Now()
+		}
+		resolved, err := s.historyRepo.Resolve(history.ID, resolvedAt)
+		if err != nil || !resolved {
+			return err
+		}
+		content := fmt.Sprintf("- 主机：%s\n- 摘要：%s\n- 告警时间：%s\n- 恢复时间：%s（持续 %s）",
+			alertHostLabel(history), history.Summary,
+			history.CreatedAt.Format("2006-01-02 15:04:05"), resolvedAt.Format("2006-01-02 15:04:05"),
+			resolvedAt.Sub(history.CreatedAt).Round(time.Second))
+		s.send(s.webhookChannels(), fmt.Sprintf("[已恢复] %s", history.RuleName), content)
+		return nil
+	}
+
+	labels, _ := json.Marshal(alert.Labels)
+	severity := alert.Labels["severity"]
+	if severity == "" {
+		severity = "warning"
+	}
+	summary := alert.Annotations["summary"]
+	if summary == "" {
+		summary = alert.Annotations["description"]
+	}
+	summary = truncateRunes(summary, 500)
+	severity = truncateRunes(severity, 20)
+
+	if history != nil {
+		return s.historyRepo.UpdateExternal(history.ID, severity, summary, string(labels))
+	}
+
+	history = &model.AlertHistory{
+		RuleName:    truncateRunes(alert.Labels["alertname"], 100),
+		Severity:    severity,
+		Status:      0, // firing
+		Source:      "alertmanager",
+		Fingerprint: fingerprint,
+		Summary:     summary,
+		Labels:      string(labels),
+		CreatedAt:   alert.StartsAt,
+	}
+	if instance := alert.Labels["instance"]; instance != "" {
+		ip := instance
+		if h, _, err := net.SplitHostPort(instance); err == nil {
+			ip = h
+		}
+		history.HostIP = truncateRunes(ip, 50)
+		history.HostName = truncateRunes(instance, 100)
+		if host := s.matchAlertHost(ip); host != nil {
+			history.HostID = host.ID
+			history.HostName = host.Name
+			history.HostIP = host.IP
+		}
+	}
+	if err := s.historyRepo.Create(history); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("- 主机：%s\n- 级别：%s\n- 摘要：%s\n- 开始时间：%s",
+		alertHostLabel(history), severity, summary, history.CreatedAt.Format("2006-01-02 15:04:05"))
+	if alert.GeneratorURL != "" {
+		content += fmt.Sprintf("\n- 来源：%s", alert.GeneratorURL)
+	}
+	s.send(s.webhookChannels(), fmt.Sprintf("[告警] %s", history.RuleName), content)
+	return nil
+}
+
+// matchAlertHost 按 IP 查找主机，instance 不是 IP 时按主机名唯一匹配
+func (s *AlertService) matchAlertHost(addr string) *model.Host {
+	if net.ParseIP(addr) != nil {
+		if host, err := s.hostRepo.GetByIP(addr); err == nil {
+			return host
+		}
+		return nil
+	}
+	hosts, err := s.hostRepo.ListByHostname(addr)
+	if err == nil && len(hosts) == 1 {
+		return &hosts[0]
+	}
+	return nil
+}
+
+// webhookChannels Alertmanager 告警转发的渠道，未指定时使用所有已配置的渠道
+func (s *AlertService) webhookChannels() []string {
+	if len(s.alertmanagerChannels) > 0 {
+		return s.alertmanagerChannels
+	}
+	return s.ListChannels()
+}
+
+func alertHostLabel(history *model.AlertHistory) string {
+	if history.HostName == "" {
+		return "-"
+	}
+	if history.HostIP == "" || history.HostIP == history.HostName {
+		return history.HostName
+	}
+	return fmt.Sprintf("%s (%s)", history.HostName, history.HostIP)
+}
+
+// labelsFingerprint 在 Alertmanager 未提供指纹时由标签计算指纹
+func labelsFingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "\xff" + labels[k] + "\xff"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
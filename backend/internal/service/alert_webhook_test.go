@@ -0,0 +1,37 @@
+package service
+
+import "testing"
+
+func TestLabelsFingerprint(t *testing.T) {
+	base := map[string]string{"alertname": "HighCPU", "instance": "10.0.0.1:9100", "severity": "critical"}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		same   bool
+	}{
+		{name: "identical labels", labels: map[string]string{"alertname": "HighCPU", "instance": "10.0.0.1:9100", "severity": "critical"}, same: true},
+		{name: "different value", labels: map[string]string{"alertname": "HighCPU", "instance": "10.0.0.2:9100", "severity": "critical"}},
+		{name: "extra label", labels: map[string]string{"alertname": "HighCPU", "instance": "10.0.0.1:9100", "severity": "critical", "job": "node"}},
+		{name: "missing label", labels: map[string]string{"alertname": "HighCPU", "instance": "10.0.0.1:9100"}},
+		{name: "value moved between keys", labels: map[string]string{"alertname": "HighCPU", "instance": "10.0.0.1:9100severity", "": "critical"}},
+		{name: "no labels", labels: map[string]string{}},
+	}
+	want := labelsFingerprint(base)
+	if len(want) != 16 {
+		t.Fatalf("fingerprint %q has length %d, want 16", want, len(want))
+	}
+	for i := 0; i < 10; i++ {
+		if got := labelsFingerprint(base); got != want {
+			t.Fatalf("fingerprint is not stable: %q != %q", got, want)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := labelsFingerprint(tt.labels)
+			if (got == want) != tt.same {
+				t.Errorf("labelsFingerprint(%v) = %q, base = %q, same = %v", tt.labels, got, want, tt.same)
+			}
+		})
+	}
+}
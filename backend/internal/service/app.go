@@ -1,18 +1,48 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
 	"time"
 
 	"devops/internal/model"
+	"devops/internal/pkg/lock"
 	"devops/internal/repository"
 
 	"github.com/google/uuid"
 )
 
 var (
-	ErrAppNotFound  = errors.New("application not found")
+	ErrAppNotFound   = errors.New("application not found")
 	ErrAppCodeExists = errors.New("application code already exists")
+	ErrAppNoHosts    = errors.New("application has no hosts")
+	ErrAppStrategy   = errors.New("invalid deploy strategy")
+	ErrAppK8sTarget  = errors.New("invalid kubernetes deploy target")
+	ErrEnvNotFound   = errors.New("environment not found")
+
+	ErrDeployNotFound      = errors.New("deployment not found")
+	ErrDeployNotPending    = errors.New("deployment is not pending")
+	ErrDeployNotRunning    = errors.New("deployment is not running")
+	ErrDeployNotPaused     = errors.New("deployment is not waiting to resume")
+	ErrDeployNotQueued     = errors.New("deployment is not queued")
+	ErrDeployFinished      = errors.New("deployment has already finished")
+	ErrRollbackUnavailable = errors.New("target deployment has no release to roll back to")
+
+	ErrDeployAwaitingApproval    = errors.New("deployment is awaiting approval")
+	ErrDeployNotAwaitingApproval = errors.New("deployment is not awaiting approval")
+	ErrApprovalNotAllowed        = errors.New("user is not allowed to approve this deployment")
+	ErrApprovalDuplicate         = errors.New("user has already decided on this deployment")
+	ErrApprovalPolicyInvalid     = errors.New("an enabled approval policy needs approver roles or groups")
+
+	ErrDeployFrozen            = errors.New("environment is in a freeze window")
+	ErrFreezeOverrideForbidden = errors.New("only admins can override a freeze window")
+	ErrFreezeWindowNotFound    = errors.New("freeze window not found")
+	ErrFreezeWindowInvalid     = errors.New("invalid freeze window")
+
+	ErrScriptNotFound    = errors.New("deploy script not found")
+	ErrScriptTypeInvalid = errors.New("invalid deploy script type")
 )
 
 type AppService struct {
@@ -51,6 +81,35 @@ type CreateAppRequest struct {
 	EnvID       *uuid.UUID  `json:"env_id"`
 	HostIDs     []uuid.UUID `json:"host_ids"`
 	Description string      `json:"description"`
+
+	DeployStrategy string `json:"deploy_strategy"`
+	BatchSize      int    `json:"batch_size"`
+	CanaryCount    int    `json:"canary_count"`
+	CanaryPromote  string `json:"canary_promote"`
+	CanaryWait     int    `json:"canary_wait"`
+	KeepReleases   int    `json:"keep_releases"`
+	KeepArtifacts  int    `json:"keep_artifacts"`
+
+	HealthStatus   int    `json:"health_status"`
+	HealthBody     string `json:"health_body"`
+	HealthRetries  int    `json:"health_retries"`
+	HealthInterval int    `json:"health_interval"`
+	HealthTimeout  int    `json:"health_timeout"`
+	AutoRollback   bool   `json:"auto_rollback"`
+
+	K8sClusterID *uuid.UUID `json:"k8s_cluster_id"`
+	K8sNamespace string     `json:"k8s_namespace"`
+	K8sKind      string     `json:"k8s_kind"`
+	K8sWorkload  string     `json:"k8s_workload"`
+	K8sContainer string     `json:"k8s_container"`
+	K8sImage     string     `json:"k8s_image"`
+	K8sTimeout   int        `json:"k8s_timeout"`
+
+	AutoStart bool `json:"auto_start"`
+
+	ConfigFormat   string `json:"config_format"`
+	ConfigPath     string `json:"config_path"`
+	ConfigTemplate string `json:"config_template"`
 }
 
 func (s *AppService) Create(req *CreateAppRequest, createdBy uuid.UUID) (*model.Application, error) {
@@ -64,6 +123,18 @@ func (s *AppService) Create(req *CreateAppRequest, createdBy uuid.UUID) (*model.
 		branch = "main"
 	}
 
+	strategy := req.DeployStrategy
+	if strategy == "" {
+		strategy = "all"
+	}
+	promote := req.CanaryPromote
+	if promote == "" {
+		promote = "manual"
+	}
+	if err := validateStrategy(strategy, promote); err != nil {
+		return nil, err
+	}
+
 	app := &model.Application{
 		Name:        req.Name,
 		Code:        req.Code,
@@ -80,6 +151,44 @@ func (s *AppService) Create(req *CreateAppRequest, createdBy uuid.UUID) (*model.
 		Description: req.Description,
 		Status:      1,
 		CreatedBy:   createdBy,
+
+		DeployStrategy: strategy,
+		BatchSize:      positiveOr(req.BatchSize, 1),
+		CanaryCount:    positiveOr(req.CanaryCount, 1),
+		CanaryPromote:  promote,
+		CanaryWait:     positiveOr(req.CanaryWait, 300),
+		KeepReleases:   positiveOr(req.KeepReleases, 5),
+		KeepArtifacts:  positiveOr(req.KeepArtifacts, 20),
+
+		HealthStatus:   req.HealthStatus,
+		HealthBody:     req.HealthBody,
+		HealthRetries:  positiveOr(req.HealthRetries, 5),
+		HealthInterval: positiveOr(req.HealthInterval, 3),
+		HealthTimeout:  positiveOr(req.HealthTimeout, 10),
+		AutoRollback:   req.AutoRollback,
+
+		K8sClusterID: req.K8sClusterID,
+		K8sNamespace: req.K8sNamespace,
+		K8sKind:      req.K8sKind,
+		K8sWorkload:  req.K8sWorkload,
+		K8sContainer: req.K8sContainer,
+		K8sImage:     req.K8sImage,
+		K8sTimeout:   positiveOr(req.K8sTimeout, 300),
+
+		AutoStart: req.AutoStart,
+
+		ConfigFormat:   req.ConfigFormat,
+		ConfigPath:     req.ConfigPath,
+		ConfigTemplate: req.ConfigTemplate,
+	}
+	if app.K8sKind == "" {
+		app.K8sKind = "Deployment"
+	}
+	if err := validateK8sTarget(app); err != nil {
+		return nil, err
+	}
+	if err := validateConfigTarget(app); err != nil {
+		return nil, err
 	}
 
 	if err := s.appRepo.Create(app); err != nil {
@@ -111,6 +220,37 @@ type UpdateAppRequest struct {
 	HostIDs     []uuid.UUID `json:"host_ids"`
 	Description string      `json:"description"`
 	Status      *int        `json:"status"`
+
+	DeployStrategy string `json:"deploy_strategy"`
+	BatchSize      int    `json:"batch_size"`
+	CanaryCount    int    `json:"canary_count"`
+	CanaryPromote  string `json:"canary_promote"`
+	CanaryWait     int    `json:"canary_wait"`
+	KeepReleases   int    `json:"keep_releases"`
+	KeepArtifacts  int    `json:"keep_artifacts"`
+
+	HealthStatus   *int    `json:"health_status"`
+	HealthBody     *string `json:"health_body"`
+	HealthRetries  int     `json:"health_retries"`
+	HealthInterval int     `json:"health_interval"`
+	HealthTimeout  int     `json:"health_timeout"`
+	AutoRollback   *bool   `json:"auto_rollback"`
+
+	// K8sClusterID 传入 uuid.Nil 时取消 Kubernetes 部署目标
+	K8sClusterID *uuid.UUID `json:"k8s_cluster_id"`
+	K8sNamespace string     `json:"k8s_namespace"`
+	K8sKind      string     `json:"k8s_kind"`
+	K8sWorkload  string     `json:"k8s_workload"`
+	K8sContainer string     `json:"k8s_container"`
+	K8sImage     string     `json:"k8s_image"`
+	K8sTimeout   int        `json:"k8s_timeout"`
+
+	AutoStart *bool `json:"auto_start"`
+
+	// ConfigFormat 传入空字符串时关闭配置注入
+	ConfigFormat   *string `json:"config_format"`
+	ConfigPath     *string `json:"config_path"`
+	ConfigTemplate *string `json:"config_template"`
 }
 
 func (s *AppService) Update(id uuid.UUID, req *UpdateAppRequest) (*model.Application, error) {
@@ -158,6 +298,91 @@ func (s *AppService) Update(id uuid.UUID, req *UpdateAppRequest) (*model.Applica
 	if req.Status != nil {
 		app.Status = *req.Status
 	}
+	if req.DeployStrategy != "" {
+		app.DeployStrategy = req.DeployStrategy
+	}
+	if req.BatchSize > 0 {
+		app.BatchSize = req.BatchSize
+	}
+	if req.CanaryCount > 0 {
+		app.CanaryCount = req.CanaryCount
+	}
+	if req.CanaryPromote != "" {
+		app.CanaryPromote = req.CanaryPromote
+	}
+	if req.CanaryWait > 0 {
+		app.CanaryWait = req.CanaryWait
+	}
+	if req.KeepReleases > 0 {
+		app.KeepReleases = req.KeepReleases
+	}
+	if req.KeepArtifacts > 0 {
+		app.KeepArtifacts = req.KeepArtifacts
+	}
+	if req.HealthStatus != nil {
+		app.HealthStatus = *req.HealthStatus
+	}
+	if req.HealthBody != nil {
+		app.HealthBody = *req.HealthBody
+	}
+	if req.HealthRetries > 0 {
+		app.HealthRetries = req.HealthRetries
+	}
+	if req.HealthInterval > 0 {
+		app.HealthInterval = req.HealthInterval
+	}
+	if req.HealthTimeout > 0 {
+		app.HealthTimeout = req.HealthTimeout
+	}
+	if req.AutoRollback != nil {
+		app.AutoRollback = *req.AutoRollback
+	}
+	if req.K8sClusterID != nil {
+		if *req.K8sClusterID == uuid.Nil {
+			app.K8sClusterID = nil
+		} else {
+			app.K8sClusterID = req.K8sClusterID
+		}
+	}
+	if req.K8sNamespace != "" {
+		app.K8sNamespace = req.K8sNamespace
+	}
+	if req.K8sKind != "" {
+		app.K8sKind = req.K8sKind
+	}
+	if req.K8sWorkload != "" {
+		app.K8sWorkload = req.K8sWorkload
+	}
+	if req.K8sContainer != "" {
+		app.K8sContainer = req.K8sContainer
+	}
+	if req.K8sImage != "" {
+		app.K8sImage = req.K8sImage
+	}
+	if req.K8sTimeout > 0 {
+		app.K8sTimeout = req.K8sTimeout
+	}
+	if req.AutoStart != nil {
+		app.AutoStart = *req.AutoStart
+	}
+	if req.ConfigFormat != nil {
+		app.ConfigFormat = *req.ConfigFormat
+	}
+	if req.ConfigPath != nil {
+		app.ConfigPath = *req.ConfigPath
+	}
+	if req.ConfigTemplate != nil {
+		app.ConfigTemplate = *req.ConfigTemplate
+	}
+	if err := validateStrategy(app.DeployStrategy, app.CanaryPromote); err != nil {
+		return nil, err
+	}
+	if err := validateK8sTarget(app); err != nil {
+		return nil, err
+	}
+	if err := validateConfigTarget(app); err != nil {
+		return nil, err
+	}
 
 	if err := s.appRepo.Update(app); err != nil {
 		return nil, err
@@ -184,32 +409,109 @@ func (s *AppService) List(page, pageSize int, envID *uuid.UUID, keyword string)
 	return s.appRepo.List(page, pageSize, envID, keyword)
 }
 
+func validateStrategy(strategy, promote string) error {
+	switch strategy {
+	case "all", "rolling", "canary":
+	default:
+		return ErrAppStrategy
+	}
+	if promote != "manual" && promote != "timed" {
+		return ErrAppStrategy
+	}
+	return nil
+}
+
+// validateK8sTarget 部署到 Kubernetes 的应用需指定命名空间和受支持类型的工作负载
+func validateK8sTarget(app *model.Application) error {
+	if app.K8sClusterID == nil {
+		return nil
+	}
+	if app.K8sNamespace == "" || app.K8sWorkload == "" {
+		return ErrAppK8sTarget
+	}
+	if _, ok := workloadResources[app.K8sKind]; !ok {
+		return ErrAppK8sTarget
+	}
+	return nil
+}
+
+func positiveOr(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
 // Deployment
 type DeploymentService struct {
-	deployRepo *repository.DeploymentRepository
-	appRepo    *repository.AppRepository
+	deployRepo    *repository.DeploymentRepository
+	appRepo       *repository.AppRepository
+	scriptRepo    *repository.DeployScriptRepository
+	approvalRepo  *repository.ApprovalRepository
+	freezeRepo    *repository.FreezeWindowRepository
+	auditRepo     *repository.AuditRepository
+	k8sService    *K8sService
+	configService *ConfigService
+	locker        lock.Locker
+	builder       *ArtifactBuilder
+	logHub        *DeployLogHub
+	runs          *deployRunRegistry
 }
 
-func NewDeploymentService(deployRepo *repository.DeploymentRepository, appRepo *repository.AppRepository) *DeploymentService {
+func NewDeploymentService(
+	deployRepo *repository.DeploymentRepository,
+	appRepo *repository.AppRepository,
+	scriptRepo *repository.DeployScriptRepository,
+	approvalRepo *repository.ApprovalRepository,
+	freezeRepo *repository.FreezeWindowRepository,
+	auditRepo *repository.AuditRepository,
+	k8sService *K8sService,
+	configService *ConfigService,
+	locker lock.Locker,
+	builder *ArtifactBuilder,
+) *DeploymentService {
 	return &DeploymentService{
-		deployRepo: deployRepo,
-		appRepo:    appRepo,
+		deployRepo:    deployRepo,
+		appRepo:       appRepo,
+		scriptRepo:    scriptRepo,
+		approvalRepo:  approvalRepo,
+		freezeRepo:    freezeRepo,
+		auditRepo:     auditRepo,
+		k8sService:    k8sService,
+		configService: configService,
+		locker:        locker,
+		builder:       builder,
+		logHub:        NewDeployLogHub(),
+		runs:          newDeployRunRegistry(),
 	}
 }
 
 type CreateDeployRequest struct {
 	AppID     uuid.UUID `json:"app_id" binding:"required"`
 	Version   string    `json:"version"`
-	CommitID  string    `json:"commit_id"`
+	CommitID  string    `json:"commit_id" binding:"omitempty,startsnotwith=-"` // 作为 git 引用传给检出命令，不能以 - 开头
 	CommitMsg string    `json:"commit_msg"`
 	Branch    string    `json:"branch"`
+	// CommitTime 提交时间，可选，用于统计变更前置时间
+	CommitTime *time.Time `json:"commit_time"`
+	// FreezeOverrideReason 封版期内强制部署的原因，仅管理员可用
+	FreezeOverrideReason string `json:"freeze_override_reason"`
 }
 
-func (s *DeploymentService) Create(req *CreateDeployRequest, createdBy uuid.UUID) (*model.Deployment, error) {
+// Create 创建部署。目标环境处于封版期时拒绝创建，除非管理员通过 override 提供了强制部署的原因
+func (s *DeploymentService) Create(req *CreateDeployRequest, createdBy uuid.UUID, override *FreezeOverride) (*model.Deployment, error) {
 	app, err := s.appRepo.GetByID(req.AppID)
 	if err != nil {
 		return nil, ErrAppNotFound
 	}
+	frozen, err := checkFreeze(s.freezeRepo, app.EnvID, time.Now(), override)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.initialStatus(app.EnvID)
+	if err != nil {
+		return nil, err
+	}
 
 	branch := req.Branch
 	if branch == "" {
@@ -217,37 +519,205 @@ func (s *DeploymentService) Create(req *CreateDeployRequest, createdBy uuid.UUID
 	}
 
 	deploy := &model.Deployment{
-		AppID:     req.AppID,
-		Version:   req.Version,
-		CommitID:  req.CommitID,
-		CommitMsg: req.CommitMsg,
-		Branch:    branch,
-		Type:      "deploy",
-		Status:    0, // pending
-		CreatedBy: createdBy,
+		AppID:      req.AppID,
+		Version:    req.Version,
+		CommitID:   req.CommitID,
+		CommitMsg:  req.CommitMsg,
+		CommitTime: req.CommitTime,
+		Branch:     branch,
+		EnvID:      app.EnvID,
+		Type:       "deploy",
+		Strategy:   app.DeployStrategy,
+		Status:     status,
+		CreatedBy:  createdBy,
 	}
 
 	if err := s.deployRepo.Create(deploy); err != nil {
 		return nil, err
 	}
+	if frozen != nil {
+		auditFreezeOverride(s.auditRepo, deploy, frozen, override)
+	}
 
 	return s.deployRepo.GetByID(deploy.ID)
 }
 
-func (s *DeploymentService) StartDeploy(id uuid.UUID) error {
+// initialStatus 新建部署的初始状态：目标环境启用了审批策略时需等待审批，否则为待执行。
+// 无法读取审批策略时返回错误，调用方应拒绝创建部署，避免跳过审批
+func (s *DeploymentService) initialStatus(envID *uuid.UUID) (int, error) {
+	return deployInitialStatus(s.approvalRepo.GetPolicyByEnv, envID)
+}
+
+func deployInitialStatus(policyByEnv func(uuid.UUID) (*model.ApprovalPolicy, error), envID *uuid.UUID) (int, error) {
+	if envID == nil {
+		return 0, nil // pending
+	}
+	policy, err := policyByEnv(*envID)
+	if err != nil {
+		return 0, fmt.Errorf("load approval policy: %w", err)
+	}
+	if policy != nil && policy.Enabled {
+		return 6, nil // awaiting_approval
+	}
+	return 0, nil // pending
+}
+
+// StartDeploy 将待执行的部署加入应用当前环境的执行队列。同一应用同一环境同时只执行一个部署，
+// 没有正在执行的部署时立即在后台开始执行，否则排队等待，queued 为 true。
+// 环境处于封版期时与 Create 相同，需要管理员提供 override 才能执行（回滚除外）
+func (s *DeploymentService) StartDeploy(id uuid.UUID, override *FreezeOverride) (queued bool, err error) {
 	deploy, err := s.deployRepo.GetByID(id)
 	if err != nil {
-		return err
+		return false, ErrDeployNotFound
+	}
+	if deploy.Status == 6 {
+		return false, ErrDeployAwaitingApproval
+	}
+	if deploy.Status != 0 {
+		return false, ErrDeployNotPending
+	}
+
+	app, err := s.appRepo.GetByID(deploy.AppID)
+	if err != nil {
+		return false, ErrAppNotFound
+	}
+	if !hasDeployTarget(app) {
+		return false, ErrAppNoHosts
+	}
+
+	if deploy.EnvID == nil {
+		deploy.EnvID = app.EnvID
+	}
+	// 回滚用于修复线上问题，不受封版限制
+	if deploy.Type != "rollback" {
+		frozen, err := checkFreeze(s.freezeRepo, deploy.EnvID, time.Now(), override)
+		if err != nil {
+			return false, err
+		}
+		if frozen != nil {
+			auditFreezeOverride(s.auditRepo, deploy, frozen, override)
+		}
+		deploy.FreezeOverride = frozen != nil
+	}
+	if err := s.enqueue(deploy); err != nil {
+		return false, err
+	}
+
+	held, ok, err := s.locker.TryLock(context.Background(), deployLockKey(deploy.AppID, deploy.EnvID))
+	if err != nil {
+		// 加锁失败时保留在队列中，由当前持锁的部署结束后调度
+		log.Printf("Failed to acquire deploy lock for deployment %s: %v", deploy.ID, err)
+		return true, nil
+	}
+	if !ok {
+		return true, nil
+	}
+
+	s.runNext(deploy.AppID, deploy.EnvID, held)
+
+	current, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return false, err
+	}
+	return current.Status == 4, nil
+}
+
+// launch 认领排队中的部署并在后台执行，held 的所有权转交给执行过程，执行结束后调度下一个部署。
+// 部署已被取消排队或无法执行时返回 false，held 仍归调用方所有
+func (s *DeploymentService) launch(deploy *model.Deployment, held lock.Lock) bool {
+	claimed, err := s.deployRepo.UpdateStatusIf(deploy.ID, 4, 1)
+	if err != nil || !claimed {
+		return false
+	}
+
+	// 排队期间环境可能进入封版期，出队时重新检查；管理员强制执行的部署和回滚除外
+	if deploy.Type != "rollback" && !deploy.FreezeOverride {
+		if _, err := checkFreeze(s.freezeRepo, deploy.EnvID, time.Now(), nil); err != nil {
+			s.unqueueFrozen(deploy, err)
+			return false
+		}
+	}
+
+	app, err := s.appRepo.GetByID(deploy.AppID)
+	if err != nil || !hasDeployTarget(app) {
+		if err := s.FinishDeploy(deploy.ID, false, "应用不存在或未关联主机，部署未执行"); err != nil {
+			log.Printf("Failed to finish deployment %s: %v", deploy.ID, err)
+		}
+		return false
+	}
+
+	if deploy.Strategy == "" {
+		deploy.Strategy = app.DeployStrategy
 	}
 
 	now := time.Now()
 	deploy.Status = 1 // running
 	deploy.StartTime = &now
+	deploy.BatchTotal = len(planBatches(deploy.Strategy, app, app.Hosts))
+	deploy.BatchCurrent = 0
+	deploy.Paused = false
+	if isK8sTarget(app) {
+		deploy.BatchTotal = 1
+	}
+	switch {
+	case deploy.Type == "rollback":
+	case isK8sTarget(app):
+		// Kubernetes 应用以镜像 tag 作为发布标识，回滚时重新部署该 tag
+		deploy.Release = k8sImageTag(deploy)
+	case usesReleases(app):
+		deploy.Release = newReleaseName(deploy.ID, now)
+	}
+
+	if err := s.deployRepo.Update(deploy); err != nil {
+		log.Printf("Failed to start deployment %s: %v", deploy.ID, err)
+		return false
+	}
+
+	s.logHub.Open(deploy.ID)
+	run := s.runs.add(deploy.ID)
+	go s.executeDeploy(deploy, app, run, held)
+	return true
+}
 
-	return s.deployRepo.Update(deploy)
+// Pause 请求在当前批次完成后暂停部署
+func (s *DeploymentService) Pause(id uuid.UUID) error {
+	deploy, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return ErrDeployNotFound
+	}
+	run, ok := s.runs.get(id)
+	if deploy.Status != 1 || !ok {
+		return ErrDeployNotRunning
+	}
+	run.requestPause()
+	return nil
+}
+
+// Resume 继续已暂停的部署；金丝雀定时晋级等待中调用时立即晋级
+func (s *DeploymentService) Resume(id uuid.UUID) error {
+	deploy, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return ErrDeployNotFound
+	}
+	run, ok := s.runs.get(id)
+	if deploy.Status != 1 || !ok {
+		return ErrDeployNotRunning
+	}
+	if !run.signalResume() {
+		return ErrDeployNotPaused
+	}
+	return nil
 }
 
 func (s *DeploymentService) FinishDeploy(id uuid.UUID, success bool, output string) error {
+	if success {
+		return s.finish(id, 2, output) // success
+	}
+	return s.finish(id, 3, output) // failed
+}
+
+// finish 以指定的结束状态保存部署
+func (s *DeploymentService) finish(id uuid.UUID, status int, output string) error {
 	deploy, err := s.deployRepo.GetByID(id)
 	if err != nil {
 		return err
@@ -256,26 +726,78 @@ func (s *DeploymentService) FinishDeploy(id uuid.UUID, success bool, output stri
 	now := time.Now()
 	deploy.EndTime = &now
 	deploy.Output = output
+	deploy.Status = status
 
-	if success {
-		deploy.Status = 2 // success
-	} else {
-		deploy.Status = 3 // failed
+	return s.deployRepo.Update(deploy)
+}
+
+// Cancel 取消部署。待执行或排队中的部署直接标记为已取消；运行中的部署会中止主机上正在执行的命令，
+// 由执行过程记录已完成的主机后标记为已取消
+func (s *DeploymentService) Cancel(id uuid.UUID) error {
+	deploy, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return ErrDeployNotFound
 	}
 
-	return s.deployRepo.Update(deploy)
+	switch deploy.Status {
+	case 0, 4, 6: // pending, queued, awaiting_approval
+		ok, err := s.deployRepo.UpdateStatusIf(id, deploy.Status, 5)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// 状态已变化（例如刚从队列中启动），按最新状态重试
+			return s.Cancel(id)
+		}
+		s.logHub.Close(id)
+		return nil
+	case 1: // running
+		run, ok := s.runs.get(id)
+		if !ok {
+			return ErrDeployNotRunning
+		}
+		s.logHub.Append(id, "[deploy] cancel requested, aborting running commands")
+		run.cancel()
+		return nil
+	default:
+		return ErrDeployFinished
+	}
 }
 
 func (s *DeploymentService) GetByID(id uuid.UUID) (*model.Deployment, error) {
 	return s.deployRepo.GetByID(id)
 }
 
-func (s *DeploymentService) List(appID uuid.UUID, page, pageSize int) ([]model.Deployment, int64, error) {
-	return s.deployRepo.List(appID, page, pageSize)
+// SubscribeLogs 订阅部署的实时日志。待执行或排队中的部署会预先建立订阅，开始后即可收到日志；
+// 已结束的部署返回 ok=false，调用方应回放 Deployment.Output
+func (s *DeploymentService) SubscribeLogs(deploy *model.Deployment) (replay []string, ch <-chan string, cancel func(), ok bool) {
+	return s.logHub.Subscribe(deploy.ID, deploy.Status == 0 || deploy.Status == 4)
+}
+
+func (s *DeploymentService) List(params *repository.DeploymentQueryParams) ([]model.Deployment, int64, error) {
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.PageSize < 1 || params.PageSize > 100 {
+		params.PageSize = 20
+	}
+	return s.deployRepo.Query(params)
+}
+
+func (s *DeploymentService) ListRunning() ([]model.Deployment, error) {
+	return s.deployRepo.ListRunning()
 }
 
 func (s *DeploymentService) Rollback(appID uuid.UUID, targetDeployID uuid.UUID, createdBy uuid.UUID) (*model.Deployment, error) {
 	target, err := s.deployRepo.GetByID(targetDeployID)
+	if err != nil || target.AppID != appID {
+		return nil, ErrDeployNotFound
+	}
+	// 只能回滚到成功发布过、且发布目录仍可能保留在主机上的版本
+	if target.Status != 2 || target.Release == "" {
+		return nil, ErrRollbackUnavailable
+	}
+	status, err := s.initialStatus(target.EnvID)
 	if err != nil {
 		return nil, err
 	}
@@ -287,7 +809,10 @@ func (s *DeploymentService) Rollback(appID uuid.UUID, targetDeployID uuid.UUID,
 		CommitMsg: "Rollback to " + target.Version,
 		Branch:    target.Branch,
 		Type:      "rollback",
-		Status:    0,
+		EnvID:     target.EnvID,
+		Release:   target.Release,
+		Strategy:  target.Strategy,
+		Status:    status,
 		CreatedBy: createdBy,
 	}
 
@@ -314,3 +839,120 @@ func (s *EnvService) List() ([]model.Environment, error) {
 func (s *EnvService) GetByID(id uuid.UUID) (*model.Environment, error) {
 	return s.envRepo.GetByID(id)
 }
+
+// Deploy Script
+type DeployScriptService struct {
+	scriptRepo *repository.DeployScriptRepository
+	appRepo    *repository.AppRepository
+}
+
+func NewDeployScriptService(scriptRepo *repository.DeployScriptRepository, appRepo *repository.AppRepository) *DeployScriptService {
+	return &DeployScriptService{
+		scriptRepo: scriptRepo,
+		appRepo:    appRepo,
+	}
+}
+
+// 部署脚本的执行阶段
+var deployScriptTypes = map[string]bool{
+	"before_deploy": true,
+	"deploy":        true,
+	"after_deploy":  true,
+}
+
+type CreateScriptRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Type    string `json:"type" binding:"required"`
+	Content string `json:"content" binding:"required"`
+	Sort    int    `json:"sort"`
+	Enabled *bool  `json:"enabled"`
+}
+
+func (s *DeployScriptService) Create(appID uuid.UUID, req *CreateScriptRequest) (*model.DeployScript, error) {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return nil, ErrAppNotFound
+	}
+	if !deployScriptTypes[req.Type] {
+		return nil, ErrScriptTypeInvalid
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	script := &model.DeployScript{
+		AppID:   appID,
+		Name:    req.Name,
+		Type:    req.Type,
+		Content: req.Content,
+		Sort:    req.Sort,
+		Enabled: enabled,
+	}
+
+	if err := s.scriptRepo.Create(script); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+type UpdateScriptRequest struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Sort    *int   `json:"sort"`
+	Enabled *bool  `json:"enabled"`
+}
+
+func (s *DeployScriptService) Update(appID, id uuid.UUID, req *UpdateScriptRequest) (*model.DeployScript, error) {
+	script, err := s.scriptRepo.GetByID(id)
+	if err != nil || script.AppID != appID {
+		return nil, ErrScriptNotFound
+	}
+
+	if req.Type != "" {
+		if !deployScriptTypes[req.Type] {
+			return nil, ErrScriptTypeInvalid
+		}
+		script.Type = req.Type
+	}
+	if req.Name != "" {
+		script.Name = req.Name
+	}
+	if req.Content != "" {
+		script.Content = req.Content
+	}
+	if req.Sort != nil {
+		script.Sort = *req.Sort
+	}
+	if req.Enabled != nil {
+		script.Enabled = *req.Enabled
+	}
+
+	if err := s.scriptRepo.Update(script); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+func (s *DeployScriptService) Delete(appID, id uuid.UUID) error {
+	script, err := s.scriptRepo.GetByID(id)
+	if err != nil || script.AppID != appID {
+		return ErrScriptNotFound
+	}
+	return s.scriptRepo.Delete(id)
+}
+
+func (s *DeployScriptService) GetByID(appID, id uuid.UUID) (*model.DeployScript, error) {
+	script, err := s.scriptRepo.GetByID(id)
+	if err != nil || script.AppID != appID {
+		return nil, ErrScriptNotFound
+	}
+	return script, nil
+}
+
+func (s *DeployScriptService) List(appID uuid.UUID) ([]model.DeployScript, error) {
+	return s.scriptRepo.ListByApp(appID)
+}
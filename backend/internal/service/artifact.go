@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"devops/internal/model"
+	"devops/internal/pkg/storage"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrArtifactNotFound = errors.New("artifact not found")
+	ErrArtifactChecksum = errors.New("artifact checksum mismatch")
+)
+
+// ArtifactService 管理构建产物的存储、查询与保留
+type ArtifactService struct {
+	artifactRepo *repository.ArtifactRepository
+	appRepo      *repository.AppRepository
+	store        storage.Storage
+}
+
+func NewArtifactService(artifactRepo *repository.ArtifactRepository, appRepo *repository.AppRepository, store storage.Storage) *ArtifactService {
+	return &ArtifactService{
+		artifactRepo: artifactRepo,
+		appRepo:      appRepo,
+		store:        store,
+	}
+}
+
+// artifactKey 产物在存储中的路径: <应用代码>/<文件名>
+func artifactKey(app *model.Application, fileName string) string {
+	return app.Code + "/" + fileName
+}
+
+// Save 将本地打包好的产物文件写入存储并记录，随后按应用的保留数量清理旧产物
+func (s *ArtifactService) Save(ctx context.Context, app *model.Application, artifact *model.Artifact, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	artifact.StoragePath = artifactKey(app, artifact.FileName)
+	if err := s.store.Put(ctx, artifact.StoragePath, f, artifact.Size); err != nil {
+		return err
+	}
+	if err := s.artifactRepo.Create(artifact); err != nil {
+		s.store.Delete(ctx, artifact.StoragePath)
+		return err
+	}
+
+	s.prune(ctx, app)
+	return nil
+}
+
+// Open 打开产物内容，调用方负责关闭
+func (s *ArtifactService) Open(ctx context.Context, artifact *model.Artifact) (io.ReadCloser, error) {
+	return s.store.Get(ctx, artifact.StoragePath)
+}
+
+// Load 将已有产物从存储下载到临时文件并校验 sha256，返回产物记录和文件路径，调用方负责删除该文件。
+// 产物已被清理时返回错误
+func (s *ArtifactService) Load(ctx context.Context, id uuid.UUID) (*model.Artifact, string, error) {
+	artifact, err := s.artifactRepo.GetByID(id)
+	if err != nil {
+		return nil, "", ErrArtifactNotFound
+	}
+	r, err := s.Open(ctx, artifact)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	f, err := os.CreateTemp("", "artifact-*.tar.gz")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := copyVerified(f, r, artifact); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return artifact, f.Name(), nil
+}
+
+// copyVerified 将产物内容写入 w，大小或 sha256 与记录不一致时返回 ErrArtifactChecksum
+func copyVerified(w io.Writer, r io.Reader, artifact *model.Artifact) error {
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), r)
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); n != artifact.Size || sum != artifact.SHA256 {
+		return fmt.Errorf("%w: got %d bytes with sha256 %s, want %d bytes with sha256 %s",
+			ErrArtifactChecksum, n, sum, artifact.Size, artifact.SHA256)
+	}
+	return nil
+}
+
+// prune 删除超出应用保留数量的旧产物，仍被部署引用（见 ListReferenced）的产物保留。
+// 失败只记录日志，下次构建时重试；无法确认引用关系时不删除任何产物
+func (s *ArtifactService) prune(ctx context.Context, app *model.Application) {
+	expired, err := s.artifactRepo.ListExpired(app.ID, positiveOr(app.KeepArtifacts, 20))
+	if err != nil {
+		log.Printf("Failed to list expired artifacts for app %s: %v", app.ID, err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+	referenced, err := s.artifactRepo.ListReferenced(app.ID)
+	if err != nil {
+		log.Printf("Failed to list referenced artifacts for app %s: %v", app.ID, err)
+		return
+	}
+	for _, a := range prunableArtifacts(expired, referenced) {
+		if err := s.store.Delete(ctx, a.StoragePath); err != nil {
+			log.Printf("Failed to delete artifact %s: %v", a.StoragePath, err)
+			continue
+		}
+		if err := s.artifactRepo.Delete(a.ID); err != nil {
+			log.Printf("Failed to delete artifact record %s: %v", a.ID, err)
+		}
+	}
+}
+
+// prunableArtifacts 从过期产物中去掉仍被引用的产物
+func prunableArtifacts(expired []model.Artifact, referenced []uuid.UUID) []model.Artifact {
+	keep := make(map[uuid.UUID]bool, len(referenced))
+	for _, id := range referenced {
+		keep[id] = true
+	}
+	var prunable []model.Artifact
+	for _, a := range expired {
+		if !keep[a.ID] {
+			prunable = append(prunable, a)
+		}
+	}
+	return prunable
+}
+
+func (s *ArtifactService) List(appID uuid.UUID, page, pageSize int) ([]model.Artifact, int64, error) {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return nil, 0, ErrAppNotFound
+	}
+	return s.artifactRepo.ListByApp(appID, page, pageSize)
+}
+
+func (s *ArtifactService) GetByID(appID, id uuid.UUID) (*model.Artifact, error) {
+	artifact, err := s.artifactRepo.GetByID(id)
+	if err != nil || artifact.AppID != appID {
+		return nil, ErrArtifactNotFound
+	}
+	return artifact, nil
+}
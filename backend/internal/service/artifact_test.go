@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestPrunableArtifacts(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	expired := []model.Artifact{{ID: a}, {ID: b}, {ID: c}}
+
+	tests := []struct {
+		name       string
+		expired    []model.Artifact
+		referenced []uuid.UUID
+		want       []uuid.UUID
+	}{
+		{name: "nothing referenced", expired: expired, want: []uuid.UUID{a, b, c}},
+		{name: "referenced kept", expired: expired, referenced: []uuid.UUID{b}, want: []uuid.UUID{a, c}},
+		{name: "all referenced", expired: expired, referenced: []uuid.UUID{c, a, b}},
+		{name: "reference outside expired", expired: expired, referenced: []uuid.UUID{uuid.New()}, want: []uuid.UUID{a, b, c}},
+		{name: "nothing expired", referenced: []uuid.UUID{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prunableArtifacts(tt.expired, tt.referenced)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d artifacts, want %d", len(got), len(tt.want))
+			}
+			for i, artifact := range got {
+				if artifact.ID != tt.want[i] {
+					t.Errorf("artifact %d = %s, want %s", i, artifact.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCopyVerified(t *testing.T) {
+	content := "release contents"
+	sum := sha256.Sum256([]byte(content))
+	artifact := &model.Artifact{Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "intact", content: content},
+		{name: "corrupted", content: "release c0ntents", wantErr: true},
+		{name: "truncated", content: content[:7], wantErr: true},
+		{name: "empty", content: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := copyVerified(&out, strings.NewReader(tt.content), artifact)
+			if tt.wantErr {
+				if !errors.Is(err, ErrArtifactChecksum) {
+					t.Fatalf("err = %v, want ErrArtifactChecksum", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != content {
+				t.Errorf("copied %q, want %q", out.String(), content)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"log"
 
 	"devops/internal/model"
@@ -225,3 +226,33 @@ func (s *ConfigService) GetByEnvAndApp(envCode, appCode string, decrypt bool) ([
 func (s *ConfigService) GetHistory(configID uuid.UUID, limit int) ([]model.ConfigHistory, error) {
 	return s.historyRepo.ListByConfigID(configID, limit)
 }
+
+// Resolve 返回应用在环境中实际生效的配置：应用配置覆盖同名的全局配置，密文配置解密后返回。
+// 与 GetByEnvAndApp 不同，解密失败时返回错误，避免将密文下发到主机
+func (s *ConfigService) Resolve(envCode, appCode string) ([]model.ConfigItem, error) {
+	configs, err := s.configRepo.GetByEnvAndApp(envCode, appCode)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(configs))
+	resolved := make([]model.ConfigItem, 0, len(configs))
+	for _, config := range configs {
+		if config.IsSecret && config.Value != "" {
+			decrypted, err := s.encryptor.Decrypt(config.Value)
+			if err != nil {
+				return nil, fmt.Errorf("decrypt config %s: %w", config.Key, err)
+			}
+			config.Value = decrypted
+		}
+		if i, ok := index[config.Key]; ok {
+			if config.AppCode != "" {
+				resolved[i] = config
+			}
+			continue
+		}
+		index[config.Key] = len(resolved)
+		resolved = append(resolved, config)
+	}
+	return resolved, nil
+}
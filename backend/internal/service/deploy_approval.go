@@ -0,0 +1,243 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/jwt"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// DeployApprovalService 管理环境审批策略，以及部署的审批与驳回
+type DeployApprovalService struct {
+	approvalRepo *repository.ApprovalRepository
+	deployRepo   *repository.DeploymentRepository
+	envRepo      *repository.EnvRepository
+	auditRepo    *repository.AuditRepository
+}
+
+func NewDeployApprovalService(
+	approvalRepo *repository.ApprovalRepository,
+	deployRepo *repository.DeploymentRepository,
+	envRepo *repository.EnvRepository,
+	auditRepo *repository.AuditRepository,
+) *DeployApprovalService {
+	return &DeployApprovalService{
+		approvalRepo: approvalRepo,
+		deployRepo:   deployRepo,
+		envRepo:      envRepo,
+		auditRepo:    auditRepo,
+	}
+}
+
+type ApprovalPolicyRequest struct {
+	Enabled          bool        `json:"enabled"`
+	ApproverRoles    []string    `json:"approver_roles"`
+	ApproverGroups   []uuid.UUID `json:"approver_groups"`
+	MinApprovals     int         `json:"min_approvals"`
+	AllowSelfApprove bool        `json:"allow_self_approve"`
+}
+
+// GetPolicy 获取环境的审批策略，未配置时返回未启用的默认策略
+func (s *DeployApprovalService) GetPolicy(envID uuid.UUID) (*model.ApprovalPolicy, error) {
+	if _, err := s.envRepo.GetByID(envID); err != nil {
+		return nil, ErrEnvNotFound
+	}
+	policy, err := s.approvalRepo.GetPolicyByEnv(envID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = &model.ApprovalPolicy{EnvID: envID, MinApprovals: 1}
+	}
+	return policy, nil
+}
+
+// SavePolicy 创建或更新环境的审批策略，只影响之后创建的部署。启用的策略必须指定审批角色或用户组，
+// 修改记录到审计日志
+func (s *DeployApprovalService) SavePolicy(envID uuid.UUID, req *ApprovalPolicyRequest, user *jwt.Claims, ip string) (*model.ApprovalPolicy, error) {
+	if req.Enabled && len(req.ApproverRoles) == 0 && len(req.ApproverGroups) == 0 {
+		return nil, ErrApprovalPolicyInvalid
+	}
+	env, err := s.envRepo.GetByID(envID)
+	if err != nil {
+		return nil, ErrEnvNotFound
+	}
+	policy, err := s.GetPolicy(envID)
+	if err != nil {
+		return nil, err
+	}
+	oldValue, _ := json.Marshal(policy)
+
+	roles, _ := json.Marshal(req.ApproverRoles)
+	groups, _ := json.Marshal(req.ApproverGroups)
+	policy.Enabled = req.Enabled
+	policy.ApproverRoles = string(roles)
+	policy.ApproverGroups = string(groups)
+	policy.MinApprovals = positiveOr(req.MinApprovals, 1)
+	policy.AllowSelfApprove = req.AllowSelfApprove
+
+	if err := s.approvalRepo.SavePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	newValue, _ := json.Marshal(policy)
+	entry := &model.AuditLog{
+		UserID:       user.UserID,
+		Username:     user.Username,
+		Action:       "update_approval_policy",
+		Module:       "deploy",
+		Resource:     "environment",
+		ResourceID:   envID.String(),
+		ResourceName: env.Name,
+		OldValue:     string(oldValue),
+		NewValue:     string(newValue),
+		IP:           ip,
+		Status:       1,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.auditRepo.Create(entry); err != nil {
+		log.Printf("Failed to write approval policy audit log for environment %s: %v", envID, err)
+	}
+	return policy, nil
+}
+
+func (s *DeployApprovalService) ListApprovals(deployID uuid.UUID) ([]model.DeploymentApproval, error) {
+	if _, err := s.deployRepo.GetByID(deployID); err != nil {
+		return nil, ErrDeployNotFound
+	}
+	return s.approvalRepo.ListByDeployment(deployID)
+}
+
+// Approve 审批通过部署，审批人数达到策略要求后部署变为待执行
+func (s *DeployApprovalService) Approve(deployID uuid.UUID, user *jwt.Claims, comment, ip string) (*model.Deployment, error) {
+	return s.decide(deployID, user, "approved", comment, ip)
+}
+
+// Reject 驳回部署，任一审批人驳回即终止该部署
+func (s *DeployApprovalService) Reject(deployID uuid.UUID, user *jwt.Claims, comment, ip string) (*model.Deployment, error) {
+	return s.decide(deployID, user, "rejected", comment, ip)
+}
+
+func (s *DeployApprovalService) decide(deployID uuid.UUID, user *jwt.Claims, decision, comment, ip string) (*model.Deployment, error) {
+	deploy, err := s.deployRepo.GetByID(deployID)
+	if err != nil {
+		return nil, ErrDeployNotFound
+	}
+	if deploy.Status != 6 {
+		return nil, ErrDeployNotAwaitingApproval
+	}
+
+	// 部署创建后策略被删除时，按至少一名管理员审批处理
+	policy := &model.ApprovalPolicy{MinApprovals: 1}
+	if deploy.EnvID != nil {
+		if p, err := s.approvalRepo.GetPolicyByEnv(*deploy.EnvID); err != nil {
+			return nil, err
+		} else if p != nil {
+			policy = p
+		}
+	}
+
+	allowed, err := s.canApprove(policy, deploy, user)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrApprovalNotAllowed
+	}
+	if decided, err := s.approvalRepo.HasDecided(deployID, user.UserID); err != nil {
+		return nil, err
+	} else if decided {
+		return nil, ErrApprovalDuplicate
+	}
+
+	approval := &model.DeploymentApproval{
+		DeploymentID: deployID,
+		UserID:       user.UserID,
+		Username:     user.Username,
+		Decision:     decision,
+		Comment:      comment,
+	}
+	if err := s.approvalRepo.Create(approval); err != nil {
+		return nil, err
+	}
+	s.audit(deploy, user, decision, comment, ip)
+
+	if decision == "rejected" {
+		if _, err := s.deployRepo.UpdateStatusIf(deployID, 6, 7); err != nil { // rejected
+			return nil, err
+		}
+	} else {
+		approved, err := s.approvalRepo.CountApproved(deployID)
+		if err != nil {
+			return nil, err
+		}
+		if approved >= int64(positiveOr(policy.MinApprovals, 1)) {
+			if _, err := s.deployRepo.UpdateStatusIf(deployID, 6, 0); err != nil { // pending
+				return nil, err
+			}
+		}
+	}
+
+	return s.deployRepo.GetByID(deployID)
+}
+
+// canApprove 审批人需具有策略中的角色或属于策略中的用户组，两者都未配置时（例如策略在部署创建后被删除）只有管理员可以审批
+func (s *DeployApprovalService) canApprove(policy *model.ApprovalPolicy, deploy *model.Deployment, user *jwt.Claims) (bool, error) {
+	if !policy.AllowSelfApprove && deploy.CreatedBy == user.UserID {
+		return false, nil
+	}
+
+	var roles []string
+	var groups []uuid.UUID
+	if policy.ApproverRoles != "" {
+		json.Unmarshal([]byte(policy.ApproverRoles), &roles)
+	}
+	if policy.ApproverGroups != "" {
+		json.Unmarshal([]byte(policy.ApproverGroups), &groups)
+	}
+	if len(roles) == 0 && len(groups) == 0 {
+		return user.RoleCode == "admin", nil
+	}
+
+	for _, role := range roles {
+		if role == user.RoleCode {
+			return true, nil
+		}
+	}
+	return s.approvalRepo.UserInGroups(user.UserID, groups)
+}
+
+// audit 将审批决定写入审计日志
+func (s *DeployApprovalService) audit(deploy *model.Deployment, user *jwt.Claims, decision, comment, ip string) {
+	action := "approve"
+	if decision == "rejected" {
+		action = "reject"
+	}
+	name := deploy.Version
+	if deploy.App != nil {
+		name = deploy.App.Name + " " + deploy.Version
+	}
+
+	entry := &model.AuditLog{
+		UserID:       user.UserID,
+		Username:     user.Username,
+		Action:       action,
+		Module:       "deploy",
+		Resource:     "deployment",
+		ResourceID:   deploy.ID.String(),
+		ResourceName: name,
+		NewValue:     decision,
+		Detail:       comment,
+		IP:           ip,
+		Status:       1,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.auditRepo.Create(entry); err != nil {
+		log.Printf("Failed to write approval audit log for deployment %s: %v", deploy.ID, err)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestDeployInitialStatus(t *testing.T) {
+	envID := uuid.New()
+
+	tests := []struct {
+		name    string
+		envID   *uuid.UUID
+		policy  *model.ApprovalPolicy
+		err     error
+		want    int
+		wantErr bool
+	}{
+		{name: "no environment", envID: nil, want: 0},
+		{name: "no policy", envID: &envID, want: 0},
+		{name: "disabled policy", envID: &envID, policy: &model.ApprovalPolicy{EnvID: envID, Enabled: false}, want: 0},
+		{name: "enabled policy", envID: &envID, policy: &model.ApprovalPolicy{EnvID: envID, Enabled: true}, want: 6},
+		{name: "policy lookup fails", envID: &envID, err: errors.New("connection refused"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(id uuid.UUID) (*model.ApprovalPolicy, error) {
+				if id != envID {
+					t.Fatalf("looked up policy for env %s, want %s", id, envID)
+				}
+				return tt.policy, tt.err
+			}
+			got, err := deployInitialStatus(lookup, tt.envID)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("status = %d, want error", got)
+				}
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want it to wrap %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
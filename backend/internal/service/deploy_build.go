@@ -0,0 +1,256 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devops/internal/model"
+)
+
+// ArtifactBuilder 在服务端检出代码、执行构建命令，并将结果打包为部署产物
+type ArtifactBuilder struct {
+	workspace string
+	artifacts *ArtifactService
+}
+
+func NewArtifactBuilder(workspace string, artifacts *ArtifactService) *ArtifactBuilder {
+	if workspace == "" {
+		workspace = filepath.Join(os.TempDir(), "devops-builds")
+	}
+	return &ArtifactBuilder{
+		workspace: workspace,
+		artifacts: artifacts,
+	}
+}
+
+// needsBuild 配置了构建命令且使用发布目录结构的应用在部署前先构建产物
+func needsBuild(app *model.Application) bool {
+	return app.BuildCmd != "" && usesReleases(app)
+}
+
+// Build 在临时工作区检出部署指定的提交（未指定时为分支最新提交）并执行 BuildCmd，
+// 将工作区（不含 .git）打包为 tar.gz 并记录校验和后存入产物存储，同时返回产物文件供上传到主机，
+// 调用方负责删除该文件。构建输出实时写入 logw
+func (b *ArtifactBuilder) Build(ctx context.Context, deploy *model.Deployment, app *model.Application, logw io.Writer) (*model.Artifact, string, error) {
+	artifact, file, err := b.build(ctx, deploy, app, logw)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := b.artifacts.Save(ctx, app, artifact, file); err != nil {
+		os.Remove(file)
+		return nil, "", fmt.Errorf("store artifact: %w", err)
+	}
+	return artifact, file, nil
+}
+
+// build 检出、构建并打包，返回产物记录和工作区外的产物文件路径，调用方负责删除该文件
+func (b *ArtifactBuilder) build(ctx context.Context, deploy *model.Deployment, app *model.Application, logw io.Writer) (*model.Artifact, string, error) {
+	if err := os.MkdirAll(b.workspace, 0755); err != nil {
+		return nil, "", fmt.Errorf("create workspace: %w", err)
+	}
+	dir, err := os.MkdirTemp(b.workspace, app.Code+"-")
+	if err != nil {
+		return nil, "", fmt.Errorf("create workspace: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	branch := deploy.Branch
+	if branch == "" {
+		branch = app.Branch
+	}
+	ref, err := checkoutRef(deploy.CommitID, branch)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := runBuildCmd(ctx, "", logw, "git", "clone", "--no-checkout", "--", app.RepoURL, dir); err != nil {
+		return nil, "", fmt.Errorf("git clone: %w", err)
+	}
+	if err := runBuildCmd(ctx, dir, logw, "git", "checkout", "--detach", ref, "--"); err != nil {
+		return nil, "", fmt.Errorf("git checkout %s: %w", ref, err)
+	}
+	var head bytes.Buffer
+	if err := runBuildCmd(ctx, dir, &head, "git", "rev-parse", "HEAD"); err != nil {
+		return nil, "", fmt.Errorf("git rev-parse: %w", err)
+	}
+	commit := strings.TrimSpace(head.String())
+	fmt.Fprintf(logw, "checked out %s\n", commit)
+
+	vars := map[string]string{
+		"APP_CODE":       app.Code,
+		"DEPLOY_VERSION": deploy.Version,
+		"DEPLOY_BRANCH":  branch,
+		"DEPLOY_COMMIT":  commit,
+	}
+	if app.Env != nil {
+		vars["DEPLOY_ENV"] = app.Env.Code
+	}
+	fmt.Fprintf(logw, "$ %s\n", app.BuildCmd)
+	if err := runBuildCmdEnv(ctx, dir, commandEnv(vars), logw, "sh", "-c", app.BuildCmd); err != nil {
+		return nil, "", fmt.Errorf("build command: %w", err)
+	}
+
+	artifact := &model.Artifact{
+		AppID:        app.ID,
+		DeploymentID: deploy.ID,
+		Version:      deploy.Version,
+		CommitID:     commit,
+		Branch:       branch,
+		FileName:     deploy.ID.String() + ".tar.gz",
+	}
+	file := dir + ".tar.gz"
+	if err := packageDir(dir, file, artifact); err != nil {
+		os.Remove(file)
+		return nil, "", fmt.Errorf("package artifact: %w", err)
+	}
+	fmt.Fprintf(logw, "packaged %s (%d bytes, sha256 %s)\n", artifact.FileName, artifact.Size, artifact.SHA256)
+	return artifact, file, nil
+}
+
+// ErrInvalidRef 提交号以 - 开头，会被 git 当作命令行选项
+var ErrInvalidRef = errors.New("invalid git ref")
+
+// checkoutRef 返回要检出的引用：指定了提交号时为该提交，否则为远程分支最新提交。
+// 提交号来自用户输入，拒绝以 - 开头的值，防止被当作 git 选项注入
+func checkoutRef(commitID, branch string) (string, error) {
+	if commitID == "" {
+		return "origin/" + branch, nil
+	}
+	if strings.HasPrefix(commitID, "-") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRef, commitID)
+	}
+	return commitID, nil
+}
+
+// runBuildCmd 以不含应用变量的 commandEnv 执行 git 等辅助命令
+func runBuildCmd(ctx context.Context, dir string, out io.Writer, name string, args ...string) error {
+	return runBuildCmdEnv(ctx, dir, commandEnv(nil), out, name, args...)
+}
+
+func runBuildCmdEnv(ctx context.Context, dir string, env []string, out io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// inheritedEnv 构建和流水线命令从服务进程继承的环境变量
+var inheritedEnv = []string{"PATH", "HOME", "LANG", "TZ", "TMPDIR"}
+
+// commandEnv 构建和流水线命令的环境变量。只继承 inheritedEnv，避免 JWT_SECRET、数据库密码、
+// 存储和通知凭据等服务端配置泄露给用户命令；vars 为应用自身的变量
+func commandEnv(vars map[string]string) []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	for _, k := range inheritedEnv {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+	return env
+}
+
+// packageDir 将 dir 打包到 file，并填充产物的 Size 和 SHA256
+func packageDir(dir, file string, artifact *model.Artifact) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(f, hash, counter))
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	artifact.Size = counter.n
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
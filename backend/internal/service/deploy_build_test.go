@@ -0,0 +1,224 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestRunBuildCmdDoesNotInheritSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("DB_PASSWORD", "db-password")
+	t.Setenv("S3_SECRET_KEY", "s3-secret")
+
+	var out bytes.Buffer
+	env := commandEnv(map[string]string{"APP_CODE": "demo", "DEPLOY_VERSION": "v1.0.0"})
+	if err := runBuildCmdEnv(context.Background(), t.TempDir(), env, &out, "sh", "-c", "env"); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			vars[k] = v
+		}
+	}
+	for _, k := range []string{"JWT_SECRET", "DB_PASSWORD", "S3_SECRET_KEY"} {
+		if _, ok := vars[k]; ok {
+			t.Errorf("%s was passed to the build command", k)
+		}
+	}
+	want := map[string]string{"APP_CODE": "demo", "DEPLOY_VERSION": "v1.0.0", "GIT_TERMINAL_PROMPT": "0"}
+	for k, v := range want {
+		if vars[k] != v {
+			t.Errorf("%s = %q, want %q", k, vars[k], v)
+		}
+	}
+	if vars["PATH"] == "" {
+		t.Error("PATH was not passed to the build command")
+	}
+}
+
+func TestCheckoutRef(t *testing.T) {
+	tests := []struct {
+		name     string
+		commitID string
+		branch   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "branch head", branch: "main", want: "origin/main"},
+		{name: "commit", commitID: "3f2a9c1", branch: "main", want: "3f2a9c1"},
+		{name: "option", commitID: "--upload-pack=touch /tmp/pwned", branch: "main", wantErr: true},
+		{name: "short option", commitID: "-b", branch: "main", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkoutRef(tt.commitID, tt.branch)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRef) {
+					t.Fatalf("err = %v, want ErrInvalidRef", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ref = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// runGit 在临时目录中执行 git 命令并返回去除首尾空白的输出
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newBareRepo 创建本地裸仓库，main 分支上依次提交 v1、v2 两个版本的 VERSION 文件，返回仓库路径和各提交号
+func newBareRepo(t *testing.T) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	bare := filepath.Join(root, "repo.git")
+	work := filepath.Join(root, "work")
+	runGit(t, root, "init", "--bare", "-q", bare)
+	runGit(t, root, "init", "-q", work)
+
+	var commits []string
+	for _, v := range []string{"v1", "v2"} {
+		if err := os.WriteFile(filepath.Join(work, "VERSION"), []byte(v+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, work, "add", "VERSION")
+		runGit(t, work, "commit", "-q", "-m", v)
+		commits = append(commits, runGit(t, work, "rev-parse", "HEAD"))
+	}
+	runGit(t, work, "push", "-q", bare, "HEAD:refs/heads/main")
+	return bare, commits
+}
+
+// readArtifact 解包产物并返回普通文件路径到内容的映射
+func readArtifact(t *testing.T, file string) map[string]string {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestBuildFromBareRepo(t *testing.T) {
+	repo, commits := newBareRepo(t)
+	app := &model.Application{
+		ID:       uuid.New(),
+		Code:     "demo",
+		RepoURL:  repo,
+		Branch:   "main",
+		BuildCmd: "mkdir dist && cp VERSION dist/ && echo \"$DEPLOY_COMMIT\" > dist/COMMIT",
+	}
+
+	tests := []struct {
+		name        string
+		commitID    string
+		wantCommit  string
+		wantVersion string
+	}{
+		{name: "branch head", wantCommit: commits[1], wantVersion: "v2\n"},
+		{name: "pinned commit", commitID: commits[0], wantCommit: commits[0], wantVersion: "v1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewArtifactBuilder(t.TempDir(), nil)
+			deploy := &model.Deployment{ID: uuid.New(), Version: "1.0.0", CommitID: tt.commitID}
+
+			var logs bytes.Buffer
+			artifact, file, err := b.build(context.Background(), deploy, app, &logs)
+			if err != nil {
+				t.Fatalf("build: %v\n%s", err, logs.String())
+			}
+			defer os.Remove(file)
+
+			if artifact.CommitID != tt.wantCommit || artifact.Branch != "main" {
+				t.Errorf("artifact commit/branch = %s/%s, want %s/main", artifact.CommitID, artifact.Branch, tt.wantCommit)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(data)
+			if artifact.SHA256 != hex.EncodeToString(sum[:]) || artifact.Size != int64(len(data)) {
+				t.Errorf("artifact size/sha256 = %d/%s, does not match the packaged file", artifact.Size, artifact.SHA256)
+			}
+
+			files := readArtifact(t, file)
+			if files["dist/VERSION"] != tt.wantVersion {
+				t.Errorf("dist/VERSION = %q, want %q", files["dist/VERSION"], tt.wantVersion)
+			}
+			if files["dist/COMMIT"] != tt.wantCommit+"\n" {
+				t.Errorf("dist/COMMIT = %q, want %q", files["dist/COMMIT"], tt.wantCommit)
+			}
+			for name := range files {
+				if strings.HasPrefix(name, ".git/") {
+					t.Fatalf("artifact contains %s", name)
+				}
+			}
+		})
+	}
+
+	t.Run("option as commit", func(t *testing.T) {
+		b := NewArtifactBuilder(t.TempDir(), nil)
+		deploy := &model.Deployment{ID: uuid.New(), CommitID: "--orphan=pwned"}
+		if _, _, err := b.build(context.Background(), deploy, app, io.Discard); !errors.Is(err, ErrInvalidRef) {
+			t.Fatalf("err = %v, want ErrInvalidRef", err)
+		}
+	})
+}
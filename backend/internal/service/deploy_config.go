@@ -0,0 +1,211 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"devops/internal/model"
+)
+
+var (
+	ErrAppConfigTarget = errors.New("invalid config injection settings")
+	ErrConfigChanged   = errors.New("config has changed since the deployment ran")
+)
+
+// deployConfig 渲染后待下发到主机的配置文件
+type deployConfig struct {
+	Data     []byte
+	Path     string
+	Versions string // Deployment.ConfigVersions
+}
+
+// configTemplateData template 格式的模板数据，Config 以配置键为 key，例如 {{ index .Config "db.host" }}
+type configTemplateData struct {
+	App     string
+	Env     string
+	Version string
+	Commit  string
+	Branch  string
+	Release string
+	Config  map[string]string
+}
+
+// validateConfigTarget 校验应用的配置注入设置。模板在保存时解析，避免部署时才发现语法错误
+func validateConfigTarget(app *model.Application) error {
+	switch app.ConfigFormat {
+	case "":
+		return nil
+	case "env":
+	case "template":
+		if app.ConfigPath == "" || app.ConfigTemplate == "" {
+			return ErrAppConfigTarget
+		}
+		if _, err := parseConfigTemplate(app.ConfigTemplate); err != nil {
+			return fmt.Errorf("%w: %v", ErrAppConfigTarget, err)
+		}
+	default:
+		return ErrAppConfigTarget
+	}
+	if app.K8sClusterID != nil {
+		return ErrAppConfigTarget
+	}
+	if !path.IsAbs(app.ConfigPath) && app.DeployPath == "" {
+		return ErrAppConfigTarget
+	}
+	return nil
+}
+
+func parseConfigTemplate(text string) (*template.Template, error) {
+	return template.New("config").Option("missingkey=error").Parse(text)
+}
+
+// configFilePath 配置文件在主机上的路径。相对路径基于本次发布目录，应用未使用发布目录时基于 DeployPath
+func configFilePath(deploy *model.Deployment, app *model.Application) string {
+	p := app.ConfigPath
+	if p == "" {
+		p = ".env"
+	}
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	if dir := releaseDir(app, deploy.Release); usesReleases(app) && dir != "" {
+		return path.Join(dir, p)
+	}
+	return path.Join(app.DeployPath, p)
+}
+
+// loadDeployConfig 从配置中心读取应用在部署环境中的配置并按应用设置渲染。
+// 应用未开启配置注入、部署到 Kubernetes 或为回滚（沿用发布目录中已有的配置）时返回 nil
+func (s *DeploymentService) loadDeployConfig(deploy *model.Deployment, app *model.Application) (*deployConfig, error) {
+	if app.ConfigFormat == "" || deploy.Type == "rollback" || isK8sTarget(app) {
+		return nil, nil
+	}
+	if err := validateConfigTarget(app); err != nil {
+		return nil, err
+	}
+	if app.Env == nil || deploy.EnvID == nil || app.Env.ID != *deploy.EnvID {
+		return nil, fmt.Errorf("application is no longer in the deployment's environment")
+	}
+
+	configs, err := s.configService.Resolve(app.Env.Code, app.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch app.ConfigFormat {
+	case "env":
+		data, err = renderEnvFile(configs)
+	case "template":
+		data, err = renderConfigTemplate(app.ConfigTemplate, &configTemplateData{
+			App:     app.Code,
+			Env:     app.Env.Code,
+			Version: deploy.Version,
+			Commit:  deploy.CommitID,
+			Branch:  deploy.Branch,
+			Release: deploy.Release,
+			Config:  configMap(configs),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := configVersions(configs)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{} // 没有配置时也写入空文件，Upload 为 nil 的步骤会被跳过
+	}
+	return &deployConfig{Data: data, Path: configFilePath(deploy, app), Versions: versions}, nil
+}
+
+// renderEnvFile 将配置渲染为 KEY='value' 格式，可被 shell source 或 systemd EnvironmentFile 读取。
+// 配置键转换为大写，非字母数字字符替换为下划线（db.host → DB_HOST）
+func renderEnvFile(configs []model.ConfigItem) ([]byte, error) {
+	var buf bytes.Buffer
+	seen := make(map[string]string, len(configs))
+	for _, c := range configs {
+		name := envName(c.Key)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("config keys %s and %s map to the same variable %s", other, c.Key, name)
+		}
+		seen[name] = c.Key
+		fmt.Fprintf(&buf, "%s=%s\n", name, shellQuote(c.Value))
+	}
+	return buf.Bytes(), nil
+}
+
+func envName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func renderConfigTemplate(text string, data *configTemplateData) ([]byte, error) {
+	tmpl, err := parseConfigTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render config template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func configMap(configs []model.ConfigItem) map[string]string {
+	values := make(map[string]string, len(configs))
+	for _, c := range configs {
+		values[c.Key] = c.Value
+	}
+	return values
+}
+
+// configVersions 按配置键排序序列化，相同的配置集合得到相同的结果，便于比较
+func configVersions(configs []model.ConfigItem) (string, error) {
+	versions := make([]model.DeployConfigVersion, 0, len(configs))
+	for _, c := range configs {
+		versions = append(versions, model.DeployConfigVersion{
+			ConfigID: c.ID,
+			Key:      c.Key,
+			AppCode:  c.AppCode,
+			Version:  c.Version,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Key < versions[j].Key })
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// configSteps 上传配置文件。先删除已有文件，确保以 0600 权限重新创建（scp 不修改已存在文件的权限）
+func configSteps(cfg *deployConfig) []deployStep {
+	if cfg == nil {
+		return nil
+	}
+	return []deployStep{
+		{Name: "config", Command: fmt.Sprintf("mkdir -p %s && rm -f %s", shellQuote(path.Dir(cfg.Path)), shellQuote(cfg.Path))},
+		{Name: "config", Upload: cfg.Data, UploadTo: cfg.Path, UploadMode: "0600"},
+	}
+}
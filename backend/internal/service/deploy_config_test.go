@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"devops/internal/model"
+	"devops/internal/pkg/ssh/sshtest"
+)
+
+func TestConfigStepsUploadWithMode0600(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	srv := sshtest.NewServer(t)
+	host := &model.Host{
+		IP:       srv.Host,
+		Port:     srv.Port,
+		Username: sshtest.User,
+		AuthType: "password",
+		Password: sshtest.Password,
+	}
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing", ".env")
+	if err := os.MkdirAll(filepath.Dir(existing), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("OLD=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  *deployConfig
+	}{
+		{name: "new directory", cfg: &deployConfig{Data: []byte("DB_HOST='db'\n"), Path: filepath.Join(dir, "releases", "v1", ".env")}},
+		{name: "replaces 0644 file", cfg: &deployConfig{Data: []byte("DB_HOST='db2'\n"), Path: existing}},
+		{name: "empty config", cfg: &deployConfig{Data: []byte{}, Path: filepath.Join(dir, "empty", "app.conf")}},
+		{name: "path with spaces", cfg: &deployConfig{Data: []byte("A=1\n"), Path: filepath.Join(dir, "my app", "app config.env")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := runHostSteps(context.Background(), host, configSteps(tt.cfg), io.Discard)
+			if result.Status != "success" {
+				t.Fatalf("status = %s, error = %s, output = %s", result.Status, result.Error, result.Output)
+			}
+			got, err := os.ReadFile(tt.cfg.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.cfg.Data) {
+				t.Errorf("content = %q, want %q", got, tt.cfg.Data)
+			}
+			info, err := os.Stat(tt.cfg.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != 0o600 {
+				t.Errorf("mode = %04o, want 0600", perm)
+			}
+		})
+	}
+}
+
+func TestRenderEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []model.ConfigItem
+		want    string
+		wantErr bool
+	}{
+		{name: "no configs", want: ""},
+		{
+			name:    "converts keys to variable names",
+			configs: []model.ConfigItem{{Key: "db.host", Value: "db"}, {Key: "redis-port", Value: "6379"}, {Key: "Log_Level", Value: "info"}},
+			want:    "DB_HOST='db'\nREDIS_PORT='6379'\nLOG_LEVEL='info'\n",
+		},
+		{
+			name:    "prefixes keys starting with a digit",
+			configs: []model.ConfigItem{{Key: "3rd.party.url", Value: "https://example.com"}},
+			want:    "_3RD_PARTY_URL='https://example.com'\n",
+		},
+		{
+			name:    "quotes shell metacharacters",
+			configs: []model.ConfigItem{{Key: "password", Value: "it's $HOME `id` \"x\""}},
+			want:    "PASSWORD='it'\\''s $HOME `id` \"x\"'\n",
+		},
+		{
+			name:    "keeps newlines inside quotes",
+			configs: []model.ConfigItem{{Key: "cert", Value: "line1\nline2"}},
+			want:    "CERT='line1\nline2'\n",
+		},
+		{
+			name:    "rejects keys mapping to the same variable",
+			configs: []model.ConfigItem{{Key: "db.host", Value: "a"}, {Key: "db-host", Value: "b"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderEnvFile(tt.configs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("renderEnvFile succeeded: %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderEnvFile = %q, want %q", got, tt.want)
+			}
+			if len(tt.configs) > 0 {
+				assertSourcedValue(t, got, envName(tt.configs[0].Key), tt.configs[0].Value)
+			}
+		})
+	}
+}
+
+// assertSourcedValue 检查 shell source 渲染结果后变量的值与配置一致
+func assertSourcedValue(t *testing.T, env []byte, name, want string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, env, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", "-c", `. "$1" && eval "printf %s \"\$$2\""`, "sh", path, name).Output()
+	if err != nil {
+		t.Fatalf("source env file: %v", err)
+	}
+	if got := string(out); got != want {
+		t.Errorf("sourced %s = %q, want %q", name, got, want)
+	}
+}
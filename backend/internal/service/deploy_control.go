@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// deployRun 运行中部署的控制句柄，用于批次间的暂停与继续，以及取消部署
+type deployRun struct {
+	mu             sync.Mutex
+	pauseRequested bool
+	waiting        bool // 正在批次间等待（暂停或定时晋级）
+	resume         chan struct{}
+
+	// ctx 在部署被取消时结束，主机上正在执行的命令会随之中止
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDeployRun() *deployRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &deployRun{resume: make(chan struct{}, 1), ctx: ctx, cancel: cancel}
+}
+
+// cancelled 部署是否已被取消
+func (r *deployRun) cancelled() bool {
+	return r.ctx.Err() != nil
+}
+
+// requestPause 请求在当前批次完成后暂停
+func (r *deployRun) requestPause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pauseRequested = true
+}
+
+// takePauseRequest 读取并清除暂停请求
+func (r *deployRun) takePauseRequest() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requested := r.pauseRequested
+	r.pauseRequested = false
+	return requested
+}
+
+func (r *deployRun) setWaiting(waiting bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waiting = waiting
+}
+
+// signalResume 唤醒批次间的等待，未处于等待状态时返回 false
+func (r *deployRun) signalResume() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.waiting {
+		return false
+	}
+	select {
+	case r.resume <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// deployRunRegistry 记录本进程内正在执行的部署
+type deployRunRegistry struct {
+	mu   sync.Mutex
+	runs map[uuid.UUID]*deployRun
+}
+
+func newDeployRunRegistry() *deployRunRegistry {
+	return &deployRunRegistry{runs: make(map[uuid.UUID]*deployRun)}
+}
+
+func (r *deployRunRegistry) add(id uuid.UUID) *deployRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := newDeployRun()
+	r.runs[id] = run
+	return run
+}
+
+func (r *deployRunRegistry) get(id uuid.UUID) (*deployRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runs[id]
+	return run, ok
+}
+
+func (r *deployRunRegistry) remove(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if run, ok := r.runs[id]; ok {
+		run.cancel()
+		delete(r.runs, id)
+	}
+}
@@ -0,0 +1,594 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/lock"
+	"devops/internal/pkg/ssh"
+)
+
+// deployStep 单台主机上按顺序执行的一个部署步骤
+type deployStep struct {
+	Name    string
+	Command string
+	// Optional 为 true 时步骤失败只记录输出，不中断部署（例如应用尚未运行时的停止命令）
+	Optional bool
+	// Script 为 true 时日志中不回显完整命令，避免脚本内容刷屏
+	Script bool
+	// Upload 非空时该步骤将内容上传到主机的 UploadTo 路径，而不是执行命令，UploadMode 为空时使用 0644。
+	// UploadFile 非空时改为流式上传该本地文件，用于体积较大的构建产物
+	Upload     []byte
+	UploadFile string
+	UploadTo   string
+	UploadMode string
+	// Health 非空时该步骤由平台对主机发起 HTTP 健康检查，而不是执行命令
+	Health *healthCheck
+}
+
+func (st deployStep) Display() string {
+	if st.Health != nil {
+		return "GET " + st.Health.URL
+	}
+	if st.Upload != nil {
+		return fmt.Sprintf("upload %d bytes to %s", len(st.Upload), st.UploadTo)
+	}
+	if st.UploadFile != "" {
+		return fmt.Sprintf("upload %s to %s", filepath.Base(st.UploadFile), st.UploadTo)
+	}
+	if st.Script {
+		return "<script>"
+	}
+	return st.Command
+}
+
+// executeDeploy 在后台按发布策略分批对应用主机执行部署，完成后调用 FinishDeploy 汇总结果，
+// 并将 (应用, 环境) 锁交给队列中的下一个部署
+func (s *DeploymentService) executeDeploy(deploy *model.Deployment, app *model.Application, run *deployRun, held lock.Lock) {
+	defer s.runNext(deploy.AppID, deploy.EnvID, held)
+	defer s.runs.remove(deploy.ID)
+	defer s.logHub.Close(deploy.ID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Deployment %s panicked: %v", deploy.ID, r)
+			if err := s.FinishDeploy(deploy.ID, false, fmt.Sprintf("部署异常终止: %v", r)); err != nil {
+				log.Printf("Failed to finish deployment %s: %v", deploy.ID, err)
+			}
+		}
+	}()
+
+	if isK8sTarget(app) {
+		results, summary := s.deployToK8s(run, deploy, app)
+		s.completeDeploy(deploy, app, run, results, summary)
+		return
+	}
+
+	// 配置在变更主机前加载，配置缺失或模板渲染失败时不执行任何步骤
+	cfg, err := s.loadDeployConfig(deploy, app)
+	if err != nil {
+		summary := fmt.Sprintf("加载配置失败: %v", err)
+		s.logHub.Append(deploy.ID, "[deploy] "+summary)
+		s.finishWithResults(deploy, run, nil, summary+"\n\n")
+		return
+	}
+	if cfg != nil {
+		deploy.ConfigVersions = cfg.Versions
+		if err := s.deployRepo.UpdateConfigVersions(deploy.ID, cfg.Versions); err != nil {
+			log.Printf("Failed to record config versions for deployment %s: %v", deploy.ID, err)
+		}
+		s.logHub.Append(deploy.ID, fmt.Sprintf("[deploy] config: %d bytes will be written to %s", len(cfg.Data), cfg.Path))
+	}
+
+	// 回滚直接切换到已有的发布目录，不再执行部署脚本。
+	// 脚本无法加载时不能跳过 before_deploy 检查，直接失败且不变更任何主机
+	var phases map[string][]model.DeployScript
+	if deploy.Type != "rollback" {
+		scripts, err := s.scriptRepo.ListEnabledByApp(app.ID)
+		if err != nil {
+			summary := fmt.Sprintf("加载部署脚本失败: %v", err)
+			s.logHub.Append(deploy.ID, "[deploy] "+summary)
+			s.finishWithResults(deploy, run, nil, summary+"\n\n")
+			return
+		}
+		phases = groupScriptsByType(scripts)
+	}
+
+	// before_deploy 脚本作为发布前置检查，需在所有主机上成功后才会开始变更主机
+	if before := scriptSteps(deploy, app, phases["before_deploy"]); len(before) > 0 {
+		results := s.runOnHosts(run.ctx, deploy, app.Hosts, before)
+		for _, r := range results {
+			if r.Status != "success" {
+				summary := fmt.Sprintf("before_deploy 脚本 %s 在主机 %s (%s) 执行失败，发布已中止",
+					strings.TrimPrefix(r.Step, "before_deploy:"), r.HostName, r.HostIP)
+				if run.cancelled() {
+					summary = "部署已取消，before_deploy 脚本未执行完成"
+				}
+				s.logHub.Append(deploy.ID, "[deploy] "+summary)
+				s.finishWithResults(deploy, run, results, summary+"\n\n")
+				return
+			}
+		}
+	}
+
+	var artifact *deployArtifact
+	if deploy.Type != "rollback" && needsBuild(app) {
+		data, err := s.buildArtifact(run.ctx, deploy, app)
+		if err != nil {
+			summary := fmt.Sprintf("构建失败: %v", err)
+			if run.cancelled() {
+				summary = "部署已取消，构建未完成"
+			}
+			s.logHub.Append(deploy.ID, "[deploy] "+summary)
+			s.finishWithResults(deploy, run, nil, summary+"\n\n")
+			return
+		}
+		artifact = data
+		defer artifact.Remove()
+	}
+
+	steps := buildDeploySteps(deploy, app, phases, artifact, cfg)
+	batches := planBatches(deploy.Strategy, app, app.Hosts)
+	results := make([]model.DeployHostResult, 0, len(app.Hosts))
+	summary := ""
+
+	for i, batch := range batches {
+		if i > 0 && !s.waitBeforeBatch(deploy, app, run, i) {
+			results = append(results, skippedResults(batches[i:])...)
+			summary = fmt.Sprintf("部署已取消，第 %d/%d 批及之后的主机未变更", i+1, len(batches))
+			s.logHub.Append(deploy.ID, "[deploy] "+summary)
+			summary += "\n\n"
+			break
+		}
+		if err := s.deployRepo.UpdateProgress(deploy.ID, i+1, false); err != nil {
+			log.Printf("Failed to update progress for deployment %s: %v", deploy.ID, err)
+		}
+		s.logHub.Append(deploy.ID, fmt.Sprintf("[deploy] batch %d/%d: %d host(s)", i+1, len(batches), len(batch)))
+
+		batchResults := s.runOnHosts(run.ctx, deploy, batch, steps)
+		results = append(results, batchResults...)
+
+		if batchFailed(batchResults) {
+			// 批次失败或被取消即停止，剩余主机不做变更
+			results = append(results, skippedResults(batches[i+1:])...)
+			summary = fmt.Sprintf("第 %d/%d 批部署失败，已停止后续批次", i+1, len(batches))
+			if run.cancelled() {
+				summary = fmt.Sprintf("部署已取消，第 %d/%d 批执行中断，已停止后续批次", i+1, len(batches))
+			}
+			s.logHub.Append(deploy.ID, "[deploy] "+summary)
+			summary += "\n\n"
+			break
+		}
+		s.saveHostResults(deploy, results)
+	}
+
+	s.completeDeploy(deploy, app, run, results, summary)
+}
+
+// completeDeploy 按需创建自动回滚，然后以汇总输出结束部署
+func (s *DeploymentService) completeDeploy(deploy *model.Deployment, app *model.Application, run *deployRun, results []model.DeployHostResult, summary string) {
+	rollback, note := s.autoRollback(deploy, app, run, results)
+	if note != "" {
+		s.logHub.Append(deploy.ID, "[deploy] "+strings.TrimSpace(note))
+	}
+	s.finishWithResults(deploy, run, results, summary+note)
+	if rollback != nil {
+		s.startAutoRollback(deploy, rollback)
+	}
+}
+
+func (st deployStep) uploadMode() string {
+	if st.UploadMode == "" {
+		return "0644"
+	}
+	return st.UploadMode
+}
+
+// uploadFile 将本地文件流式上传到主机
+func uploadFile(ctx context.Context, executor *ssh.Executor, file, remotePath, mode string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return executor.UploadReader(ctx, f, info.Size(), remotePath, mode)
+}
+
+// deployArtifact 待上传到主机的构建产物，File 为服务端的临时文件，部署结束后由调用方删除
+type deployArtifact struct {
+	File   string
+	SHA256 string
+}
+
+func (a *deployArtifact) Remove() {
+	if a != nil {
+		os.Remove(a.File)
+	}
+}
+
+// buildArtifact 构建部署产物并关联到部署，返回待上传到主机的产物文件
+func (s *DeploymentService) buildArtifact(ctx context.Context, deploy *model.Deployment, app *model.Application) (*deployArtifact, error) {
+	logw := s.logHub.writer(deploy.ID, "[build] ")
+	defer logw.Flush()
+
+	// 晋级的部署沿用来源部署的产物，不重新构建
+	if deploy.ArtifactID != nil {
+		artifact, file, err := s.builder.artifacts.Load(ctx, *deploy.ArtifactID)
+		if err != nil {
+			return nil, fmt.Errorf("load artifact %s: %w", *deploy.ArtifactID, err)
+		}
+		fmt.Fprintf(logw, "reusing artifact %s (sha256 %s)\n", artifact.FileName, artifact.SHA256)
+		return &deployArtifact{File: file, SHA256: artifact.SHA256}, nil
+	}
+
+	artifact, file, err := s.builder.Build(ctx, deploy, app, logw)
+	if err != nil {
+		return nil, err
+	}
+	deploy.ArtifactID = &artifact.ID
+	deploy.CommitID = artifact.CommitID
+	if err := s.deployRepo.UpdateArtifact(deploy.ID, artifact.ID, artifact.CommitID); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return &deployArtifact{File: file, SHA256: artifact.SHA256}, nil
+}
+
+// skippedResults 为未执行的批次生成 skipped 结果
+func skippedResults(batches [][]model.Host) []model.DeployHostResult {
+	var results []model.DeployHostResult
+	for _, batch := range batches {
+		for _, host := range batch {
+			results = append(results, model.DeployHostResult{
+				HostID:    host.ID,
+				HostName:  host.Name,
+				HostIP:    host.IP,
+				Status:    "skipped",
+				StartTime: time.Now(),
+			})
+		}
+	}
+	return results
+}
+
+// waitBeforeBatch 在开始第 index 批之前等待：金丝雀首批之后按晋级方式等待，
+// 其余情况仅在收到暂停请求时暂停。等待期间部署被取消时返回 false
+func (s *DeploymentService) waitBeforeBatch(deploy *model.Deployment, app *model.Application, run *deployRun, index int) bool {
+	canaryGate := deploy.Strategy == "canary" && index == 1
+
+	if canaryGate && app.CanaryPromote == "timed" {
+		s.logHub.Append(deploy.ID, fmt.Sprintf("[deploy] canary batch finished, promoting in %ds", app.CanaryWait))
+		run.setWaiting(true)
+		select {
+		case <-time.After(time.Duration(app.CanaryWait) * time.Second):
+		case <-run.resume:
+		case <-run.ctx.Done():
+		}
+		run.setWaiting(false)
+		canaryGate = false
+	}
+
+	if run.cancelled() {
+		return false
+	}
+	if !canaryGate && !run.takePauseRequest() {
+		return true
+	}
+
+	if err := s.deployRepo.UpdateProgress(deploy.ID, index, true); err != nil {
+		log.Printf("Failed to update progress for deployment %s: %v", deploy.ID, err)
+	}
+	s.logHub.Append(deploy.ID, fmt.Sprintf("[deploy] paused after batch %d, waiting for resume", index))
+	run.setWaiting(true)
+	select {
+	case <-run.resume:
+	case <-run.ctx.Done():
+	}
+	run.setWaiting(false)
+	if run.cancelled() {
+		return false
+	}
+	s.logHub.Append(deploy.ID, "[deploy] resumed")
+	return true
+}
+
+// planBatches 按发布策略将主机划分为批次，主机按 IP 排序以保证批次稳定
+func planBatches(strategy string, app *model.Application, hosts []model.Host) [][]model.Host {
+	sorted := append([]model.Host(nil), hosts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].IP < sorted[j].IP })
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	switch strategy {
+	case "rolling":
+		size := positiveOr(app.BatchSize, 1)
+		var batches [][]model.Host
+		for start := 0; start < len(sorted); start += size {
+			end := start + size
+			if end > len(sorted) {
+				end = len(sorted)
+			}
+			batches = append(batches, sorted[start:end])
+		}
+		return batches
+	case "canary":
+		n := positiveOr(app.CanaryCount, 1)
+		if n >= len(sorted) {
+			return [][]model.Host{sorted}
+		}
+		return [][]model.Host{sorted[:n], sorted[n:]}
+	default:
+		return [][]model.Host{sorted}
+	}
+}
+
+func batchFailed(results []model.DeployHostResult) bool {
+	for _, r := range results {
+		if r.Status != "success" {
+			return true
+		}
+	}
+	return false
+}
+
+// saveHostResults 保存当前已完成主机的执行结果，供 API 展示部署进度
+func (s *DeploymentService) saveHostResults(deploy *model.Deployment, results []model.DeployHostResult) {
+	data, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Failed to encode host results for deployment %s: %v", deploy.ID, err)
+		return
+	}
+	if err := s.deployRepo.UpdateHostResults(deploy.ID, string(data)); err != nil {
+		log.Printf("Failed to save host results for deployment %s: %v", deploy.ID, err)
+	}
+}
+
+// finishWithResults 保存每台主机的执行结果，并以汇总输出结束部署，部署被取消时状态为 cancelled
+func (s *DeploymentService) finishWithResults(deploy *model.Deployment, run *deployRun, results []model.DeployHostResult, summary string) {
+	success := true
+	var output strings.Builder
+	output.WriteString(summary)
+	for _, r := range results {
+		if r.Status != "success" {
+			success = false
+		}
+		if r.Status == "skipped" {
+			fmt.Fprintf(&output, "==== %s (%s): skipped ====\n\n", r.HostName, r.HostIP)
+			continue
+		}
+		fmt.Fprintf(&output, "==== %s (%s): %s ====\n", r.HostName, r.HostIP, r.Status)
+		output.WriteString(r.Output)
+		if r.Error != "" {
+			fmt.Fprintf(&output, "ERROR: %s\n", r.Error)
+		}
+		output.WriteString("\n")
+	}
+
+	s.saveHostResults(deploy, results)
+
+	status := 3 // failed
+	if run.cancelled() {
+		status = 5 // cancelled
+	} else if success {
+		status = 2 // success
+	}
+	if err := s.finish(deploy.ID, status, output.String()); err != nil {
+		log.Printf("Failed to finish deployment %s: %v", deploy.ID, err)
+	}
+}
+
+// runOnHosts 在所有主机上并发执行同一组步骤，结果顺序与 hosts 一致。
+// 各主机输出以 "[IP] " 为前缀实时写入部署日志
+func (s *DeploymentService) runOnHosts(ctx context.Context, deploy *model.Deployment, hosts []model.Host, steps []deployStep) []model.DeployHostResult {
+	results := make([]model.DeployHostResult, len(hosts))
+	var wg sync.WaitGroup
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logw := s.logHub.writer(deploy.ID, "["+hosts[i].IP+"] ")
+			defer logw.Flush()
+			results[i] = runHostSteps(ctx, &hosts[i], steps, logw)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// runHostSteps 在单台主机上按顺序执行步骤，遇到非可选步骤失败即停止。
+// ctx 取消时中止正在执行的命令，结果状态为 cancelled
+func runHostSteps(ctx context.Context, host *model.Host, steps []deployStep, logw io.Writer) (result model.DeployHostResult) {
+	result = model.DeployHostResult{
+		HostID:    host.ID,
+		HostName:  host.Name,
+		HostIP:    host.IP,
+		StartTime: time.Now(),
+	}
+	defer func() {
+		now := time.Now()
+		result.EndTime = &now
+	}()
+
+	executor, err := newHostExecutor(host)
+	if err != nil {
+		fmt.Fprintf(logw, "[connect] %v\n", err)
+		result.Status = "failed"
+		result.Step = "connect"
+		result.Error = err.Error()
+		return result
+	}
+	defer executor.Close()
+
+	var out bytes.Buffer
+	w := io.MultiWriter(&out, logw)
+	for _, step := range steps {
+		if step.Command == "" && step.Upload == nil && step.UploadFile == "" && step.Health == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return cancelledResult(result, step, out.String())
+		}
+		fmt.Fprintf(w, "[%s] $ %s\n", step.Name, step.Display())
+
+		var stepErr error
+		var err error
+		switch {
+		case step.Health != nil:
+			err = step.Health.Wait(ctx, host, w)
+		case step.Upload != nil:
+			err = executor.Upload(ctx, step.Upload, step.UploadTo, step.uploadMode())
+		case step.UploadFile != "":
+			err = uploadFile(ctx, executor, step.UploadFile, step.UploadTo, step.uploadMode())
+		default:
+			err = executor.ExecuteWithOutput(ctx, step.Command, w)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Fprintf(w, "[%s] cancelled\n", step.Name)
+				return cancelledResult(result, step, out.String())
+			}
+			if code, ok := ssh.ExitStatus(err); ok {
+				stepErr = fmt.Errorf("exit code %d", code)
+			} else {
+				stepErr = err
+			}
+		}
+		if stepErr == nil {
+			continue
+		}
+		if step.Optional {
+			fmt.Fprintf(w, "[%s] ignored: %v\n", step.Name, stepErr)
+			continue
+		}
+		fmt.Fprintf(w, "[%s] failed: %v\n", step.Name, stepErr)
+
+		result.Status = "failed"
+		result.Step = step.Name
+		result.Error = fmt.Sprintf("%s failed: %v", step.Name, stepErr)
+		result.Output = out.String()
+		return result
+	}
+
+	result.Status = "success"
+	result.Output = out.String()
+	return result
+}
+
+func cancelledResult(result model.DeployHostResult, step deployStep, output string) model.DeployHostResult {
+	result.Status = "cancelled"
+	result.Step = step.Name
+	result.Error = fmt.Sprintf("%s cancelled", step.Name)
+	result.Output = output
+	return result
+}
+
+// buildDeploySteps 根据应用配置生成部署步骤，空命令会被跳过。
+// 新版本先同步到独立的发布目录并执行 deploy 阶段脚本，然后停止旧版本、切换 current 链接并启动，
+// after_deploy 脚本在健康检查通过后执行。回滚只切换到已有的发布目录并重启，不重新同步。
+func buildDeploySteps(deploy *model.Deployment, app *model.Application, phases map[string][]model.DeployScript, artifact *deployArtifact, cfg *deployConfig) []deployStep {
+	if deploy.Type == "rollback" {
+		return []deployStep{
+			{Name: "check_release", Command: checkReleaseCommand(app, deploy.Release)},
+			{Name: "stop", Command: inDeployPath(app, app.StopCmd), Optional: true},
+			{Name: "activate", Command: activateCommand(app, deploy.Release)},
+			{Name: "start", Command: inDeployPath(app, app.StartCmd)},
+			{Name: "health_check", Health: newHealthCheck(app)},
+		}
+	}
+
+	var steps []deployStep
+	if artifact != nil {
+		steps = artifactSteps(deploy, app, artifact)
+	} else {
+		steps = []deployStep{{Name: "sync", Command: syncCommand(deploy, app)}}
+	}
+	// 配置文件在发布目录就绪后写入，deploy 脚本和启动命令即可读取
+	steps = append(steps, configSteps(cfg)...)
+	steps = append(steps, scriptSteps(deploy, app, phases["deploy"])...)
+	steps = append(steps,
+		deployStep{Name: "stop", Command: inDeployPath(app, app.StopCmd), Optional: true},
+		deployStep{Name: "activate", Command: activateCommand(app, deploy.Release)},
+		deployStep{Name: "start", Command: inDeployPath(app, app.StartCmd)},
+		deployStep{Name: "health_check", Health: newHealthCheck(app)},
+	)
+	return append(steps, scriptSteps(deploy, app, phases["after_deploy"])...)
+}
+
+// groupScriptsByType 按执行阶段分组，组内保持 Sort 顺序
+func groupScriptsByType(scripts []model.DeployScript) map[string][]model.DeployScript {
+	phases := make(map[string][]model.DeployScript)
+	for _, script := range scripts {
+		phases[script.Type] = append(phases[script.Type], script)
+	}
+	return phases
+}
+
+// scriptSteps 将部署脚本转换为步骤。脚本通过 bash 执行，工作目录与启动命令相同，
+// 并可通过 DEPLOY_* 环境变量获取本次部署的信息（DEPLOY_RELEASE_DIR 为本次发布目录）
+func scriptSteps(deploy *model.Deployment, app *model.Application, scripts []model.DeployScript) []deployStep {
+	env := fmt.Sprintf("APP_CODE=%s DEPLOY_ID=%s DEPLOY_VERSION=%s DEPLOY_COMMIT=%s DEPLOY_BRANCH=%s DEPLOY_PATH=%s DEPLOY_RELEASE_DIR=%s",
+		shellQuote(app.Code), shellQuote(deploy.ID.String()), shellQuote(deploy.Version),
+		shellQuote(deploy.CommitID), shellQuote(deploy.Branch), shellQuote(app.DeployPath),
+		shellQuote(releaseDir(app, deploy.Release)))
+
+	prefix := ""
+	if app.DeployPath != "" {
+		prefix = fmt.Sprintf("test -d %[1]s && cd %[1]s && { cd current 2>/dev/null || true; }; ", shellQuote(app.DeployPath))
+	}
+
+	steps := make([]deployStep, 0, len(scripts))
+	for _, script := range scripts {
+		steps = append(steps, deployStep{
+			Name:    script.Type + ":" + script.Name,
+			Command: prefix + env + " bash -c " + shellQuote(script.Content),
+			Script:  true,
+		})
+	}
+	return steps
+}
+
+// inDeployPath 在当前发布目录（DeployPath/current，不存在时为 DeployPath）下执行命令
+func inDeployPath(app *model.Application, command string) string {
+	if command == "" || app.DeployPath == "" {
+		return command
+	}
+	return fmt.Sprintf("cd %s && { cd current 2>/dev/null || true; } && %s", shellQuote(app.DeployPath), command)
+}
+
+// newHostExecutor 根据主机的认证方式创建 SSH 执行器
+func newHostExecutor(host *model.Host) (*ssh.Executor, error) {
+	cfg := &ssh.Config{
+		Host:     host.IP,
+		Port:     host.Port,
+		Username: host.Username,
+	}
+	if host.AuthType == "key" {
+		cfg.PrivateKey = host.PrivateKey
+	} else {
+		cfg.Password = host.Password
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	return ssh.NewExecutor(cfg)
+}
+
+// shellQuote 将参数包裹为单引号字符串，避免被远端 shell 解释
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
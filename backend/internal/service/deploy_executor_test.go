@@ -0,0 +1,66 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"devops/internal/model"
+)
+
+func TestPlanBatches(t *testing.T) {
+	hosts := func(ips ...string) []model.Host {
+		out := make([]model.Host, len(ips))
+		for i, ip := range ips {
+			out[i] = model.Host{IP: ip}
+		}
+		return out
+	}
+	ips := func(batches [][]model.Host) [][]string {
+		var out [][]string
+		for _, batch := range batches {
+			var b []string
+			for _, h := range batch {
+				b = append(b, h.IP)
+			}
+			out = append(out, b)
+		}
+		return out
+	}
+	all := hosts("10.0.0.3", "10.0.0.1", "10.0.0.5", "10.0.0.2", "10.0.0.4")
+
+	tests := []struct {
+		name     string
+		strategy string
+		app      model.Application
+		hosts    []model.Host
+		want     [][]string
+	}{
+		{name: "no hosts", strategy: "rolling", app: model.Application{BatchSize: 2}, hosts: nil, want: nil},
+		{name: "all at once", strategy: "", hosts: all,
+			want: [][]string{{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}}},
+		{name: "rolling", strategy: "rolling", app: model.Application{BatchSize: 2}, hosts: all,
+			want: [][]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.3", "10.0.0.4"}, {"10.0.0.5"}}},
+		{name: "rolling default batch size", strategy: "rolling", hosts: hosts("10.0.0.2", "10.0.0.1"),
+			want: [][]string{{"10.0.0.1"}, {"10.0.0.2"}}},
+		{name: "rolling batch larger than hosts", strategy: "rolling", app: model.Application{BatchSize: 10}, hosts: hosts("10.0.0.2", "10.0.0.1"),
+			want: [][]string{{"10.0.0.1", "10.0.0.2"}}},
+		{name: "canary", strategy: "canary", app: model.Application{CanaryCount: 2}, hosts: all,
+			want: [][]string{{"10.0.0.1", "10.0.0.2"}, {"10.0.0.3", "10.0.0.4", "10.0.0.5"}}},
+		{name: "canary default count", strategy: "canary", hosts: hosts("10.0.0.2", "10.0.0.1"),
+			want: [][]string{{"10.0.0.1"}, {"10.0.0.2"}}},
+		{name: "canary covers all hosts", strategy: "canary", app: model.Application{CanaryCount: 5}, hosts: hosts("10.0.0.2", "10.0.0.1"),
+			want: [][]string{{"10.0.0.1", "10.0.0.2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append([]model.Host(nil), tt.hosts...)
+			got := ips(planBatches(tt.strategy, &tt.app, input))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planBatches = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(input, tt.hosts) {
+				t.Errorf("planBatches reordered its input: %v", input)
+			}
+		})
+	}
+}
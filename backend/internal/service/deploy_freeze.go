@@ -0,0 +1,297 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// FrozenError 部署落在环境的封版窗口内
+type FrozenError struct {
+	Window model.FreezeWindow
+	Start  time.Time
+	End    time.Time
+}
+
+func (e *FrozenError) Error() string {
+	return fmt.Sprintf("environment is frozen by %q until %s", e.Window.Name, e.End.Format(time.RFC3339))
+}
+
+func (e *FrozenError) Is(target error) bool {
+	return target == ErrDeployFrozen
+}
+
+// FreezeOverride 管理员在封版期内强制部署时提供的信息，Reason 会写入审计日志
+type FreezeOverride struct {
+	UserID   uuid.UUID
+	Username string
+	RoleCode string
+	Reason   string
+	IP       string
+}
+
+// FreezeOccurrence 封版窗口的一次具体生效时间段
+type FreezeOccurrence struct {
+	WindowID uuid.UUID `json:"window_id"`
+	EnvID    uuid.UUID `json:"env_id"`
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Active   bool      `json:"active"`
+}
+
+// freezeOccurrences 返回窗口在 [from, to) 内生效的时间段
+func freezeOccurrences(w *model.FreezeWindow, from, to time.Time) []FreezeOccurrence {
+	var spans [][2]time.Time
+
+	switch w.Type {
+	case "once":
+		if w.StartAt != nil && w.EndAt != nil && w.EndAt.After(from) && w.StartAt.Before(to) {
+			spans = append(spans, [2]time.Time{*w.StartAt, *w.EndAt})
+		}
+	case "weekly":
+		loc := time.Local
+		if w.Timezone != "" {
+			if l, err := time.LoadLocation(w.Timezone); err == nil {
+				loc = l
+			}
+		}
+		startMin, err1 := minuteOfDay(w.StartTime)
+		endMin, err2 := minuteOfDay(w.EndTime)
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		// 按日历日计算结束时间，跨夏令时切换时结束时刻仍为当地的 EndTime
+		days := w.EndDay - w.StartDay
+		if days < 0 || (days == 0 && endMin <= startMin) {
+			days += 7
+		}
+
+		// 从 from 前一周开始逐日查找，以包含 from 时已在生效的窗口
+		day := from.In(loc).AddDate(0, 0, -7)
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+		for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+			if int(day.Weekday()) != w.StartDay {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), startMin/60, startMin%60, 0, 0, loc)
+			end := time.Date(day.Year(), day.Month(), day.Day()+days, endMin/60, endMin%60, 0, 0, loc)
+			if end.After(from) && start.Before(to) {
+				spans = append(spans, [2]time.Time{start, end})
+			}
+		}
+	}
+
+	occurrences := make([]FreezeOccurrence, 0, len(spans))
+	for _, span := range spans {
+		occurrences = append(occurrences, FreezeOccurrence{
+			WindowID: w.ID,
+			EnvID:    w.EnvID,
+			Name:     w.Name,
+			Start:    span[0],
+			End:      span[1],
+			Active:   !span[0].After(from) && span[1].After(from),
+		})
+	}
+	return occurrences
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// checkFreeze 检查环境在 t 时刻是否处于封版窗口内。管理员提供原因时允许强制部署，
+// 返回值 overridden 为生效的窗口，调用方需记录审计日志
+func checkFreeze(freezeRepo *repository.FreezeWindowRepository, envID *uuid.UUID, t time.Time, override *FreezeOverride) (overridden *FrozenError, err error) {
+	if envID == nil {
+		return nil, nil
+	}
+	windows, err := freezeRepo.ListEnabledByEnv(*envID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range windows {
+		occurrences := freezeOccurrences(&windows[i], t, t.Add(time.Nanosecond))
+		if len(occurrences) == 0 {
+			continue
+		}
+		frozen := &FrozenError{Window: windows[i], Start: occurrences[0].Start, End: occurrences[0].End}
+		if override == nil || override.Reason == "" {
+			return nil, frozen
+		}
+		if override.RoleCode != "admin" {
+			return nil, ErrFreezeOverrideForbidden
+		}
+		return frozen, nil
+	}
+	return nil, nil
+}
+
+// auditFreezeOverride 记录管理员在封版期内强制部署的审计日志
+func auditFreezeOverride(auditRepo *repository.AuditRepository, deploy *model.Deployment, frozen *FrozenError, override *FreezeOverride) {
+	entry := &model.AuditLog{
+		UserID:       override.UserID,
+		Username:     override.Username,
+		Action:       "freeze_override",
+		Module:       "deploy",
+		Resource:     "deployment",
+		ResourceID:   deploy.ID.String(),
+		ResourceName: frozen.Window.Name,
+		NewValue:     fmt.Sprintf("%s ~ %s", frozen.Start.Format(time.RFC3339), frozen.End.Format(time.RFC3339)),
+		Detail:       override.Reason,
+		IP:           override.IP,
+		Status:       1,
+		CreatedAt:    time.Now(),
+	}
+	if err := auditRepo.Create(entry); err != nil {
+		log.Printf("Failed to write freeze override audit log for deployment %s: %v", deploy.ID, err)
+	}
+}
+
+// Freeze Window
+type FreezeWindowService struct {
+	freezeRepo *repository.FreezeWindowRepository
+	envRepo    *repository.EnvRepository
+}
+
+func NewFreezeWindowService(freezeRepo *repository.FreezeWindowRepository, envRepo *repository.EnvRepository) *FreezeWindowService {
+	return &FreezeWindowService{
+		freezeRepo: freezeRepo,
+		envRepo:    envRepo,
+	}
+}
+
+type FreezeWindowRequest struct {
+	Name        string     `json:"name" binding:"required"`
+	Type        string     `json:"type" binding:"required"`
+	StartAt     *time.Time `json:"start_at"`
+	EndAt       *time.Time `json:"end_at"`
+	StartDay    int        `json:"start_day"`
+	StartTime   string     `json:"start_time"`
+	EndDay      int        `json:"end_day"`
+	EndTime     string     `json:"end_time"`
+	Timezone    string     `json:"timezone"`
+	Enabled     *bool      `json:"enabled"`
+	Description string     `json:"description"`
+}
+
+func (req *FreezeWindowRequest) validate() error {
+	switch req.Type {
+	case "once":
+		if req.StartAt == nil || req.EndAt == nil || !req.EndAt.After(*req.StartAt) {
+			return ErrFreezeWindowInvalid
+		}
+	case "weekly":
+		if req.StartDay < 0 || req.StartDay > 6 || req.EndDay < 0 || req.EndDay > 6 {
+			return ErrFreezeWindowInvalid
+		}
+		if _, err := minuteOfDay(req.StartTime); err != nil {
+			return ErrFreezeWindowInvalid
+		}
+		if _, err := minuteOfDay(req.EndTime); err != nil {
+			return ErrFreezeWindowInvalid
+		}
+		if req.Timezone != "" {
+			if _, err := time.LoadLocation(req.Timezone); err != nil {
+				return ErrFreezeWindowInvalid
+			}
+		}
+	default:
+		return ErrFreezeWindowInvalid
+	}
+	return nil
+}
+
+func (req *FreezeWindowRequest) apply(w *model.FreezeWindow) {
+	w.Name = req.Name
+	w.Type = req.Type
+	w.StartAt = req.StartAt
+	w.EndAt = req.EndAt
+	w.StartDay = req.StartDay
+	w.StartTime = req.StartTime
+	w.EndDay = req.EndDay
+	w.EndTime = req.EndTime
+	w.Timezone = req.Timezone
+	w.Description = req.Description
+	if req.Enabled != nil {
+		w.Enabled = *req.Enabled
+	}
+}
+
+func (s *FreezeWindowService) List(envID uuid.UUID) ([]model.FreezeWindow, error) {
+	if _, err := s.envRepo.GetByID(envID); err != nil {
+		return nil, ErrEnvNotFound
+	}
+	return s.freezeRepo.ListByEnv(&envID)
+}
+
+func (s *FreezeWindowService) Create(envID uuid.UUID, req *FreezeWindowRequest, createdBy uuid.UUID) (*model.FreezeWindow, error) {
+	if _, err := s.envRepo.GetByID(envID); err != nil {
+		return nil, ErrEnvNotFound
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	window := &model.FreezeWindow{EnvID: envID, Enabled: true, CreatedBy: createdBy}
+	req.apply(window)
+	if err := s.freezeRepo.Create(window); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+func (s *FreezeWindowService) Update(envID, id uuid.UUID, req *FreezeWindowRequest) (*model.FreezeWindow, error) {
+	window, err := s.freezeRepo.GetByID(id)
+	if err != nil || window.EnvID != envID {
+		return nil, ErrFreezeWindowNotFound
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	req.apply(window)
+	if err := s.freezeRepo.Update(window); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+func (s *FreezeWindowService) Delete(envID, id uuid.UUID) error {
+	window, err := s.freezeRepo.GetByID(id)
+	if err != nil || window.EnvID != envID {
+		return ErrFreezeWindowNotFound
+	}
+	return s.freezeRepo.Delete(id)
+}
+
+// Upcoming 列出当前生效及未来 days 天内将生效的封版时间段，按开始时间排序。envID 为 nil 时包含所有环境
+func (s *FreezeWindowService) Upcoming(envID *uuid.UUID, days int) ([]FreezeOccurrence, error) {
+	windows, err := s.freezeRepo.ListByEnv(envID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	to := now.AddDate(0, 0, days)
+	occurrences := []FreezeOccurrence{}
+	for i := range windows {
+		if !windows[i].Enabled {
+			continue
+		}
+		occurrences = append(occurrences, freezeOccurrences(&windows[i], now, to)...)
+	}
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Start.Before(occurrences[j].Start) })
+	return occurrences, nil
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"devops/internal/model"
+)
+
+func TestFreezeOccurrences(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	utc := func(day, hour, minute int) time.Time {
+		// 2024-03-01 为周五
+		return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	weekly := func(startDay int, startTime string, endDay int, endTime string) *model.FreezeWindow {
+		return &model.FreezeWindow{Name: "weekly", Type: "weekly", StartDay: startDay, StartTime: startTime, EndDay: endDay, EndTime: endTime, Timezone: "UTC"}
+	}
+	once := func(start, end time.Time) *model.FreezeWindow {
+		return &model.FreezeWindow{Name: "once", Type: "once", StartAt: &start, EndAt: &end}
+	}
+	type span struct {
+		start, end time.Time
+		active     bool
+	}
+
+	// 周五 18:00 至下周一 09:00，跨过周日
+	weekend := weekly(5, "18:00", 1, "09:00")
+	// 周六 22:00 至周日 02:00，跨过周六到周日的午夜
+	saturdayNight := weekly(6, "22:00", 0, "02:00")
+	openEnded := once(utc(1, 0, 0), utc(1, 0, 0))
+	openEnded.EndAt = nil
+
+	tests := []struct {
+		name     string
+		window   *model.FreezeWindow
+		from, to time.Time
+		want     []span
+	}{
+		{
+			name:   "once inside range",
+			window: once(utc(2, 0, 0), utc(3, 0, 0)),
+			from:   utc(1, 0, 0),
+			to:     utc(5, 0, 0),
+			want:   []span{{utc(2, 0, 0), utc(3, 0, 0), false}},
+		},
+		{
+			name:   "once active at from",
+			window: once(utc(1, 0, 0), utc(3, 0, 0)),
+			from:   utc(2, 0, 0),
+			to:     utc(2, 0, 0).Add(time.Nanosecond),
+			want:   []span{{utc(1, 0, 0), utc(3, 0, 0), true}},
+		},
+		{
+			name:   "once ended at from",
+			window: once(utc(1, 0, 0), utc(2, 0, 0)),
+			from:   utc(2, 0, 0),
+			to:     utc(3, 0, 0),
+		},
+		{
+			name:   "once starts at to",
+			window: once(utc(3, 0, 0), utc(4, 0, 0)),
+			from:   utc(2, 0, 0),
+			to:     utc(3, 0, 0),
+		},
+		{
+			name:   "once without end",
+			window: openEnded,
+			from:   utc(1, 0, 0),
+			to:     utc(5, 0, 0),
+		},
+		{
+			name:   "weekly before the weekend",
+			window: weekend,
+			from:   utc(1, 17, 59),
+			to:     utc(1, 17, 59).Add(time.Nanosecond),
+		},
+		{
+			name:   "weekly at friday start",
+			window: weekend,
+			from:   utc(1, 18, 0),
+			to:     utc(1, 18, 0).Add(time.Nanosecond),
+			want:   []span{{utc(1, 18, 0), utc(4, 9, 0), true}},
+		},
+		{
+			name:   "weekly on sunday",
+			window: weekend,
+			from:   utc(3, 12, 0),
+			to:     utc(3, 12, 0).Add(time.Nanosecond),
+			want:   []span{{utc(1, 18, 0), utc(4, 9, 0), true}},
+		},
+		{
+			name:   "weekly monday before end",
+			window: weekend,
+			from:   utc(4, 8, 59),
+			to:     utc(4, 8, 59).Add(time.Nanosecond),
+			want:   []span{{utc(1, 18, 0), utc(4, 9, 0), true}},
+		},
+		{
+			name:   "weekly monday at end",
+			window: weekend,
+			from:   utc(4, 9, 0),
+			to:     utc(4, 9, 0).Add(time.Nanosecond),
+		},
+		{
+			name:   "weekly range lists every occurrence",
+			window: weekend,
+			from:   utc(3, 0, 0),
+			to:     utc(16, 0, 0),
+			want: []span{
+				{utc(1, 18, 0), utc(4, 9, 0), true},
+				{utc(8, 18, 0), utc(11, 9, 0), false},
+				{utc(15, 18, 0), utc(18, 9, 0), false},
+			},
+		},
+		{
+			name:   "weekly across saturday midnight",
+			window: saturdayNight,
+			from:   utc(3, 1, 0),
+			to:     utc(3, 1, 0).Add(time.Nanosecond),
+			want:   []span{{utc(2, 22, 0), utc(3, 2, 0), true}},
+		},
+		{
+			name:   "weekly after saturday midnight window",
+			window: saturdayNight,
+			from:   utc(3, 2, 0),
+			to:     utc(3, 2, 0).Add(time.Nanosecond),
+		},
+		{
+			name:   "weekly within one night",
+			window: weekly(2, "23:00", 3, "01:00"),
+			from:   utc(6, 0, 30),
+			to:     utc(6, 0, 30).Add(time.Nanosecond),
+			want:   []span{{utc(5, 23, 0), utc(6, 1, 0), true}},
+		},
+		{
+			name:   "weekly same day end before start wraps a week",
+			window: weekly(1, "10:00", 1, "09:00"),
+			from:   utc(10, 12, 0),
+			to:     utc(10, 12, 0).Add(time.Nanosecond),
+			want:   []span{{utc(4, 10, 0), utc(11, 9, 0), true}},
+		},
+		{
+			name:   "weekly same start and end covers the whole week",
+			window: weekly(1, "10:00", 1, "10:00"),
+			from:   utc(4, 9, 0),
+			to:     utc(4, 9, 0).Add(time.Nanosecond),
+			want:   []span{{time.Date(2024, 2, 26, 10, 0, 0, 0, time.UTC), utc(4, 10, 0), true}},
+		},
+		{
+			name: "weekly in the window time zone",
+			window: &model.FreezeWindow{Type: "weekly", StartDay: 6, StartTime: "00:00", EndDay: 0, EndTime: "00:00",
+				Timezone: "Asia/Shanghai"},
+			// 周五 16:30 UTC 为上海时间周六 00:30
+			from: utc(1, 16, 30),
+			to:   utc(1, 16, 30).Add(time.Nanosecond),
+			want: []span{{time.Date(2024, 3, 2, 0, 0, 0, 0, shanghai), time.Date(2024, 3, 3, 0, 0, 0, 0, shanghai), true}},
+		},
+		{
+			// 2024-03-10 美国开始夏令时，结束时刻仍为当地 09:00
+			name: "weekly across a daylight saving change",
+			window: &model.FreezeWindow{Type: "weekly", StartDay: 5, StartTime: "18:00", EndDay: 1, EndTime: "09:00",
+				Timezone: "America/New_York"},
+			from: time.Date(2024, 3, 11, 8, 30, 0, 0, newYork),
+			to:   time.Date(2024, 3, 11, 8, 30, 0, 0, newYork).Add(time.Nanosecond),
+			want: []span{{time.Date(2024, 3, 8, 18, 0, 0, 0, newYork), time.Date(2024, 3, 11, 9, 0, 0, 0, newYork), true}},
+		},
+		{
+			name:   "weekly invalid time",
+			window: weekly(1, "25:00", 2, "09:00"),
+			from:   utc(1, 0, 0),
+			to:     utc(30, 0, 0),
+		},
+		{
+			name:   "unknown type",
+			window: &model.FreezeWindow{Type: "daily"},
+			from:   utc(1, 0, 0),
+			to:     utc(30, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := freezeOccurrences(tt.window, tt.from, tt.to)
+			if len(got) != len(tt.want) {
+				t.Fatalf("freezeOccurrences = %+v, want %d occurrences", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !got[i].Start.Equal(want.start) || !got[i].End.Equal(want.end) || got[i].Active != want.active {
+					t.Errorf("occurrence %d = %v ~ %v active=%v, want %v ~ %v active=%v",
+						i, got[i].Start, got[i].End, got[i].Active, want.start, want.end, want.active)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+)
+
+// healthCheck 启动命令执行后由平台向主机发起的 HTTP 健康检查
+type healthCheck struct {
+	URL      string
+	Status   int // 期望的状态码，0 表示任意 2xx
+	Body     string
+	Retries  int
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// newHealthCheck 根据应用配置生成健康检查，未配置地址时返回 nil
+func newHealthCheck(app *model.Application) *healthCheck {
+	if app.HealthCheck == "" {
+		return nil
+	}
+	return &healthCheck{
+		URL:      app.HealthCheck,
+		Status:   app.HealthStatus,
+		Body:     app.HealthBody,
+		Retries:  positiveOr(app.HealthRetries, 5),
+		Interval: time.Duration(positiveOr(app.HealthInterval, 3)) * time.Second,
+		Timeout:  time.Duration(positiveOr(app.HealthTimeout, 10)) * time.Second,
+	}
+}
+
+// targetURL 返回针对 host 的检查地址。地址中的 localhost、127.0.0.1 和 0.0.0.0 替换为主机 IP，
+// 使同一个健康检查地址可以在平台上逐台检查
+func (hc *healthCheck) targetURL(host *model.Host) (string, error) {
+	raw := hc.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "0.0.0.0":
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(host.IP, port)
+		} else {
+			u.Host = host.IP
+		}
+	}
+	return u.String(), nil
+}
+
+// Wait 按配置的次数和间隔轮询检查地址，直到检查通过。所有尝试都失败时返回最后一次的错误
+func (hc *healthCheck) Wait(ctx context.Context, host *model.Host, w io.Writer) error {
+	target, err := hc.targetURL(host)
+	if err != nil {
+		return fmt.Errorf("invalid health check url: %w", err)
+	}
+
+	client := &http.Client{Timeout: hc.Timeout}
+	var lastErr error
+	for attempt := 1; attempt <= hc.Retries; attempt++ {
+		lastErr = hc.probe(ctx, client, target)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if lastErr == nil {
+			fmt.Fprintf(w, "[health_check] healthy (attempt %d/%d)\n", attempt, hc.Retries)
+			return nil
+		}
+		fmt.Fprintf(w, "[health_check] attempt %d/%d: %v\n", attempt, hc.Retries, lastErr)
+
+		if attempt < hc.Retries {
+			select {
+			case <-time.After(hc.Interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return fmt.Errorf("unhealthy after %d attempts: %w", hc.Retries, lastErr)
+}
+
+func (hc *healthCheck) probe(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if hc.Status != 0 && resp.StatusCode != hc.Status {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, hc.Status)
+	}
+	if hc.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if hc.Body == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(body, []byte(hc.Body)) {
+		return fmt.Errorf("response body does not contain %q", hc.Body)
+	}
+	return nil
+}
+
+// healthCheckFailed 是否有主机因健康检查未通过，或 Kubernetes 工作负载因滚动更新未完成而失败
+func healthCheckFailed(results []model.DeployHostResult) bool {
+	for _, r := range results {
+		if r.Status == "failed" && (r.Step == "health_check" || r.Step == "rollout") {
+			return true
+		}
+	}
+	return false
+}
+
+// autoRollback 应用开启自动回滚且部署因健康检查失败时，按 Rollback 的流程创建回滚到上一个成功发布的部署。
+// 返回写入部署输出的说明，没有可回滚的发布时不创建
+func (s *DeploymentService) autoRollback(deploy *model.Deployment, app *model.Application, run *deployRun, results []model.DeployHostResult) (*model.Deployment, string) {
+	if deploy.Type == "rollback" || !app.AutoRollback || run.cancelled() || !healthCheckFailed(results) {
+		return nil, ""
+	}
+
+	target, err := s.deployRepo.LatestRelease(deploy.AppID, deploy.EnvID)
+	if err != nil {
+		log.Printf("Failed to find rollback target for deployment %s: %v", deploy.ID, err)
+		return nil, "健康检查失败，查找可回滚的发布失败，未自动回滚\n\n"
+	}
+	if target == nil {
+		return nil, "健康检查失败，没有可回滚的成功发布，未自动回滚\n\n"
+	}
+
+	rollback, err := s.Rollback(deploy.AppID, target.ID, deploy.CreatedBy)
+	if err != nil {
+		log.Printf("Failed to create auto rollback for deployment %s: %v", deploy.ID, err)
+		return nil, fmt.Sprintf("健康检查失败，创建自动回滚失败: %v\n\n", err)
+	}
+	if rollback.Status == 6 {
+		return rollback, fmt.Sprintf("健康检查失败，已创建回滚到版本 %s 的部署 %s，等待审批\n\n", target.Version, rollback.ID)
+	}
+	return rollback, fmt.Sprintf("健康检查失败，已自动回滚到版本 %s（回滚部署 %s）\n\n", target.Version, rollback.ID)
+}
+
+// startAutoRollback 启动自动回滚部署。当前部署仍持有应用环境锁，回滚会先排队，
+// 在当前部署结束后执行；需要审批的回滚由审批通过后手动启动
+func (s *DeploymentService) startAutoRollback(deploy, rollback *model.Deployment) {
+	if rollback.Status != 0 {
+		return
+	}
+	if _, err := s.StartDeploy(rollback.ID, nil); err != nil {
+		log.Printf("Failed to start auto rollback %s for deployment %s: %v", rollback.ID, deploy.ID, err)
+	}
+}
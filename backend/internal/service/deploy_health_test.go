@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"devops/internal/model"
+)
+
+func TestHealthCheckTargetURL(t *testing.T) {
+	host := &model.Host{IP: "10.0.0.5"}
+	v6 := &model.Host{IP: "fd00::5"}
+
+	tests := []struct {
+		name    string
+		url     string
+		host    *model.Host
+		want    string
+		wantErr bool
+	}{
+		{name: "localhost with port", url: "http://localhost:8080/health", host: host, want: "http://10.0.0.5:8080/health"},
+		{name: "loopback without port", url: "http://127.0.0.1/health", host: host, want: "http://10.0.0.5/health"},
+		{name: "any address", url: "http://0.0.0.0:9000/ready?full=1", host: host, want: "http://10.0.0.5:9000/ready?full=1"},
+		{name: "no scheme", url: "localhost:8080/health", host: host, want: "http://10.0.0.5:8080/health"},
+		{name: "https kept", url: "https://localhost:8443/health", host: host, want: "https://10.0.0.5:8443/health"},
+		{name: "ipv6 host", url: "http://localhost:8080/health", host: v6, want: "http://[fd00::5]:8080/health"},
+		{name: "other host unchanged", url: "http://app.internal:8080/health", host: host, want: "http://app.internal:8080/health"},
+		{name: "lookalike host unchanged", url: "http://localhost.example.com/health", host: host, want: "http://localhost.example.com/health"},
+		{name: "invalid", url: "http://localhost:port/health", host: host, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &healthCheck{URL: tt.url}
+			got, err := hc.targetURL(tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("targetURL = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("targetURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckWait(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures 服务端在返回 status/body 之前先返回 503 的次数
+		failures     int32
+		status       int
+		body         string
+		wantStatus   int
+		wantBody     string
+		retries      int
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "healthy at once", status: 200, retries: 3, wantAttempts: 1},
+		{name: "healthy after retries", failures: 2, status: 200, retries: 3, wantAttempts: 3},
+		{name: "retries exhausted", failures: 5, status: 200, retries: 3, wantErr: true, wantAttempts: 3},
+		{name: "non 2xx", status: 302, retries: 2, wantErr: true, wantAttempts: 2},
+		{name: "expected status", status: 204, wantStatus: 204, retries: 2, wantAttempts: 1},
+		{name: "unexpected status", status: 200, wantStatus: 204, retries: 2, wantErr: true, wantAttempts: 2},
+		{name: "body matches", status: 200, body: `{"status":"UP"}`, wantBody: `"UP"`, retries: 2, wantAttempts: 1},
+		{name: "body mismatch", status: 200, body: `{"status":"DOWN"}`, wantBody: `"UP"`, retries: 2, wantErr: true, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					http.NotFound(w, r)
+					return
+				}
+				if atomic.AddInt32(&attempts, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			// 配置为 localhost，由 targetURL 替换为主机 IP 后访问测试服务
+			u, _ := url.Parse(srv.URL)
+			hc := &healthCheck{
+				URL:      "http://localhost:" + u.Port() + "/health",
+				Status:   tt.wantStatus,
+				Body:     tt.wantBody,
+				Retries:  tt.retries,
+				Interval: time.Millisecond,
+				Timeout:  time.Second,
+			}
+			var logs bytes.Buffer
+			err := hc.Wait(context.Background(), &model.Host{IP: "127.0.0.1"}, &logs)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Wait = %v, wantErr %v\n%s", err, tt.wantErr, logs.String())
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "unhealthy after") {
+				t.Errorf("err = %v, want the attempt count", err)
+			}
+		})
+	}
+}
+
+func TestHealthCheckWaitCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hc := &healthCheck{URL: srv.URL, Retries: 100, Interval: time.Hour, Timeout: time.Second}
+	start := time.Now()
+	if err := hc.Wait(ctx, &model.Host{IP: "127.0.0.1"}, io.Discard); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Wait returned after %v, want it to stop when the context ends", elapsed)
+	}
+}
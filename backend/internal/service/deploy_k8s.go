@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// workloadResources 可作为应用部署目标的工作负载类型
+var workloadResources = map[string]schema.GroupVersionResource{
+	"Deployment":  appsv1.SchemeGroupVersion.WithResource("deployments"),
+	"StatefulSet": appsv1.SchemeGroupVersion.WithResource("statefulsets"),
+	"DaemonSet":   appsv1.SchemeGroupVersion.WithResource("daemonsets"),
+}
+
+var podResource = corev1.SchemeGroupVersion.WithResource("pods")
+
+// k8sDeployFieldManager 部署只提交容器镜像字段，使用独立的 fieldManager 以免清除 YAML 编辑器管理的字段
+const k8sDeployFieldManager = "devops-deploy"
+
+const k8sRolloutPollInterval = 2 * time.Second
+
+// isK8sTarget 应用是否部署到 Kubernetes 工作负载
+func isK8sTarget(app *model.Application) bool {
+	return app.K8sClusterID != nil
+}
+
+// hasDeployTarget 应用是否关联了主机或 Kubernetes 工作负载
+func hasDeployTarget(app *model.Application) bool {
+	return isK8sTarget(app) || len(app.Hosts) > 0
+}
+
+// k8sImageTag 新部署使用的镜像 tag，未填写版本时使用提交 ID
+func k8sImageTag(deploy *model.Deployment) string {
+	if deploy.Version != "" {
+		return deploy.Version
+	}
+	return deploy.CommitID
+}
+
+// withImageTag 将镜像的 tag 替换为 tag，repo 不为空时同时替换镜像仓库
+func withImageTag(current, repo, tag string) string {
+	if repo == "" {
+		repo = current
+		if i := strings.Index(repo, "@"); i >= 0 {
+			repo = repo[:i]
+		}
+		if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+			repo = repo[:i]
+		}
+	}
+	return repo + ":" + tag
+}
+
+// deployToK8s 通过 server-side apply 更新工作负载容器的镜像 tag，并等待滚动更新完成，
+// 返回每个新 Pod 的执行结果和汇总说明
+func (s *DeploymentService) deployToK8s(run *deployRun, deploy *model.Deployment, app *model.Application) ([]model.DeployHostResult, string) {
+	logw := s.logHub.writer(deploy.ID, "[k8s] ")
+	defer logw.Flush()
+
+	start := time.Now()
+	if err := s.deployRepo.UpdateProgress(deploy.ID, 1, false); err != nil {
+		fmt.Fprintf(logw, "failed to update progress: %v\n", err)
+	}
+
+	if deploy.Release == "" {
+		return k8sFailed(run, app, start, "apply", errors.New("未指定镜像版本"), logw), ""
+	}
+	client, err := s.k8sService.getDynamicClientByClusterID(*app.K8sClusterID)
+	if err != nil {
+		return k8sFailed(run, app, start, "apply", err, logw), ""
+	}
+	apply := func(ctx context.Context, manifest string) error {
+		_, err := s.k8sService.applyYAML(ctx, *app.K8sClusterID, manifest, app.K8sNamespace, false, deploy.Type, k8sDeployFieldManager, deploy.CreatedBy, "")
+		return err
+	}
+	return rolloutImage(run, client, apply, deploy, app, start, k8sRolloutPollInterval, logw), ""
+}
+
+// k8sApplyFunc 以 server-side apply 提交清单
+type k8sApplyFunc func(ctx context.Context, manifest string) error
+
+// k8sFailed 以工作负载记录 step 失败的结果，部署已取消时记为 cancelled
+func k8sFailed(run *deployRun, app *model.Application, start time.Time, step string, err error, logw io.Writer) []model.DeployHostResult {
+	result := workloadResult(app, start)
+	result.Status = "failed"
+	result.Step = step
+	result.Error = err.Error()
+	if run.cancelled() {
+		result.Status = "cancelled"
+		result.Error = step + " cancelled"
+	}
+	fmt.Fprintf(logw, "[%s] %s\n", step, result.Error)
+	return []model.DeployHostResult{result}
+}
+
+// rolloutImage 将工作负载容器的镜像替换为 deploy.Release 并通过 apply 提交，然后每隔 interval
+// 检查一次滚动更新状态直到完成、失败或超时，返回工作负载和新 Pod 的执行结果
+func rolloutImage(run *deployRun, client dynamic.Interface, apply k8sApplyFunc, deploy *model.Deployment, app *model.Application, start time.Time, interval time.Duration, logw io.Writer) []model.DeployHostResult {
+	gvr := workloadResources[app.K8sKind]
+	workloads := client.Resource(gvr).Namespace(app.K8sNamespace)
+	obj, err := workloads.Get(run.ctx, app.K8sWorkload, metav1.GetOptions{})
+	if err != nil {
+		return k8sFailed(run, app, start, "apply", fmt.Errorf("get %s/%s: %w", app.K8sKind, app.K8sWorkload, err), logw)
+	}
+	container, current, err := workloadContainer(obj, app.K8sContainer)
+	if err != nil {
+		return k8sFailed(run, app, start, "apply", err, logw)
+	}
+	image := withImageTag(current, app.K8sImage, deploy.Release)
+
+	patch, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": gvr.GroupVersion().String(),
+		"kind":       app.K8sKind,
+		"metadata": map[string]interface{}{
+			"name":      app.K8sWorkload,
+			"namespace": app.K8sNamespace,
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": container, "image": image},
+					},
+				},
+			},
+		},
+	})
+	fmt.Fprintf(logw, "[apply] %s/%s container %s: %s -> %s\n", app.K8sKind, app.K8sWorkload, container, current, image)
+	if err := apply(run.ctx, string(patch)); err != nil {
+		return k8sFailed(run, app, start, "apply", err, logw)
+	}
+
+	timeout := time.Duration(positiveOr(app.K8sTimeout, 300)) * time.Second
+	obj, rolloutErr := waitRollout(run.ctx, workloads, app.K8sKind, app.K8sWorkload, timeout, interval, logw)
+	if rolloutErr != nil && obj == nil {
+		return k8sFailed(run, app, start, "rollout", rolloutErr, logw)
+	}
+
+	// 滚动更新结束或超时后，按 Pod 记录新版本的运行情况
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results, err := podResults(ctx, client, obj, container, image, start)
+	if err != nil {
+		fmt.Fprintf(logw, "[rollout] list pods: %v\n", err)
+	}
+	if rolloutErr != nil {
+		result := workloadResult(app, start)
+		result.Status = "failed"
+		result.Step = "rollout"
+		result.Error = rolloutErr.Error()
+		if run.cancelled() {
+			result.Status = "cancelled"
+			result.Error = "rollout cancelled"
+		}
+		results = append([]model.DeployHostResult{result}, results...)
+	} else if len(results) == 0 {
+		result := workloadResult(app, start)
+		result.Status = "success"
+		result.Output = "successfully rolled out, no pods running the new image\n"
+		results = append(results, result)
+	}
+	return results
+}
+
+// workloadResult 以工作负载本身作为一条执行结果
+func workloadResult(app *model.Application, start time.Time) model.DeployHostResult {
+	now := time.Now()
+	return model.DeployHostResult{
+		HostName:  app.K8sKind + "/" + app.K8sWorkload,
+		HostIP:    app.K8sNamespace,
+		StartTime: start,
+		EndTime:   &now,
+	}
+}
+
+// workloadContainer 返回需要更新镜像的容器名和当前镜像，name 为空时取第一个容器
+func workloadContainer(obj *unstructured.Unstructured, name string) (string, string, error) {
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cname, _, _ := unstructured.NestedString(container, "name")
+		image, _, _ := unstructured.NestedString(container, "image")
+		if name == "" || cname == name {
+			return cname, image, nil
+		}
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("%s/%s has no containers", obj.GetKind(), obj.GetName())
+	}
+	return "", "", fmt.Errorf("container %s not found in %s/%s", name, obj.GetKind(), obj.GetName())
+}
+
+// waitRollout 轮询工作负载直到滚动更新完成、失败或超时，进度变化时写入 logw。
+// 返回最后一次获取到的工作负载，获取失败时为 nil
+func waitRollout(ctx context.Context, workloads dynamic.ResourceInterface, kind, name string, timeout, interval time.Duration, logw io.Writer) (*unstructured.Unstructured, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last *unstructured.Unstructured
+	lastMsg := ""
+	for {
+		obj, err := workloads.Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			last = obj
+			done, msg, err := rolloutStatus(kind, obj)
+			if msg != lastMsg {
+				fmt.Fprintf(logw, "[rollout] %s\n", msg)
+				lastMsg = msg
+			}
+			if err != nil || done {
+				return last, err
+			}
+		} else if ctx.Err() == nil {
+			fmt.Fprintf(logw, "[rollout] get %s/%s: %v\n", kind, name, err)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return last, fmt.Errorf("rollout did not complete within %s: %s", timeout, lastMsg)
+			}
+			return last, ctx.Err()
+		}
+	}
+}
+
+// rolloutStatus 判断滚动更新是否完成，判断条件与 kubectl rollout status 一致
+func rolloutStatus(kind string, obj *unstructured.Unstructured) (bool, string, error) {
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observed < obj.GetGeneration() {
+		return false, "waiting for the new spec to be observed", nil
+	}
+
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	status := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+		return v
+	}
+
+	switch kind {
+	case "Deployment":
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, c := range conditions {
+			cond, _ := c.(map[string]interface{})
+			if cond["type"] == "Progressing" && cond["reason"] == "ProgressDeadlineExceeded" {
+				return false, "progress deadline exceeded", fmt.Errorf("deployment %s exceeded its progress deadline", obj.GetName())
+			}
+		}
+		updated, total, available := status("updatedReplicas"), status("replicas"), status("availableReplicas")
+		switch {
+		case updated < replicas:
+			return false, fmt.Sprintf("%d out of %d new replicas have been updated", updated, replicas), nil
+		case total > updated:
+			return false, fmt.Sprintf("%d old replicas are pending termination", total-updated), nil
+		case available < updated:
+			return false, fmt.Sprintf("%d of %d updated replicas are available", available, updated), nil
+		}
+	case "StatefulSet":
+		ready := status("readyReplicas")
+		current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		switch {
+		case ready < replicas:
+			return false, fmt.Sprintf("%d of %d pods are ready", ready, replicas), nil
+		case status("updatedReplicas") < replicas || current != update:
+			return false, fmt.Sprintf("%d out of %d pods have been updated to revision %s", status("updatedReplicas"), replicas, update), nil
+		}
+	case "DaemonSet":
+		desired := status("desiredNumberScheduled")
+		updated, available := status("updatedNumberScheduled"), status("numberAvailable")
+		switch {
+		case updated < desired:
+			return false, fmt.Sprintf("%d out of %d new pods have been updated", updated, desired), nil
+		case available < desired:
+			return false, fmt.Sprintf("%d of %d updated pods are available", available, desired), nil
+		}
+	}
+	return true, "successfully rolled out", nil
+}
+
+// podResults 列出工作负载中运行新镜像的 Pod，就绪的 Pod 为 success，否则为 failed 并记录原因
+func podResults(ctx context.Context, client dynamic.Interface, obj *unstructured.Unstructured, container, image string, start time.Time) ([]model.DeployHostResult, error) {
+	selectorMap, _, _ := unstructured.NestedMap(obj.Object, "spec", "selector")
+	var ls metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selectorMap, &ls); err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&ls)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.Resource(podResource).Namespace(obj.GetNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []model.DeployHostResult
+	for i := range list.Items {
+		var pod corev1.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &pod); err != nil {
+			return nil, err
+		}
+		if pod.DeletionTimestamp != nil || !podRunsImage(&pod, container, image) {
+			continue
+		}
+		results = append(results, podResult(&pod, container, start))
+	}
+	return results, nil
+}
+
+func podRunsImage(pod *corev1.Pod, container, image string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return c.Image == image
+		}
+	}
+	return false
+}
+
+func podResult(pod *corev1.Pod, container string, start time.Time) model.DeployHostResult {
+	now := time.Now()
+	result := model.DeployHostResult{
+		HostName:  pod.Name,
+		HostIP:    pod.Status.PodIP,
+		StartTime: start,
+		EndTime:   &now,
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "node: %s\nphase: %s\n", pod.Spec.NodeName, pod.Status.Phase)
+	reason := ""
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != container {
+			continue
+		}
+		fmt.Fprintf(&out, "image: %s\nready: %t\nrestarts: %d\n", cs.Image, cs.Ready, cs.RestartCount)
+		switch {
+		case cs.State.Waiting != nil:
+			reason = strings.TrimSpace(cs.State.Waiting.Reason + " " + cs.State.Waiting.Message)
+		case cs.State.Terminated != nil:
+			reason = strings.TrimSpace(cs.State.Terminated.Reason + " " + cs.State.Terminated.Message)
+		}
+	}
+	result.Output = out.String()
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+			result.Status = "success"
+			return result
+		}
+	}
+	if reason == "" {
+		reason = "pod is not ready"
+	}
+	result.Status = "failed"
+	result.Step = "rollout"
+	result.Error = reason
+	return result
+}
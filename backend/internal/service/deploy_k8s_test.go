@@ -0,0 +1,371 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var deploymentResource = workloadResources["Deployment"]
+
+func testDeployment(image string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":       "web",
+			"namespace":  "prod",
+			"generation": int64(1),
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"selector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{"app": "web"},
+			},
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "web", "image": image},
+						map[string]interface{}{"name": "proxy", "image": "envoy:1.28"},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"observedGeneration": int64(1),
+			"replicas":           int64(2),
+			"updatedReplicas":    int64(2),
+			"availableReplicas":  int64(2),
+		},
+	}}
+}
+
+func testPod(name, image string, labels map[string]interface{}, ready bool, waiting string) *unstructured.Unstructured {
+	containerState := map[string]interface{}{"running": map[string]interface{}{}}
+	if waiting != "" {
+		containerState = map[string]interface{}{"waiting": map[string]interface{}{"reason": waiting}}
+	}
+	readyStatus := "False"
+	if ready {
+		readyStatus = "True"
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "prod",
+			"labels":    labels,
+		},
+		"spec": map[string]interface{}{
+			"nodeName":   "node-1",
+			"containers": []interface{}{map[string]interface{}{"name": "web", "image": image}},
+		},
+		"status": map[string]interface{}{
+			"phase": "Running",
+			"podIP": "10.1.0.1",
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": readyStatus},
+			},
+			"containerStatuses": []interface{}{
+				map[string]interface{}{"name": "web", "image": image, "ready": ready, "restartCount": int64(0), "state": containerState},
+			},
+		},
+	}}
+}
+
+// rolloutController 模拟 Deployment 控制器：apply 后的前 pending 次 Get 返回滚动更新中的状态，
+// 之后返回 final 设置的状态
+type rolloutController struct {
+	mu      sync.Mutex
+	applied bool
+	gets    int
+	pending int
+	final   func(obj *unstructured.Unstructured)
+}
+
+func (c *rolloutController) react(client *dynamicfake.FakeDynamicClient) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.applied {
+			return false, nil, nil
+		}
+		obj, err := client.Tracker().Get(deploymentResource, "prod", "web")
+		if err != nil {
+			return true, nil, err
+		}
+		u := obj.(*unstructured.Unstructured).DeepCopy()
+		c.gets++
+		if c.gets <= c.pending {
+			unstructured.SetNestedField(u.Object, int64(2), "metadata", "generation")
+			unstructured.SetNestedField(u.Object, int64(2), "status", "observedGeneration")
+			unstructured.SetNestedField(u.Object, int64(1), "status", "updatedReplicas")
+			unstructured.SetNestedField(u.Object, int64(3), "status", "replicas")
+			return true, u, nil
+		}
+		c.final(u)
+		return true, u, nil
+	}
+}
+
+// applyReactor 处理 Deployment 的 server-side apply。client-go v0.29 的 fake 无法对 Unstructured
+// 做策略合并，这里按 apps/v1 Deployment 的合并规则合并（容器按 name 合并），与 API Server 的行为一致
+func applyReactor(client *dynamicfake.FakeDynamicClient) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj, err := client.Tracker().Get(deploymentResource, patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		current, err := json.Marshal(obj)
+		if err != nil {
+			return true, nil, err
+		}
+		merged, err := strategicpatch.StrategicMergePatch(current, patch.GetPatch(), &appsv1.Deployment{})
+		if err != nil {
+			return true, nil, err
+		}
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(merged); err != nil {
+			return true, nil, err
+		}
+		return true, u, client.Tracker().Update(deploymentResource, u, patch.GetNamespace())
+	}
+}
+
+func TestRolloutImage(t *testing.T) {
+	labels := map[string]interface{}{"app": "web"}
+	rolledOut := func(u *unstructured.Unstructured) {}
+
+	tests := []struct {
+		name      string
+		container string
+		timeout   int
+		pending   int
+		final     func(u *unstructured.Unstructured)
+		applyErr  error
+		cancel    bool
+		// wantImage 为 apply 后工作负载中 web 容器的镜像，为空表示不应提交
+		wantImage string
+		want      []model.DeployHostResult // 只比较 HostName、Status、Step
+		wantError string
+	}{
+		{
+			name:      "rolled out",
+			pending:   2,
+			final:     rolledOut,
+			wantImage: "registry.local/web:v2",
+			want: []model.DeployHostResult{
+				{HostName: "web-new-a", Status: "success"},
+				{HostName: "web-new-b", Status: "failed", Step: "rollout"},
+			},
+		},
+		{
+			name:      "named container",
+			container: "web",
+			final:     rolledOut,
+			wantImage: "registry.local/web:v2",
+			want: []model.DeployHostResult{
+				{HostName: "web-new-a", Status: "success"},
+				{HostName: "web-new-b", Status: "failed", Step: "rollout"},
+			},
+		},
+		{
+			name: "progress deadline exceeded",
+			final: func(u *unstructured.Unstructured) {
+				unstructured.SetNestedSlice(u.Object, []interface{}{
+					map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+				}, "status", "conditions")
+			},
+			wantImage: "registry.local/web:v2",
+			want: []model.DeployHostResult{
+				{HostName: "Deployment/web", Status: "failed", Step: "rollout"},
+				{HostName: "web-new-a", Status: "success"},
+				{HostName: "web-new-b", Status: "failed", Step: "rollout"},
+			},
+			wantError: "progress deadline",
+		},
+		{
+			name:      "rollout timeout",
+			timeout:   1,
+			pending:   1 << 30,
+			wantImage: "registry.local/web:v2",
+			want: []model.DeployHostResult{
+				{HostName: "Deployment/web", Status: "failed", Step: "rollout"},
+				{HostName: "web-new-a", Status: "success"},
+				{HostName: "web-new-b", Status: "failed", Step: "rollout"},
+			},
+			wantError: "did not complete within 1s",
+		},
+		{
+			name:      "missing container",
+			container: "worker",
+			want:      []model.DeployHostResult{{HostName: "Deployment/web", Status: "failed", Step: "apply"}},
+			wantError: "container worker not found",
+		},
+		{
+			name:      "apply rejected",
+			applyErr:  errors.New("admission webhook denied the request"),
+			want:      []model.DeployHostResult{{HostName: "Deployment/web", Status: "failed", Step: "apply"}},
+			wantError: "admission webhook",
+		},
+		{
+			name:      "cancelled",
+			pending:   1 << 30,
+			cancel:    true,
+			wantImage: "registry.local/web:v2",
+			want: []model.DeployHostResult{
+				{HostName: "Deployment/web", Status: "cancelled", Step: "rollout"},
+				{HostName: "web-new-a", Status: "success"},
+				{HostName: "web-new-b", Status: "failed", Step: "rollout"},
+			},
+			wantError: "rollout cancelled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			appsv1.AddToScheme(scheme)
+			corev1.AddToScheme(scheme)
+			client := dynamicfake.NewSimpleDynamicClient(scheme,
+				testDeployment("registry.local/web:v1"),
+				testPod("web-new-a", "registry.local/web:v2", labels, true, ""),
+				testPod("web-new-b", "registry.local/web:v2", labels, false, "CrashLoopBackOff"),
+				testPod("web-old", "registry.local/web:v1", labels, true, ""),
+				testPod("other-app", "registry.local/web:v2", map[string]interface{}{"app": "other"}, true, ""),
+			)
+			ctrl := &rolloutController{pending: tt.pending, final: tt.final}
+			client.PrependReactor("get", "deployments", ctrl.react(client))
+			client.PrependReactor("patch", "deployments", applyReactor(client))
+
+			run := newDeployRun()
+			defer run.cancel()
+			apply := func(ctx context.Context, manifest string) error {
+				if tt.applyErr != nil {
+					return tt.applyErr
+				}
+				// 与 applyYAML 一样以 server-side apply 提交
+				force := true
+				_, err := client.Resource(deploymentResource).Namespace("prod").Patch(ctx, "web", types.ApplyPatchType, []byte(manifest),
+					metav1.PatchOptions{FieldManager: k8sDeployFieldManager, Force: &force})
+				ctrl.mu.Lock()
+				ctrl.applied = true
+				ctrl.mu.Unlock()
+				if tt.cancel {
+					time.AfterFunc(20*time.Millisecond, run.cancel)
+				}
+				return err
+			}
+
+			app := &model.Application{
+				K8sKind:      "Deployment",
+				K8sNamespace: "prod",
+				K8sWorkload:  "web",
+				K8sContainer: tt.container,
+				K8sTimeout:   tt.timeout,
+			}
+			deploy := &model.Deployment{ID: uuid.New(), Release: "v2"}
+			var logs bytes.Buffer
+			results := rolloutImage(run, client, apply, deploy, app, time.Now(), 10*time.Millisecond, &logs)
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results %+v, want %d\n%s", len(results), results, len(tt.want), logs.String())
+			}
+			for i, want := range tt.want {
+				got := results[i]
+				if got.HostName != want.HostName || got.Status != want.Status || got.Step != want.Step {
+					t.Errorf("result %d = %s/%s/%s, want %s/%s/%s", i, got.HostName, got.Status, got.Step, want.HostName, want.Status, want.Step)
+				}
+			}
+			if tt.wantError != "" && !strings.Contains(results[0].Error, tt.wantError) {
+				t.Errorf("error = %q, want it to contain %q", results[0].Error, tt.wantError)
+			}
+
+			obj, err := client.Tracker().Get(deploymentResource, "prod", "web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			image := "registry.local/web:v1"
+			if tt.wantImage != "" {
+				image = tt.wantImage
+			}
+			if _, got, _ := workloadContainer(obj.(*unstructured.Unstructured), "web"); got != image {
+				t.Errorf("web image = %q, want %q", got, image)
+			}
+			// 只提交目标容器的镜像，其他容器保持不变
+			if _, got, _ := workloadContainer(obj.(*unstructured.Unstructured), "proxy"); got != "envoy:1.28" {
+				t.Errorf("proxy image = %q, want envoy:1.28", got)
+			}
+		})
+	}
+}
+
+func TestRolloutStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		gen      int64
+		spec     map[string]interface{}
+		status   map[string]interface{}
+		wantDone bool
+		wantErr  bool
+	}{
+		{name: "new spec not observed", kind: "Deployment", gen: 2, status: map[string]interface{}{"observedGeneration": int64(1)}},
+		{name: "deployment updating", kind: "Deployment", spec: map[string]interface{}{"replicas": int64(3)},
+			status: map[string]interface{}{"updatedReplicas": int64(1), "replicas": int64(3), "availableReplicas": int64(3)}},
+		{name: "deployment old replicas terminating", kind: "Deployment", spec: map[string]interface{}{"replicas": int64(2)},
+			status: map[string]interface{}{"updatedReplicas": int64(2), "replicas": int64(3), "availableReplicas": int64(2)}},
+		{name: "deployment unavailable", kind: "Deployment", spec: map[string]interface{}{"replicas": int64(2)},
+			status: map[string]interface{}{"updatedReplicas": int64(2), "replicas": int64(2), "availableReplicas": int64(1)}},
+		{name: "deployment done", kind: "Deployment", spec: map[string]interface{}{"replicas": int64(2)},
+			status: map[string]interface{}{"updatedReplicas": int64(2), "replicas": int64(2), "availableReplicas": int64(2)}, wantDone: true},
+		{name: "deployment default replicas", kind: "Deployment",
+			status: map[string]interface{}{"updatedReplicas": int64(1), "replicas": int64(1), "availableReplicas": int64(1)}, wantDone: true},
+		{name: "deployment deadline", kind: "Deployment", status: map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Progressing", "reason": "ProgressDeadlineExceeded"}}}, wantErr: true},
+		{name: "statefulset revision pending", kind: "StatefulSet", spec: map[string]interface{}{"replicas": int64(2)},
+			status: map[string]interface{}{"readyReplicas": int64(2), "updatedReplicas": int64(2), "currentRevision": "web-1", "updateRevision": "web-2"}},
+		{name: "statefulset done", kind: "StatefulSet", spec: map[string]interface{}{"replicas": int64(2)},
+			status: map[string]interface{}{"readyReplicas": int64(2), "updatedReplicas": int64(2), "currentRevision": "web-2", "updateRevision": "web-2"}, wantDone: true},
+		{name: "daemonset updating", kind: "DaemonSet",
+			status: map[string]interface{}{"desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(2), "numberAvailable": int64(3)}},
+		{name: "daemonset done", kind: "DaemonSet",
+			status: map[string]interface{}{"desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(3)}, wantDone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{"status": tt.status}}
+			if tt.spec != nil {
+				obj.Object["spec"] = tt.spec
+			}
+			obj.SetName("web")
+			obj.SetGeneration(tt.gen)
+			done, msg, err := rolloutStatus(tt.kind, obj)
+			if done != tt.wantDone || (err != nil) != tt.wantErr {
+				t.Errorf("rolloutStatus = %v, %q, %v; want done %v, err %v", done, msg, err, tt.wantDone, tt.wantErr)
+			}
+		})
+	}
+}
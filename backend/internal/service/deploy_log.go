@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// deployLogSubscriberBuffer 每个订阅者的缓冲行数，消费过慢的订阅者会被断开
+	deployLogSubscriberBuffer = 512
+	// deployLogRetention 部署结束后内存日志的保留时间，之后从 Deployment.Output 回放
+	deployLogRetention = time.Minute
+	// deployLogMaxLines 每个部署在内存中保留的最大行数，超出后丢弃最早的日志
+	deployLogMaxLines = 10000
+)
+
+// DeployLogHub 缓存运行中部署的实时日志，并分发给订阅者
+type DeployLogHub struct {
+	mu   sync.Mutex
+	logs map[uuid.UUID]*deployLog
+}
+
+type deployLog struct {
+	lines   []string // 环形缓冲，写满后 next 为最早一行的位置
+	next    int
+	dropped int
+	subs    map[chan string]struct{}
+	started bool
+	closed  bool
+}
+
+func NewDeployLogHub() *DeployLogHub {
+	return &DeployLogHub{logs: make(map[uuid.UUID]*deployLog)}
+}
+
+// Open 标记部署开始产生日志，已存在的订阅者会继续接收。
+// 部署重试时上一次执行的日志已关闭，此时重新开始记录
+func (h *DeployLogHub) Open(id uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if l, ok := h.logs[id]; ok && l.closed {
+		delete(h.logs, id)
+	}
+	l := h.getOrCreate(id)
+	l.started = true
+}
+
+// Append 追加一行日志并推送给所有订阅者
+func (h *DeployLogHub) Append(id uuid.UUID, line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.logs[id]
+	if !ok || l.closed {
+		return
+	}
+	l.append(line)
+	for ch := range l.subs {
+		select {
+		case ch <- line:
+		default:
+			// 订阅者消费过慢，断开连接，客户端可重新订阅获取回放
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (l *deployLog) append(line string) {
+	if len(l.lines) < deployLogMaxLines {
+		l.lines = append(l.lines, line)
+		return
+	}
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	l.dropped++
+}
+
+// snapshot 按时间顺序返回缓冲的日志，有日志被丢弃时第一行为提示
+func (l *deployLog) snapshot() []string {
+	out := make([]string, 0, len(l.lines)+1)
+	if l.dropped > 0 {
+		out = append(out, fmt.Sprintf("[deploy] %d earlier line(s) omitted", l.dropped))
+	}
+	out = append(out, l.lines[l.next:]...)
+	return append(out, l.lines[:l.next]...)
+}
+
+// writer 返回按行写入日志的 Writer，每行以 prefix 开头，结束时需调用 Flush
+func (h *DeployLogHub) writer(id uuid.UUID, prefix string) *deployLogWriter {
+	return &deployLogWriter{hub: h, id: id, prefix: prefix}
+}
+
+// Subscribe 订阅部署日志，返回已缓冲的日志用于回放，以及后续日志的通道。
+// 部署结束时通道会被关闭；ok 为 false 表示该部署没有可订阅的实时日志。
+func (h *DeployLogHub) Subscribe(id uuid.UUID, create bool) (replay []string, ch <-chan string, cancel func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, exists := h.logs[id]
+	if !exists {
+		if !create {
+			return nil, nil, nil, false
+		}
+		l = h.getOrCreate(id)
+	}
+	if l.closed {
+		return nil, nil, nil, false
+	}
+
+	sub := make(chan string, deployLogSubscriberBuffer)
+	l.subs[sub] = struct{}{}
+	replay = l.snapshot()
+
+	cancel = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := l.subs[sub]; ok {
+			delete(l.subs, sub)
+			close(sub)
+		}
+		// 部署尚未开始且无人订阅时不再保留
+		if !l.started && len(l.subs) == 0 && h.logs[id] == l {
+			delete(h.logs, id)
+		}
+	}
+
+	return replay, sub, cancel, true
+}
+
+// Close 结束部署日志，关闭所有订阅者通道，并在保留期后释放内存
+func (h *DeployLogHub) Close(id uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.logs[id]
+	if !ok || l.closed {
+		return
+	}
+	l.closed = true
+	for ch := range l.subs {
+		close(ch)
+	}
+	l.subs = nil
+
+	time.AfterFunc(deployLogRetention, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.logs[id] == l {
+			delete(h.logs, id)
+		}
+	})
+}
+
+func (h *DeployLogHub) getOrCreate(id uuid.UUID) *deployLog {
+	l, ok := h.logs[id]
+	if !ok {
+		l = &deployLog{subs: make(map[chan string]struct{})}
+		h.logs[id] = l
+	}
+	return l
+}
+
+// deployLogWriter 将写入内容按行切分，不完整的行会缓存到下一次写入
+type deployLogWriter struct {
+	mu      sync.Mutex
+	hub     *DeployLogHub
+	id      uuid.UUID
+	prefix  string
+	partial []byte
+}
+
+func (w *deployLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		w.hub.Append(w.id, w.prefix+string(bytes.TrimRight(data[:idx], "\r")))
+		data = data[idx+1:]
+	}
+	w.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Flush 输出缓存中不以换行结尾的最后一行
+func (w *deployLogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.hub.Append(w.id, w.prefix+string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeployLogHubKeepsBoundedReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines int
+		want  []string
+	}{
+		{name: "below limit", lines: 3, want: []string{"line 0", "line 1", "line 2"}},
+		{name: "at limit", lines: deployLogMaxLines},
+		{name: "over limit", lines: deployLogMaxLines + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewDeployLogHub()
+			id := uuid.New()
+			hub.Open(id)
+			for i := 0; i < tt.lines; i++ {
+				hub.Append(id, fmt.Sprintf("line %d", i))
+			}
+			replay, _, cancel, ok := hub.Subscribe(id, false)
+			if !ok {
+				t.Fatal("log is not live")
+			}
+			defer cancel()
+
+			if tt.want != nil {
+				if !reflect.DeepEqual(replay, tt.want) {
+					t.Errorf("replay = %v, want %v", replay, tt.want)
+				}
+				return
+			}
+			dropped := tt.lines - deployLogMaxLines
+			wantLen := deployLogMaxLines
+			if dropped > 0 {
+				wantLen++
+				if want := fmt.Sprintf("[deploy] %d earlier line(s) omitted", dropped); replay[0] != want {
+					t.Errorf("first line = %q, want %q", replay[0], want)
+				}
+			}
+			if len(replay) != wantLen {
+				t.Fatalf("replay has %d lines, want %d", len(replay), wantLen)
+			}
+			if want := fmt.Sprintf("line %d", tt.lines-deployLogMaxLines); replay[len(replay)-deployLogMaxLines] != want {
+				t.Errorf("oldest kept line = %q, want %q", replay[len(replay)-deployLogMaxLines], want)
+			}
+			if want := fmt.Sprintf("line %d", tt.lines-1); replay[len(replay)-1] != want {
+				t.Errorf("last line = %q, want %q", replay[len(replay)-1], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPromoteSource       = errors.New("only successful deployments can be promoted")
+	ErrPromoteSameEnv      = errors.New("deployment is already in the target environment")
+	ErrPromoteTarget       = errors.New("cannot determine the target application in the environment")
+	ErrPromoteSourceConfig = errors.New("cannot determine the application or environment of the source deployment")
+)
+
+// MissingConfigError 目标环境缺少应用在来源环境中使用的配置项
+type MissingConfigError struct {
+	Env  string
+	Keys []string
+}
+
+func (e *MissingConfigError) Error() string {
+	return fmt.Sprintf("environment %s is missing config keys: %s", e.Env, strings.Join(e.Keys, ", "))
+}
+
+// DeployPromotionService 将成功的部署原样晋级到下一个环境（如 test → staging → prod），
+// 新部署沿用来源部署的版本、提交和构建产物，并通过 PromotedFrom 记录来源
+type DeployPromotionService struct {
+	deployRepo    *repository.DeploymentRepository
+	appRepo       *repository.AppRepository
+	envRepo       *repository.EnvRepository
+	configService *ConfigService
+	deployService *DeploymentService
+}
+
+func NewDeployPromotionService(
+	deployRepo *repository.DeploymentRepository,
+	appRepo *repository.AppRepository,
+	envRepo *repository.EnvRepository,
+	configService *ConfigService,
+	deployService *DeploymentService,
+) *DeployPromotionService {
+	return &DeployPromotionService{
+		deployRepo:    deployRepo,
+		appRepo:       appRepo,
+		envRepo:       envRepo,
+		configService: configService,
+		deployService: deployService,
+	}
+}
+
+type PromoteRequest struct {
+	EnvID uuid.UUID `json:"env_id" binding:"required"`
+	// AppID 目标环境中的应用，为空时查找目标环境中使用同一代码仓库的唯一应用
+	AppID *uuid.UUID `json:"app_id"`
+	// FreezeOverrideReason 封版期内强制部署的原因，仅管理员可用
+	FreezeOverrideReason string `json:"freeze_override_reason"`
+}
+
+// Promote 校验来源部署已成功、目标环境已配置应用所需的全部配置项后创建晋级部署。
+// 晋级部署与普通部署一样需要审批并受封版约束，创建后需另行开始执行
+func (s *DeployPromotionService) Promote(id uuid.UUID, req *PromoteRequest, createdBy uuid.UUID, override *FreezeOverride) (*model.Deployment, error) {
+	source, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrDeployNotFound
+	}
+	if source.Status != 2 || source.Type != "deploy" {
+		return nil, ErrPromoteSource
+	}
+	env, err := s.envRepo.GetByID(req.EnvID)
+	if err != nil {
+		return nil, ErrEnvNotFound
+	}
+	if source.EnvID != nil && *source.EnvID == env.ID {
+		return nil, ErrPromoteSameEnv
+	}
+
+	target, err := s.targetApp(source.App, env, req.AppID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkConfigKeys(source, target, env); err != nil {
+		return nil, err
+	}
+
+	return s.deployService.createPromotion(source, target, createdBy, override)
+}
+
+func (s *DeployPromotionService) targetApp(sourceApp *model.Application, env *model.Environment, appID *uuid.UUID) (*model.Application, error) {
+	if appID != nil {
+		app, err := s.appRepo.GetByID(*appID)
+		if err != nil {
+			return nil, ErrAppNotFound
+		}
+		if app.EnvID == nil || *app.EnvID != env.ID {
+			return nil, ErrPromoteTarget
+		}
+		return app, nil
+	}
+
+	if sourceApp == nil || sourceApp.RepoURL == "" {
+		return nil, ErrPromoteTarget
+	}
+	apps, err := s.appRepo.ListByEnvAndRepo(env.ID, sourceApp.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) != 1 {
+		return nil, ErrPromoteTarget
+	}
+	return &apps[0], nil
+}
+
+// checkConfigKeys 来源环境中应用可见的配置键（含全局配置）在目标环境中都必须存在。
+// 无法确定来源应用或环境时拒绝晋级，避免跳过校验
+func (s *DeployPromotionService) checkConfigKeys(source *model.Deployment, target *model.Application, env *model.Environment) error {
+	if source.App == nil {
+		return ErrPromoteSourceConfig
+	}
+	envID := source.EnvID
+	if envID == nil {
+		envID = source.App.EnvID
+	}
+	if envID == nil {
+		return ErrPromoteSourceConfig
+	}
+	sourceEnv, err := s.envRepo.GetByID(*envID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPromoteSourceConfig
+		}
+		return err
+	}
+
+	required, err := s.configService.GetByEnvAndApp(sourceEnv.Code, source.App.Code, false)
+	if err != nil {
+		return err
+	}
+	existing, err := s.configService.GetByEnvAndApp(env.Code, target.Code, false)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		keys[c.Key] = true
+	}
+	var missing []string
+	for _, c := range required {
+		if !keys[c.Key] {
+			missing = append(missing, c.Key)
+			keys[c.Key] = true
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingConfigError{Env: env.Code, Keys: missing}
+	}
+	return nil
+}
+
+// createPromotion 为目标应用创建沿用来源部署版本、提交和产物的部署
+func (s *DeploymentService) createPromotion(source *model.Deployment, app *model.Application, createdBy uuid.UUID, override *FreezeOverride) (*model.Deployment, error) {
+	frozen, err := checkFreeze(s.freezeRepo, app.EnvID, time.Now(), override)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.initialStatus(app.EnvID)
+	if err != nil {
+		return nil, err
+	}
+
+	deploy := &model.Deployment{
+		AppID:        app.ID,
+		Version:      source.Version,
+		CommitID:     source.CommitID,
+		CommitMsg:    source.CommitMsg,
+		CommitTime:   source.CommitTime,
+		Branch:       source.Branch,
+		EnvID:        app.EnvID,
+		Type:         "deploy",
+		PromotedFrom: &source.ID,
+		Strategy:     app.DeployStrategy,
+		Status:       status,
+		CreatedBy:    createdBy,
+	}
+	// 只有同样需要构建的应用才能直接使用来源部署的产物
+	if source.ArtifactID != nil && needsBuild(app) {
+		deploy.ArtifactID = source.ArtifactID
+	}
+
+	if err := s.deployRepo.Create(deploy); err != nil {
+		return nil, err
+	}
+	if frozen != nil {
+		auditFreezeOverride(s.auditRepo, deploy, frozen, override)
+	}
+
+	return s.deployRepo.GetByID(deploy.ID)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/lock"
+
+	"github.com/google/uuid"
+)
+
+// deployLockKey 部署锁按 (应用, 环境) 划分，未关联环境的应用共用一把锁
+func deployLockKey(appID uuid.UUID, envID *uuid.UUID) string {
+	env := "none"
+	if envID != nil {
+		env = envID.String()
+	}
+	return "deploy:" + appID.String() + ":" + env
+}
+
+// enqueue 将部署标记为排队中
+func (s *DeploymentService) enqueue(deploy *model.Deployment) error {
+	now := time.Now()
+	deploy.Status = 4 // queued
+	deploy.QueuedAt = &now
+	return s.deployRepo.Update(deploy)
+}
+
+// runNext 持有 (应用, 环境) 锁时按 FIFO 启动下一个排队中的部署，队列为空时释放锁
+func (s *DeploymentService) runNext(appID uuid.UUID, envID *uuid.UUID, held lock.Lock) {
+	for {
+		next, err := s.deployRepo.FirstQueued(appID, envID)
+		if err != nil {
+			log.Printf("Failed to load deploy queue for app %s: %v", appID, err)
+		}
+		if next != nil {
+			if s.launch(next, held) {
+				return
+			}
+			continue
+		}
+
+		if err := held.Unlock(); err != nil {
+			log.Printf("Failed to release deploy lock for app %s: %v", appID, err)
+		}
+
+		// 释放锁之前可能有请求入队但未拿到锁，需再次检查，避免其一直排队
+		if count, err := s.deployRepo.CountQueued(appID, envID); err != nil || count == 0 {
+			return
+		}
+		var ok bool
+		held, ok, err = s.locker.TryLock(context.Background(), deployLockKey(appID, envID))
+		if err != nil || !ok {
+			return
+		}
+	}
+}
+
+// RecoverDeployments 在服务启动时恢复部署队列。执行部署期间一直持有 (应用, 环境) 锁，
+// 能拿到锁说明执行该部署的服务实例已退出：运行中（含暂停）的部署标记为失败，排队中的部署重新开始调度。
+// 其他实例仍在执行的部署拿不到锁，保持不变
+func (s *DeploymentService) RecoverDeployments() {
+	running, err := s.deployRepo.ListRunning()
+	if err != nil {
+		log.Printf("Failed to load running deployments: %v", err)
+		return
+	}
+	queued, err := s.deployRepo.ListQueued(nil, nil)
+	if err != nil {
+		log.Printf("Failed to load queued deployments: %v", err)
+		return
+	}
+
+	held := make(map[string]lock.Lock)
+	var order []*model.Deployment
+	acquire := func(deploy *model.Deployment) bool {
+		key := deployLockKey(deploy.AppID, deploy.EnvID)
+		if l, ok := held[key]; ok {
+			return l != nil
+		}
+		l, ok, err := s.locker.TryLock(context.Background(), key)
+		if err != nil {
+			log.Printf("Failed to acquire deploy lock for deployment %s: %v", deploy.ID, err)
+		}
+		if err != nil || !ok {
+			held[key] = nil
+			return false
+		}
+		held[key] = l
+		order = append(order, deploy)
+		return true
+	}
+
+	for i := range running {
+		deploy := &running[i]
+		if !acquire(deploy) {
+			continue
+		}
+		current, err := s.deployRepo.GetByID(deploy.ID)
+		if err != nil || current.Status != 1 {
+			continue
+		}
+		log.Printf("Deployment %s was interrupted by a server restart, marking it failed", deploy.ID)
+		output := strings.TrimSpace(current.Output + "\n部署因服务重启中断")
+		if err := s.FinishDeploy(deploy.ID, false, output); err != nil {
+			log.Printf("Failed to finish deployment %s: %v", deploy.ID, err)
+		}
+	}
+	for i := range queued {
+		acquire(&queued[i])
+	}
+
+	for _, deploy := range order {
+		s.runNext(deploy.AppID, deploy.EnvID, held[deployLockKey(deploy.AppID, deploy.EnvID)])
+	}
+}
+
+// unqueueFrozen 将因封版（或无法检查封版窗口）不能执行的部署移出队列，恢复为待执行状态，
+// 封版结束后可重新开始执行。调用方已将部署认领为运行中
+func (s *DeploymentService) unqueueFrozen(deploy *model.Deployment, err error) {
+	var frozen *FrozenError
+	if errors.As(err, &frozen) {
+		deploy.Output = fmt.Sprintf("环境处于封版期「%s」，至 %s 结束，部署已移出队列", frozen.Window.Name, frozen.End.Format("2006-01-02 15:04"))
+	} else {
+		log.Printf("Failed to check freeze windows for deployment %s: %v", deploy.ID, err)
+		deploy.Output = fmt.Sprintf("无法检查封版窗口，部署已移出队列: %v", err)
+	}
+	deploy.Status = 0 // pending
+	deploy.QueuedAt = nil
+	if err := s.deployRepo.Update(deploy); err != nil {
+		log.Printf("Failed to unqueue frozen deployment %s: %v", deploy.ID, err)
+	}
+}
+
+// ListQueue 按执行顺序列出排队中的部署
+func (s *DeploymentService) ListQueue(appID, envID *uuid.UUID) ([]model.Deployment, error) {
+	return s.deployRepo.ListQueued(appID, envID)
+}
+
+// CancelQueued 将排队中的部署移出队列，恢复为待执行状态
+func (s *DeploymentService) CancelQueued(id uuid.UUID) error {
+	if _, err := s.deployRepo.GetByID(id); err != nil {
+		return ErrDeployNotFound
+	}
+	ok, err := s.deployRepo.Dequeue(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDeployNotQueued
+	}
+	return nil
+}
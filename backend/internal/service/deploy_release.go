@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// 主机上的发布目录结构:
+//
+//	<DeployPath>/.repo                 代码仓库缓存（bare）
+//	<DeployPath>/.artifacts/           上传中的构建产物，解压后删除
+//	<DeployPath>/releases/<release>/   每次发布的独立目录，保留最近 KeepReleases 个
+//	<DeployPath>/current -> releases/<release>
+//
+// 回滚只需将 current 切回旧的发布目录并重启应用。
+
+// newReleaseName 生成按时间排序的发布目录名
+func newReleaseName(id uuid.UUID, now time.Time) string {
+	return now.Format("20060102150405") + "-" + id.String()[:8]
+}
+
+// usesReleases 应用是否使用发布目录结构
+func usesReleases(app *model.Application) bool {
+	return app.RepoURL != "" && app.DeployPath != ""
+}
+
+func releaseDir(app *model.Application, release string) string {
+	if app.DeployPath == "" || release == "" {
+		return ""
+	}
+	return path.Join(app.DeployPath, "releases", release)
+}
+
+// syncCommand 在主机的仓库缓存中拉取代码，并导出本次部署的版本到新的发布目录
+func syncCommand(deploy *model.Deployment, app *model.Application) string {
+	if !usesReleases(app) || deploy.Release == "" {
+		return ""
+	}
+
+	ref := deploy.CommitID
+	if ref == "" {
+		branch := deploy.Branch
+		if branch == "" {
+			branch = app.Branch
+		}
+		ref = "refs/heads/" + branch
+	}
+
+	repo := path.Join(app.DeployPath, ".repo")
+	dir := releaseDir(app, deploy.Release)
+	return fmt.Sprintf("(test -d %[1]s || git clone --bare -- %[2]s %[1]s) && "+
+		"git -C %[1]s fetch --prune origin '+refs/heads/*:refs/heads/*' && "+
+		"rm -rf %[3]s && mkdir -p %[3]s && git -C %[1]s archive %[4]s -- | tar -x -C %[3]s",
+		shellQuote(repo), shellQuote(app.RepoURL), shellQuote(dir), shellQuote(ref))
+}
+
+// artifactSteps 将服务端构建的产物上传到主机，校验 sha256 后解压到新的发布目录
+func artifactSteps(deploy *model.Deployment, app *model.Application, artifact *deployArtifact) []deployStep {
+	dir := releaseDir(app, deploy.Release)
+	uploadDir := path.Join(app.DeployPath, ".artifacts")
+	remote := path.Join(uploadDir, deploy.Release+".tar.gz")
+	return []deployStep{
+		{Name: "prepare", Command: fmt.Sprintf("mkdir -p %s", shellQuote(uploadDir))},
+		{Name: "upload", UploadFile: artifact.File, UploadTo: remote},
+		{Name: "verify", Command: fmt.Sprintf("echo %s | sha256sum -c - || { rm -f %s; exit 1; }",
+			shellQuote(artifact.SHA256+"  "+remote), shellQuote(remote))},
+		{Name: "sync", Command: fmt.Sprintf("rm -rf %[1]s && mkdir -p %[1]s && tar -xzf %[2]s -C %[1]s && rm -f %[2]s",
+			shellQuote(dir), shellQuote(remote))},
+	}
+}
+
+// activateCommand 原子地将 current 指向发布目录，并清理超出保留数量的旧发布
+func activateCommand(app *model.Application, release string) string {
+	if !usesReleases(app) || release == "" {
+		return ""
+	}
+
+	keep := positiveOr(app.KeepReleases, 5)
+	return fmt.Sprintf("cd %s && ln -sfn %s .current.tmp && mv -Tf .current.tmp current && "+
+		"(cd releases && ls -1 | sort -r | tail -n +%d | grep -vxF \"$(basename \"$(readlink ../current)\")\" | xargs -r rm -rf)",
+		shellQuote(app.DeployPath), shellQuote(path.Join("releases", release)), keep+1)
+}
+
+// checkReleaseCommand 回滚前确认目标发布目录仍保留在主机上
+func checkReleaseCommand(app *model.Application, release string) string {
+	dir := releaseDir(app, release)
+	if dir == "" {
+		return "echo 'no release to roll back to' >&2; exit 1"
+	}
+	return fmt.Sprintf("test -d %[1]s || { echo 'release '%[1]s' not found on host' >&2; exit 1; }", shellQuote(dir))
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/lock"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrDeployNotRetryable = errors.New("deployment cannot be retried")
+	ErrRetryHosts         = errors.New("hosts are not retryable in this deployment")
+	ErrDeployBusy         = errors.New("another deployment of the application is running")
+)
+
+type RetryDeployRequest struct {
+	// HostIDs 需要重试的主机，为空时重试所有未成功的主机
+	HostIDs []uuid.UUID `json:"host_ids"`
+	// FreezeOverrideReason 封版期内强制重试的原因，仅管理员可用
+	FreezeOverrideReason string `json:"freeze_override_reason"`
+}
+
+// Retry 在原部署上重新执行未成功（failed、cancelled、skipped）的主机，沿用原部署的发布目录和构建产物，
+// 执行后原地更新这些主机的结果并根据全部主机的结果重新计算部署状态。
+// 只能重试同一应用同一环境下最新的部署。
+// 重试需要持有 (应用, 环境) 部署锁，有其他部署正在执行时返回 ErrDeployBusy。
+// 与 StartDeploy 相同，环境处于封版期时需要管理员提供 override 才能重试（回滚除外）
+func (s *DeploymentService) Retry(id uuid.UUID, req *RetryDeployRequest, override *FreezeOverride) (*model.Deployment, error) {
+	deploy, err := s.deployRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrDeployNotFound
+	}
+	if deploy.Status != 3 && deploy.Status != 5 {
+		return nil, ErrDeployNotRetryable
+	}
+	// 之后已有新的部署（包括自动回滚）时，重试会在部分主机上重新启用旧版本
+	newer, err := s.deployRepo.HasNewer(deploy)
+	if err != nil {
+		return nil, err
+	}
+	if newer {
+		return nil, ErrDeployNotRetryable
+	}
+	app, err := s.appRepo.GetByID(deploy.AppID)
+	if err != nil {
+		return nil, ErrAppNotFound
+	}
+	if isK8sTarget(app) {
+		return nil, ErrDeployNotRetryable
+	}
+
+	var results []model.DeployHostResult
+	if deploy.HostResults != "" {
+		if err := json.Unmarshal([]byte(deploy.HostResults), &results); err != nil {
+			return nil, fmt.Errorf("decode host results: %w", err)
+		}
+	}
+	hosts, err := retryHosts(app, results, req.HostIDs)
+	if err != nil {
+		return nil, err
+	}
+	// 重试的主机需与已成功的主机使用相同版本的配置，配置变更后应重新部署
+	cfg, err := s.loadDeployConfig(deploy, app)
+	if err != nil {
+		return nil, err
+	}
+	versions := ""
+	if cfg != nil {
+		versions = cfg.Versions
+	}
+	if versions != deploy.ConfigVersions {
+		return nil, ErrConfigChanged
+	}
+	var frozen *FrozenError
+	if deploy.Type != "rollback" {
+		if frozen, err = checkFreeze(s.freezeRepo, deploy.EnvID, time.Now(), override); err != nil {
+			return nil, err
+		}
+	}
+
+	held, ok, err := s.locker.TryLock(context.Background(), deployLockKey(deploy.AppID, deploy.EnvID))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDeployBusy
+	}
+	claimed, err := s.deployRepo.UpdateStatusIf(deploy.ID, deploy.Status, 1)
+	if err != nil || !claimed {
+		if err := held.Unlock(); err != nil {
+			log.Printf("Failed to release deploy lock for deployment %s: %v", deploy.ID, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrDeployNotRetryable
+	}
+	if frozen != nil {
+		auditFreezeOverride(s.auditRepo, deploy, frozen, override)
+	}
+
+	deploy.Status = 1 // running
+	deploy.EndTime = nil
+	if err := s.deployRepo.Update(deploy); err != nil {
+		log.Printf("Failed to update deployment %s for retry: %v", deploy.ID, err)
+	}
+
+	s.logHub.Open(deploy.ID)
+	run := s.runs.add(deploy.ID)
+	go s.executeRetry(deploy, app, run, held, hosts, results, cfg)
+
+	return s.deployRepo.GetByID(deploy.ID)
+}
+
+// retryHosts 返回需要重试的主机。hostIDs 为空时选择所有未成功的主机，
+// 指定的主机必须在原部署中未成功且仍关联在应用上
+func retryHosts(app *model.Application, results []model.DeployHostResult, hostIDs []uuid.UUID) ([]model.Host, error) {
+	retryable := make(map[uuid.UUID]bool)
+	for _, r := range results {
+		if r.Status != "success" {
+			retryable[r.HostID] = true
+		}
+	}
+
+	wanted := make(map[uuid.UUID]bool)
+	for _, id := range hostIDs {
+		if !retryable[id] {
+			return nil, ErrRetryHosts
+		}
+		wanted[id] = true
+	}
+	if len(wanted) == 0 {
+		wanted = retryable
+	}
+
+	var hosts []model.Host
+	for _, host := range app.Hosts {
+		if wanted[host.ID] {
+			hosts = append(hosts, host)
+			delete(wanted, host.ID)
+		}
+	}
+	if len(hostIDs) > 0 && len(wanted) > 0 {
+		return nil, ErrRetryHosts
+	}
+	if len(hosts) == 0 {
+		return nil, ErrRetryHosts
+	}
+	return hosts, nil
+}
+
+// executeRetry 在指定主机上重新执行部署步骤，不分批也不触发自动回滚
+func (s *DeploymentService) executeRetry(deploy *model.Deployment, app *model.Application, run *deployRun, held lock.Lock, hosts []model.Host, results []model.DeployHostResult, cfg *deployConfig) {
+	defer s.runNext(deploy.AppID, deploy.EnvID, held)
+	defer s.runs.remove(deploy.ID)
+	defer s.logHub.Close(deploy.ID)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Deployment %s retry panicked: %v", deploy.ID, r)
+			if err := s.FinishDeploy(deploy.ID, false, fmt.Sprintf("重试异常终止: %v", r)); err != nil {
+				log.Printf("Failed to finish deployment %s: %v", deploy.ID, err)
+			}
+		}
+	}()
+
+	names := make([]string, len(hosts))
+	for i, host := range hosts {
+		names[i] = host.IP
+	}
+	s.logHub.Append(deploy.ID, fmt.Sprintf("[deploy] retrying %d host(s): %s", len(hosts), strings.Join(names, ", ")))
+
+	var phases map[string][]model.DeployScript
+	if deploy.Type != "rollback" {
+		scripts, err := s.scriptRepo.ListEnabledByApp(app.ID)
+		if err != nil {
+			summary := fmt.Sprintf("重试时加载部署脚本失败: %v", err)
+			s.logHub.Append(deploy.ID, "[deploy] "+summary)
+			s.finishWithResults(deploy, run, results, summary+"\n\n")
+			return
+		}
+		phases = groupScriptsByType(scripts)
+	}
+
+	retried := make([]model.DeployHostResult, 0, len(hosts))
+	summary := ""
+	if before := scriptSteps(deploy, app, phases["before_deploy"]); len(before) > 0 {
+		retried = s.runOnHosts(run.ctx, deploy, hosts, before)
+		if batchFailed(retried) {
+			summary = "重试时 before_deploy 脚本执行失败，主机未变更"
+		}
+	}
+
+	if summary == "" {
+		var artifact *deployArtifact
+		if deploy.Type != "rollback" && needsBuild(app) {
+			data, err := s.buildArtifact(run.ctx, deploy, app)
+			if err != nil {
+				summary = fmt.Sprintf("重试时构建失败: %v", err)
+				if run.cancelled() {
+					summary = "重试已取消，构建未完成"
+				}
+				s.logHub.Append(deploy.ID, "[deploy] "+summary)
+				s.finishWithResults(deploy, run, results, summary+"\n\n")
+				return
+			}
+			artifact = data
+			defer artifact.Remove()
+		}
+		retried = s.runOnHosts(run.ctx, deploy, hosts, buildDeploySteps(deploy, app, phases, artifact, cfg))
+	}
+
+	succeeded := 0
+	for _, r := range retried {
+		if r.Status == "success" {
+			succeeded++
+		}
+	}
+	results = mergeRetryResults(results, retried)
+	if summary == "" {
+		summary = fmt.Sprintf("重试 %d 台主机：%d 台成功，%d 台失败", len(retried), succeeded, len(retried)-succeeded)
+		if run.cancelled() {
+			summary = fmt.Sprintf("重试已取消，%d 台主机中 %d 台成功", len(retried), succeeded)
+		}
+	}
+	s.logHub.Append(deploy.ID, "[deploy] "+summary)
+	s.finishWithResults(deploy, run, results, summary+"\n\n")
+}
+
+// mergeRetryResults 用重试结果替换原结果中对应主机的条目并累计重试次数
+func mergeRetryResults(results, retried []model.DeployHostResult) []model.DeployHostResult {
+	for _, r := range retried {
+		replaced := false
+		for i := range results {
+			if results[i].HostID == r.HostID {
+				r.Retries = results[i].Retries + 1
+				results[i] = r
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			r.Retries = 1
+			results = append(results, r)
+		}
+	}
+	return results
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestRetryHosts(t *testing.T) {
+	h1, h2, h3, h4 := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	app := &model.Application{Hosts: []model.Host{
+		{ID: h1, IP: "10.0.0.1"},
+		{ID: h2, IP: "10.0.0.2"},
+		{ID: h3, IP: "10.0.0.3"},
+	}}
+	results := []model.DeployHostResult{
+		{HostID: h1, Status: "success"},
+		{HostID: h2, Status: "failed"},
+		{HostID: h3, Status: "cancelled"},
+		{HostID: h4, Status: "failed"}, // 已从应用中移除
+	}
+
+	tests := []struct {
+		name    string
+		results []model.DeployHostResult
+		hostIDs []uuid.UUID
+		want    []uuid.UUID
+		wantErr bool
+	}{
+		{name: "all unsuccessful hosts", results: results, want: []uuid.UUID{h2, h3}},
+		{name: "selected host", results: results, hostIDs: []uuid.UUID{h3}, want: []uuid.UUID{h3}},
+		{name: "duplicate selection", results: results, hostIDs: []uuid.UUID{h2, h2}, want: []uuid.UUID{h2}},
+		{name: "successful host", results: results, hostIDs: []uuid.UUID{h1}, wantErr: true},
+		{name: "host not in deployment", results: results, hostIDs: []uuid.UUID{uuid.New()}, wantErr: true},
+		{name: "host removed from application", results: results, hostIDs: []uuid.UUID{h4}, wantErr: true},
+		{name: "nothing to retry", results: []model.DeployHostResult{{HostID: h1, Status: "success"}}, wantErr: true},
+		{name: "only removed hosts failed", results: []model.DeployHostResult{{HostID: h4, Status: "failed"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := retryHosts(app, tt.results, tt.hostIDs)
+			if tt.wantErr {
+				if err != ErrRetryHosts {
+					t.Fatalf("err = %v, want ErrRetryHosts", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uuid.UUID
+			for _, h := range hosts {
+				got = append(got, h.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRetryResults(t *testing.T) {
+	h1, h2, h3 := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name    string
+		results []model.DeployHostResult
+		retried []model.DeployHostResult
+		want    []model.DeployHostResult
+	}{
+		{
+			name:    "replaces retried hosts in place",
+			results: []model.DeployHostResult{{HostID: h1, Status: "success"}, {HostID: h2, Status: "failed", Error: "boom"}},
+			retried: []model.DeployHostResult{{HostID: h2, Status: "success"}},
+			want:    []model.DeployHostResult{{HostID: h1, Status: "success"}, {HostID: h2, Status: "success", Retries: 1}},
+		},
+		{
+			name:    "accumulates retries",
+			results: []model.DeployHostResult{{HostID: h1, Status: "failed", Retries: 2}},
+			retried: []model.DeployHostResult{{HostID: h1, Status: "failed", Step: "health_check"}},
+			want:    []model.DeployHostResult{{HostID: h1, Status: "failed", Step: "health_check", Retries: 3}},
+		},
+		{
+			name:    "appends hosts missing from the original results",
+			results: []model.DeployHostResult{{HostID: h1, Status: "success"}},
+			retried: []model.DeployHostResult{{HostID: h3, Status: "success"}},
+			want:    []model.DeployHostResult{{HostID: h1, Status: "success"}, {HostID: h3, Status: "success", Retries: 1}},
+		},
+		{
+			name:    "nothing retried",
+			results: []model.DeployHostResult{{HostID: h1, Status: "failed"}},
+			want:    []model.DeployHostResult{{HostID: h1, Status: "failed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeRetryResults(tt.results, tt.retried)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeRetryResults = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var ErrStatsRange = errors.New("invalid statistics time range")
+
+// maxStatsDays 统计的最大天数
+const maxStatsDays = 366
+
+// DeployStatsService 基于已结束的部署计算 DORA 指标：部署频率、变更失败率、平均恢复时间和变更前置时间
+type DeployStatsService struct {
+	deployRepo *repository.DeploymentRepository
+	envRepo    *repository.EnvRepository
+}
+
+func NewDeployStatsService(deployRepo *repository.DeploymentRepository, envRepo *repository.EnvRepository) *DeployStatsService {
+	return &DeployStatsService{
+		deployRepo: deployRepo,
+		envRepo:    envRepo,
+	}
+}
+
+// DeployStatsQuery 统计 [From, To) 内结束的部署，按 From 所在时区划分日期
+type DeployStatsQuery struct {
+	From  time.Time
+	To    time.Time
+	AppID *uuid.UUID
+	EnvID *uuid.UUID
+}
+
+// DeployStats 一组部署的统计结果。恢复时间和前置时间没有样本时为 null
+type DeployStats struct {
+	Total             int      `json:"total"`               // 已结束的部署数，含回滚
+	Deploys           int      `json:"deploys"`             // 普通部署数（不含回滚）
+	Succeeded         int      `json:"succeeded"`           // 成功的普通部署数
+	Failed            int      `json:"failed"`              // 失败的普通部署数
+	Rollbacks         int      `json:"rollbacks"`           // 成功的回滚数
+	ChangeFailures    int      `json:"change_failures"`     // 失败的变更数：失败或成功后被回滚的普通部署，同一变更只计一次
+	DeployFrequency   float64  `json:"deploy_frequency"`    // 平均每天成功部署次数
+	ChangeFailureRate float64  `json:"change_failure_rate"` // 失败的变更数 / 普通部署数
+	Restores          int      `json:"restores"`            // 故障恢复次数
+	MTTRSeconds       *float64 `json:"mttr_seconds"`        // 平均恢复时间
+	LeadTimeSamples   int      `json:"lead_time_samples"`   // 已知提交时间的成功部署数
+	LeadTimeSeconds   *float64 `json:"lead_time_seconds"`   // 从提交到部署成功的平均时间
+}
+
+type DeployStatsDay struct {
+	Date      string `json:"date"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Rollbacks int    `json:"rollbacks"`
+}
+
+// DeployStatsGroup 按应用和环境或按环境分组的统计
+type DeployStatsGroup struct {
+	AppID   *uuid.UUID `json:"app_id,omitempty"`
+	AppCode string     `json:"app_code,omitempty"`
+	AppName string     `json:"app_name,omitempty"`
+	EnvID   *uuid.UUID `json:"env_id,omitempty"`
+	EnvCode string     `json:"env_code,omitempty"`
+	EnvName string     `json:"env_name,omitempty"`
+	DeployStats
+	Daily []DeployStatsDay `json:"daily"`
+}
+
+type DeployStatsResult struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Days    int                `json:"days"`
+	Summary DeployStatsGroup   `json:"summary"`
+	Apps    []DeployStatsGroup `json:"apps"`
+	Envs    []DeployStatsGroup `json:"envs"`
+}
+
+// Stats 计算统计区间内的指标。
+// 故障从失败部署结束时开始；被回滚的变更从该变更部署成功时开始，到同一应用同一环境下一次成功的部署或回滚结束时恢复
+func (s *DeployStatsService) Stats(q *DeployStatsQuery) (*DeployStatsResult, error) {
+	if !q.To.After(q.From) || q.To.Sub(q.From) > maxStatsDays*24*time.Hour {
+		return nil, ErrStatsRange
+	}
+	deploys, err := s.deployRepo.ListFinished(q.From, q.To, q.AppID, q.EnvID)
+	if err != nil {
+		return nil, err
+	}
+	envs, err := s.envRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	return aggregateDeployStats(q, deploys, envs), nil
+}
+
+// aggregateDeployStats 按结束时间顺序汇总 deploys 的指标
+func aggregateDeployStats(q *DeployStatsQuery, deploys []model.Deployment, envs []model.Environment) *DeployStatsResult {
+	envByID := make(map[uuid.UUID]*model.Environment, len(envs))
+	for i := range envs {
+		envByID[envs[i].ID] = &envs[i]
+	}
+
+	loc := q.From.Location()
+	var dates []string
+	for d := q.From; d.Before(q.To); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+
+	summary := newStatsAcc(dates)
+	apps := make(map[statsKey]*statsAcc)
+	envGroups := make(map[uuid.UUID]*statsAcc)
+	incidents := make(map[statsKey]*incidentTracker)
+
+	for i := range deploys {
+		d := &deploys[i]
+		if d.EndTime == nil {
+			continue
+		}
+		key := statsKey{AppID: d.AppID}
+		if d.EnvID != nil {
+			key.EnvID = *d.EnvID
+		}
+
+		appAcc, ok := apps[key]
+		if !ok {
+			appAcc = newStatsAcc(dates)
+			appAcc.group.AppID = &d.AppID
+			if d.App != nil {
+				appAcc.group.AppCode = d.App.Code
+				appAcc.group.AppName = d.App.Name
+			}
+			setStatsEnv(&appAcc.group, d.EnvID, envByID)
+			apps[key] = appAcc
+		}
+		accs := []*statsAcc{summary, appAcc}
+		if d.EnvID != nil {
+			envAcc, ok := envGroups[*d.EnvID]
+			if !ok {
+				envAcc = newStatsAcc(dates)
+				setStatsEnv(&envAcc.group, d.EnvID, envByID)
+				envGroups[*d.EnvID] = envAcc
+			}
+			accs = append(accs, envAcc)
+		}
+
+		tracker := incidents[key]
+		if tracker == nil {
+			tracker = &incidentTracker{}
+			incidents[key] = tracker
+		}
+		restore, failedChange := tracker.observe(d)
+
+		date := d.EndTime.In(loc).Format("2006-01-02")
+		for _, acc := range accs {
+			acc.add(d, date)
+			if failedChange {
+				acc.group.ChangeFailures++
+			}
+			if restore > 0 {
+				acc.group.Restores++
+				acc.restoreTotal += restore
+			}
+		}
+	}
+
+	days := len(dates)
+	result := &DeployStatsResult{
+		From:    q.From,
+		To:      q.To,
+		Days:    days,
+		Summary: summary.finish(days),
+	}
+	for _, acc := range apps {
+		result.Apps = append(result.Apps, acc.finish(days))
+	}
+	for _, acc := range envGroups {
+		result.Envs = append(result.Envs, acc.finish(days))
+	}
+	sort.Slice(result.Apps, func(i, j int) bool {
+		if result.Apps[i].AppCode != result.Apps[j].AppCode {
+			return result.Apps[i].AppCode < result.Apps[j].AppCode
+		}
+		return result.Apps[i].EnvCode < result.Apps[j].EnvCode
+	})
+	sort.Slice(result.Envs, func(i, j int) bool { return result.Envs[i].EnvCode < result.Envs[j].EnvCode })
+	return result
+}
+
+type statsKey struct {
+	AppID uuid.UUID
+	EnvID uuid.UUID
+}
+
+type statsAcc struct {
+	group         DeployStatsGroup
+	days          map[string]*DeployStatsDay
+	restoreTotal  time.Duration
+	leadTimeTotal time.Duration
+}
+
+func newStatsAcc(dates []string) *statsAcc {
+	acc := &statsAcc{days: make(map[string]*DeployStatsDay, len(dates))}
+	acc.group.Daily = make([]DeployStatsDay, len(dates))
+	for i, date := range dates {
+		acc.group.Daily[i].Date = date
+		acc.days[date] = &acc.group.Daily[i]
+	}
+	return acc
+}
+
+func setStatsEnv(group *DeployStatsGroup, envID *uuid.UUID, envByID map[uuid.UUID]*model.Environment) {
+	if envID == nil {
+		return
+	}
+	group.EnvID = envID
+	if env, ok := envByID[*envID]; ok {
+		group.EnvCode = env.Code
+		group.EnvName = env.Name
+	}
+}
+
+func (acc *statsAcc) add(d *model.Deployment, date string) {
+	stats := &acc.group.DeployStats
+	day := acc.days[date]
+	stats.Total++
+	if day != nil {
+		day.Total++
+	}
+
+	if d.Type == "rollback" {
+		if d.Status == 2 {
+			stats.Rollbacks++
+			if day != nil {
+				day.Rollbacks++
+			}
+		}
+		return
+	}
+
+	stats.Deploys++
+	switch d.Status {
+	case 2:
+		stats.Succeeded++
+		if day != nil {
+			day.Succeeded++
+		}
+		if d.CommitTime != nil && d.EndTime.After(*d.CommitTime) {
+			stats.LeadTimeSamples++
+			acc.leadTimeTotal += d.EndTime.Sub(*d.CommitTime)
+		}
+	case 3:
+		stats.Failed++
+		if day != nil {
+			day.Failed++
+		}
+	}
+}
+
+func (acc *statsAcc) finish(days int) DeployStatsGroup {
+	stats := &acc.group.DeployStats
+	if days > 0 {
+		stats.DeployFrequency = float64(stats.Succeeded) / float64(days)
+	}
+	if stats.Deploys > 0 {
+		stats.ChangeFailureRate = float64(stats.ChangeFailures) / float64(stats.Deploys)
+	}
+	if stats.Restores > 0 {
+		mttr := acc.restoreTotal.Seconds() / float64(stats.Restores)
+		stats.MTTRSeconds = &mttr
+	}
+	if stats.LeadTimeSamples > 0 {
+		lead := acc.leadTimeTotal.Seconds() / float64(stats.LeadTimeSamples)
+		stats.LeadTimeSeconds = &lead
+	}
+	return acc.group
+}
+
+// incidentTracker 按结束时间顺序跟踪同一应用同一环境的故障
+type incidentTracker struct {
+	brokenSince *time.Time // 故障开始时间，nil 表示正常
+	lastChange  *time.Time // 最近一次成功的普通部署的结束时间
+	// changeLive 最近一次普通部署成功且尚未计为失败变更，之后的回滚会将其计为失败变更。
+	// 失败的部署已计入，随后的（自动）回滚不再重复计数
+	changeLive bool
+}
+
+// observe 处理一次部署，部署使故障恢复时返回恢复耗时，否则返回 0；
+// failedChange 表示本次部署使统计区间内的一次变更被判定为失败
+func (t *incidentTracker) observe(d *model.Deployment) (restore time.Duration, failedChange bool) {
+	end := *d.EndTime
+	switch {
+	case d.Status == 3:
+		if t.brokenSince == nil {
+			t.brokenSince = &end
+			if d.Type == "rollback" && t.lastChange != nil {
+				t.brokenSince = t.lastChange
+			}
+		}
+		if d.Type == "rollback" {
+			return 0, false
+		}
+		t.changeLive = false
+		return 0, true
+	case d.Type == "rollback":
+		// 回滚说明最近的变更有问题，故障从该变更上线时开始
+		failedChange = t.changeLive
+		t.changeLive = false
+		start := t.brokenSince
+		if start == nil {
+			start = t.lastChange
+		}
+		t.brokenSince = nil
+		t.lastChange = nil
+		if start == nil {
+			return 0, failedChange
+		}
+		return end.Sub(*start), failedChange
+	default:
+		start := t.brokenSince
+		t.brokenSince = nil
+		t.lastChange = &end
+		t.changeLive = true
+		if start == nil {
+			return 0, false
+		}
+		return end.Sub(*start), false
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestAggregateDeployStats(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	q := &DeployStatsQuery{From: from, To: from.AddDate(0, 0, 2)}
+	appID, envID := uuid.New(), uuid.New()
+
+	// deploy 构造一个在 from 之后 minute 分钟结束的部署
+	deploy := func(typ string, status, minute int) model.Deployment {
+		end := from.Add(time.Duration(minute) * time.Minute)
+		return model.Deployment{ID: uuid.New(), AppID: appID, EnvID: &envID, Type: typ, Status: status, EndTime: &end}
+	}
+	withCommit := func(d model.Deployment, leadTime time.Duration) model.Deployment {
+		commit := d.EndTime.Add(-leadTime)
+		d.CommitTime = &commit
+		return d
+	}
+	seconds := func(d time.Duration) *float64 {
+		v := d.Seconds()
+		return &v
+	}
+
+	tests := []struct {
+		name               string
+		deploys            []model.Deployment
+		wantDeploys        int
+		wantFailed         int
+		wantRollbacks      int
+		wantChangeFailures int
+		wantCFR            float64
+		wantMTTR           *float64
+		wantLeadTime       *float64
+		wantFrequency      float64
+	}{
+		{
+			name:    "no deployments",
+			wantCFR: 0,
+		},
+		{
+			name: "all succeeded",
+			deploys: []model.Deployment{
+				withCommit(deploy("deploy", 2, 10), time.Hour),
+				withCommit(deploy("deploy", 2, 20), 3*time.Hour),
+			},
+			wantDeploys:   2,
+			wantLeadTime:  seconds(2 * time.Hour),
+			wantFrequency: 1,
+		},
+		{
+			name: "failed deploy auto rolled back counts once",
+			deploys: []model.Deployment{
+				deploy("deploy", 3, 10),
+				deploy("rollback", 2, 15),
+			},
+			wantDeploys:        1,
+			wantFailed:         1,
+			wantRollbacks:      1,
+			wantChangeFailures: 1,
+			wantCFR:            1,
+			wantMTTR:           seconds(5 * time.Minute),
+		},
+		{
+			name: "successful deploy rolled back later",
+			deploys: []model.Deployment{
+				deploy("deploy", 2, 0),
+				deploy("deploy", 2, 10),
+				deploy("deploy", 2, 20),
+				deploy("rollback", 2, 50),
+			},
+			wantDeploys:        3,
+			wantRollbacks:      1,
+			wantChangeFailures: 1,
+			wantCFR:            1.0 / 3,
+			wantMTTR:           seconds(30 * time.Minute),
+			wantFrequency:      1.5,
+		},
+		{
+			name: "rollback after a failed deploy does not blame the earlier change",
+			deploys: []model.Deployment{
+				deploy("deploy", 2, 0),
+				deploy("deploy", 3, 10),
+				deploy("rollback", 2, 20),
+			},
+			wantDeploys:        2,
+			wantFailed:         1,
+			wantRollbacks:      1,
+			wantChangeFailures: 1,
+			wantCFR:            0.5,
+			wantMTTR:           seconds(10 * time.Minute),
+			wantFrequency:      0.5,
+		},
+		{
+			name: "rollback of a change deployed before the range",
+			deploys: []model.Deployment{
+				deploy("rollback", 2, 10),
+				deploy("deploy", 2, 20),
+			},
+			wantDeploys:   1,
+			wantRollbacks: 1,
+			wantFrequency: 0.5,
+		},
+		{
+			name: "repeated failures until a fix",
+			deploys: []model.Deployment{
+				deploy("deploy", 3, 0),
+				deploy("deploy", 3, 10),
+				deploy("deploy", 2, 40),
+			},
+			wantDeploys:        3,
+			wantFailed:         2,
+			wantChangeFailures: 2,
+			wantCFR:            2.0 / 3,
+			wantMTTR:           seconds(40 * time.Minute),
+			wantFrequency:      0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := aggregateDeployStats(q, tt.deploys, nil)
+			got := result.Summary.DeployStats
+			if got.Deploys != tt.wantDeploys || got.Failed != tt.wantFailed || got.Rollbacks != tt.wantRollbacks {
+				t.Errorf("deploys/failed/rollbacks = %d/%d/%d, want %d/%d/%d",
+					got.Deploys, got.Failed, got.Rollbacks, tt.wantDeploys, tt.wantFailed, tt.wantRollbacks)
+			}
+			if got.ChangeFailures != tt.wantChangeFailures {
+				t.Errorf("change failures = %d, want %d", got.ChangeFailures, tt.wantChangeFailures)
+			}
+			if !floatNear(got.ChangeFailureRate, tt.wantCFR) {
+				t.Errorf("change failure rate = %v, want %v", got.ChangeFailureRate, tt.wantCFR)
+			}
+			if !floatPtrNear(got.MTTRSeconds, tt.wantMTTR) {
+				t.Errorf("mttr = %v, want %v", fmtFloatPtr(got.MTTRSeconds), fmtFloatPtr(tt.wantMTTR))
+			}
+			if !floatPtrNear(got.LeadTimeSeconds, tt.wantLeadTime) {
+				t.Errorf("lead time = %v, want %v", fmtFloatPtr(got.LeadTimeSeconds), fmtFloatPtr(tt.wantLeadTime))
+			}
+			if !floatNear(got.DeployFrequency, tt.wantFrequency) {
+				t.Errorf("deploy frequency = %v, want %v", got.DeployFrequency, tt.wantFrequency)
+			}
+			if len(tt.deploys) > 0 {
+				if len(result.Apps) != 1 || result.Apps[0].ChangeFailures != got.ChangeFailures || result.Apps[0].Deploys != got.Deploys {
+					t.Errorf("app groups = %+v, want one group matching the summary", result.Apps)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateDeployStatsSeparatesEnvironments(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	q := &DeployStatsQuery{From: from, To: from.AddDate(0, 0, 1)}
+	appID, staging, prod := uuid.New(), uuid.New(), uuid.New()
+	at := func(minute int) *time.Time {
+		end := from.Add(time.Duration(minute) * time.Minute)
+		return &end
+	}
+
+	// staging 上成功的部署不能被 prod 的回滚计为失败变更
+	deploys := []model.Deployment{
+		{AppID: appID, EnvID: &staging, Type: "deploy", Status: 2, EndTime: at(0)},
+		{AppID: appID, EnvID: &prod, Type: "rollback", Status: 2, EndTime: at(10)},
+	}
+	envs := []model.Environment{{ID: staging, Code: "staging"}, {ID: prod, Code: "prod"}}
+	result := aggregateDeployStats(q, deploys, envs)
+
+	if got := result.Summary.ChangeFailures; got != 0 {
+		t.Errorf("change failures = %d, want 0", got)
+	}
+	if len(result.Envs) != 2 || result.Envs[0].EnvCode != "prod" || result.Envs[1].EnvCode != "staging" {
+		t.Fatalf("envs = %+v", result.Envs)
+	}
+	if result.Envs[0].Rollbacks != 1 || result.Envs[1].Succeeded != 1 {
+		t.Errorf("prod rollbacks = %d, staging succeeded = %d", result.Envs[0].Rollbacks, result.Envs[1].Succeeded)
+	}
+	if len(result.Summary.Daily) != 1 || result.Summary.Daily[0].Total != 2 {
+		t.Errorf("daily = %+v", result.Summary.Daily)
+	}
+}
+
+func floatNear(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
+
+func floatPtrNear(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return floatNear(*a, *b)
+}
+
+func fmtFloatPtr(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
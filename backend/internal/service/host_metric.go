@@ -0,0 +1,471 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var ErrMetricQueryInvalid = errors.New("invalid metric query")
+
+const (
+	rawMetricRetention    = 24 * time.Hour
+	minuteMetricRetention = 7 * 24 * time.Hour
+	hourMetricRetention   = 90 * 24 * time.Hour
+
+	// metricRollupLag 采集时间由 Agent 提供，每次重新计算最近几分钟的汇总以纳入迟到的上报
+	metricRollupLag = 5 * time.Minute
+	// metricDeltaLookback 计算网络流量增量时向前查找上一次上报的范围
+	metricDeltaLookback = 5 * time.Minute
+	// metricCleanupInterval 清理过期指标的间隔
+	metricCleanupInterval = time.Hour
+	// defaultMetricPoints 未指定 step 时每条序列的目标点数，step 不小于 Agent 默认的上报间隔
+	defaultMetricPoints = 300
+	minDefaultStep      = 15 * time.Second
+	maxMetricPoints     = 11000
+)
+
+// HostMetricService 保存主机指标的降采样数据并按时间范围查询。
+// 原始数据保留 24 小时，1 分钟汇总保留 7 天，1 小时汇总保留 90 天
+type HostMetricService struct {
+	metricRepo *repository.HostMetricRepository
+	hostRepo   *repository.HostRepository
+	// rolledUntil 上一次汇总完成的时间，只由 Run 所在的 goroutine 访问
+	rolledUntil time.Time
+}
+
+func NewHostMetricService(metricRepo *repository.HostMetricRepository, hostRepo *repository.HostRepository) *HostMetricService {
+	return &HostMetricService{metricRepo: metricRepo, hostRepo: hostRepo}
+}
+
+// HostMetricQuery 查询参数，Step 为 0 时按时间范围自动选择
+type HostMetricQuery struct {
+	Metric string
+	From   time.Time
+	To     time.Time
+	Step   time.Duration
+}
+
+// MetricSeries 一条指标序列，Points 为 [unix 秒, 值]
+type MetricSeries struct {
+	Name   string       `json:"name"`
+	Unit   string       `json:"unit"`
+	Points [][2]float64 `json:"points"`
+}
+
+type HostMetricResult struct {
+	HostID     uuid.UUID      `json:"host_id"`
+	Metric     string         `json:"metric"`
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Step       int            `json:"step"`       // seconds
+	Resolution string         `json:"resolution"` // raw, 1m, 1h
+	Series     []MetricSeries `json:"series"`
+}
+
+// metricPoint 一个时间区间内的指标。汇总时仪表类指标按样本数加权平均，CPUMax 取最大值，
+// 总量取最后一次的值，网络流量为区间内的增量之和
+type metricPoint struct {
+	Time           time.Time
+	Samples        int
+	CPUPercent     float64
+	CPUMax         float64
+	MemPercent     float64
+	MemUsed        float64
+	MemTotal       uint64
+	DiskPercent    float64
+	DiskUsed       float64
+	DiskTotal      uint64
+	NetBytesSent   uint64
+	NetBytesRecv   uint64
+	NetPacketsSent uint64
+	NetPacketsRecv uint64
+	Load1          float64
+	Load5          float64
+	Load15         float64
+}
+
+type metricSeriesDef struct {
+	name  string
+	unit  string
+	value func(p *metricPoint) float64
+}
+
+// hostMetricSeries 每种指标返回的序列，网络流量为每个 step 内的增量
+var hostMetricSeries = map[string][]metricSeriesDef{
+	"cpu": {
+		{"usage_percent", "percent", func(p *metricPoint) float64 { return p.CPUPercent }},
+		{"max_percent", "percent", func(p *metricPoint) float64 { return p.CPUMax }},
+	},
+	"memory": {
+		{"used_percent", "percent", func(p *metricPoint) float64 { return p.MemPercent }},
+		{"used", "bytes", func(p *metricPoint) float64 { return p.MemUsed }},
+		{"total", "bytes", func(p *metricPoint) float64 { return float64(p.MemTotal) }},
+	},
+	"disk": {
+		{"used_percent", "percent", func(p *metricPoint) float64 { return p.DiskPercent }},
+		{"used", "bytes", func(p *metricPoint) float64 { return p.DiskUsed }},
+		{"total", "bytes", func(p *metricPoint) float64 { return float64(p.DiskTotal) }},
+	},
+	"network": {
+		{"bytes_sent", "bytes", func(p *metricPoint) float64 { return float64(p.NetBytesSent) }},
+		{"bytes_recv", "bytes", func(p *metricPoint) float64 { return float64(p.NetBytesRecv) }},
+		{"packets_sent", "packets", func(p *metricPoint) float64 { return float64(p.NetPacketsSent) }},
+		{"packets_recv", "packets", func(p *metricPoint) float64 { return float64(p.NetPacketsRecv) }},
+	},
+	"load": {
+		{"load1", "", func(p *metricPoint) float64 { return p.Load1 }},
+		{"load5", "", func(p *metricPoint) float64 { return p.Load5 }},
+		{"load15", "", func(p *metricPoint) float64 { return p.Load15 }},
+	},
+}
+
+// Query 按时间范围查询主机的指标序列。根据时间范围和 step 选择原始数据、1 分钟或 1 小时汇总
+func (s *HostMetricService) Query(hostID uuid.UUID, q *HostMetricQuery) (*HostMetricResult, error) {
+	defs, ok := hostMetricSeries[q.Metric]
+	if !ok || !q.From.Before(q.To) || q.Step < 0 {
+		return nil, ErrMetricQueryInvalid
+	}
+	if _, err := s.hostRepo.GetByID(hostID); err != nil {
+		return nil, ErrHostNotFound
+	}
+
+	span := q.To.Sub(q.From)
+	step := q.Step
+	if step == 0 {
+		step = maxDuration((span / defaultMetricPoints).Round(time.Second), minDefaultStep)
+	}
+	age := time.Since(q.From)
+	resolution := "raw"
+	switch {
+	case step >= time.Hour || age > minuteMetricRetention:
+		resolution = "1h"
+		step = maxDuration(step, time.Hour)
+	case step >= time.Minute || age > rawMetricRetention:
+		resolution = "1m"
+		step = maxDuration(step, time.Minute)
+	default:
+		step = maxDuration(step, time.Second)
+	}
+	if span/step > maxMetricPoints {
+		return nil, ErrMetricQueryInvalid
+	}
+
+	var points []metricPoint
+	switch resolution {
+	case "raw":
+		metrics, err := s.metricRepo.ListRaw(&hostID, q.From.Add(-metricDeltaLookback), q.To)
+		if err != nil {
+			return nil, err
+		}
+		points = rawMetricPoints(metrics)
+	case "1m":
+		rollups, err := s.metricRepo.ListRollups(&hostID, 60, q.From.Truncate(time.Minute), q.To)
+		if err != nil {
+			return nil, err
+		}
+		points = rollupMetricPoints(rollups)
+	default:
+		rollups, err := s.metricRepo.ListRollups(&hostID, 3600, q.From.Truncate(time.Hour), q.To)
+		if err != nil {
+			return nil, err
+		}
+		points = rollupMetricPoints(rollups)
+	}
+
+	from := q.From.Truncate(step)
+	series := make([]MetricSeries, len(defs))
+	for i, def := range defs {
+		series[i] = MetricSeries{Name: def.name, Unit: def.unit, Points: [][2]float64{}}
+	}
+	for _, p := range aggregateMetricPoints(points, step) {
+		if p.Time.Before(from) {
+			continue
+		}
+		for i, def := range defs {
+			series[i].Points = append(series[i].Points, [2]float64{float64(p.Time.Unix()), def.value(&p)})
+		}
+	}
+
+	return &HostMetricResult{
+		HostID:     hostID,
+		Metric:     q.Metric,
+		From:       q.From,
+		To:         q.To,
+		Step:       int(step / time.Second),
+		Resolution: resolution,
+		Series:     series,
+	}, nil
+}
+
+// Run 每分钟将原始指标汇总为 1 分钟和 1 小时的数据，每小时清理过期指标，直到 ctx 结束
+func (s *HostMetricService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	var lastCleanup time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.rollup(now); err != nil {
+				log.Printf("Failed to roll up host metrics: %v", err)
+			}
+			if now.Sub(lastCleanup) >= metricCleanupInterval {
+				s.cleanup(now)
+				lastCleanup = now
+			}
+		}
+	}
+}
+
+// rollup 重新计算最近几分钟的 1 分钟汇总以及所在小时的 1 小时汇总。
+// 服务启动后的第一次汇总从最后一条 1 分钟汇总开始补算停止期间遗漏的部分，最多补算原始数据的保留期
+func (s *HostMetricService) rollup(now time.Time) error {
+	end := now.Truncate(time.Minute)
+	start := end.Add(-metricRollupLag)
+	if s.rolledUntil.IsZero() {
+		last, err := s.metricRepo.LatestRollup(60)
+		if err != nil {
+			return err
+		}
+		if last == nil {
+			start = end.Add(-rawMetricRetention)
+		} else if last.Before(start) {
+			start = *last
+		}
+	} else if s.rolledUntil.Add(-metricRollupLag).Before(start) {
+		start = s.rolledUntil.Add(-metricRollupLag)
+	}
+	if floor := end.Add(-rawMetricRetention); start.Before(floor) {
+		start = floor
+	}
+
+	// 补算时按小时分批，避免一次加载过多原始数据
+	for from := start; from.Before(end); from = from.Add(time.Hour) {
+		to := from.Add(time.Hour)
+		if to.After(end) {
+			to = end
+		}
+		if err := s.rollupMinutes(from, to); err != nil {
+			return err
+		}
+	}
+	for hour := start.Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		if err := s.rollupHour(hour); err != nil {
+			return err
+		}
+	}
+	s.rolledUntil = end
+	return nil
+}
+
+// rollupMinutes 将 [from, to) 的原始指标汇总为 1 分钟数据
+func (s *HostMetricService) rollupMinutes(from, to time.Time) error {
+	metrics, err := s.metricRepo.ListRaw(nil, from.Add(-metricDeltaLookback), to)
+	if err != nil {
+		return err
+	}
+	var rollups []model.HostMetricRollup
+	for i := 0; i < len(metrics); {
+		j := i
+		for j < len(metrics) && *metrics[j].HostID == *metrics[i].HostID {
+			j++
+		}
+		for _, p := range aggregateMetricPoints(rawMetricPoints(metrics[i:j]), time.Minute) {
+			if !p.Time.Before(from) {
+				rollups = append(rollups, p.rollup(*metrics[i].HostID, 60))
+			}
+		}
+		i = j
+	}
+	return s.metricRepo.SaveRollups(rollups)
+}
+
+// rollupHour 将 hour 开始的一小时内的 1 分钟数据汇总为 1 小时数据
+func (s *HostMetricService) rollupHour(hour time.Time) error {
+	minutes, err := s.metricRepo.ListRollups(nil, 60, hour, hour.Add(time.Hour))
+	if err != nil {
+		return err
+	}
+	var rollups []model.HostMetricRollup
+	for i := 0; i < len(minutes); {
+		j := i
+		for j < len(minutes) && minutes[j].HostID == minutes[i].HostID {
+			j++
+		}
+		for _, p := range aggregateMetricPoints(rollupMetricPoints(minutes[i:j]), time.Hour) {
+			rollups = append(rollups, p.rollup(minutes[i].HostID, 3600))
+		}
+		i = j
+	}
+	return s.metricRepo.SaveRollups(rollups)
+}
+
+func (s *HostMetricService) cleanup(now time.Time) {
+	if _, err := s.metricRepo.DeleteRawBefore(now.Add(-rawMetricRetention)); err != nil {
+		log.Printf("Failed to clean up raw host metrics: %v", err)
+	}
+	if _, err := s.metricRepo.DeleteRollupsBefore(60, now.Add(-minuteMetricRetention)); err != nil {
+		log.Printf("Failed to clean up 1m host metrics: %v", err)
+	}
+	if _, err := s.metricRepo.DeleteRollupsBefore(3600, now.Add(-hourMetricRetention)); err != nil {
+		log.Printf("Failed to clean up 1h host metrics: %v", err)
+	}
+}
+
+// rawMetricPoints 将同一主机按时间排序的原始指标转换为数据点，网络流量为与上一次上报的差值。
+// 第一条上报没有可比较的前值，流量记为 0；计数器变小（Agent 所在主机重启）时以当前值作为增量
+func rawMetricPoints(metrics []model.HostMetric) []metricPoint {
+	points := make([]metricPoint, 0, len(metrics))
+	for i, m := range metrics {
+		p := metricPoint{
+			Time:        m.CollectedAt,
+			Samples:     1,
+			CPUPercent:  m.CPUPercent,
+			CPUMax:      m.CPUPercent,
+			MemPercent:  m.MemPercent,
+			MemUsed:     float64(m.MemUsed),
+			MemTotal:    m.MemTotal,
+			DiskPercent: m.DiskPercent,
+			DiskUsed:    float64(m.DiskUsed),
+			DiskTotal:   m.DiskTotal,
+			Load1:       m.Load1,
+			Load5:       m.Load5,
+			Load15:      m.Load15,
+		}
+		if i > 0 {
+			prev := metrics[i-1]
+			p.NetBytesSent = counterDelta(prev.NetBytesSent, m.NetBytesSent)
+			p.NetBytesRecv = counterDelta(prev.NetBytesRecv, m.NetBytesRecv)
+			p.NetPacketsSent = counterDelta(prev.NetPacketsSent, m.NetPacketsSent)
+			p.NetPacketsRecv = counterDelta(prev.NetPacketsRecv, m.NetPacketsRecv)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func rollupMetricPoints(rollups []model.HostMetricRollup) []metricPoint {
+	points := make([]metricPoint, 0, len(rollups))
+	for _, r := range rollups {
+		points = append(points, metricPoint{
+			Time:           r.BucketStart,
+			Samples:        r.Samples,
+			CPUPercent:     r.CPUPercent,
+			CPUMax:         r.CPUMax,
+			MemPercent:     r.MemPercent,
+			MemUsed:        float64(r.MemUsed),
+			MemTotal:       r.MemTotal,
+			DiskPercent:    r.DiskPercent,
+			DiskUsed:       float64(r.DiskUsed),
+			DiskTotal:      r.DiskTotal,
+			NetBytesSent:   r.NetBytesSent,
+			NetBytesRecv:   r.NetBytesRecv,
+			NetPacketsSent: r.NetPacketsSent,
+			NetPacketsRecv: r.NetPacketsRecv,
+			Load1:          r.Load1,
+			Load5:          r.Load5,
+			Load15:         r.Load15,
+		})
+	}
+	return points
+}
+
+// aggregateMetricPoints 将按时间排序的数据点按 step 对齐汇总
+func aggregateMetricPoints(points []metricPoint, step time.Duration) []metricPoint {
+	var out []metricPoint
+	for _, p := range points {
+		bucket := p.Time.Truncate(step)
+		if len(out) == 0 || !out[len(out)-1].Time.Equal(bucket) {
+			out = append(out, metricPoint{Time: bucket})
+		}
+		out[len(out)-1].add(&p)
+	}
+	for i := range out {
+		out[i].finish()
+	}
+	return out
+}
+
+// add 累加一个数据点，仪表类指标先按样本数加权求和，由 finish 计算平均值
+func (a *metricPoint) add(p *metricPoint) {
+	w := float64(p.Samples)
+	a.Samples += p.Samples
+	a.CPUPercent += p.CPUPercent * w
+	a.MemPercent += p.MemPercent * w
+	a.MemUsed += p.MemUsed * w
+	a.DiskPercent += p.DiskPercent * w
+	a.DiskUsed += p.DiskUsed * w
+	a.Load1 += p.Load1 * w
+	a.Load5 += p.Load5 * w
+	a.Load15 += p.Load15 * w
+	if p.CPUMax > a.CPUMax {
+		a.CPUMax = p.CPUMax
+	}
+	a.MemTotal = p.MemTotal
+	a.DiskTotal = p.DiskTotal
+	a.NetBytesSent += p.NetBytesSent
+	a.NetBytesRecv += p.NetBytesRecv
+	a.NetPacketsSent += p.NetPacketsSent
+	a.NetPacketsRecv += p.NetPacketsRecv
+}
+
+func (a *metricPoint) finish() {
+	if a.Samples == 0 {
+		return
+	}
+	n := float64(a.Samples)
+	a.CPUPercent /= n
+	a.MemPercent /= n
+	a.MemUsed /= n
+	a.DiskPercent /= n
+	a.DiskUsed /= n
+	a.Load1 /= n
+	a.Load5 /= n
+	a.Load15 /= n
+}
+
+func (a *metricPoint) rollup(hostID uuid.UUID, resolution int) model.HostMetricRollup {
+	return model.HostMetricRollup{
+		HostID:         hostID,
+		Resolution:     resolution,
+		BucketStart:    a.Time,
+		Samples:        a.Samples,
+		CPUPercent:     a.CPUPercent,
+		CPUMax:         a.CPUMax,
+		MemPercent:     a.MemPercent,
+		MemUsed:        uint64(a.MemUsed),
+		MemTotal:       a.MemTotal,
+		DiskPercent:    a.DiskPercent,
+		DiskUsed:       uint64(a.DiskUsed),
+		DiskTotal:      a.DiskTotal,
+		NetBytesSent:   a.NetBytesSent,
+		NetBytesRecv:   a.NetBytesRecv,
+		NetPacketsSent: a.NetPacketsSent,
+		NetPacketsRecv: a.NetPacketsRecv,
+		Load1:          a.Load1,
+		Load5:          a.Load5,
+		Load15:         a.Load15,
+	}
+}
+
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
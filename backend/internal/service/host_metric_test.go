@@ -0,0 +1,108 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"devops/internal/model"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+	}{
+		{name: "increase", prev: 100, cur: 250, want: 150},
+		{name: "unchanged", prev: 100, cur: 100, want: 0},
+		{name: "counter reset", prev: 1000, cur: 40, want: 40},
+		{name: "near wraparound", prev: math.MaxUint64 - 10, cur: math.MaxUint64, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterDelta(tt.prev, tt.cur); got != tt.want {
+				t.Errorf("counterDelta(%d, %d) = %d, want %d", tt.prev, tt.cur, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateMetricPoints(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name   string
+		points []metricPoint
+		step   time.Duration
+		want   []metricPoint
+	}{
+		{name: "no points", step: time.Minute, want: nil},
+		{
+			name: "averages gauges and sums counters per bucket",
+			points: []metricPoint{
+				{Time: at(5), Samples: 1, CPUPercent: 10, CPUMax: 10, MemPercent: 40, MemTotal: 100, NetBytesSent: 5, Load1: 1},
+				{Time: at(35), Samples: 1, CPUPercent: 30, CPUMax: 30, MemPercent: 60, MemTotal: 200, NetBytesSent: 7, Load1: 3},
+				{Time: at(65), Samples: 1, CPUPercent: 50, CPUMax: 50, MemPercent: 20, MemTotal: 200, NetBytesRecv: 9, Load1: 2},
+			},
+			step: time.Minute,
+			want: []metricPoint{
+				{Time: at(0), Samples: 2, CPUPercent: 20, CPUMax: 30, MemPercent: 50, MemTotal: 200, NetBytesSent: 12, Load1: 2},
+				{Time: at(60), Samples: 1, CPUPercent: 50, CPUMax: 50, MemPercent: 20, MemTotal: 200, NetBytesRecv: 9, Load1: 2},
+			},
+		},
+		{
+			name: "weights rollups by sample count",
+			points: []metricPoint{
+				{Time: at(0), Samples: 3, CPUPercent: 10, CPUMax: 80, DiskPercent: 50},
+				{Time: at(60), Samples: 1, CPUPercent: 50, CPUMax: 60, DiskPercent: 90},
+			},
+			step: time.Hour,
+			want: []metricPoint{
+				{Time: at(0), Samples: 4, CPUPercent: 20, CPUMax: 80, DiskPercent: 60},
+			},
+		},
+		{
+			name: "skips empty buckets",
+			points: []metricPoint{
+				{Time: at(10), Samples: 1, Load5: 1},
+				{Time: at(190), Samples: 1, Load5: 3},
+			},
+			step: time.Minute,
+			want: []metricPoint{
+				{Time: at(0), Samples: 1, Load5: 1},
+				{Time: at(180), Samples: 1, Load5: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateMetricPoints(tt.points, tt.step)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregateMetricPoints =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawMetricPointsNetworkDelta(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	metrics := []model.HostMetric{
+		{CollectedAt: base, NetBytesSent: 1000, NetBytesRecv: 500},
+		{CollectedAt: base.Add(15 * time.Second), NetBytesSent: 1600, NetBytesRecv: 800},
+		{CollectedAt: base.Add(30 * time.Second), NetBytesSent: 100, NetBytesRecv: 50}, // 主机重启
+	}
+	var sent, recv []uint64
+	for _, p := range rawMetricPoints(metrics) {
+		sent = append(sent, p.NetBytesSent)
+		recv = append(recv, p.NetBytesRecv)
+	}
+	if want := []uint64{0, 600, 100}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+	if want := []uint64{0, 300, 50}; !reflect.DeepEqual(recv, want) {
+		t.Errorf("recv = %v, want %v", recv, want)
+	}
+}
@@ -440,6 +440,12 @@ func (s *K8sService) GetServices(id uuid.UUID, namespace string) ([]model.K8sRes
 }
 
 func (s *K8sService) ApplyYAML(id uuid.UUID, yamlText, defaultNamespace string, dryRun bool, action string, createdBy uuid.UUID, username string) ([]ApplyYAMLResult, error) {
+	return s.applyYAML(context.Background(), id, yamlText, defaultNamespace, dryRun, action, "devops-ui", createdBy, username)
+}
+
+// applyYAML 以 server-side apply 提交 yaml 中的资源。fieldManager 区分字段的管理方，
+// 只提交部分字段的调用方需使用独立的 fieldManager，避免清除其他管理方设置的字段
+func (s *K8sService) applyYAML(ctx context.Context, id uuid.UUID, yamlText, defaultNamespace string, dryRun bool, action, fieldManager string, createdBy uuid.UUID, username string) ([]ApplyYAMLResult, error) {
 	if action == "" {
 		action = "apply"
 	}
@@ -460,7 +466,6 @@ func (s *K8sService) ApplyYAML(id uuid.UUID, yamlText, defaultNamespace string,
 
 	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
 	decoder := yamlutil.NewYAMLOrJSONDecoder(strings.NewReader(yamlText), 4096)
-	ctx := context.Background()
 
 	var results []ApplyYAMLResult
 
@@ -522,7 +527,7 @@ func (s *K8sService) ApplyYAML(id uuid.UUID, yamlText, defaultNamespace string,
 
 			force := true
 			patchOptions := metav1.PatchOptions{
-				FieldManager: "devops-ui",
+				FieldManager: fieldManager,
 				Force:        &force,
 			}
 			if dryRun {
@@ -728,6 +733,20 @@ func (s *K8sService) getClientByClusterID(id uuid.UUID) (*kubernetes.Clientset,
 	return client, nil
 }
 
+func (s *K8sService) getDynamicClientByClusterID(id uuid.UUID) (dynamic.Interface, error) {
+	config, err := s.getRestConfigByClusterID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("create dynamic client: %w", err)
+	}
+
+	return client, nil
+}
+
 func (s *K8sService) getRestConfig(kubeconfig string) (*rest.Config, error) {
 	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
 	if err != nil {
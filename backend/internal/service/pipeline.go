@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPipelineNotFound      = errors.New("pipeline not found")
+	ErrPipelineInvalid       = errors.New("invalid pipeline")
+	ErrPipelineRunNotFound   = errors.New("pipeline run not found")
+	ErrPipelineRunFinished   = errors.New("pipeline run has already finished")
+	ErrPipelineRunNotWaiting = errors.New("pipeline run is not waiting for confirmation")
+)
+
+// PipelineService 管理应用的流水线定义及其版本历史，并执行流水线。
+// 流水线的 deploy 步骤通过 DeploymentService 创建和执行部署，因此同样受审批、封版和部署队列约束
+type PipelineService struct {
+	pipelineRepo  *repository.PipelineRepository
+	historyRepo   *repository.PipelineHistoryRepository
+	runRepo       *repository.PipelineRunRepository
+	appRepo       *repository.AppRepository
+	envRepo       *repository.EnvRepository
+	deployRepo    *repository.DeploymentRepository
+	deployService *DeploymentService
+	k8sService    *K8sService
+	workspace     string
+
+	mu   sync.Mutex
+	runs map[uuid.UUID]*pipelineRunState
+}
+
+func NewPipelineService(
+	pipelineRepo *repository.PipelineRepository,
+	historyRepo *repository.PipelineHistoryRepository,
+	runRepo *repository.PipelineRunRepository,
+	appRepo *repository.AppRepository,
+	envRepo *repository.EnvRepository,
+	deployRepo *repository.DeploymentRepository,
+	deployService *DeploymentService,
+	k8sService *K8sService,
+	workspace string,
+) *PipelineService {
+	return &PipelineService{
+		pipelineRepo:  pipelineRepo,
+		historyRepo:   historyRepo,
+		runRepo:       runRepo,
+		appRepo:       appRepo,
+		envRepo:       envRepo,
+		deployRepo:    deployRepo,
+		deployService: deployService,
+		k8sService:    k8sService,
+		workspace:     workspace,
+		runs:          make(map[uuid.UUID]*pipelineRunState),
+	}
+}
+
+// pipelineRunState 执行中的流水线，gate 用于传递人工确认的结果
+type pipelineRunState struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	gate   chan pipelineGateDecision
+}
+
+type pipelineGateDecision struct {
+	Approved bool
+	Username string
+	Comment  string
+}
+
+func (s *PipelineService) Get(appID uuid.UUID) (*model.Pipeline, error) {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return nil, ErrAppNotFound
+	}
+	pipeline, err := s.pipelineRepo.GetByApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline == nil {
+		return nil, ErrPipelineNotFound
+	}
+	return pipeline, nil
+}
+
+type SavePipelineRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// Save 校验并保存流水线定义，每次保存递增版本号并记录历史
+func (s *PipelineService) Save(appID uuid.UUID, req *SavePipelineRequest, updatedBy uuid.UUID, username string) (*model.Pipeline, error) {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return nil, ErrAppNotFound
+	}
+	if _, err := ParsePipelineSpec(req.Content); err != nil {
+		return nil, err
+	}
+
+	pipeline, err := s.pipelineRepo.GetByApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	action := "update"
+	if pipeline == nil {
+		action = "create"
+		pipeline = &model.Pipeline{AppID: appID, CreatedBy: updatedBy}
+	}
+	pipeline.Content = req.Content
+	pipeline.Version++
+	pipeline.UpdatedBy = updatedBy
+
+	if err := s.pipelineRepo.Save(pipeline); err != nil {
+		return nil, err
+	}
+
+	history := &model.PipelineHistory{
+		PipelineID: pipeline.ID,
+		AppID:      appID,
+		Content:    pipeline.Content,
+		Version:    pipeline.Version,
+		Action:     action,
+		CreatedBy:  updatedBy,
+		Username:   username,
+	}
+	if err := s.historyRepo.Create(history); err != nil {
+		log.Printf("Failed to create pipeline history: %v", err)
+	}
+
+	return pipeline, nil
+}
+
+func (s *PipelineService) GetHistory(appID uuid.UUID, limit int) ([]model.PipelineHistory, error) {
+	return s.historyRepo.ListByApp(appID, limit)
+}
+
+func (s *PipelineService) GetVersion(appID uuid.UUID, version int) (*model.PipelineHistory, error) {
+	history, err := s.historyRepo.GetByVersion(appID, version)
+	if err != nil {
+		return nil, ErrPipelineNotFound
+	}
+	return history, nil
+}
+
+type RunPipelineRequest struct {
+	Version   string            `json:"version"`
+	Branch    string            `json:"branch"`
+	CommitID  string            `json:"commit_id"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Run 以当前流水线定义创建一次执行并在后台运行，trigger 为 manual 或 webhook
+func (s *PipelineService) Run(appID uuid.UUID, req *RunPipelineRequest, trigger string, createdBy uuid.UUID) (*model.PipelineRun, error) {
+	app, err := s.appRepo.GetByID(appID)
+	if err != nil {
+		return nil, ErrAppNotFound
+	}
+	pipeline, err := s.Get(appID)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParsePipelineSpec(pipeline.Content)
+	if err != nil {
+		return nil, err
+	}
+	for name := range req.Variables {
+		if !pipelineVarName.MatchString(name) {
+			return nil, fmt.Errorf("%w: invalid variable name %q", ErrPipelineInvalid, name)
+		}
+	}
+
+	branch := req.Branch
+	if branch == "" {
+		branch = app.Branch
+	}
+	variables, _ := json.Marshal(req.Variables)
+	run := &model.PipelineRun{
+		AppID:           appID,
+		PipelineVersion: pipeline.Version,
+		Content:         pipeline.Content,
+		Version:         req.Version,
+		Branch:          branch,
+		CommitID:        req.CommitID,
+		Trigger:         trigger,
+		Variables:       string(variables),
+		Status:          0, // pending
+		Steps:           encodeStepResults(initialStepResults(spec)),
+		CreatedBy:       createdBy,
+	}
+	if err := s.runRepo.Create(run); err != nil {
+		return nil, err
+	}
+
+	state := s.addRun(run.ID)
+	go s.execute(state, run, app, spec, req.Variables)
+
+	return s.runRepo.GetByID(run.ID)
+}
+
+func (s *PipelineService) ListRuns(appID uuid.UUID, page, pageSize int) ([]model.PipelineRun, int64, error) {
+	return s.runRepo.List(appID, page, pageSize)
+}
+
+func (s *PipelineService) GetRun(id uuid.UUID) (*model.PipelineRun, error) {
+	run, err := s.runRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrPipelineRunNotFound
+	}
+	return run, nil
+}
+
+// CancelRun 取消流水线，正在执行的部署也会被取消
+func (s *PipelineService) CancelRun(id uuid.UUID) error {
+	run, err := s.runRepo.GetByID(id)
+	if err != nil {
+		return ErrPipelineRunNotFound
+	}
+	if run.Status != 0 && run.Status != 1 && run.Status != 4 {
+		return ErrPipelineRunFinished
+	}
+
+	if state := s.getRun(id); state != nil {
+		state.cancel()
+		return nil
+	}
+	// 服务重启后遗留的流水线已不在执行，直接标记为取消
+	return s.runRepo.Finish(id, 5, time.Now())
+}
+
+// Approve 通过等待中的人工确认步骤，流水线继续执行
+func (s *PipelineService) Approve(id uuid.UUID, username, comment string) error {
+	return s.decideGate(id, pipelineGateDecision{Approved: true, Username: username, Comment: comment})
+}
+
+// Reject 驳回等待中的人工确认步骤，该步骤失败
+func (s *PipelineService) Reject(id uuid.UUID, username, comment string) error {
+	return s.decideGate(id, pipelineGateDecision{Approved: false, Username: username, Comment: comment})
+}
+
+func (s *PipelineService) decideGate(id uuid.UUID, decision pipelineGateDecision) error {
+	run, err := s.runRepo.GetByID(id)
+	if err != nil {
+		return ErrPipelineRunNotFound
+	}
+	state := s.getRun(id)
+	if run.Status != 4 || state == nil {
+		return ErrPipelineRunNotWaiting
+	}
+
+	select {
+	case state.gate <- decision:
+		return nil
+	default:
+		return ErrPipelineRunNotWaiting
+	}
+}
+
+func (s *PipelineService) addRun(id uuid.UUID) *pipelineRunState {
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &pipelineRunState{ctx: ctx, cancel: cancel, gate: make(chan pipelineGateDecision)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[id] = state
+	return state
+}
+
+func (s *PipelineService) getRun(id uuid.UUID) *pipelineRunState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[id]
+}
+
+func (s *PipelineService) removeRun(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.runs[id]; ok {
+		state.cancel()
+		delete(s.runs, id)
+	}
+}
+
+func initialStepResults(spec *PipelineSpec) []model.PipelineStepResult {
+	var results []model.PipelineStepResult
+	for _, stage := range spec.Stages {
+		for _, step := range stage.Steps {
+			results = append(results, model.PipelineStepResult{
+				Stage:  stage.Name,
+				Step:   step.Name,
+				Type:   step.Type,
+				Env:    stage.Env,
+				Status: "pending",
+			})
+		}
+	}
+	return results
+}
+
+func encodeStepResults(results []model.PipelineStepResult) string {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
@@ -0,0 +1,584 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+const (
+	pipelineOutputLimit   = 64 << 10
+	pipelineDeployPolling = 2 * time.Second
+)
+
+// pipelineExecution 一次流水线执行的上下文
+type pipelineExecution struct {
+	s       *PipelineService
+	state   *pipelineRunState
+	run     *model.PipelineRun
+	app     *model.Application
+	spec    *PipelineSpec
+	vars    map[string]string
+	results []model.PipelineStepResult
+	workdir string // worker_shell 共用的工作目录，首次使用时创建
+}
+
+// execute 按顺序执行各阶段的步骤。有步骤失败后，后续步骤只在 when 为 on_failure 或 always 时执行；
+// 流水线被取消时，剩余步骤标记为 cancelled
+func (s *PipelineService) execute(state *pipelineRunState, run *model.PipelineRun, app *model.Application, spec *PipelineSpec, variables map[string]string) {
+	e := &pipelineExecution{
+		s:       s,
+		state:   state,
+		run:     run,
+		app:     app,
+		spec:    spec,
+		vars:    pipelineVars(run, app, spec, variables),
+		results: initialStepResults(spec),
+	}
+	defer s.removeRun(run.ID)
+	defer e.cleanup()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Pipeline run %s panicked: %v", run.ID, r)
+			if err := s.runRepo.Finish(run.ID, 3, time.Now()); err != nil {
+				log.Printf("Failed to finish pipeline run %s: %v", run.ID, err)
+			}
+		}
+	}()
+
+	if err := s.runRepo.Start(run.ID, time.Now()); err != nil {
+		log.Printf("Failed to start pipeline run %s: %v", run.ID, err)
+	}
+
+	stageConds, stepConds, ok := e.parseConditions()
+	if !ok {
+		e.save()
+		if err := s.runRepo.Finish(run.ID, 3, time.Now()); err != nil {
+			log.Printf("Failed to finish pipeline run %s: %v", run.ID, err)
+		}
+		return
+	}
+
+	failed := false
+	i := 0
+	for si, stage := range spec.Stages {
+		stage := stage
+		vars := e.stageVars(&stage)
+		runStage := stageConds[si].Match(vars, failed)
+
+		for sj, step := range stage.Steps {
+			step := step
+			result := &e.results[i]
+			i++
+
+			if state.ctx.Err() != nil {
+				result.Status = "cancelled"
+				continue
+			}
+			if !runStage || !stepConds[si][sj].Match(vars, failed) {
+				result.Status = "skipped"
+				continue
+			}
+
+			start := time.Now()
+			result.StartTime = &start
+			result.Status = "running"
+			e.save()
+
+			out := &tailBuffer{limit: pipelineOutputLimit}
+			err := e.runStep(&stage, &step, vars, result, out)
+			end := time.Now()
+			result.EndTime = &end
+			result.Output = out.String()
+			switch {
+			case err == nil:
+				result.Status = "success"
+			case state.ctx.Err() != nil:
+				result.Status = "cancelled"
+			default:
+				result.Status = "failed"
+				result.Error = err.Error()
+				failed = true
+			}
+			e.save()
+		}
+	}
+	e.save()
+
+	status := 2 // success
+	if state.ctx.Err() != nil {
+		status = 5 // cancelled
+	} else if failed {
+		status = 3 // failed
+	}
+	if err := s.runRepo.Finish(run.ID, status, time.Now()); err != nil {
+		log.Printf("Failed to finish pipeline run %s: %v", run.ID, err)
+	}
+}
+
+// parseConditions 解析各阶段和步骤的 when 条件。定义在保存时已校验，出错说明存储的定义不符合当前规则：
+// 条件无效的步骤（阶段条件无效时为该阶段的第一个步骤）标记为失败，其余步骤跳过，不执行任何步骤
+func (e *pipelineExecution) parseConditions() (stages []*whenCondition, steps [][]*whenCondition, ok bool) {
+	fail := func(index int, err error) ([]*whenCondition, [][]*whenCondition, bool) {
+		log.Printf("Pipeline run %s has an invalid when condition: %v", e.run.ID, err)
+		for i := range e.results {
+			e.results[i].Status = "skipped"
+		}
+		if index < len(e.results) {
+			e.results[index].Status = "failed"
+			e.results[index].Error = err.Error()
+		}
+		return nil, nil, false
+	}
+
+	i := 0
+	for _, stage := range e.spec.Stages {
+		cond, err := parseWhen(stage.When)
+		if err != nil {
+			return fail(i, fmt.Errorf("stage %s: %v", stage.Name, err))
+		}
+		stages = append(stages, cond)
+
+		conds := make([]*whenCondition, len(stage.Steps))
+		for j, step := range stage.Steps {
+			if conds[j], err = parseWhen(step.When); err != nil {
+				return fail(i+j, fmt.Errorf("step %s: %v", step.Name, err))
+			}
+		}
+		steps = append(steps, conds)
+		i += len(stage.Steps)
+	}
+	return stages, steps, true
+}
+
+func (e *pipelineExecution) save() {
+	if err := e.s.runRepo.UpdateSteps(e.run.ID, encodeStepResults(e.results)); err != nil {
+		log.Printf("Failed to save pipeline run %s steps: %v", e.run.ID, err)
+	}
+}
+
+func (e *pipelineExecution) cleanup() {
+	if e.workdir != "" {
+		os.RemoveAll(e.workdir)
+	}
+}
+
+// pipelineVars 流水线变量：定义中的 variables 被执行时传入的同名变量覆盖，
+// 内置变量 app、branch、version、commit、trigger 优先级最高
+func pipelineVars(run *model.PipelineRun, app *model.Application, spec *PipelineSpec, variables map[string]string) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range spec.Variables {
+		vars[k] = v
+	}
+	for k, v := range variables {
+		vars[k] = v
+	}
+	vars["app"] = app.Code
+	vars["branch"] = run.Branch
+	vars["version"] = run.Version
+	vars["commit"] = run.CommitID
+	vars["trigger"] = run.Trigger
+	return vars
+}
+
+// stageVars 在流水线变量基础上加入阶段的 env，以及阶段目标应用的 app
+func (e *pipelineExecution) stageVars(stage *PipelineStage) map[string]string {
+	vars := make(map[string]string, len(e.vars)+1)
+	for k, v := range e.vars {
+		vars[k] = v
+	}
+	vars["env"] = stage.Env
+	if stage.App != "" {
+		vars["app"] = stage.App
+	}
+	return vars
+}
+
+// shellEnv 传给 shell 步骤的环境变量
+func (e *pipelineExecution) shellEnv(vars map[string]string) map[string]string {
+	env := make(map[string]string)
+	for k, v := range vars {
+		switch k {
+		case "app", "env", "branch", "version", "commit", "trigger":
+		default:
+			env[k] = v
+		}
+	}
+	env["PIPELINE_RUN_ID"] = e.run.ID.String()
+	env["PIPELINE_TRIGGER"] = vars["trigger"]
+	env["APP_CODE"] = vars["app"]
+	env["DEPLOY_ENV"] = vars["env"]
+	env["DEPLOY_VERSION"] = vars["version"]
+	env["DEPLOY_BRANCH"] = vars["branch"]
+	env["DEPLOY_COMMIT"] = vars["commit"]
+	return env
+}
+
+// expand 替换文本中的 ${变量}，未定义的变量保持原样
+func expand(text string, vars map[string]string) string {
+	return os.Expand(text, func(name string) string {
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return "${" + name + "}"
+	})
+}
+
+func (e *pipelineExecution) runStep(stage *PipelineStage, step *PipelineStep, vars map[string]string, result *model.PipelineStepResult, out io.Writer) error {
+	ctx, cancel := context.WithTimeout(e.state.ctx, step.timeout())
+	defer cancel()
+
+	target, err := e.stageApp(stage)
+	if err != nil {
+		return err
+	}
+
+	switch step.Type {
+	case "host_shell":
+		err = e.runHostShell(ctx, target, step, vars, out)
+	case "worker_shell":
+		err = e.runWorkerShell(ctx, step, vars, out)
+	case "k8s_apply":
+		err = e.runK8sApply(ctx, target, step, vars, out)
+	case "http":
+		err = runHTTPStep(ctx, step, vars, out)
+	case "manual":
+		err = e.waitGate(ctx, step, result, out)
+	case "deploy":
+		err = e.runDeploy(ctx, stage, target, result, out)
+	default:
+		err = fmt.Errorf("unknown step type %q", step.Type)
+	}
+
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && e.state.ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s", step.timeout())
+	}
+	return err
+}
+
+// stageApp 阶段的目标应用，未指定 app 时为流水线所属应用
+func (e *pipelineExecution) stageApp(stage *PipelineStage) (*model.Application, error) {
+	if stage.App == "" || stage.App == e.app.Code {
+		return e.app, nil
+	}
+	app, err := e.s.appRepo.GetByCode(stage.App)
+	if err != nil {
+		return nil, fmt.Errorf("application %s not found", stage.App)
+	}
+	return app, nil
+}
+
+// runHostShell 依次在应用的每台主机上执行命令
+func (e *pipelineExecution) runHostShell(ctx context.Context, app *model.Application, step *PipelineStep, vars map[string]string, out io.Writer) error {
+	if len(app.Hosts) == 0 {
+		return fmt.Errorf("application %s has no hosts", app.Code)
+	}
+
+	env := e.shellEnv(vars)
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var assignments strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&assignments, "%s=%s ", k, shellQuote(env[k]))
+	}
+	command := assignments.String() + "bash -c " + shellQuote(step.Run)
+
+	for i := range app.Hosts {
+		host := &app.Hosts[i]
+		fmt.Fprintf(out, "==== %s (%s) ====\n", host.Name, host.IP)
+		executor, err := newHostExecutor(host)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host.IP, err)
+		}
+		err = executor.ExecuteWithOutput(ctx, command, out)
+		executor.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", host.IP, err)
+		}
+	}
+	return nil
+}
+
+// runWorkerShell 在平台的工作目录中执行命令。应用配置了仓库时，工作目录为检出的代码
+func (e *pipelineExecution) runWorkerShell(ctx context.Context, step *PipelineStep, vars map[string]string, out io.Writer) error {
+	if err := e.prepareWorkdir(ctx, out); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", step.Run)
+	cmd.Dir = e.workdir
+	cmd.Env = commandEnv(e.shellEnv(vars))
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *pipelineExecution) prepareWorkdir(ctx context.Context, out io.Writer) error {
+	if e.workdir != "" {
+		return nil
+	}
+	if err := os.MkdirAll(e.s.workspace, 0755); err != nil {
+		return fmt.Errorf("create workspace: %w", err)
+	}
+	dir, err := os.MkdirTemp(e.s.workspace, e.app.Code+"-pipeline-")
+	if err != nil {
+		return fmt.Errorf("create workspace: %w", err)
+	}
+	e.workdir = dir
+	if e.app.RepoURL == "" {
+		return nil
+	}
+
+	ref, err := checkoutRef(e.run.CommitID, e.run.Branch)
+	if err != nil {
+		return err
+	}
+	if err := runBuildCmd(ctx, "", out, "git", "clone", "--no-checkout", "--", e.app.RepoURL, dir); err != nil {
+		return fmt.Errorf("git clone: %w", err)
+	}
+	if err := runBuildCmd(ctx, dir, out, "git", "checkout", "--detach", ref, "--"); err != nil {
+		return fmt.Errorf("git checkout %s: %w", ref, err)
+	}
+	return nil
+}
+
+// runK8sApply 以 server-side apply 提交清单，清单中的 ${变量} 会被替换
+func (e *pipelineExecution) runK8sApply(ctx context.Context, app *model.Application, step *PipelineStep, vars map[string]string, out io.Writer) error {
+	var clusterID uuid.UUID
+	switch {
+	case step.Cluster != "":
+		cluster, err := e.s.k8sService.clusterRepo.GetByCode(step.Cluster)
+		if err != nil {
+			return fmt.Errorf("cluster %s not found", step.Cluster)
+		}
+		clusterID = cluster.ID
+	case app.K8sClusterID != nil:
+		clusterID = *app.K8sClusterID
+	default:
+		return fmt.Errorf("cluster is required when application %s has no kubernetes target", app.Code)
+	}
+
+	namespace := step.Namespace
+	if namespace == "" {
+		namespace = app.K8sNamespace
+	}
+	results, err := e.s.k8sService.applyYAML(ctx, clusterID, expand(step.Manifest, vars), namespace, false, "pipeline", "devops-pipeline", e.run.CreatedBy, "")
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Fprintf(out, "%s %s/%s %s\n", r.Action, r.Kind, r.Name, r.Namespace)
+	}
+	return nil
+}
+
+// runHTTPStep 发起 HTTP 请求并检查状态码和响应内容，地址、请求头和请求体中的 ${变量} 会被替换
+func runHTTPStep(ctx context.Context, step *PipelineStep, vars map[string]string, out io.Writer) error {
+	method := step.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(expand(step.Body, vars))
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), expand(step.URL, vars), body)
+	if err != nil {
+		return err
+	}
+	for k, v := range step.Headers {
+		req.Header.Set(k, expand(v, vars))
+	}
+
+	fmt.Fprintf(out, "%s %s\n", req.Method, req.URL)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "HTTP %d\n%s\n", resp.StatusCode, data)
+
+	if step.ExpectStatus != 0 && resp.StatusCode != step.ExpectStatus {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, step.ExpectStatus)
+	}
+	if step.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if step.ExpectBody != "" && !bytes.Contains(data, []byte(expand(step.ExpectBody, vars))) {
+		return fmt.Errorf("response body does not contain %q", step.ExpectBody)
+	}
+	return nil
+}
+
+// waitGate 等待人工确认，等待期间流水线状态为 waiting
+func (e *pipelineExecution) waitGate(ctx context.Context, step *PipelineStep, result *model.PipelineStepResult, out io.Writer) error {
+	if step.Message != "" {
+		fmt.Fprintln(out, step.Message)
+	}
+	result.Status = "waiting"
+	e.save()
+	if err := e.s.runRepo.UpdateStatus(e.run.ID, 4); err != nil { // waiting
+		log.Printf("Failed to update pipeline run %s status: %v", e.run.ID, err)
+	}
+	defer func() {
+		if err := e.s.runRepo.UpdateStatus(e.run.ID, 1); err != nil { // running
+			log.Printf("Failed to update pipeline run %s status: %v", e.run.ID, err)
+		}
+	}()
+
+	select {
+	case decision := <-e.state.gate:
+		verb := "approved"
+		if !decision.Approved {
+			verb = "rejected"
+		}
+		fmt.Fprintf(out, "%s by %s", verb, decision.Username)
+		if decision.Comment != "" {
+			fmt.Fprintf(out, ": %s", decision.Comment)
+		}
+		fmt.Fprintln(out)
+		if !decision.Approved {
+			return fmt.Errorf("rejected by %s", decision.Username)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runDeploy 为阶段的目标应用创建部署并等待其结束。部署需要审批时等待审批通过后再启动
+func (e *pipelineExecution) runDeploy(ctx context.Context, stage *PipelineStage, app *model.Application, result *model.PipelineStepResult, out io.Writer) error {
+	env, err := e.s.envRepo.GetByCode(stage.Env)
+	if err != nil {
+		return fmt.Errorf("environment %s not found", stage.Env)
+	}
+	if app.EnvID == nil || *app.EnvID != env.ID {
+		return fmt.Errorf("application %s does not belong to environment %s", app.Code, stage.Env)
+	}
+
+	deploy, err := e.s.deployService.Create(&CreateDeployRequest{
+		AppID:     app.ID,
+		Version:   e.run.Version,
+		CommitID:  e.run.CommitID,
+		CommitMsg: fmt.Sprintf("pipeline run %s", e.run.ID),
+		Branch:    e.run.Branch,
+	}, e.run.CreatedBy, nil)
+	if err != nil {
+		return err
+	}
+	result.DeploymentID = &deploy.ID
+	e.save()
+	fmt.Fprintf(out, "created deployment %s for %s in %s\n", deploy.ID, app.Code, stage.Env)
+
+	started := false
+	last := -1
+	for {
+		current, err := e.s.deployRepo.GetByID(deploy.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != last {
+			fmt.Fprintf(out, "deployment status: %s\n", deployStatusName(current.Status))
+			last = current.Status
+		}
+
+		switch current.Status {
+		case 0: // pending
+			if started {
+				return fmt.Errorf("deployment %s was removed from the queue", deploy.ID)
+			}
+			started = true
+			if _, err := e.s.deployService.StartDeploy(deploy.ID, nil); err != nil {
+				return err
+			}
+			continue
+		case 2: // success
+			return nil
+		case 3, 5, 7: // failed, cancelled, rejected
+			return fmt.Errorf("deployment %s %s", deploy.ID, deployStatusName(current.Status))
+		}
+
+		select {
+		case <-time.After(pipelineDeployPolling):
+		case <-ctx.Done():
+			if err := e.s.deployService.Cancel(deploy.ID); err != nil && err != ErrDeployFinished {
+				log.Printf("Failed to cancel deployment %s for pipeline run %s: %v", deploy.ID, e.run.ID, err)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func deployStatusName(status int) string {
+	switch status {
+	case 0:
+		return "pending"
+	case 1:
+		return "running"
+	case 2:
+		return "success"
+	case 3:
+		return "failed"
+	case 4:
+		return "queued"
+	case 5:
+		return "cancelled"
+	case 6:
+		return "awaiting_approval"
+	case 7:
+		return "rejected"
+	default:
+		return fmt.Sprintf("status %d", status)
+	}
+}
+
+// tailBuffer 只保留最后 limit 字节的输出
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestPipelineParseConditions(t *testing.T) {
+	stage := func(name, when string, steps ...PipelineStep) PipelineStage {
+		return PipelineStage{Name: name, Type: "build", When: when, Steps: steps}
+	}
+	step := func(name, when string) PipelineStep {
+		return PipelineStep{Name: name, Type: "worker_shell", Run: "true", When: when}
+	}
+
+	tests := []struct {
+		name string
+		// 存储的定义可能早于当前的校验规则，不经 ParsePipelineSpec 直接构造
+		spec         *PipelineSpec
+		wantOK       bool
+		wantStatuses []string
+		wantError    string
+	}{
+		{
+			name: "valid",
+			spec: &PipelineSpec{Stages: []PipelineStage{
+				stage("build", "", step("a", ""), step("b", "always")),
+				stage("deploy", `branch == "main"`, step("c", "on_failure")),
+			}},
+			wantOK:       true,
+			wantStatuses: []string{"pending", "pending", "pending"},
+		},
+		{
+			name: "invalid step condition",
+			spec: &PipelineSpec{Stages: []PipelineStage{
+				stage("build", "", step("a", ""), step("b", "sometimes")),
+				stage("deploy", "", step("c", "always")),
+			}},
+			wantStatuses: []string{"skipped", "failed", "skipped"},
+			wantError:    "step b: invalid when condition",
+		},
+		{
+			name: "invalid stage condition",
+			spec: &PipelineSpec{Stages: []PipelineStage{
+				stage("build", "", step("a", "")),
+				stage("deploy", "branch = main", step("b", ""), step("c", "always")),
+			}},
+			wantStatuses: []string{"skipped", "failed", "skipped"},
+			wantError:    "stage deploy: invalid when condition",
+		},
+		{
+			name: "invalid condition on a stage without steps",
+			spec: &PipelineSpec{Stages: []PipelineStage{
+				stage("build", "", step("a", "")),
+				stage("empty", "1x == y"),
+			}},
+			wantStatuses: []string{"skipped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &pipelineExecution{
+				run:     &model.PipelineRun{ID: uuid.New()},
+				spec:    tt.spec,
+				results: initialStepResults(tt.spec),
+			}
+			stages, steps, ok := e.parseConditions()
+			if ok != tt.wantOK {
+				t.Fatalf("parseConditions ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok {
+				if len(stages) != len(tt.spec.Stages) || len(steps) != len(tt.spec.Stages) {
+					t.Fatalf("conditions for %d/%d stages, want %d", len(stages), len(steps), len(tt.spec.Stages))
+				}
+				for i, s := range tt.spec.Stages {
+					if stages[i] == nil || len(steps[i]) != len(s.Steps) {
+						t.Errorf("stage %s: conditions %+v / %+v", s.Name, stages[i], steps[i])
+					}
+					for j := range steps[i] {
+						if steps[i][j] == nil {
+							t.Errorf("stage %s: step %d has no condition", s.Name, j)
+						}
+					}
+				}
+			}
+
+			var statuses []string
+			var errs []string
+			for _, r := range e.results {
+				statuses = append(statuses, r.Status)
+				if r.Error != "" {
+					errs = append(errs, r.Error)
+				}
+			}
+			if strings.Join(statuses, ",") != strings.Join(tt.wantStatuses, ",") {
+				t.Errorf("statuses = %v, want %v", statuses, tt.wantStatuses)
+			}
+			if tt.wantError != "" && (len(errs) != 1 || !strings.Contains(errs[0], tt.wantError)) {
+				t.Errorf("errors = %q, want one mentioning %q", errs, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// PipelineSpec 流水线定义。阶段按顺序执行，阶段内的步骤也按顺序执行
+type PipelineSpec struct {
+	Variables map[string]string `json:"variables,omitempty"`
+	Stages    []PipelineStage   `json:"stages"`
+}
+
+// PipelineStage 流水线阶段。deploy 阶段需指定目标环境 env，app 为该环境下的应用编码，默认为流水线所属应用
+type PipelineStage struct {
+	Name  string         `json:"name"`
+	Type  string         `json:"type"` // build, test, deploy, verify
+	Env   string         `json:"env,omitempty"`
+	App   string         `json:"app,omitempty"`
+	When  string         `json:"when,omitempty"`
+	Steps []PipelineStep `json:"steps"`
+}
+
+// PipelineStep 流水线步骤，不同类型使用的字段不同
+type PipelineStep struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // host_shell, worker_shell, k8s_apply, http, manual, deploy
+	When    string `json:"when,omitempty"`
+	Timeout int    `json:"timeout,omitempty"` // 秒
+
+	// host_shell 在应用主机上执行，worker_shell 在平台的工作目录中执行
+	Run string `json:"run,omitempty"`
+
+	// k8s_apply，集群为空时使用应用的部署目标集群
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Manifest  string `json:"manifest,omitempty"`
+
+	// http
+	URL          string            `json:"url,omitempty"`
+	Method       string            `json:"method,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	ExpectStatus int               `json:"expect_status,omitempty"` // 0 表示任意 2xx
+	ExpectBody   string            `json:"expect_body,omitempty"`
+
+	// manual
+	Message string `json:"message,omitempty"`
+}
+
+var (
+	pipelineStageTypes = map[string]bool{"build": true, "test": true, "deploy": true, "verify": true}
+	pipelineStepTypes  = map[string]bool{"host_shell": true, "worker_shell": true, "k8s_apply": true, "http": true, "manual": true, "deploy": true}
+	pipelineVarName    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ParsePipelineSpec 解析并校验流水线 YAML，未知字段视为错误
+func ParsePipelineSpec(content string) (*PipelineSpec, error) {
+	var spec PipelineSpec
+	if err := yaml.UnmarshalStrict([]byte(content), &spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPipelineInvalid, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPipelineInvalid, err)
+	}
+	return &spec, nil
+}
+
+func (spec *PipelineSpec) validate() error {
+	if len(spec.Stages) == 0 {
+		return fmt.Errorf("at least one stage is required")
+	}
+	for name := range spec.Variables {
+		if !pipelineVarName.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+
+	stageNames := make(map[string]bool)
+	for i, stage := range spec.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stages[%d]: name is required", i)
+		}
+		if stageNames[stage.Name] {
+			return fmt.Errorf("stage %s: duplicate name", stage.Name)
+		}
+		stageNames[stage.Name] = true
+		if !pipelineStageTypes[stage.Type] {
+			return fmt.Errorf("stage %s: unknown type %q", stage.Name, stage.Type)
+		}
+		if stage.Type == "deploy" && stage.Env == "" {
+			return fmt.Errorf("stage %s: env is required for deploy stages", stage.Name)
+		}
+		if _, err := parseWhen(stage.When); err != nil {
+			return fmt.Errorf("stage %s: %v", stage.Name, err)
+		}
+		if len(stage.Steps) == 0 {
+			return fmt.Errorf("stage %s: at least one step is required", stage.Name)
+		}
+
+		stepNames := make(map[string]bool)
+		for j, step := range stage.Steps {
+			if step.Name == "" {
+				return fmt.Errorf("stage %s: steps[%d]: name is required", stage.Name, j)
+			}
+			if stepNames[step.Name] {
+				return fmt.Errorf("stage %s: step %s: duplicate name", stage.Name, step.Name)
+			}
+			stepNames[step.Name] = true
+			if err := step.validate(&stage); err != nil {
+				return fmt.Errorf("stage %s: step %s: %v", stage.Name, step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (step *PipelineStep) validate(stage *PipelineStage) error {
+	if !pipelineStepTypes[step.Type] {
+		return fmt.Errorf("unknown type %q", step.Type)
+	}
+	if step.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if _, err := parseWhen(step.When); err != nil {
+		return err
+	}
+
+	switch step.Type {
+	case "host_shell", "worker_shell":
+		if step.Run == "" {
+			return fmt.Errorf("run is required")
+		}
+	case "k8s_apply":
+		if step.Manifest == "" {
+			return fmt.Errorf("manifest is required")
+		}
+	case "http":
+		if step.URL == "" {
+			return fmt.Errorf("url is required")
+		}
+	case "deploy":
+		if stage.Type != "deploy" {
+			return fmt.Errorf("deploy steps are only allowed in deploy stages")
+		}
+	}
+	return nil
+}
+
+// timeout 步骤的超时时间，未配置时部署步骤为 1 小时、人工确认为 24 小时，其他为 10 分钟
+func (step *PipelineStep) timeout() time.Duration {
+	if step.Timeout > 0 {
+		return time.Duration(step.Timeout) * time.Second
+	}
+	switch step.Type {
+	case "deploy":
+		return time.Hour
+	case "manual":
+		return 24 * time.Hour
+	default:
+		return 10 * time.Minute
+	}
+}
+
+// whenCondition 解析后的 when 条件。Mode 为 on_success（默认）、on_failure 或 always，
+// on_success 时还需满足所有比较条件
+type whenCondition struct {
+	Mode        string
+	Comparisons []whenComparison
+}
+
+type whenComparison struct {
+	Name  string
+	Equal bool
+	Value string
+}
+
+// parseWhen 解析 when 条件：always、on_failure、on_success，或以 && 连接的 `变量 == "值"`、`变量 != "值"`
+func parseWhen(expr string) (*whenCondition, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "", "on_success":
+		return &whenCondition{Mode: "on_success"}, nil
+	case "on_failure", "always":
+		return &whenCondition{Mode: expr}, nil
+	}
+
+	cond := &whenCondition{Mode: "on_success"}
+	for _, part := range strings.Split(expr, "&&") {
+		part = strings.TrimSpace(part)
+		op := "=="
+		i := strings.Index(part, op)
+		if j := strings.Index(part, "!="); j >= 0 && (i < 0 || j < i) {
+			op, i = "!=", j
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("invalid when condition %q", part)
+		}
+
+		name := strings.TrimSpace(part[:i])
+		value := strings.TrimSpace(part[i+len(op):])
+		if !pipelineVarName.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable %q in when condition", name)
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		cond.Comparisons = append(cond.Comparisons, whenComparison{Name: name, Equal: op == "==", Value: value})
+	}
+	return cond, nil
+}
+
+// Match 根据之前是否有步骤失败以及变量判断是否执行
+func (c *whenCondition) Match(vars map[string]string, failed bool) bool {
+	switch c.Mode {
+	case "always":
+		return true
+	case "on_failure":
+		return failed
+	}
+	if failed {
+		return false
+	}
+	for _, cmp := range c.Comparisons {
+		if (vars[cmp.Name] == cmp.Value) != cmp.Equal {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePipelineSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `
+variables:
+  region: cn
+stages:
+  - name: build
+    type: build
+    steps:
+      - name: compile
+        type: worker_shell
+        run: make
+  - name: prod
+    type: deploy
+    env: prod
+    when: region == "cn"
+    steps:
+      - name: release
+        type: deploy
+      - name: notify
+        type: http
+        url: https://example.com/hook
+        when: always
+`,
+		},
+		{name: "empty", content: ``, wantErr: "at least one stage"},
+		{name: "not yaml", content: `stages: [`, wantErr: "invalid pipeline"},
+		{
+			name:    "unknown field",
+			content: "stages:\n  - name: build\n    type: build\n    image: golang\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: "image",
+		},
+		{
+			name:    "invalid variable name",
+			content: "variables:\n  1st: x\nstages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: `invalid variable name "1st"`,
+		},
+		{
+			name:    "stage without name",
+			content: "stages:\n  - type: build\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: "stages[0]: name is required",
+		},
+		{
+			name:    "duplicate stage",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: worker_shell, run: make}\n  - name: build\n    type: test\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: "stage build: duplicate name",
+		},
+		{
+			name:    "unknown stage type",
+			content: "stages:\n  - name: build\n    type: package\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: `unknown type "package"`,
+		},
+		{
+			name:    "deploy stage without env",
+			content: "stages:\n  - name: prod\n    type: deploy\n    steps:\n      - {name: a, type: deploy}\n",
+			wantErr: "env is required",
+		},
+		{
+			name:    "invalid stage condition",
+			content: "stages:\n  - name: build\n    type: build\n    when: sometimes\n    steps:\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: "stage build: invalid when condition",
+		},
+		{
+			name:    "stage without steps",
+			content: "stages:\n  - name: build\n    type: build\n    steps: []\n",
+			wantErr: "at least one step",
+		},
+		{
+			name:    "duplicate step",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: worker_shell, run: make}\n      - {name: a, type: worker_shell, run: make}\n",
+			wantErr: "step a: duplicate name",
+		},
+		{
+			name:    "unknown step type",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: ssh}\n",
+			wantErr: `unknown type "ssh"`,
+		},
+		{
+			name:    "negative timeout",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: worker_shell, run: make, timeout: -1}\n",
+			wantErr: "timeout must not be negative",
+		},
+		{
+			name:    "invalid step condition",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: worker_shell, run: make, when: \"1x == y\"}\n",
+			wantErr: "step a: invalid variable",
+		},
+		{
+			name:    "shell without run",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: host_shell}\n",
+			wantErr: "run is required",
+		},
+		{
+			name:    "k8s apply without manifest",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: k8s_apply}\n",
+			wantErr: "manifest is required",
+		},
+		{
+			name:    "http without url",
+			content: "stages:\n  - name: verify\n    type: verify\n    steps:\n      - {name: a, type: http}\n",
+			wantErr: "url is required",
+		},
+		{
+			name:    "deploy step outside a deploy stage",
+			content: "stages:\n  - name: build\n    type: build\n    steps:\n      - {name: a, type: deploy}\n",
+			wantErr: "only allowed in deploy stages",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParsePipelineSpec(tt.content)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(spec.Stages) != 2 || len(spec.Stages[1].Steps) != 2 || spec.Variables["region"] != "cn" {
+					t.Errorf("spec = %+v", spec)
+				}
+				return
+			}
+			if !errors.Is(err, ErrPipelineInvalid) {
+				t.Fatalf("ParsePipelineSpec = %v, want ErrPipelineInvalid", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePipelineSpec = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    *whenCondition
+		wantErr bool
+	}{
+		{expr: "", want: &whenCondition{Mode: "on_success"}},
+		{expr: " on_success ", want: &whenCondition{Mode: "on_success"}},
+		{expr: "on_failure", want: &whenCondition{Mode: "on_failure"}},
+		{expr: "always", want: &whenCondition{Mode: "always"}},
+		{expr: `branch == "main"`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "branch", Equal: true, Value: "main"}}}},
+		{expr: `env != 'prod'`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "env", Value: "prod"}}}},
+		{expr: `branch==main`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "branch", Equal: true, Value: "main"}}}},
+		{
+			expr: `branch == "main" && region != "us"`,
+			want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{
+				{Name: "branch", Equal: true, Value: "main"},
+				{Name: "region", Value: "us"},
+			}},
+		},
+		// 第一个出现的运算符决定比较方式，值中可以包含另一个运算符
+		{expr: `msg == "a != b"`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "msg", Equal: true, Value: "a != b"}}}},
+		{expr: `msg != "=="`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "msg", Value: "=="}}}},
+		{expr: `branch == ""`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "branch", Equal: true, Value: ""}}}},
+		{expr: `name == "unbalanced'`, want: &whenCondition{Mode: "on_success", Comparisons: []whenComparison{{Name: "name", Equal: true, Value: `"unbalanced'`}}}},
+		{expr: "sometimes", wantErr: true},
+		{expr: "branch = main", wantErr: true},
+		{expr: `== "main"`, wantErr: true},
+		{expr: `1branch == "main"`, wantErr: true},
+		{expr: `$(id) == "x"`, wantErr: true},
+		{expr: `branch == "main" &&`, wantErr: true},
+		{expr: `always && branch == "main"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseWhen(tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseWhen = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWhen = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhenConditionMatch(t *testing.T) {
+	vars := map[string]string{"branch": "main", "env": "prod"}
+	tests := []struct {
+		expr   string
+		failed bool
+		want   bool
+	}{
+		{expr: "", want: true},
+		{expr: "", failed: true, want: false},
+		{expr: "on_failure", want: false},
+		{expr: "on_failure", failed: true, want: true},
+		{expr: "always", failed: true, want: true},
+		{expr: `branch == "main"`, want: true},
+		{expr: `branch == "dev"`, want: false},
+		{expr: `branch == "main"`, failed: true, want: false},
+		{expr: `branch == "main" && env != "prod"`, want: false},
+		{expr: `missing == ""`, want: true},
+		{expr: `missing != ""`, want: false},
+	}
+	for _, tt := range tests {
+		cond, err := parseWhen(tt.expr)
+		if err != nil {
+			t.Fatalf("parseWhen(%q): %v", tt.expr, err)
+		}
+		if got := cond.Match(vars, tt.failed); got != tt.want {
+			t.Errorf("Match(%q, failed=%v) = %v, want %v", tt.expr, tt.failed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"devops/internal/model"
+	"devops/internal/pkg/crypto"
+	"devops/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrWebhookNotConfigured = errors.New("webhook is not configured for this application")
+	ErrWebhookSignature     = errors.New("invalid webhook signature")
+	ErrWebhookProvider      = errors.New("unsupported webhook provider")
+	ErrWebhookPayload       = errors.New("invalid webhook payload")
+	ErrWebhookDuplicate     = errors.New("webhook delivery has already been received")
+)
+
+// maxDeliveryIDLength 与 WebhookDelivery.DeliveryID 的列长度一致
+const maxDeliveryIDLength = 100
+
+// webhookDeliveryStore 记录已接收的 webhook 投递，由 AppRepository 实现
+type webhookDeliveryStore interface {
+	ClaimWebhookDelivery(delivery *model.WebhookDelivery) (bool, error)
+	ReleaseWebhookDelivery(provider, deliveryID string) error
+}
+
+// GitWebhookService 接收 GitHub、GitLab、Gitea 的 push 事件，推送分支与应用分支一致时创建部署。
+// 每个应用使用独立的密钥：GitHub、Gitea 校验 HMAC-SHA256 签名，GitLab 校验 X-Gitlab-Token。
+// 签名不含时间戳，通过记录投递 ID 拒绝重放的请求
+type GitWebhookService struct {
+	appRepo       *repository.AppRepository
+	auditRepo     *repository.AuditRepository
+	deployService *DeploymentService
+	deliveries    webhookDeliveryStore
+	encryptor     *crypto.Encryptor
+}
+
+func NewGitWebhookService(appRepo *repository.AppRepository, auditRepo *repository.AuditRepository, deployService *DeploymentService, encryptKey string) *GitWebhookService {
+	return &GitWebhookService{
+		appRepo:       appRepo,
+		auditRepo:     auditRepo,
+		deployService: deployService,
+		deliveries:    appRepo,
+		encryptor:     crypto.NewEncryptor(encryptKey),
+	}
+}
+
+// GenerateSecret 为应用生成新的 webhook 密钥，旧密钥立即失效。密钥只在生成时返回一次
+func (s *GitWebhookService) GenerateSecret(appID uuid.UUID) (string, error) {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return "", ErrAppNotFound
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+
+	encrypted, err := s.encryptor.Encrypt(secret)
+	if err != nil {
+		return "", err
+	}
+	if err := s.appRepo.UpdateWebhookSecret(appID, encrypted); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// DeleteSecret 删除应用的 webhook 密钥，之后不再接收该应用的 webhook
+func (s *GitWebhookService) DeleteSecret(appID uuid.UUID) error {
+	if _, err := s.appRepo.GetByID(appID); err != nil {
+		return ErrAppNotFound
+	}
+	return s.appRepo.UpdateWebhookSecret(appID, "")
+}
+
+// GitWebhookResult webhook 的处理结果，Ignored 为 true 时未创建部署，Reason 说明原因
+type GitWebhookResult struct {
+	Provider   string            `json:"provider"`
+	Event      string            `json:"event"`
+	DeliveryID string            `json:"delivery_id"`
+	Branch     string            `json:"branch,omitempty"`
+	CommitID   string            `json:"commit_id,omitempty"`
+	Ignored    bool              `json:"ignored"`
+	Reason     string            `json:"reason,omitempty"`
+	Deployment *model.Deployment `json:"deployment,omitempty"`
+	Started    bool              `json:"started"`
+	Queued     bool              `json:"queued"`
+}
+
+type gitPushPayload struct {
+	Ref         string      `json:"ref"`
+	After       string      `json:"after"`
+	CheckoutSHA string      `json:"checkout_sha"` // GitLab
+	HeadCommit  *gitCommit  `json:"head_commit"`  // GitHub, Gitea
+	Commits     []gitCommit `json:"commits"`
+	UserName    string      `json:"user_name"` // GitLab
+	Pusher      struct {
+		Name     string `json:"name"`     // GitHub
+		Username string `json:"username"` // Gitea
+	} `json:"pusher"`
+}
+
+type gitCommit struct {
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"` // RFC 3339
+}
+
+func (c *gitCommit) time() *time.Time {
+	t, err := time.Parse(time.RFC3339, c.Timestamp)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// HandlePush 校验请求并处理 push 事件。非 push 事件、标签推送、分支删除以及其他分支的推送会被忽略；
+// 已接收过的投递返回 ErrWebhookDuplicate
+func (s *GitWebhookService) HandlePush(appCode string, header http.Header, body []byte, ip string) (*GitWebhookResult, error) {
+	app, err := s.appRepo.GetByCode(appCode)
+	if err != nil {
+		return nil, ErrAppNotFound
+	}
+	if app.WebhookSecret == "" {
+		return nil, ErrWebhookNotConfigured
+	}
+	secret, err := s.encryptor.Decrypt(app.WebhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt webhook secret: %w", err)
+	}
+
+	result, err := verifyGitWebhook(header, body, secret)
+	if err != nil {
+		return nil, err
+	}
+	release, err := claimDelivery(s.deliveries, app.ID, result)
+	if err != nil {
+		return nil, err
+	}
+	result, err = s.handlePush(app, result, body, ip)
+	if err != nil {
+		// 处理失败的投递允许来源重新发送
+		release()
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *GitWebhookService) handlePush(app *model.Application, result *GitWebhookResult, body []byte, ip string) (*GitWebhookResult, error) {
+	if !isPushEvent(result.Provider, result.Event) {
+		result.Ignored = true
+		result.Reason = "not a push event"
+		return result, nil
+	}
+
+	var payload gitPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebhookPayload, err)
+	}
+	branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/")
+	if !ok {
+		result.Ignored = true
+		result.Reason = "not a branch push"
+		return result, nil
+	}
+	result.Branch = branch
+	commit := payload.headCommit()
+	result.CommitID = commit.ID
+
+	switch {
+	case strings.Trim(payload.After, "0") == "" && payload.After != "":
+		result.Ignored = true
+		result.Reason = "branch deleted"
+		return result, nil
+	case branch != app.Branch:
+		result.Ignored = true
+		result.Reason = fmt.Sprintf("branch %s does not match %s", branch, app.Branch)
+		return result, nil
+	case app.Status != 1:
+		result.Ignored = true
+		result.Reason = "application is disabled"
+		return result, nil
+	case commit.ID == "":
+		return nil, fmt.Errorf("%w: missing commit", ErrWebhookPayload)
+	}
+
+	deploy, err := s.deployService.Create(&CreateDeployRequest{
+		AppID:      app.ID,
+		CommitID:   commit.ID,
+		CommitMsg:  commitSubject(commit.Message),
+		CommitTime: commit.time(),
+		Branch:     branch,
+	}, uuid.Nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(app, deploy, result.Provider, payload.pusher(), ip)
+
+	if app.AutoStart {
+		switch deploy.Status {
+		case 0: // pending
+			queued, err := s.deployService.StartDeploy(deploy.ID, nil)
+			if err != nil {
+				result.Reason = fmt.Sprintf("deployment created but not started: %v", err)
+			} else {
+				result.Started = !queued
+				result.Queued = queued
+			}
+		case 6: // awaiting_approval
+			result.Reason = "deployment is awaiting approval"
+		}
+		if refreshed, err := s.deployService.GetByID(deploy.ID); err == nil {
+			deploy = refreshed
+		}
+	}
+	result.Deployment = deploy
+	return result, nil
+}
+
+// verifyGitWebhook 根据请求头识别来源并校验签名。Gitea 同时发送 GitHub 兼容的请求头，需优先识别
+func verifyGitWebhook(header http.Header, body []byte, secret string) (*GitWebhookResult, error) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		if !validHMAC(body, secret, header.Get("X-Gitea-Signature")) {
+			return nil, ErrWebhookSignature
+		}
+		return &GitWebhookResult{Provider: "gitea", Event: header.Get("X-Gitea-Event"), DeliveryID: header.Get("X-Gitea-Delivery")}, nil
+	case header.Get("X-Gitlab-Event") != "":
+		token := header.Get("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return nil, ErrWebhookSignature
+		}
+		return &GitWebhookResult{Provider: "gitlab", Event: header.Get("X-Gitlab-Event"), DeliveryID: header.Get("X-Gitlab-Event-UUID")}, nil
+	case header.Get("X-GitHub-Event") != "":
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !validHMAC(body, secret, signature) {
+			return nil, ErrWebhookSignature
+		}
+		return &GitWebhookResult{Provider: "github", Event: header.Get("X-GitHub-Event"), DeliveryID: header.Get("X-GitHub-Delivery")}, nil
+	default:
+		return nil, ErrWebhookProvider
+	}
+}
+
+// claimDelivery 在签名校验通过后记录投递 ID，缺少投递 ID 或已接收过时拒绝请求。
+// 返回的 release 删除该记录，用于处理失败后允许来源重新发送
+func claimDelivery(store webhookDeliveryStore, appID uuid.UUID, result *GitWebhookResult) (release func(), err error) {
+	if result.DeliveryID == "" {
+		return nil, fmt.Errorf("%w: missing delivery id", ErrWebhookPayload)
+	}
+	if len(result.DeliveryID) > maxDeliveryIDLength {
+		return nil, fmt.Errorf("%w: delivery id too long", ErrWebhookPayload)
+	}
+	claimed, err := store.ClaimWebhookDelivery(&model.WebhookDelivery{
+		AppID:      appID,
+		Provider:   result.Provider,
+		DeliveryID: result.DeliveryID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("record webhook delivery: %w", err)
+	}
+	if !claimed {
+		return nil, ErrWebhookDuplicate
+	}
+	return func() {
+		if err := store.ReleaseWebhookDelivery(result.Provider, result.DeliveryID); err != nil {
+			log.Printf("Failed to release webhook delivery %s/%s: %v", result.Provider, result.DeliveryID, err)
+		}
+	}, nil
+}
+
+func validHMAC(body []byte, secret, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func isPushEvent(provider, event string) bool {
+	if provider == "gitlab" {
+		return event == "Push Hook"
+	}
+	return event == "push"
+}
+
+// headCommit 推送后的分支头提交。GitLab 没有 head_commit，从 commits 中查找 checkout_sha
+func (p *gitPushPayload) headCommit() gitCommit {
+	if p.HeadCommit != nil && p.HeadCommit.ID != "" {
+		return *p.HeadCommit
+	}
+	id := p.CheckoutSHA
+	if id == "" {
+		id = p.After
+	}
+	for _, c := range p.Commits {
+		if c.ID == id {
+			return c
+		}
+	}
+	return gitCommit{ID: id}
+}
+
+func (p *gitPushPayload) pusher() string {
+	switch {
+	case p.Pusher.Username != "":
+		return p.Pusher.Username
+	case p.Pusher.Name != "":
+		return p.Pusher.Name
+	default:
+		return p.UserName
+	}
+}
+
+// commitSubject 提交信息的首行，截断到 Deployment.CommitMsg 的长度
+func commitSubject(message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+	subject = strings.TrimSpace(subject)
+	if runes := []rune(subject); len(runes) > 255 {
+		subject = string(runes[:255])
+	}
+	return subject
+}
+
+// audit webhook 不经过 JWT 和审计中间件，单独记录创建部署的操作
+func (s *GitWebhookService) audit(app *model.Application, deploy *model.Deployment, provider, pusher, ip string) {
+	entry := &model.AuditLog{
+		Username:     "webhook:" + provider,
+		Action:       "create",
+		Module:       "deploy",
+		Resource:     "deployment",
+		ResourceID:   deploy.ID.String(),
+		ResourceName: app.Code,
+		NewValue:     fmt.Sprintf("%s@%s", deploy.Branch, deploy.CommitID),
+		Detail:       fmt.Sprintf("pushed by %s", pusher),
+		IP:           ip,
+		Status:       1,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.auditRepo.Create(entry); err != nil {
+		log.Printf("Failed to write webhook audit log for deployment %s: %v", deploy.ID, err)
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"devops/internal/model"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyGitWebhook(t *testing.T) {
+	const secret = "s3cret"
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	sign := func(key string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	headers := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		wantProvider string
+		wantEvent    string
+		wantDelivery string
+		wantErr      error
+	}{
+		{
+			name:         "github",
+			header:       headers("X-GitHub-Event", "push", "X-GitHub-Delivery", "gh-1", "X-Hub-Signature-256", "sha256="+sign(secret)),
+			wantProvider: "github", wantEvent: "push", wantDelivery: "gh-1",
+		},
+		{
+			name:    "github wrong secret",
+			header:  headers("X-GitHub-Event", "push", "X-Hub-Signature-256", "sha256="+sign("other")),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "github without prefix",
+			header:  headers("X-GitHub-Event", "push", "X-Hub-Signature-256", sign(secret)),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "github without signature",
+			header:  headers("X-GitHub-Event", "push"),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "github legacy sha1 only",
+			header:  headers("X-GitHub-Event", "push", "X-Hub-Signature", "sha1=00"),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:         "gitea",
+			header:       headers("X-Gitea-Event", "push", "X-Gitea-Delivery", "gt-1", "X-Gitea-Signature", sign(secret)),
+			wantProvider: "gitea", wantEvent: "push", wantDelivery: "gt-1",
+		},
+		{
+			name:    "gitea non hex signature",
+			header:  headers("X-Gitea-Event", "push", "X-Gitea-Signature", "not-hex"),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			// Gitea 同时发送 GitHub 兼容的请求头，必须按 Gitea 的签名校验
+			name: "gitea takes precedence over github headers",
+			header: headers("X-Gitea-Event", "push", "X-Gitea-Delivery", "gt-2", "X-Gitea-Signature", sign(secret),
+				"X-GitHub-Event", "push", "X-GitHub-Delivery", "gh-2"),
+			wantProvider: "gitea", wantEvent: "push", wantDelivery: "gt-2",
+		},
+		{
+			name: "gitea with only a valid github signature",
+			header: headers("X-Gitea-Event", "push", "X-GitHub-Event", "push",
+				"X-Hub-Signature-256", "sha256="+sign(secret)),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:         "gitlab",
+			header:       headers("X-Gitlab-Event", "Push Hook", "X-Gitlab-Event-UUID", "gl-1", "X-Gitlab-Token", secret),
+			wantProvider: "gitlab", wantEvent: "Push Hook", wantDelivery: "gl-1",
+		},
+		{
+			name:    "gitlab wrong token",
+			header:  headers("X-Gitlab-Event", "Push Hook", "X-Gitlab-Token", secret+"x"),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "gitlab token prefix",
+			header:  headers("X-Gitlab-Event", "Push Hook", "X-Gitlab-Token", secret[:3]),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "gitlab without token",
+			header:  headers("X-Gitlab-Event", "Push Hook"),
+			wantErr: ErrWebhookSignature,
+		},
+		{
+			name:    "unknown provider",
+			header:  headers("X-Bitbucket-Event", "repo:push", "X-Hub-Signature-256", "sha256="+sign(secret)),
+			wantErr: ErrWebhookProvider,
+		},
+		{
+			name:    "no headers",
+			header:  http.Header{},
+			wantErr: ErrWebhookProvider,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyGitWebhook(tt.header, body, secret)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("verifyGitWebhook = %+v, %v, want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Provider != tt.wantProvider || got.Event != tt.wantEvent || got.DeliveryID != tt.wantDelivery {
+				t.Errorf("verifyGitWebhook = %s/%s/%s, want %s/%s/%s",
+					got.Provider, got.Event, got.DeliveryID, tt.wantProvider, tt.wantEvent, tt.wantDelivery)
+			}
+		})
+	}
+}
+
+func TestVerifyGitWebhookTamperedBody(t *testing.T) {
+	const secret = "s3cret"
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(`{"ref":"refs/heads/main"}`))
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "push")
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	if _, err := verifyGitWebhook(header, []byte(`{"ref":"refs/heads/prod"}`), secret); !errors.Is(err, ErrWebhookSignature) {
+		t.Fatalf("verifyGitWebhook = %v, want ErrWebhookSignature", err)
+	}
+}
+
+func TestIsPushEvent(t *testing.T) {
+	tests := []struct {
+		provider string
+		event    string
+		want     bool
+	}{
+		{"github", "push", true},
+		{"gitea", "push", true},
+		{"github", "ping", false},
+		{"github", "Push Hook", false},
+		{"gitlab", "Push Hook", true},
+		{"gitlab", "Tag Push Hook", false},
+		{"gitlab", "push", false},
+	}
+	for _, tt := range tests {
+		if got := isPushEvent(tt.provider, tt.event); got != tt.want {
+			t.Errorf("isPushEvent(%q, %q) = %v, want %v", tt.provider, tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestPushPayloadHeadCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload gitPushPayload
+		want    string
+	}{
+		{
+			name:    "github head commit",
+			payload: gitPushPayload{After: "b", HeadCommit: &gitCommit{ID: "b", Message: "fix"}},
+			want:    "b",
+		},
+		{
+			name:    "gitlab checkout sha",
+			payload: gitPushPayload{After: "b", CheckoutSHA: "b", Commits: []gitCommit{{ID: "a"}, {ID: "b", Message: "fix"}}},
+			want:    "b",
+		},
+		{
+			name:    "after without commits",
+			payload: gitPushPayload{After: "c"},
+			want:    "c",
+		},
+		{
+			name:    "empty head commit falls back",
+			payload: gitPushPayload{After: "d", HeadCommit: &gitCommit{}},
+			want:    "d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.payload.headCommit(); got.ID != tt.want {
+				t.Errorf("headCommit = %q, want %q", got.ID, tt.want)
+			}
+		})
+	}
+
+	gitlab := gitPushPayload{CheckoutSHA: "b", Commits: []gitCommit{{ID: "b", Message: "fix: retry"}}}
+	if got := gitlab.headCommit().Message; got != "fix: retry" {
+		t.Errorf("gitlab head commit message = %q", got)
+	}
+}
+
+func TestCommitSubject(t *testing.T) {
+	long := strings.Repeat("界", 300)
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"fix: retry\n\nlonger body", "fix: retry"},
+		{"  padded  ", "padded"},
+		{"", ""},
+		{long, strings.Repeat("界", 255)},
+	}
+	for _, tt := range tests {
+		if got := commitSubject(tt.message); got != tt.want {
+			t.Errorf("commitSubject(%.20q) = %.20q, want %.20q", tt.message, got, tt.want)
+		}
+	}
+}
+
+// memoryDeliveryStore 以内存模拟投递记录的唯一约束
+type memoryDeliveryStore struct {
+	seen map[string]bool
+	err  error
+}
+
+func (m *memoryDeliveryStore) ClaimWebhookDelivery(d *model.WebhookDelivery) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	key := d.Provider + "/" + d.DeliveryID
+	if m.seen[key] {
+		return false, nil
+	}
+	m.seen[key] = true
+	return true, nil
+}
+
+func (m *memoryDeliveryStore) ReleaseWebhookDelivery(provider, deliveryID string) error {
+	delete(m.seen, provider+"/"+deliveryID)
+	return nil
+}
+
+func TestClaimDelivery(t *testing.T) {
+	store := &memoryDeliveryStore{seen: map[string]bool{}}
+	appID := uuid.New()
+	claim := func(provider, id string) error {
+		_, err := claimDelivery(store, appID, &GitWebhookResult{Provider: provider, DeliveryID: id})
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		id       string
+		wantErr  error
+	}{
+		{name: "first delivery", provider: "github", id: "d-1"},
+		{name: "replayed delivery", provider: "github", id: "d-1", wantErr: ErrWebhookDuplicate},
+		{name: "same id from another provider", provider: "gitlab", id: "d-1"},
+		{name: "another delivery", provider: "github", id: "d-2"},
+		{name: "missing id", provider: "github", wantErr: ErrWebhookPayload},
+		{name: "id too long", provider: "github", id: strings.Repeat("x", maxDeliveryIDLength+1), wantErr: ErrWebhookPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := claim(tt.provider, tt.id); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("claimDelivery = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 处理失败后释放，来源重新发送同一投递时可以再次处理
+	release, err := claimDelivery(store, appID, &GitWebhookResult{Provider: "gitea", DeliveryID: "d-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if err := claim("gitea", "d-3"); err != nil {
+		t.Fatalf("claim after release = %v", err)
+	}
+
+	// 无法记录投递时拒绝请求
+	store.err = errors.New("connection refused")
+	if err := claim("github", "d-4"); err == nil || errors.Is(err, ErrWebhookDuplicate) {
+		t.Fatalf("claimDelivery with a failing store = %v, want the store error", err)
+	}
+}